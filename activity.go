@@ -0,0 +1,109 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// activityIndexName is the name of the activity index.
+const activityIndexName = "ActivityIndex"
+
+// activityIndex is the index used to load the events of recent time buckets.
+var activityIndex = tableIndex{
+	name:        activityIndexName,
+	hashKey:     "Bucket",
+	hashKeyType: dynamodb.ScalarAttributeTypeN,
+}
+
+// WithActivityBuckets stores the time bucket of the size of bucketSize of
+// every saved event, for example an hour, and adds an index on the bucket to
+// the table in CreateTable. RecentEvents then loads the events of recent
+// buckets for activity feeds without a scan. Note that all events of a bucket
+// are in the same index partition, which limits the write throughput of a
+// namespace to what a single partition can take.
+func WithActivityBuckets(bucketSize time.Duration) Option {
+	return func(s *EventStore) error {
+		if bucketSize < time.Second {
+			return fmt.Errorf("invalid activity bucket size %v", bucketSize)
+		}
+		s.activityBucket = bucketSize
+		s.indexes = append(s.indexes, activityIndex)
+		return nil
+	}
+}
+
+// RecentEvents loads the events of the time buckets from the bucket of since
+// until now, in timestamp order. The events of the bucket of since that are
+// older than since are included. It needs the WithActivityBuckets option.
+func (s *EventStore) RecentEvents(ctx context.Context, since time.Time) ([]eh.Event, error) {
+	ctx, err := s.namespace(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.activityBucket <= 0 {
+		return nil, eh.EventStoreError{
+			Err:       ErrIndexNotEnabled,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	tables, err := s.eventTables(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var dbEvents []dbEvent
+	last := activityBucket(s.now(), s.activityBucket)
+	step := int64(s.activityBucket / time.Second)
+	for bucket := activityBucket(since, s.activityBucket); bucket <= last; bucket += step {
+		for _, tableName := range tables {
+			var tableEvents []dbEvent
+			start := time.Now()
+			err := s.service.Table(tableName).
+				Get("Bucket", bucket).
+				Index(activityIndexName).
+				AllWithContext(ctx, &tableEvents)
+			observe(ctx, s.metrics, OperationQuery, tableName, start, err)
+			if err != nil {
+				return nil, eh.EventStoreError{
+					BaseErr:   withRequestID(err),
+					Err:       err,
+					Namespace: eh.NamespaceFromContext(ctx),
+				}
+			}
+			dbEvents = append(dbEvents, tableEvents...)
+		}
+	}
+
+	sort.SliceStable(dbEvents, func(i, j int) bool {
+		return dbEvents[i].Timestamp.Before(dbEvents[j].Timestamp)
+	})
+
+	return s.buildEvents(ctx, dbEvents)
+}
+
+// activityBucket returns the start of the time bucket of a time in Unix
+// seconds.
+func activityBucket(t time.Time, bucketSize time.Duration) int64 {
+	return t.Truncate(bucketSize).Unix()
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestActivityBuckets will load the events of recent time buckets
+func (suite *EventStoreTestSuite) TestActivityBuckets() {
+	store := suite.newStore(WithActivityBuckets(time.Hour))
+
+	ctx := eh.NewContextWithNamespace(context.Background(), "activity")
+	assert.Nil(suite.T(), store.CreateTable(ctx))
+	defer store.DeleteTable(ctx)
+
+	now := time.Now().UTC()
+	old := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "old"},
+		now.Add(-3*time.Hour), mocks.AggregateType, uuid.New(), 1)
+	event1 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event1"},
+		now.Add(-time.Hour), mocks.AggregateType, uuid.New(), 1)
+	event2 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event2"},
+		now, mocks.AggregateType, uuid.New(), 1)
+	for _, event := range []eh.Event{event2, old, event1} {
+		assert.Nil(suite.T(), store.Save(ctx, []eh.Event{event}, 0))
+	}
+
+	events, err := store.RecentEvents(ctx, now.Add(-time.Hour))
+	assert.Nil(suite.T(), err)
+	if assert.Len(suite.T(), events, 2) {
+		assert.Equal(suite.T(), event1.Data(), events[0].Data())
+		assert.Equal(suite.T(), event2.Data(), events[1].Data())
+	}
+
+	_, err = store.RecentEvents(ctx, now)
+	if esErr, ok := err.(eh.EventStoreError); !ok || !errors.Is(esErr.Err, ErrIndexNotEnabled) {
+		suite.T().Error("there should be an index not enabled error:", err)
+	}
+}
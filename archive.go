@@ -0,0 +1,556 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/google/uuid"
+	"github.com/guregu/dynamo"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// ErrNoArchive is when events are archived but no archive is set.
+var ErrNoArchive = errors.New("no archive set")
+
+// ErrArchiveLocked is when the events of an aggregate are being archived by
+// another archiver.
+var ErrArchiveLocked = errors.New("aggregate is being archived")
+
+// ErrArchiveNotVerified is when the archive object of an aggregate doesn't
+// have the archived events after it was written, and the events are kept in
+// the table.
+var ErrArchiveNotVerified = errors.New("archived events not found in archive")
+
+// archiveLockVersion is the version of the item that locks the archive of an
+// aggregate while it is written, which is outside the range of events and the
+// continuation items of event data.
+const archiveLockVersion = -2
+
+// archiveLockTimeout is how long an archive lock is held before it can be
+// taken by another archiver, in case its holder failed.
+const archiveLockTimeout = 5 * time.Minute
+
+// eventArchive is the config of the S3 archive of events.
+type eventArchive struct {
+	client s3iface.S3API
+	bucket string
+}
+
+// WithArchive sets an S3 bucket for events that are archived by an Archiver.
+// Load and LoadFrom fetch archived events that were deleted from the table
+// from the archive, so cold aggregates can still be loaded.
+func WithArchive(bucket string) Option {
+	return func(s *EventStore) error {
+		if s.archive == nil {
+			s.archive = &eventArchive{}
+		}
+		s.archive.bucket = bucket
+		return nil
+	}
+}
+
+// WithArchiveClient uses a custom S3 client for the archive. Without it a
+// client is created from the AWS session of the store.
+func WithArchiveClient(client s3iface.S3API) Option {
+	return func(s *EventStore) error {
+		if s.archive == nil {
+			s.archive = &eventArchive{}
+		}
+		s.archive.client = client
+		return nil
+	}
+}
+
+// Archiver exports old events to the S3 archive of an event store, as NDJSON
+// with one stored event item per line and one object per aggregate, and can
+// delete them from the table. The latest event of an aggregate is always kept
+// in the table, which keeps the version checks of saves working and tells
+// loads when to fetch from the archive.
+type Archiver struct {
+	store  *EventStore
+	delete bool
+}
+
+// ArchiveOption is an option setter used to configure an archiver.
+type ArchiveOption func(*Archiver) error
+
+// WithArchiveDelete deletes the events from the table once they are archived.
+func WithArchiveDelete() ArchiveOption {
+	return func(a *Archiver) error {
+		a.delete = true
+		return nil
+	}
+}
+
+// NewArchiver creates an archiver for an event store with an archive.
+func NewArchiver(store *EventStore, options ...ArchiveOption) (*Archiver, error) {
+	if store.archive == nil {
+		return nil, ErrNoArchive
+	}
+
+	a := &Archiver{
+		store: store,
+	}
+
+	for _, option := range options {
+		if err := option(a); err != nil {
+			return nil, err
+		}
+	}
+
+	return a, nil
+}
+
+// Archive archives the events of the namespace of the context that are older
+// than a cutoff and returns the number of archived events. The tables are
+// scanned page by page and the events of an aggregate, which are read
+// together, are archived before the next aggregate is read. Aggregates that
+// are being archived by another archiver are skipped.
+func (a *Archiver) Archive(ctx context.Context, before time.Time) (int, error) {
+	ctx, err := a.store.namespace(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	tables, err := a.store.eventTables(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	// Throttle and report the progress when run as a scheduler job.
+	run := jobRunFromContext(ctx)
+
+	archived := 0
+	aggregates := 0
+	for _, tableName := range tables {
+		var stream []dbEvent
+		archiveStream := func() error {
+			if len(stream) == 0 {
+				return nil
+			}
+			if run != nil {
+				if err := run.Wait(ctx); err != nil {
+					return err
+				}
+			}
+			n, err := a.archiveEvents(ctx, tableName, stream[0].AggregateID, stream)
+			archived += n
+			stream = nil
+			if err != nil && !errors.Is(err, ErrArchiveLocked) {
+				return err
+			}
+			aggregates++
+			if run != nil {
+				run.Progress(int64(aggregates), 0)
+			}
+			return nil
+		}
+
+		// Resume the scan once if the credentials expire.
+		table := a.store.service.Table(tableName)
+		start := time.Now()
+		throttle := a.store.newScanThrottle()
+		var key dynamo.PagingKey
+		var archiveErr error
+		for retried := false; ; retried = true {
+			scan := table.Scan().
+				Filter("Version > ? AND 'Timestamp' < ?", aggregateHeadVersion, before).
+				Consistent(true)
+			iter := throttle.scan(scan).StartFrom(key).Iter()
+			var e dbEvent
+			var waitErr error
+			for waitErr == nil && archiveErr == nil && iter.NextWithContext(ctx, &e) {
+				// The items of an aggregate are read one after the other.
+				if len(stream) > 0 && stream[0].AggregateID != e.AggregateID {
+					archiveErr = archiveStream()
+				}
+				stream = append(stream, e)
+				e = dbEvent{}
+				waitErr = throttle.wait(ctx)
+			}
+			if err = iter.Err(); waitErr != nil {
+				err = waitErr
+			}
+			if archiveErr != nil || retried || !refreshExpiredCredentials(a.store.service.Client(), err) {
+				break
+			}
+			key = iter.LastEvaluatedKey()
+		}
+		observe(ctx, a.store.metrics, OperationScan, tableName, start, err)
+		if archiveErr != nil {
+			return archived, archiveErr
+		} else if isAWSErrorCode(err, dynamodb.ErrCodeResourceNotFoundException) {
+			continue
+		} else if err != nil {
+			return archived, eh.EventStoreError{
+				BaseErr:   withRequestID(err),
+				Err:       err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+		if err := archiveStream(); err != nil {
+			return archived, err
+		}
+	}
+
+	return archived, nil
+}
+
+// Schedule registers the archiver as a job of a scheduler, named by the table
+// prefix and the namespace of the context, like "prefix/archive/ns", which
+// archives the events of the namespace that are older than age every
+// interval. Each aggregate is a unit of work of the job, so archiving is rate
+// limited and paused by the options of the job. The job is unregistered when
+// the store is closed.
+func (a *Archiver) Schedule(ctx context.Context, scheduler *Scheduler, interval, age time.Duration, options ...JobOption) error {
+	ctx, err := a.store.namespace(ctx)
+	if err != nil {
+		return err
+	}
+
+	ns := eh.NamespaceFromContext(ctx)
+	jobName := a.store.tablePrefix + "/archive/" + ns
+	if err := scheduler.Register(jobName, func(ctx context.Context, run *JobRun) error {
+		_, err := a.Archive(eh.NewContextWithNamespace(ctx, ns), time.Now().Add(-age))
+		return err
+	}, append([]JobOption{WithJobInterval(interval)}, options...)...); err != nil {
+		return err
+	}
+
+	a.store.onClose(func(context.Context) error {
+		if err := scheduler.Unregister(jobName); err != ErrJobNotFound {
+			return err
+		}
+		return nil
+	})
+	return nil
+}
+
+// ArchiveAggregate archives the events of an aggregate up to and including a
+// version, like the version of its latest snapshot, and returns the number of
+// archived events. It returns ErrArchiveLocked if the aggregate is being
+// archived by another archiver.
+func (a *Archiver) ArchiveAggregate(ctx context.Context, id uuid.UUID, version int) (int, error) {
+	ctx, err := a.store.namespace(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	tables, err := a.store.eventTables(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	archived := 0
+	for _, tableName := range tables {
+		var items []dbEvent
+		start := time.Now()
+		err := a.store.service.Table(tableName).
+			Get("AggregateID", id.String()).
+			Range("Version", dynamo.Between, 1, version).
+			Consistent(true).
+			AllWithContext(ctx, &items)
+		observe(ctx, a.store.metrics, OperationQuery, tableName, start, err)
+		if isAWSErrorCode(err, dynamodb.ErrCodeResourceNotFoundException) {
+			continue
+		} else if err != nil {
+			return archived, eh.EventStoreError{
+				BaseErr:   withRequestID(err),
+				Err:       err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+
+		n, err := a.archiveEvents(ctx, tableName, id, items)
+		archived += n
+		if err != nil {
+			return archived, err
+		}
+	}
+
+	return archived, nil
+}
+
+// archiveEvents merges events of an aggregate from a table into its archive
+// object, and deletes them from the table if enabled. The archive of the
+// aggregate is locked while it is read, written and verified, so that
+// concurrent archivers never overwrite each other's events.
+func (a *Archiver) archiveEvents(ctx context.Context, tableName string, id uuid.UUID, items []dbEvent) (_ int, err error) {
+	if len(items) == 0 {
+		return 0, nil
+	}
+
+	s := a.store
+	owner, err := s.lockArchive(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if unlockErr := s.unlockArchive(ctx, id, owner); err == nil {
+			err = unlockErr
+		}
+	}()
+
+	archived, err := s.readArchive(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+
+	// Merge the events by version, the events from the table win.
+	versions := map[int]bool{}
+	for _, item := range items {
+		versions[item.Version] = true
+	}
+	merged := append([]dbEvent{}, items...)
+	for _, e := range archived {
+		if !versions[e.Version] {
+			merged = append(merged, e)
+		}
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		return merged[i].Version < merged[j].Version
+	})
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range merged {
+		item, err := dynamo.MarshalItem(e)
+		if err != nil {
+			return 0, eh.EventStoreError{
+				BaseErr:   err,
+				Err:       wrapError(ErrCouldNotMarshalEvent, err),
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+		if err := enc.Encode(item); err != nil {
+			return 0, eh.EventStoreError{
+				BaseErr:   err,
+				Err:       wrapError(ErrCouldNotMarshalEvent, err),
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+	}
+	if _, err := s.archive.client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.archive.bucket),
+		Key:    aws.String(s.archiveKey(ctx, id)),
+		Body:   bytes.NewReader(buf.Bytes()),
+	}); err != nil {
+		return 0, eh.EventStoreError{
+			BaseErr:   withRequestID(err),
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	if !a.delete {
+		return len(items), nil
+	}
+
+	// Only delete events that are in the archive as written.
+	if archived, err = s.readArchive(ctx, id); err != nil {
+		return len(items), err
+	}
+	for _, e := range archived {
+		delete(versions, e.Version)
+	}
+	if len(versions) > 0 {
+		return len(items), eh.EventStoreError{
+			Err:       ErrArchiveNotVerified,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	// Keep the latest event of the aggregate in the table.
+	latest, err := s.AggregateVersion(ctx, id)
+	if err != nil {
+		return len(items), err
+	}
+	var reqs []*dynamodb.WriteRequest
+	for _, item := range items {
+		if item.Version >= latest {
+			continue
+		}
+		reqs = append(reqs, &dynamodb.WriteRequest{DeleteRequest: &dynamodb.DeleteRequest{
+			Key: map[string]*dynamodb.AttributeValue{
+				"AggregateID": {S: aws.String(id.String())},
+				"Version":     {N: aws.String(strconv.Itoa(item.Version))},
+			},
+		}})
+	}
+
+	start := time.Now()
+	outcomes := batchWrite(ctx, s.service.Client(), tableName, []string{"AggregateID", "Version"}, reqs)
+	err = batchResult(outcomes, eh.NamespaceFromContext(ctx))
+	observe(ctx, s.metrics, OperationBatchWriteItem, tableName, start, err)
+	if err != nil {
+		return len(items), eh.EventStoreError{
+			BaseErr:   withRequestID(err),
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+	if s.cache != nil {
+		s.cache.remove(loadCacheKey(ctx, id))
+	}
+
+	return len(items), nil
+}
+
+// lockArchive takes the archive lock of an aggregate, which is an item in
+// the event table that expires after archiveLockTimeout, and returns the
+// owner of the lock. It returns ErrArchiveLocked if another archiver holds
+// the lock.
+func (s *EventStore) lockArchive(ctx context.Context, id uuid.UUID) (string, error) {
+	tableName := s.tableName(ctx)
+	owner := uuid.New().String()
+	now := s.now()
+	item := s.itemKey(ctx, id, archiveLockVersion)
+	item["LockOwner"] = &dynamodb.AttributeValue{S: aws.String(owner)}
+	item["LockedUntil"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(now.Add(archiveLockTimeout).Unix(), 10))}
+
+	start := time.Now()
+	_, err := s.service.Client().PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(tableName),
+		Item:                item,
+		ConditionExpression: aws.String(s.keyNotExists() + " OR LockedUntil < :now"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now": {N: aws.String(strconv.FormatInt(now.Unix(), 10))},
+		},
+	})
+	observe(ctx, s.metrics, OperationPutItem, tableName, start, err)
+	if isConditionalCheckFailed(err) {
+		return "", eh.EventStoreError{
+			BaseErr:   withRequestID(err),
+			Err:       ErrArchiveLocked,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	} else if err != nil {
+		return "", eh.EventStoreError{
+			BaseErr:   withRequestID(err),
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+	return owner, nil
+}
+
+// unlockArchive releases the archive lock of an aggregate, unless it was
+// taken over by another archiver.
+func (s *EventStore) unlockArchive(ctx context.Context, id uuid.UUID, owner string) error {
+	tableName := s.tableName(ctx)
+	start := time.Now()
+	_, err := s.service.Client().DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName:           aws.String(tableName),
+		Key:                 s.itemKey(ctx, id, archiveLockVersion),
+		ConditionExpression: aws.String("LockOwner = :owner"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":owner": {S: aws.String(owner)},
+		},
+	})
+	observe(ctx, s.metrics, OperationDeleteItem, tableName, start, err)
+	if err != nil && !isConditionalCheckFailed(err) {
+		return eh.EventStoreError{
+			BaseErr:   withRequestID(err),
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+	return nil
+}
+
+// loadArchived returns the archived events of an aggregate from a version and
+// before another version.
+func (s *EventStore) loadArchived(ctx context.Context, id uuid.UUID, from, before int) ([]dbEvent, error) {
+	archived, err := s.readArchive(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var dbEvents []dbEvent
+	for _, e := range archived {
+		if e.Version >= from && e.Version < before {
+			dbEvents = append(dbEvents, e)
+		}
+	}
+	return dbEvents, nil
+}
+
+// readArchive reads the archived events of an aggregate, in version order.
+func (s *EventStore) readArchive(ctx context.Context, id uuid.UUID) ([]dbEvent, error) {
+	out, err := s.archive.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.archive.bucket),
+		Key:    aws.String(s.archiveKey(ctx, id)),
+	})
+	if isAWSErrorCode(err, s3.ErrCodeNoSuchKey) {
+		return nil, nil
+	} else if err != nil {
+		return nil, eh.EventStoreError{
+			BaseErr:   withRequestID(err),
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+	defer out.Body.Close()
+
+	var dbEvents []dbEvent
+	scanner := bufio.NewScanner(out.Body)
+	scanner.Buffer(make([]byte, 64*1024), maxImportLineSize)
+	for scanner.Scan() {
+		var item map[string]*dynamodb.AttributeValue
+		if err := json.Unmarshal(scanner.Bytes(), &item); err != nil {
+			return nil, eh.EventStoreError{
+				BaseErr:   err,
+				Err:       wrapError(ErrCouldNotUnmarshalEvent, err),
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+		var e dbEvent
+		if err := dynamo.UnmarshalItem(item, &e); err != nil {
+			return nil, eh.EventStoreError{
+				BaseErr:   err,
+				Err:       wrapError(ErrCouldNotUnmarshalEvent, err),
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+		dbEvents = append(dbEvents, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, eh.EventStoreError{
+			BaseErr:   err,
+			Err:       wrapError(ErrCouldNotUnmarshalEvent, err),
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	return dbEvents, nil
+}
+
+// archiveKey returns the key of the archive object of an aggregate, which is
+// shared by the partitions of the event table.
+func (s *EventStore) archiveKey(ctx context.Context, id uuid.UUID) string {
+	return s.tableName(ctx) + "/" + id.String() + ".ndjson"
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/guregu/dynamo"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestArchive will archive old events to S3 and load them from the archive
+func (suite *EventStoreTestSuite) TestArchive() {
+	objects := newMemoryS3()
+	store := suite.newStore(WithArchive("archive"), WithArchiveClient(objects))
+
+	archiver, err := NewArchiver(store, WithArchiveDelete())
+	assert.Nil(suite.T(), err)
+
+	id := uuid.New()
+	old := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	var saved []eh.Event
+	for i := 1; i <= 3; i++ {
+		saved = append(saved, eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event"},
+			old, mocks.AggregateType, id, i))
+	}
+	assert.Nil(suite.T(), store.Save(suite.ctx, saved, 0))
+
+	archived, err := archiver.Archive(suite.ctx, old.Add(time.Hour))
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 3, archived)
+	assert.Len(suite.T(), objects.objects, 1)
+
+	// Only the latest event is kept in the table.
+	var items []dbEvent
+	assert.Nil(suite.T(), store.service.Table(store.tableName(suite.ctx)).
+		Get("AggregateID", id.String()).Range("Version", dynamo.Greater, aggregateHeadVersion).All(&items))
+	if assert.Len(suite.T(), items, 1) {
+		assert.Equal(suite.T(), 3, items[0].Version)
+	}
+
+	events, err := store.Load(suite.ctx, id)
+	assert.Nil(suite.T(), err)
+	if assert.Len(suite.T(), events, 3) {
+		for i, event := range events {
+			assert.Equal(suite.T(), i+1, event.Version())
+			assert.Equal(suite.T(), saved[i].Data(), event.Data())
+		}
+	}
+
+	events, err = store.LoadFrom(suite.ctx, id, 2)
+	assert.Nil(suite.T(), err)
+	assert.Len(suite.T(), events, 2)
+
+	// An aggregate that is being archived by another archiver is not
+	// archived until the lock is released.
+	assert.Nil(suite.T(), store.Save(suite.ctx, []eh.Event{
+		eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event"}, old, mocks.AggregateType, id, 4),
+	}, 3))
+	owner, err := store.lockArchive(suite.ctx, id)
+	assert.Nil(suite.T(), err)
+	_, err = archiver.ArchiveAggregate(suite.ctx, id, 3)
+	assert.True(suite.T(), errors.Is(err, ErrArchiveLocked))
+	archived, err = archiver.Archive(suite.ctx, old.Add(time.Hour))
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 0, archived)
+	assert.Nil(suite.T(), store.unlockArchive(suite.ctx, id, owner))
+	archived, err = archiver.ArchiveAggregate(suite.ctx, id, 3)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 1, archived)
+	events, err = store.Load(suite.ctx, id)
+	assert.Nil(suite.T(), err)
+	assert.Len(suite.T(), events, 4)
+
+	_, err = NewArchiver(&EventStore{})
+	assert.Equal(suite.T(), ErrNoArchive, err)
+}
+
+// TestArchiveSchedule will archive old events as a job of a scheduler
+func (suite *EventStoreTestSuite) TestArchiveSchedule() {
+	objects := newMemoryS3()
+	store := suite.newStore(WithArchive("archive"), WithArchiveClient(objects))
+	archiver, err := NewArchiver(store)
+	assert.Nil(suite.T(), err)
+
+	id := uuid.New()
+	old := time.Now().Add(-48 * time.Hour)
+	var saved []eh.Event
+	for i := 1; i <= 3; i++ {
+		saved = append(saved, eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event"},
+			old, mocks.AggregateType, id, i))
+	}
+	assert.Nil(suite.T(), store.Save(suite.ctx, saved, 0))
+
+	scheduler := NewScheduler(1)
+	defer scheduler.Close()
+	assert.Nil(suite.T(), archiver.Schedule(suite.ctx, scheduler, time.Hour, 24*time.Hour))
+	assert.Equal(suite.T(), ErrJobExists, archiver.Schedule(suite.ctx, scheduler, time.Hour, 24*time.Hour))
+
+	name := store.tablePrefix + "/archive/" + eh.NamespaceFromContext(suite.ctx)
+	assert.Eventually(suite.T(), func() bool {
+		status, err := scheduler.Status(name)
+		return err == nil && status.Runs == 1
+	}, 10*time.Second, 10*time.Millisecond)
+	status, _ := scheduler.Status(name)
+	assert.Nil(suite.T(), status.LastErr)
+	assert.Len(suite.T(), objects.objects, 1)
+
+	// The job is unregistered when the store is closed.
+	assert.Nil(suite.T(), store.Close(context.Background()))
+	_, err = scheduler.Status(name)
+	assert.Equal(suite.T(), ErrJobNotFound, err)
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// WithAutoCreateTable creates the tables of a namespace, as CreateTable, when
+// a save finds that the table does not exist, and then retries the write
+// once. This removes the need to create the tables before the first save, for
+// example in development; the first save of a namespace waits until the
+// table is active. Loads from a namespace without a table still fail.
+func WithAutoCreateTable() Option {
+	return func(s *EventStore) error {
+		s.autoCreateTable = true
+		return nil
+	}
+}
+
+// WithRepoAutoCreateTable creates the table of a namespace when a save finds
+// that it does not exist, as WithAutoCreateTable.
+func WithRepoAutoCreateTable() OptionRepo {
+	return func(r *Repo) error {
+		r.autoCreateTable = true
+		return nil
+	}
+}
+
+// autoCreate runs a write, and if the table does not exist and auto creation
+// is enabled, creates the tables and runs the write again once.
+func (s *EventStore) autoCreate(ctx context.Context, write func() error) error {
+	err := write()
+	if !s.autoCreateTable || !isAWSErrorCode(err, dynamodb.ErrCodeResourceNotFoundException) {
+		return err
+	}
+	if err := s.CreateTable(ctx); err != nil {
+		return err
+	}
+	return write()
+}
+
+// autoCreate runs a write, and if the table does not exist and auto creation
+// is enabled, creates the table and runs the write again once.
+func (r *Repo) autoCreate(ctx context.Context, write func() error) error {
+	err := write()
+	if !r.autoCreateTable || !isAWSErrorCode(err, dynamodb.ErrCodeResourceNotFoundException) {
+		return err
+	}
+	if err := r.CreateTable(ctx); err != nil {
+		return err
+	}
+	return write()
+}
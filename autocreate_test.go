@@ -0,0 +1,45 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestAutoCreateTable will create the table of a namespace on the first save
+func (suite *EventStoreTestSuite) TestAutoCreateTable() {
+	ctx := eh.NewContextWithNamespace(context.Background(), "auto_create")
+	defer suite.store.DeleteTable(ctx)
+
+	id := uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	event := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event1"}, timestamp, mocks.AggregateType, id, 1)
+	err := suite.store.Save(ctx, []eh.Event{event}, 0)
+	assert.True(suite.T(), isAWSErrorCode(err.(eh.EventStoreError).Err, dynamodb.ErrCodeResourceNotFoundException))
+
+	store := suite.newStore(WithAutoCreateTable())
+	assert.Nil(suite.T(), store.Save(ctx, []eh.Event{event}, 0))
+
+	events, err := store.Load(ctx, id)
+	assert.Nil(suite.T(), err)
+	assert.Len(suite.T(), events, 1)
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/guregu/dynamo"
+)
+
+// WithAWSConfig uses an AWS config for the session that is created when no
+// session is given with WithDynamoDB. It is merged into any config that is
+// set by earlier options.
+func WithAWSConfig(cfg *aws.Config) Option {
+	return func(s *EventStore) error {
+		s.awsConfig.MergeIn(cfg)
+		return nil
+	}
+}
+
+// WithEndpoint uses a custom DynamoDB endpoint, for example
+// "http://localhost:8000" for DynamoDB Local.
+func WithEndpoint(endpoint string) Option {
+	return WithAWSConfig(aws.NewConfig().WithEndpoint(endpoint))
+}
+
+// WithRegion uses a custom AWS region.
+func WithRegion(region string) Option {
+	return WithAWSConfig(aws.NewConfig().WithRegion(region))
+}
+
+// WithRepoAWSConfig uses an AWS config for the session that is created when no
+// session is given with WithRepoDynamoDB. It is merged into any config that is
+// set by earlier options.
+func WithRepoAWSConfig(cfg *aws.Config) OptionRepo {
+	return func(r *Repo) error {
+		r.awsConfig.MergeIn(cfg)
+		return nil
+	}
+}
+
+// WithRepoEndpoint uses a custom DynamoDB endpoint, for example
+// "http://localhost:8000" for DynamoDB Local.
+func WithRepoEndpoint(endpoint string) OptionRepo {
+	return WithRepoAWSConfig(aws.NewConfig().WithEndpoint(endpoint))
+}
+
+// WithRepoRegion uses a custom AWS region.
+func WithRepoRegion(region string) OptionRepo {
+	return WithRepoAWSConfig(aws.NewConfig().WithRegion(region))
+}
+
+// WithSnapshotAWSConfig uses an AWS config for the session that is created
+// when no session is given with WithSnapshotDynamoDB. It is merged into any
+// config that is set by earlier options.
+func WithSnapshotAWSConfig(cfg *aws.Config) OptionSnapshotStore {
+	return func(s *SnapshotStore) error {
+		s.awsConfig.MergeIn(cfg)
+		return nil
+	}
+}
+
+// WithSnapshotEndpoint uses a custom DynamoDB endpoint, for example
+// "http://localhost:8000" for DynamoDB Local.
+func WithSnapshotEndpoint(endpoint string) OptionSnapshotStore {
+	return WithSnapshotAWSConfig(aws.NewConfig().WithEndpoint(endpoint))
+}
+
+// WithSnapshotRegion uses a custom AWS region.
+func WithSnapshotRegion(region string) OptionSnapshotStore {
+	return WithSnapshotAWSConfig(aws.NewConfig().WithRegion(region))
+}
+
+// newSession creates an AWS session from an AWS config. Anything not set in
+// the config is resolved by the standard AWS chain: the environment, the
+// shared config and credentials files, and the instance or task role.
+func newSession(cfg *aws.Config) (*session.Session, error) {
+	return session.NewSessionWithOptions(session.Options{
+		Config:            *cfg,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+}
+
+// newService creates a DynamoDB service from an AWS config.
+func newService(cfg *aws.Config) (*dynamo.DB, error) {
+	sess, err := newSession(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return dynamo.New(sess), nil
+}
@@ -0,0 +1,45 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAWSConfigOptions(t *testing.T) {
+	s, err := NewEventStore("test",
+		WithAWSConfig(aws.NewConfig().WithRegion("us-east-1").WithMaxRetries(3)),
+		WithRegion("eu-west-1"),
+		WithEndpoint("http://localhost:8000"),
+	)
+	assert.Nil(t, err)
+	assert.NotNil(t, s.service)
+	assert.Equal(t, "eu-west-1", aws.StringValue(s.awsConfig.Region))
+	assert.Equal(t, "http://localhost:8000", aws.StringValue(s.awsConfig.Endpoint))
+	assert.Equal(t, 3, aws.IntValue(s.awsConfig.MaxRetries))
+
+	r, err := NewRepo("test", WithRepoRegion("eu-west-1"), WithRepoEndpoint("http://localhost:8000"))
+	assert.Nil(t, err)
+	assert.NotNil(t, r.service)
+	assert.Equal(t, "eu-west-1", aws.StringValue(r.awsConfig.Region))
+
+	ss, err := NewSnapshotStore("test", WithSnapshotRegion("eu-west-1"))
+	assert.Nil(t, err)
+	assert.NotNil(t, ss.service)
+	assert.Equal(t, "eu-west-1", aws.StringValue(ss.awsConfig.Region))
+}
@@ -0,0 +1,191 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// ErrUnprocessedItem is when DynamoDB did not process an item of a batch
+// within the allowed number of attempts.
+var ErrUnprocessedItem = errors.New("item was not processed")
+
+const (
+	// maxBatchWriteItems is the maximum number of items in a BatchWriteItem call.
+	maxBatchWriteItems = 25
+	// maxBatchWriteAttempts is the number of attempts for unprocessed items.
+	maxBatchWriteAttempts = 5
+	// batchRetryInterval is the base backoff between attempts.
+	batchRetryInterval = 50 * time.Millisecond
+)
+
+// ItemOutcome is the outcome for a single item of a batch operation.
+type ItemOutcome struct {
+	// Key is the key of the item, the key attribute values joined by "/".
+	Key string
+	// Attempts is the number of times the item was sent to DynamoDB.
+	Attempts int
+	// Err is the final error for the item, nil if it succeeded.
+	Err error
+}
+
+// PartialFailureError is returned from batch operations when some of the
+// items failed. It lists the outcome of every item so that callers can retry
+// only the failed subset.
+type PartialFailureError struct {
+	// Outcomes are the outcomes of all items in the batch.
+	Outcomes []ItemOutcome
+	// Namespace is the namespace of the batch.
+	Namespace string
+}
+
+// Error implements the Error method of the errors.Error interface.
+func (e PartialFailureError) Error() string {
+	failed := e.Failed()
+	errStr := fmt.Sprintf("%d of %d items failed", len(failed), len(e.Outcomes))
+	if len(failed) > 0 {
+		errStr += ": " + failed[0].Key + ": " + failed[0].Err.Error()
+	}
+	return errStr + " (" + e.Namespace + ")"
+}
+
+// Failed returns the outcomes of the items that failed.
+func (e PartialFailureError) Failed() []ItemOutcome {
+	var failed []ItemOutcome
+	for _, o := range e.Outcomes {
+		if o.Err != nil {
+			failed = append(failed, o)
+		}
+	}
+	return failed
+}
+
+// FailedKeys returns the keys of the items that failed.
+func (e PartialFailureError) FailedKeys() []string {
+	var keys []string
+	for _, o := range e.Failed() {
+		keys = append(keys, o.Key)
+	}
+	return keys
+}
+
+// batchWrite writes the requests in chunks with BatchWriteItem, retrying
+// unprocessed items with backoff. The key attributes are used to match
+// unprocessed items to their outcomes.
+func batchWrite(ctx context.Context, client dynamodbiface.DynamoDBAPI, tableName string, keyAttrs []string, reqs []*dynamodb.WriteRequest) []ItemOutcome {
+	outcomes := make([]ItemOutcome, len(reqs))
+	for start := 0; start < len(reqs); start += maxBatchWriteItems {
+		end := start + maxBatchWriteItems
+		if end > len(reqs) {
+			end = len(reqs)
+		}
+
+		// Index the chunk by key to be able to match unprocessed items.
+		pending := map[string]int{}
+		for i := start; i < end; i++ {
+			outcomes[i].Key = itemKey(writeRequestItem(reqs[i]), keyAttrs)
+			pending[outcomes[i].Key] = i
+		}
+		chunk := reqs[start:end]
+
+		refreshed := false
+		for attempt := 1; len(chunk) > 0; attempt++ {
+			for _, req := range chunk {
+				outcomes[pending[itemKey(writeRequestItem(req), keyAttrs)]].Attempts++
+			}
+
+			out, err := client.BatchWriteItemWithContext(ctx, &dynamodb.BatchWriteItemInput{
+				RequestItems: map[string][]*dynamodb.WriteRequest{tableName: chunk},
+			})
+			if err != nil && !refreshed && refreshExpiredCredentials(client, err) {
+				// Retry the chunk once with refreshed credentials.
+				refreshed = true
+				continue
+			} else if err != nil {
+				for _, req := range chunk {
+					outcomes[pending[itemKey(writeRequestItem(req), keyAttrs)]].Err = withRequestID(err)
+				}
+				break
+			}
+
+			chunk = out.UnprocessedItems[tableName]
+			if attempt == maxBatchWriteAttempts {
+				for _, req := range chunk {
+					outcomes[pending[itemKey(writeRequestItem(req), keyAttrs)]].Err = ErrUnprocessedItem
+				}
+				break
+			} else if len(chunk) > 0 {
+				select {
+				case <-ctx.Done():
+					for _, req := range chunk {
+						outcomes[pending[itemKey(writeRequestItem(req), keyAttrs)]].Err = ctx.Err()
+					}
+					chunk = nil
+				case <-time.After(jitter(batchRetryInterval << uint(attempt))):
+				}
+			}
+		}
+	}
+
+	return outcomes
+}
+
+// batchResult returns a PartialFailureError if any of the outcomes failed.
+func batchResult(outcomes []ItemOutcome, namespace string) error {
+	for _, o := range outcomes {
+		if o.Err != nil {
+			return PartialFailureError{
+				Outcomes:  outcomes,
+				Namespace: namespace,
+			}
+		}
+	}
+	return nil
+}
+
+// writeRequestItem returns the item or key of a write request.
+func writeRequestItem(req *dynamodb.WriteRequest) map[string]*dynamodb.AttributeValue {
+	if req.PutRequest != nil {
+		return req.PutRequest.Item
+	} else if req.DeleteRequest != nil {
+		return req.DeleteRequest.Key
+	}
+	return nil
+}
+
+// itemKey formats the key attributes of an item as a string.
+func itemKey(item map[string]*dynamodb.AttributeValue, keyAttrs []string) string {
+	parts := make([]string, len(keyAttrs))
+	for i, name := range keyAttrs {
+		if av, ok := item[name]; ok {
+			if av.S != nil {
+				parts[i] = aws.StringValue(av.S)
+			} else if av.N != nil {
+				parts[i] = aws.StringValue(av.N)
+			} else {
+				parts[i] = string(av.B)
+			}
+		}
+	}
+	return strings.Join(parts, "/")
+}
@@ -0,0 +1,37 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPartialFailureError(t *testing.T) {
+	err := PartialFailureError{
+		Outcomes: []ItemOutcome{
+			{Key: "a", Attempts: 1},
+			{Key: "b", Attempts: 5, Err: ErrUnprocessedItem},
+			{Key: "c", Attempts: 1, Err: errors.New("failed")},
+		},
+		Namespace: "ns",
+	}
+
+	assert.EqualError(t, err, "2 of 3 items failed: b: item was not processed (ns)")
+	assert.Equal(t, []string{"b", "c"}, err.FailedKeys())
+	assert.Len(t, err.Failed(), 2)
+}
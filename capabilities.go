@@ -0,0 +1,152 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import "sort"
+
+// LayoutVersion is the version of the layout of the tables and items written
+// by the event store and repo. It changes when items written by a new version
+// can not be read by an older one.
+const LayoutVersion = 1
+
+// Capabilities describes the features that are enabled on an event store, so
+// that other libraries can detect what an instance supports.
+type Capabilities struct {
+	// LayoutVersion is the version of the table and item layout.
+	LayoutVersion int
+	// NamespaceProvider is true when a custom namespace provider is used.
+	NamespaceProvider bool
+	// Environment is the environment suffix of the table names, if any.
+	Environment string
+
+	SharedTable       bool
+	AutoCreateTable   bool
+	SaveInterceptors  bool
+	VersionValidation VersionValidation
+	MonthlyPartitions bool
+	GlobalPosition    bool
+	Encryption        bool
+	CryptoShredding   bool
+	// Compression is the name of the compression, empty when disabled.
+	Compression  string
+	CustomCodec  bool
+	Upcasting    bool
+	S3Overflow   bool
+	Archive      bool
+	Chunking     bool
+	PayloadDedup bool
+	LoadCache    bool
+	Outbox       bool
+	AsyncHandler bool
+	LoadFilter   bool
+	Forwarding   bool
+	Kinesis      bool
+	EventTTL     bool
+
+	SnapshotFallback bool
+	DAX              bool
+	Scheduler        bool
+	ReadConsistency  ReadConsistency
+	// ScanRateLimit is the limit of the read capacity of scans per second,
+	// 0 when unlimited.
+	ScanRateLimit float64
+	// Indexes are the names of the global secondary indexes of the event
+	// tables, in name order.
+	Indexes []string
+}
+
+// RepoCapabilities describes the features that are enabled on a repo.
+type RepoCapabilities struct {
+	// LayoutVersion is the version of the table and item layout.
+	LayoutVersion int
+	// NamespaceProvider is true when a custom namespace provider is used.
+	NamespaceProvider bool
+	// Environment is the environment suffix of the table names, if any.
+	Environment string
+
+	StalenessTracking bool
+	AutoCreateTable   bool
+	DAX               bool
+	ReadConsistency   ReadConsistency
+	// Indexes are the names of the queryable indexes, in name order.
+	Indexes []string
+}
+
+// Capabilities returns the features that are enabled on the event store.
+func (s *EventStore) Capabilities() Capabilities {
+	c := Capabilities{
+		LayoutVersion:     LayoutVersion,
+		NamespaceProvider: s.namespaceProvider != nil,
+		SharedTable:       s.sharedTable,
+		AutoCreateTable:   s.autoCreateTable,
+		SaveInterceptors:  len(s.saveInterceptors) > 0,
+		VersionValidation: s.versionValidation,
+		MonthlyPartitions: s.partitions != nil,
+		GlobalPosition:    s.globalPosition,
+		Encryption:        s.encryption != nil && s.encryption.kmsKeyID != "",
+		CryptoShredding:   s.shredding != nil,
+		CustomCodec:       s.codec != nil,
+		Upcasting:         len(s.upcasters) > 0,
+		S3Overflow:        s.overflow != nil,
+		Archive:           s.archive != nil,
+		Chunking:          s.chunking != nil,
+		PayloadDedup:      s.payloads != nil,
+		LoadCache:         s.cache != nil,
+		Outbox:            s.outbox != nil,
+		AsyncHandler:      s.handlerQueue != nil,
+		LoadFilter:        s.loadFilter != nil,
+		Forwarding:        s.forward != nil,
+		Kinesis:           s.kinesis != nil,
+		EventTTL:          s.ttlEnabled,
+		SnapshotFallback:  s.snapshotFallback != nil,
+		DAX:               s.dax != nil,
+		Scheduler:         s.scheduler != nil,
+		ReadConsistency:   s.readConsistency,
+	}
+	if s.environment != nil {
+		c.Environment = s.environment.name
+	}
+	if s.compression != nil {
+		c.Compression = s.compression.Name()
+	}
+	if s.scanLimit != nil {
+		c.ScanRateLimit = s.scanLimit.rate
+	}
+	for _, index := range s.indexes {
+		c.Indexes = append(c.Indexes, index.name)
+	}
+	sort.Strings(c.Indexes)
+	return c
+}
+
+// Capabilities returns the features that are enabled on the repo.
+func (r *Repo) Capabilities() RepoCapabilities {
+	c := RepoCapabilities{
+		LayoutVersion:     LayoutVersion,
+		NamespaceProvider: r.namespaceProvider != nil,
+		StalenessTracking: r.staleness != nil,
+		AutoCreateTable:   r.autoCreateTable,
+		DAX:               r.dax != nil,
+		ReadConsistency:   r.readConsistency,
+	}
+	if r.environment != nil {
+		c.Environment = r.environment.name
+	}
+	for name := range r.indexQueries {
+		c.Indexes = append(c.Indexes, name)
+	}
+	sort.Strings(c.Indexes)
+	return c
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCapabilities(t *testing.T) {
+	sess := session.Must(session.NewSession(&aws.Config{Region: aws.String("us-west-2")}))
+
+	s, err := NewEventStore("events", WithDynamoDB(sess),
+		WithCompression(GzipCompression{}, 1024),
+		WithEventTTL(0),
+		WithEnvironmentSuffix("test"),
+	)
+	if !assert.Nil(t, err) {
+		return
+	}
+	c := s.Capabilities()
+	assert.Equal(t, LayoutVersion, c.LayoutVersion)
+	assert.Equal(t, "gzip", c.Compression)
+	assert.Equal(t, "test", c.Environment)
+	assert.True(t, c.EventTTL)
+	assert.False(t, c.Encryption)
+	assert.False(t, c.MonthlyPartitions)
+	assert.False(t, c.DAX)
+
+	r, err := NewRepo("models", WithRepoDynamoDB(sess), WithRepoStalenessTracking())
+	if !assert.Nil(t, err) {
+		return
+	}
+	rc := r.Capabilities()
+	assert.Equal(t, LayoutVersion, rc.LayoutVersion)
+	assert.True(t, rc.StalenessTracking)
+	assert.Empty(t, rc.Indexes)
+}
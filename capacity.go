@@ -0,0 +1,139 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/guregu/dynamo"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// ConsumedCapacity is the capacity that a DynamoDB operation consumed on a
+// table, including its indexes.
+type ConsumedCapacity struct {
+	// Operation is the DynamoDB operation.
+	Operation Operation
+	// Table is the name of the table.
+	Table string
+	// Namespace is the namespace of the context.
+	Namespace string
+	// CapacityUnits is the total capacity, and ReadCapacityUnits and
+	// WriteCapacityUnits are the read and write parts of it when reported
+	// separately by DynamoDB, which is for transactions.
+	CapacityUnits      float64
+	ReadCapacityUnits  float64
+	WriteCapacityUnits float64
+}
+
+// CapacityMetrics is a sink for the consumed capacity of operations,
+// typically recording counters of capacity units tagged by operation, table
+// and namespace.
+type CapacityMetrics interface {
+	ObserveCapacity(ctx context.Context, c ConsumedCapacity)
+}
+
+// CapacityMetricsFunc is a function that can be used as CapacityMetrics.
+type CapacityMetricsFunc func(ctx context.Context, c ConsumedCapacity)
+
+// ObserveCapacity implements the ObserveCapacity method of the CapacityMetrics interface.
+func (f CapacityMetricsFunc) ObserveCapacity(ctx context.Context, c ConsumedCapacity) {
+	f(ctx, c)
+}
+
+// WithConsumedCapacity requests the consumed capacity of every DynamoDB
+// operation of the event store and reports it to a sink, to attribute the
+// cost of a table to saves, loads and projections. Operations of a DAX
+// cluster are not reported.
+func WithConsumedCapacity(m CapacityMetrics) Option {
+	return func(s *EventStore) error {
+		s.capacityMetrics = m
+		return nil
+	}
+}
+
+// WithRepoConsumedCapacity requests the consumed capacity of every DynamoDB
+// operation of the repo and reports it to a sink, as WithConsumedCapacity.
+func WithRepoConsumedCapacity(m CapacityMetrics) OptionRepo {
+	return func(r *Repo) error {
+		r.capacityMetrics = m
+		return nil
+	}
+}
+
+// applyCapacityMetrics adds handlers to the DynamoDB client of a service that
+// request and report the consumed capacity of every operation.
+func applyCapacityMetrics(db *dynamo.DB, m CapacityMetrics) {
+	if m == nil {
+		return
+	}
+	c, ok := db.Client().(*dynamodb.DynamoDB)
+	if !ok {
+		return
+	}
+
+	// All operations that consume capacity have a ReturnConsumedCapacity
+	// input field and a ConsumedCapacity output field. Operations that
+	// already request it, like throttled scans, are left as is.
+	c.Handlers.Validate.PushBack(func(r *request.Request) {
+		v := reflect.ValueOf(r.Params)
+		if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+			return
+		}
+		f := v.Elem().FieldByName("ReturnConsumedCapacity")
+		if f.IsValid() && f.Kind() == reflect.Ptr && f.IsNil() {
+			f.Set(reflect.ValueOf(aws.String(dynamodb.ReturnConsumedCapacityTotal)))
+		}
+	})
+	c.Handlers.Complete.PushBack(func(r *request.Request) {
+		if r.Error != nil {
+			return
+		}
+		v := reflect.ValueOf(r.Data)
+		if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+			return
+		}
+		f := v.Elem().FieldByName("ConsumedCapacity")
+		if !f.IsValid() {
+			return
+		}
+
+		var consumed []*dynamodb.ConsumedCapacity
+		switch cc := f.Interface().(type) {
+		case *dynamodb.ConsumedCapacity:
+			consumed = append(consumed, cc)
+		case []*dynamodb.ConsumedCapacity:
+			consumed = cc
+		}
+		ctx := r.Context()
+		for _, cc := range consumed {
+			if cc == nil {
+				continue
+			}
+			m.ObserveCapacity(ctx, ConsumedCapacity{
+				Operation:          Operation(r.Operation.Name),
+				Table:              aws.StringValue(cc.TableName),
+				Namespace:          eh.NamespaceFromContext(ctx),
+				CapacityUnits:      aws.Float64Value(cc.CapacityUnits),
+				ReadCapacityUnits:  aws.Float64Value(cc.ReadCapacityUnits),
+				WriteCapacityUnits: aws.Float64Value(cc.WriteCapacityUnits),
+			})
+		}
+	})
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/guregu/dynamo"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCapacityMetrics(t *testing.T) {
+	sess := session.Must(session.NewSession(&aws.Config{Region: aws.String("us-west-2")}))
+	db := dynamo.New(sess)
+
+	var consumed []ConsumedCapacity
+	applyCapacityMetrics(db, CapacityMetricsFunc(func(ctx context.Context, c ConsumedCapacity) {
+		consumed = append(consumed, c)
+	}))
+	client := db.Client().(*dynamodb.DynamoDB)
+	ctx := eh.NewContextWithNamespace(context.Background(), "ns")
+
+	req, out := client.PutItemRequest(&dynamodb.PutItemInput{
+		TableName: aws.String("events"),
+		Item:      map[string]*dynamodb.AttributeValue{"AggregateID": {S: aws.String("id")}},
+	})
+	req.SetContext(ctx)
+	req.Handlers.Validate.Run(req)
+	assert.Equal(t, dynamodb.ReturnConsumedCapacityTotal, aws.StringValue(req.Params.(*dynamodb.PutItemInput).ReturnConsumedCapacity))
+
+	req.Error = nil
+	out.ConsumedCapacity = &dynamodb.ConsumedCapacity{TableName: aws.String("events"), CapacityUnits: aws.Float64(2)}
+	req.Handlers.Complete.Run(req)
+	assert.Equal(t, []ConsumedCapacity{{
+		Operation:     OperationPutItem,
+		Table:         "events",
+		Namespace:     "ns",
+		CapacityUnits: 2,
+	}}, consumed)
+
+	// Batch operations report every table.
+	consumed = nil
+	req, batchOut := client.BatchWriteItemRequest(&dynamodb.BatchWriteItemInput{})
+	req.Handlers.Validate.Run(req)
+	req.Error = nil
+	batchOut.ConsumedCapacity = []*dynamodb.ConsumedCapacity{
+		{TableName: aws.String("events"), CapacityUnits: aws.Float64(1)},
+		{TableName: aws.String("payloads"), CapacityUnits: aws.Float64(3)},
+	}
+	req.Handlers.Complete.Run(req)
+	if assert.Len(t, consumed, 2) {
+		assert.Equal(t, OperationBatchWriteItem, consumed[0].Operation)
+		assert.Equal(t, "payloads", consumed[1].Table)
+	}
+}
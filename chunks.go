@@ -0,0 +1,202 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/guregu/dynamo"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// ErrNoEventChunking is when event data is stored in chunks but chunking is
+// not enabled.
+var ErrNoEventChunking = errors.New("no event chunking set")
+
+// ErrTooManyChunks is when event data needs more chunks than fit in the sort
+// key of the continuation items.
+var ErrTooManyChunks = errors.New("too many chunks of event data")
+
+// maxChunkSize is the size of the chunks of event data, which leaves room
+// for the key and attribute names below the item size limit.
+const maxChunkSize = 350 * 1024
+
+// maxChunks is the number of chunk indexes per event version in the sort key
+// of continuation items.
+const maxChunks = 1000
+
+// eventChunking is the config of the chunked storage of event data.
+type eventChunking struct {
+	threshold int
+}
+
+// WithEventChunking stores event data that is larger than threshold bytes when
+// encoded in continuation items in the event table instead of in the event
+// item, as an alternative to WithS3Overflow that keeps all data in DynamoDB.
+// The continuation items are written in the same transaction as the event,
+// and reassembled transparently when the event is loaded. Without a codec the
+// data is stored as JSON.
+//
+// The sort key of a continuation item is the version of the event with the
+// index of the chunk as a three digit suffix, negated so that continuation
+// items are never read as events: chunk 2 of version 15 is at -15002. As the
+// events of a save are written in one transaction, the data of a save can be
+// at most 4 MB. Replace, RewriteEvents and DeleteAggregate also replace and
+// delete the continuation items. Archived events that are deleted from the
+// table keep their continuation items.
+func WithEventChunking(threshold int) Option {
+	return func(s *EventStore) error {
+		s.chunking = &eventChunking{
+			threshold: threshold,
+		}
+		return nil
+	}
+}
+
+// chunkVersion returns the sort key of a continuation item of an event.
+func chunkVersion(version, index int) int {
+	return -(version*maxChunks + index)
+}
+
+// chunkData moves the data of an event to chunks if it is over the threshold,
+// which are written as continuation items with chunkItems.
+func (s *EventStore) chunkData(ctx context.Context, event eh.Event, e *dbEvent) error {
+	if s.chunking == nil || event.Data() == nil || e.DataRef != "" {
+		return nil
+	}
+
+	payload := e.EncodedData
+	encoded := payload != nil
+	if e.encrypted() {
+		// The codec of encrypted data is kept as is.
+		payload, encoded = e.Encrypted, true
+	} else if !encoded {
+		var err error
+		if payload, err = (JSONCodec{}).Marshal(event.Data()); err != nil {
+			return err
+		}
+	}
+	if len(payload) <= s.chunking.threshold {
+		return nil
+	}
+
+	var chunks [][]byte
+	for start := 0; start < len(payload); start += maxChunkSize {
+		end := start + maxChunkSize
+		if end > len(payload) {
+			end = len(payload)
+		}
+		chunks = append(chunks, payload[start:end])
+	}
+	if len(chunks) > maxChunks {
+		return ErrTooManyChunks
+	}
+
+	e.chunks = chunks
+	e.Chunks = len(chunks)
+	e.ChunksEncoded = encoded
+	e.RawData = nil
+	e.EncodedData = nil
+	e.Encrypted = nil
+	return nil
+}
+
+// chunkItems returns the writes of the continuation items of an event to the
+// table of the event. When the event replaces an existing event, the
+// continuation items of the existing event that are left over are deleted.
+func (s *EventStore) chunkItems(ctx context.Context, tableName string, e *dbEvent, existing *dbEvent) []*dynamodb.TransactWriteItem {
+	var items []*dynamodb.TransactWriteItem
+	for i, data := range e.chunks {
+		item := s.itemKey(ctx, e.AggregateID, chunkVersion(e.Version, i))
+		item["Data"] = &dynamodb.AttributeValue{B: data}
+		if e.ExpiresAt > 0 {
+			item[expiresAtAttr] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(e.ExpiresAt, 10))}
+		}
+		items = append(items, &dynamodb.TransactWriteItem{Put: &dynamodb.Put{
+			TableName: aws.String(tableName),
+			Item:      item,
+		}})
+	}
+	if existing != nil {
+		for i := len(e.chunks); i < existing.Chunks; i++ {
+			items = append(items, &dynamodb.TransactWriteItem{Delete: &dynamodb.Delete{
+				TableName: aws.String(tableName),
+				Key:       s.itemKey(ctx, e.AggregateID, chunkVersion(e.Version, i)),
+			}})
+		}
+	}
+	return items
+}
+
+// assembleData reads the data of an event from its continuation items if it
+// was chunked, or uses the chunks of the event if they are not written yet.
+func (s *EventStore) assembleData(ctx context.Context, e *dbEvent) error {
+	if e.Chunks == 0 {
+		return nil
+	}
+	if s.chunking == nil {
+		return ErrNoEventChunking
+	}
+
+	chunks := e.chunks
+	if chunks == nil {
+		tableName := s.eventTableName(ctx, e.Timestamp)
+		var items []dbChunk
+		start := time.Now()
+		err := s.service.Table(tableName).
+			Get(s.hashKey(), s.hashValue(ctx, e.AggregateID)).
+			Range("Version", dynamo.Between, chunkVersion(e.Version, e.Chunks-1), chunkVersion(e.Version, 0)).
+			Consistent(true).
+			AllWithContext(ctx, &items)
+		observe(ctx, s.metrics, OperationQuery, tableName, start, err)
+		if err != nil {
+			return withRequestID(err)
+		}
+		if len(items) != e.Chunks {
+			return errors.New("missing chunks of event data")
+		}
+
+		// The chunk with index 0 has the highest sort key.
+		sort.Slice(items, func(i, j int) bool {
+			return items[i].Version > items[j].Version
+		})
+		for _, item := range items {
+			chunks = append(chunks, item.Data)
+		}
+	}
+
+	var payload []byte
+	for _, c := range chunks {
+		payload = append(payload, c...)
+	}
+	if e.encrypted() {
+		e.Encrypted = payload
+	} else {
+		e.EncodedData = payload
+	}
+	return nil
+}
+
+// dbChunk is a continuation item with a chunk of the data of an event.
+type dbChunk struct {
+	Version int
+	Data    []byte
+}
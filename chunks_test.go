@@ -0,0 +1,97 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/guregu/dynamo"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestEventChunking will store large event data in continuation items and
+// load it transparently
+func (suite *EventStoreTestSuite) TestEventChunking() {
+	store := suite.newStore(WithEventChunking(32))
+
+	ctx := eh.NewContextWithNamespace(context.Background(), "chunks")
+	assert.Nil(suite.T(), store.CreateTable(ctx))
+	defer store.DeleteTable(ctx)
+
+	id := uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	small := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "small"},
+		timestamp, mocks.AggregateType, id, 1)
+	large := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: strings.Repeat("large", 160*1024)},
+		timestamp, mocks.AggregateType, id, 2)
+	assert.Nil(suite.T(), store.Save(ctx, []eh.Event{small, large}, 0))
+
+	var e dbEvent
+	err := store.service.Table(store.tableName(ctx)).
+		Get("AggregateID", id.String()).Range("Version", dynamo.Equal, 2).One(&e)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 3, e.Chunks)
+	assert.Nil(suite.T(), e.RawData)
+	assert.Equal(suite.T(), []int{-2002, -2001, -2000}, suite.chunkVersions(store, ctx, id))
+
+	events, err := store.Load(ctx, id)
+	assert.Nil(suite.T(), err)
+	if assert.Len(suite.T(), events, 2) {
+		assert.Equal(suite.T(), small.Data(), events[0].Data())
+		assert.Equal(suite.T(), large.Data(), events[1].Data())
+	}
+
+	// A save that conflicts writes no continuation items.
+	conflicting := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: strings.Repeat("other", 240*1024)},
+		timestamp, mocks.AggregateType, id, 2)
+	assert.Error(suite.T(), store.Save(ctx, []eh.Event{conflicting}, 1))
+	assert.Equal(suite.T(), []int{-2002, -2001, -2000}, suite.chunkVersions(store, ctx, id))
+
+	// Replacing with smaller data deletes the continuation items left over.
+	replaced := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: strings.Repeat("small", 100*1024)},
+		timestamp, mocks.AggregateType, id, 2)
+	assert.Nil(suite.T(), store.Replace(ctx, replaced))
+	assert.Equal(suite.T(), []int{-2001, -2000}, suite.chunkVersions(store, ctx, id))
+	events, err = store.Load(ctx, id)
+	assert.Nil(suite.T(), err)
+	if assert.Len(suite.T(), events, 2) {
+		assert.Equal(suite.T(), replaced.Data(), events[1].Data())
+	}
+
+	// Deleting the aggregate deletes the continuation items.
+	deleted, err := store.DeleteAggregate(ctx, id)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 2, deleted)
+	assert.Empty(suite.T(), suite.chunkVersions(store, ctx, id))
+}
+
+// chunkVersions returns the sort keys of the continuation items of an
+// aggregate.
+func (suite *EventStoreTestSuite) chunkVersions(store *EventStore, ctx context.Context, id uuid.UUID) []int {
+	var items []dbChunk
+	err := store.service.Table(store.tableName(ctx)).
+		Get("AggregateID", id.String()).Range("Version", dynamo.Less, aggregateHeadVersion).All(&items)
+	assert.Nil(suite.T(), err)
+	var versions []int
+	for _, item := range items {
+		versions = append(versions, item.Version)
+	}
+	return versions
+}
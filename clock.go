@@ -0,0 +1,52 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"time"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// WithClock uses a custom clock for the times that the store stamps, like
+// the ReceivedAt time and the global position time of saved events, the
+// times of outbox records and checkpoints, and the current activity bucket,
+// so that tests and deterministic replays can control time. The timestamps
+// of the events themselves are set by their creators, and durations for
+// metrics and rate limits always use the wall clock.
+func WithClock(now func() time.Time) Option {
+	return func(s *EventStore) error {
+		s.clock = now
+		return nil
+	}
+}
+
+// now returns the current time of the clock of the store.
+func (s *EventStore) now() time.Time {
+	if s.clock != nil {
+		return s.clock()
+	}
+	return time.Now()
+}
+
+// ReceivedAt returns when an event loaded from the store was received by
+// Save, according to the clock of the store, or the zero time for events that
+// were saved before it was stamped.
+func ReceivedAt(e eh.Event) time.Time {
+	if e, ok := e.(event); ok {
+		return e.ReceivedAt
+	}
+	return time.Time{}
+}
@@ -0,0 +1,47 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestClock will stamp saved events with the time of a custom clock
+func (suite *EventStoreTestSuite) TestClock() {
+	now := time.Date(2020, time.January, 1, 12, 0, 0, 0, time.UTC)
+	store := suite.newStore(WithClock(func() time.Time { return now }))
+
+	id := uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	event := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event1"}, timestamp, mocks.AggregateType, id, 1)
+	saved, err := store.SaveWithResult(suite.ctx, []eh.Event{event}, 0)
+	assert.Nil(suite.T(), err)
+	if assert.Len(suite.T(), saved, 1) {
+		assert.Equal(suite.T(), now, saved[0].WrittenAt)
+		assert.Equal(suite.T(), now, ReceivedAt(saved[0].Event))
+	}
+
+	events, err := store.Load(suite.ctx, id)
+	assert.Nil(suite.T(), err)
+	if assert.Len(suite.T(), events, 1) {
+		assert.True(suite.T(), timestamp.Equal(events[0].Timestamp()))
+		assert.True(suite.T(), now.Equal(ReceivedAt(events[0])))
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command eh-dynamodb manages the DynamoDB tables of the event store, repos
+// and snapshot stores.
+//
+// Usage:
+//
+//	eh-dynamodb apply -f manifest.json [-dry-run] [-prune] [-region r] [-endpoint url]
+//
+// The apply command converges the tables to a JSON manifest: missing tables
+// are created, missing indexes and streams are added and any other drift is
+// reported. Tables that were managed by apply for the environment of the
+// manifest but are no longer in it are only deleted with -prune.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	ehdynamodb "github.com/sysbot/eh-dynamodb"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	switch os.Args[1] {
+	case "apply":
+		if err := apply(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			os.Exit(1)
+		}
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: eh-dynamodb apply -f manifest.json [-dry-run] [-prune] [-region r] [-endpoint url]")
+	os.Exit(2)
+}
+
+func apply(args []string) error {
+	flags := flag.NewFlagSet("apply", flag.ExitOnError)
+	file := flags.String("f", "", "the manifest file")
+	dryRun := flags.Bool("dry-run", false, "only report the changes")
+	prune := flags.Bool("prune", false, "delete managed tables that are no longer in the manifest")
+	region := flags.String("region", "", "the AWS region")
+	endpoint := flags.String("endpoint", "", "a custom DynamoDB endpoint")
+	flags.Parse(args)
+
+	if *file == "" {
+		return fmt.Errorf("missing manifest file")
+	}
+	f, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	manifest, err := ehdynamodb.ReadManifest(f)
+	if err != nil {
+		return err
+	}
+
+	cfg := aws.NewConfig()
+	if *region != "" {
+		cfg = cfg.WithRegion(*region)
+	}
+	if *endpoint != "" {
+		cfg = cfg.WithEndpoint(*endpoint)
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            *cfg,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return err
+	}
+
+	changes, err := ehdynamodb.Apply(context.Background(), sess, manifest, ehdynamodb.ApplyOptions{
+		DryRun: *dryRun,
+		Prune:  *prune,
+	})
+	for _, change := range changes {
+		fmt.Println(change)
+	}
+	return err
+}
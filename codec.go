@@ -0,0 +1,55 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"encoding/json"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// Codec encodes event data to and from the bytes stored in the EncodedData attribute
+// of event items, for example as JSON, protobuf or msgpack.
+type Codec interface {
+	// Marshal encodes event data.
+	Marshal(data eh.EventData) ([]byte, error)
+	// Unmarshal decodes event data into data, which is created by the
+	// registered event data factory of the event type.
+	Unmarshal(b []byte, data eh.EventData) error
+}
+
+// JSONCodec is a Codec that stores event data as compact JSON.
+type JSONCodec struct{}
+
+// Marshal implements the Marshal method of the Codec interface.
+func (JSONCodec) Marshal(data eh.EventData) ([]byte, error) {
+	return json.Marshal(data)
+}
+
+// Unmarshal implements the Unmarshal method of the Codec interface.
+func (JSONCodec) Unmarshal(b []byte, data eh.EventData) error {
+	return json.Unmarshal(b, data)
+}
+
+// WithEventCodec stores event data encoded by a codec instead of as a
+// DynamoDB attribute map. Events that are already stored as attribute maps
+// are still decoded, so the codec can be changed on an existing table; events
+// stored with a codec can only be decoded with the same codec.
+func WithEventCodec(c Codec) Option {
+	return func(s *EventStore) error {
+		s.codec = c
+		return nil
+	}
+}
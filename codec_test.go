@@ -0,0 +1,72 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/guregu/dynamo"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONCodec(t *testing.T) {
+	b, err := JSONCodec{}.Marshal(&mocks.EventData{Content: "event1"})
+	assert.Nil(t, err)
+	assert.Contains(t, string(b), `"event1"`)
+
+	data := &mocks.EventData{}
+	assert.Nil(t, JSONCodec{}.Unmarshal(b, data))
+	assert.Equal(t, &mocks.EventData{Content: "event1"}, data)
+}
+
+// TestEventCodec will save events with a codec and still load legacy events
+func (suite *EventStoreTestSuite) TestEventCodec() {
+	id := uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	legacy := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event1"},
+		timestamp, mocks.AggregateType, id, 1)
+	assert.Nil(suite.T(), suite.store.Save(suite.ctx, []eh.Event{legacy}, 0))
+
+	store := suite.newStore(WithEventCodec(JSONCodec{}))
+
+	encoded := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event2"},
+		timestamp, mocks.AggregateType, id, 2)
+	assert.Nil(suite.T(), store.Save(suite.ctx, []eh.Event{encoded}, 1))
+
+	var e dbEvent
+	err := store.service.Table(store.tableName(suite.ctx)).
+		Get("AggregateID", id.String()).Range("Version", dynamo.Equal, 2).One(&e)
+	assert.Nil(suite.T(), err)
+	assert.Contains(suite.T(), string(e.EncodedData), `"event2"`)
+	assert.Nil(suite.T(), e.RawData)
+
+	events, err := store.Load(suite.ctx, id)
+	assert.Nil(suite.T(), err)
+	if assert.Len(suite.T(), events, 2) {
+		assert.Equal(suite.T(), legacy.Data(), events[0].Data())
+		assert.Equal(suite.T(), encoded.Data(), events[1].Data())
+	}
+
+	// Without the codec the encoded event can not be decoded.
+	_, err = suite.store.Load(suite.ctx, id)
+	if esErr, ok := err.(eh.EventStoreError); !ok || !errors.Is(esErr.Err, ErrCouldNotUnmarshalEvent) {
+		suite.T().Fatal("there should be an unmarshal error:", err)
+	}
+}
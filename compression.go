@@ -0,0 +1,130 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// Compression compresses the encoded event data. Its name is stored on the
+// event item to select the decompression when loading.
+type Compression interface {
+	// Name is the name of the compression, like "gzip".
+	Name() string
+	// Compress compresses data.
+	Compress(data []byte) ([]byte, error)
+	// Decompress decompresses data.
+	Decompress(data []byte) ([]byte, error)
+}
+
+// GzipCompression is a Compression using gzip.
+type GzipCompression struct{}
+
+// Name implements the Name method of the Compression interface.
+func (GzipCompression) Name() string {
+	return "gzip"
+}
+
+// Compress implements the Compress method of the Compression interface.
+func (GzipCompression) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress implements the Decompress method of the Compression interface.
+func (GzipCompression) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// WithCompression compresses event data that is at least minSize bytes when
+// encoded, which cuts the write capacity used by verbose event data. Without
+// a codec the data is encoded as JSON before it is compressed. Other
+// algorithms like zstd can be used by implementing Compression. Events that
+// were stored with gzip can always be loaded.
+func WithCompression(c Compression, minSize int) Option {
+	return func(s *EventStore) error {
+		s.compression = c
+		s.compressionMinSize = minSize
+		return nil
+	}
+}
+
+// compressData compresses the data of an event item if it is large enough.
+func (s *EventStore) compressData(event eh.Event, e *dbEvent) error {
+	if s.compression == nil || event.Data() == nil {
+		return nil
+	}
+
+	data := e.EncodedData
+	isJSON := data == nil
+	if isJSON {
+		var err error
+		if data, err = (JSONCodec{}).Marshal(event.Data()); err != nil {
+			return err
+		}
+	}
+	if len(data) < s.compressionMinSize {
+		return nil
+	}
+
+	compressed, err := s.compression.Compress(data)
+	if err != nil {
+		return err
+	}
+
+	e.EncodedData = compressed
+	e.Compression = s.compression.Name()
+	e.DataJSON = isJSON
+	e.RawData = nil
+	return nil
+}
+
+// decompressData decompresses the data of an event item.
+func (s *EventStore) decompressData(e *dbEvent) error {
+	var c Compression
+	switch {
+	case s.compression != nil && s.compression.Name() == e.Compression:
+		c = s.compression
+	case e.Compression == (GzipCompression{}).Name():
+		c = GzipCompression{}
+	default:
+		return fmt.Errorf("unknown compression %q", e.Compression)
+	}
+
+	data, err := c.Decompress(e.EncodedData)
+	if err != nil {
+		return err
+	}
+	e.EncodedData = data
+	e.Compression = ""
+	return nil
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompression(t *testing.T) {
+	ctx := context.Background()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	small := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "small"},
+		timestamp, mocks.AggregateType, uuid.New(), 1)
+	large := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: strings.Repeat("large", 100)},
+		timestamp, mocks.AggregateType, uuid.New(), 1)
+
+	for _, codec := range []Codec{nil, JSONCodec{}} {
+		s := &EventStore{codec: codec}
+		assert.Nil(t, WithCompression(GzipCompression{}, 100)(s))
+
+		e, err := s.newDBEvent(ctx, small)
+		assert.Nil(t, err)
+		assert.Empty(t, e.Compression, "small events should not be compressed")
+
+		e, err = s.newDBEvent(ctx, large)
+		assert.Nil(t, err)
+		assert.Equal(t, "gzip", e.Compression)
+		assert.Nil(t, e.RawData)
+		assert.True(t, len(e.EncodedData) < 100)
+
+		// Gzip compressed events can be loaded without compression enabled.
+		for _, store := range []*EventStore{s, {codec: codec}} {
+			loaded, err := store.buildEvent(ctx, *e)
+			assert.Nil(t, err)
+			assert.Equal(t, large.Data(), loaded.Data())
+		}
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/google/uuid"
+	"github.com/guregu/dynamo"
+)
+
+// ErrConditionCheckFailed is when a condition check of a save failed.
+var ErrConditionCheckFailed = errors.New("condition check failed")
+
+type conditionChecksKey int
+
+const (
+	// conditionChecksCtxKey is the context key of the condition checks.
+	conditionChecksCtxKey conditionChecksKey = iota
+	// transactItemsCtxKey is the context key of the transact items.
+	transactItemsCtxKey
+)
+
+// ConditionCheck is a condition on another item that must hold for a save to
+// succeed. The item is only checked, not written, in the same transaction as
+// the save, which protects invariants across records against write skew.
+type ConditionCheck struct {
+	// TableName is the table of the item.
+	TableName string
+	// Key is the key attributes of the item.
+	Key map[string]interface{}
+	// Condition is the condition expression, for example
+	// "attribute_exists(ID) AND #status = :active".
+	Condition string
+	// Names are the expression attribute names of the condition, if any.
+	Names map[string]string
+	// Values are the expression attribute values of the condition, if any.
+	Values map[string]interface{}
+}
+
+// EntityCondition returns a condition check on a read model entity, keyed
+// by ID, in a repo table.
+func EntityCondition(tableName string, id uuid.UUID, condition string, values map[string]interface{}) ConditionCheck {
+	return ConditionCheck{
+		TableName: tableName,
+		Key:       map[string]interface{}{"ID": id.String()},
+		Condition: condition,
+		Values:    values,
+	}
+}
+
+// EntityExists returns a condition check that a read model entity exists.
+func EntityExists(tableName string, id uuid.UUID) ConditionCheck {
+	return EntityCondition(tableName, id, "attribute_exists(ID)", nil)
+}
+
+// NewContextWithConditionChecks returns a context for the Save of the event
+// store or a repo that makes the save conditional on the checks. The save
+// and the checks are written as one transaction, and the save fails with
+// ErrConditionCheckFailed if any of the checks fails.
+func NewContextWithConditionChecks(ctx context.Context, checks ...ConditionCheck) context.Context {
+	return context.WithValue(ctx, conditionChecksCtxKey, append(conditionChecksFromContext(ctx), checks...))
+}
+
+// NewContextWithTransactItems returns a context for the Save of the event
+// store or a repo that writes the items in the same transaction as the save,
+// for example to reserve a unique username with a conditional Put. The save
+// fails with ErrConditionCheckFailed if the condition of any of the items
+// fails. The items count against the limit of 100 items of a transaction.
+func NewContextWithTransactItems(ctx context.Context, items ...*dynamodb.TransactWriteItem) context.Context {
+	return context.WithValue(ctx, transactItemsCtxKey, append(transactItemsFromContext(ctx), items...))
+}
+
+// transactItemsFromContext returns the transact items of the context.
+func transactItemsFromContext(ctx context.Context) []*dynamodb.TransactWriteItem {
+	items, _ := ctx.Value(transactItemsCtxKey).([]*dynamodb.TransactWriteItem)
+	return items
+}
+
+// conditionChecksFromContext returns the condition checks of the context.
+func conditionChecksFromContext(ctx context.Context) []ConditionCheck {
+	checks, _ := ctx.Value(conditionChecksCtxKey).([]ConditionCheck)
+	return checks
+}
+
+// transactItem returns the condition check as an item of a transaction.
+func (c ConditionCheck) transactItem() (*dynamodb.TransactWriteItem, error) {
+	key, err := marshalAttributes(c.Key)
+	if err != nil {
+		return nil, err
+	}
+	check := &dynamodb.ConditionCheck{
+		TableName:           aws.String(c.TableName),
+		Key:                 key,
+		ConditionExpression: aws.String(c.Condition),
+	}
+	if len(c.Names) > 0 {
+		check.ExpressionAttributeNames = aws.StringMap(c.Names)
+	}
+	if len(c.Values) > 0 {
+		if check.ExpressionAttributeValues, err = marshalAttributes(c.Values); err != nil {
+			return nil, err
+		}
+	}
+	return &dynamodb.TransactWriteItem{ConditionCheck: check}, nil
+}
+
+// conditionCheckItems returns the condition checks and the transact items of
+// the context as items of a transaction.
+func conditionCheckItems(ctx context.Context) ([]*dynamodb.TransactWriteItem, error) {
+	checks := conditionChecksFromContext(ctx)
+	items := make([]*dynamodb.TransactWriteItem, len(checks))
+	for i, c := range checks {
+		item, err := c.transactItem()
+		if err != nil {
+			return nil, err
+		}
+		items[i] = item
+	}
+	return append(items, transactItemsFromContext(ctx)...), nil
+}
+
+// isConditionCheckFailed checks if a transaction failed on one of its
+// condition checks or transact items of the context, rather than on one of
+// its own writes.
+func isConditionCheckFailed(ctx context.Context, err error, items []*dynamodb.TransactWriteItem) bool {
+	var txErr *dynamodb.TransactionCanceledException
+	if !errors.As(err, &txErr) {
+		return false
+	}
+	for i, reason := range txErr.CancellationReasons {
+		if aws.StringValue(reason.Code) == "ConditionalCheckFailed" && i < len(items) &&
+			(items[i].ConditionCheck != nil || isTransactItem(ctx, items[i])) {
+			return true
+		}
+	}
+	return false
+}
+
+// isTransactItem checks if an item is one of the transact items of the
+// context.
+func isTransactItem(ctx context.Context, item *dynamodb.TransactWriteItem) bool {
+	for _, i := range transactItemsFromContext(ctx) {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
+
+// marshalAttributes marshals the values of a map to attribute values.
+func marshalAttributes(values map[string]interface{}) (map[string]*dynamodb.AttributeValue, error) {
+	attrs := make(map[string]*dynamodb.AttributeValue, len(values))
+	for name, v := range values {
+		av, err := dynamo.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		attrs[name] = av
+	}
+	return attrs, nil
+}
@@ -0,0 +1,99 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConditionChecks(t *testing.T) {
+	id := uuid.New()
+	ctx := NewContextWithConditionChecks(context.Background(), EntityExists("parents", id))
+	ctx = NewContextWithConditionChecks(ctx, ConditionCheck{
+		TableName: "other",
+		Key:       map[string]interface{}{"AggregateID": id.String(), "Version": -1},
+		Condition: "#v < :max",
+		Names:     map[string]string{"#v": "CurrentVersion"},
+		Values:    map[string]interface{}{":max": 10},
+	})
+
+	items, err := conditionCheckItems(ctx)
+	assert.Nil(t, err)
+	if assert.Len(t, items, 2) {
+		assert.Equal(t, &dynamodb.ConditionCheck{
+			TableName:           aws.String("parents"),
+			Key:                 map[string]*dynamodb.AttributeValue{"ID": {S: aws.String(id.String())}},
+			ConditionExpression: aws.String("attribute_exists(ID)"),
+		}, items[0].ConditionCheck)
+		assert.Equal(t, &dynamodb.ConditionCheck{
+			TableName: aws.String("other"),
+			Key: map[string]*dynamodb.AttributeValue{
+				"AggregateID": {S: aws.String(id.String())},
+				"Version":     {N: aws.String("-1")},
+			},
+			ConditionExpression:       aws.String("#v < :max"),
+			ExpressionAttributeNames:  map[string]*string{"#v": aws.String("CurrentVersion")},
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":max": {N: aws.String("10")}},
+		}, items[1].ConditionCheck)
+	}
+
+	items, err = conditionCheckItems(context.Background())
+	assert.Nil(t, err)
+	assert.Len(t, items, 0)
+}
+
+func TestIsConditionCheckFailed(t *testing.T) {
+	items := []*dynamodb.TransactWriteItem{
+		{Put: &dynamodb.Put{}},
+		{ConditionCheck: &dynamodb.ConditionCheck{}},
+	}
+	reasons := func(codes ...string) error {
+		err := &dynamodb.TransactionCanceledException{}
+		for _, code := range codes {
+			err.CancellationReasons = append(err.CancellationReasons, &dynamodb.CancellationReason{Code: aws.String(code)})
+		}
+		return err
+	}
+
+	assert.True(t, isConditionCheckFailed(context.Background(), reasons("None", "ConditionalCheckFailed"), items))
+	assert.False(t, isConditionCheckFailed(context.Background(), reasons("ConditionalCheckFailed", "None"), items))
+	assert.False(t, isConditionCheckFailed(context.Background(), nil, items))
+
+	// Transact items of the context are checked too.
+	reserve := &dynamodb.TransactWriteItem{Put: &dynamodb.Put{ConditionExpression: aws.String("attribute_not_exists(ID)")}}
+	ctx := NewContextWithTransactItems(context.Background(), reserve)
+	items = append(items, reserve)
+	assert.True(t, isConditionCheckFailed(ctx, reasons("None", "None", "ConditionalCheckFailed"), items))
+	assert.False(t, isConditionCheckFailed(context.Background(), reasons("None", "None", "ConditionalCheckFailed"), items))
+}
+
+func TestTransactItems(t *testing.T) {
+	reserve := &dynamodb.TransactWriteItem{Put: &dynamodb.Put{TableName: aws.String("usernames")}}
+	ctx := NewContextWithConditionChecks(context.Background(), EntityExists("table", uuid.New()))
+	ctx = NewContextWithTransactItems(ctx, reserve)
+
+	items, err := conditionCheckItems(ctx)
+	assert.Nil(t, err)
+	if assert.Len(t, items, 2) {
+		assert.NotNil(t, items[0].ConditionCheck)
+		assert.Equal(t, reserve, items[1])
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// RetryOnConflict runs fn, and runs it again after a backoff while it fails
+// on a version conflict, up to the attempts of the retry policy. fn must
+// reload the aggregate and apply the command again, as in the optimistic
+// concurrency loop of a command handler. It returns the error of the last
+// attempt, or the error of the context if it is done during a backoff.
+func RetryOnConflict(ctx context.Context, p RetryPolicy, fn func(ctx context.Context) error) error {
+	r := newRetryer(p)
+	for attempt := 0; ; attempt++ {
+		err := fn(ctx)
+		if err == nil || !IsVersionConflict(err) || attempt+1 >= r.policy.MaxAttempts {
+			return err
+		}
+
+		t := time.NewTimer(r.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// SaveWithRetry loads the events of an aggregate, saves the events returned
+// by apply for them at the version of the aggregate from AggregateVersion,
+// and does it again with RetryOnConflict when another save of the aggregate
+// came first. apply is called with the events of every attempt, and must not
+// have side effects other than its result.
+func (s *EventStore) SaveWithRetry(ctx context.Context, id uuid.UUID, p RetryPolicy,
+	apply func(ctx context.Context, events []eh.Event) ([]eh.Event, error)) error {
+	ctx, err := s.namespace(ctx)
+	if err != nil {
+		return err
+	}
+
+	return RetryOnConflict(ctx, p, func(ctx context.Context) error {
+		// Read the version before the events, a save in between is then a
+		// conflict instead of being lost.
+		version, err := s.AggregateVersion(ctx, id)
+		if err != nil {
+			return err
+		}
+		events, err := s.Load(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		newEvents, err := apply(ctx, events)
+		if err != nil || len(newEvents) == 0 {
+			return err
+		}
+		return s.Save(ctx, newEvents, version)
+	})
+}
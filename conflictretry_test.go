@@ -0,0 +1,123 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryOnConflict(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	conflict := eh.EventStoreError{Err: VersionConflictError{Version: 1}}
+
+	attempts := 0
+	err := RetryOnConflict(context.Background(), p, func(ctx context.Context) error {
+		if attempts++; attempts < 2 {
+			return conflict
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, attempts)
+
+	attempts = 0
+	err = RetryOnConflict(context.Background(), p, func(ctx context.Context) error {
+		attempts++
+		return conflict
+	})
+	assert.Equal(t, conflict, err)
+	assert.Equal(t, 3, attempts)
+
+	attempts = 0
+	other := errors.New("other")
+	err = RetryOnConflict(context.Background(), p, func(ctx context.Context) error {
+		attempts++
+		return other
+	})
+	assert.Equal(t, other, err)
+	assert.Equal(t, 1, attempts)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = RetryOnConflict(ctx, RetryPolicy{MinBackoff: time.Hour}, func(ctx context.Context) error {
+		return conflict
+	})
+	assert.Equal(t, context.Canceled, err)
+}
+
+// TestSaveWithRetry will apply a command again after a concurrent save
+func (suite *EventStoreTestSuite) TestSaveWithRetry() {
+	id := uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	newEvent := func(content string, version int) eh.Event {
+		return eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: content},
+			timestamp, mocks.AggregateType, id, version)
+	}
+	assert.Nil(suite.T(), suite.store.Save(suite.ctx, []eh.Event{newEvent("event1", 1)}, 0))
+
+	p := RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond}
+	attempts := 0
+	err := suite.store.SaveWithRetry(suite.ctx, id, p, func(ctx context.Context, events []eh.Event) ([]eh.Event, error) {
+		attempts++
+		if attempts == 1 {
+			// Another save of the aggregate comes first.
+			assert.Nil(suite.T(), suite.store.Save(ctx, []eh.Event{newEvent("concurrent", 2)}, 1))
+		}
+		return []eh.Event{newEvent("command", len(events)+1)}, nil
+	})
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 2, attempts)
+
+	events, err := suite.store.Load(suite.ctx, id)
+	assert.Nil(suite.T(), err)
+	if assert.Len(suite.T(), events, 3) {
+		assert.Equal(suite.T(), &mocks.EventData{Content: "concurrent"}, events[1].Data())
+		assert.Equal(suite.T(), &mocks.EventData{Content: "command"}, events[2].Data())
+	}
+}
+
+// TestSaveWithRetryLoadFilter will save at the version of the aggregate when
+// the loaded events leave some out
+func (suite *EventStoreTestSuite) TestSaveWithRetryLoadFilter() {
+	id := uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	event1 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event1"}, timestamp, mocks.AggregateType, id, 1)
+	event2 := eh.NewEventForAggregate(mocks.EventOtherType, &mocks.EventData{Content: "event2"}, timestamp, mocks.AggregateType, id, 2)
+	event3 := eh.NewEventForAggregate(mocks.EventOtherType, &mocks.EventData{Content: "event3"}, timestamp, mocks.AggregateType, id, 3)
+	assert.Nil(suite.T(), suite.store.Save(suite.ctx, []eh.Event{event1, event2, event3}, 0))
+
+	store := suite.newStore(WithLoadFilter(EventFilter{ExcludedEventTypes: []eh.EventType{mocks.EventOtherType}}))
+	p := RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond}
+	attempts := 0
+	err := store.SaveWithRetry(suite.ctx, id, p, func(ctx context.Context, events []eh.Event) ([]eh.Event, error) {
+		attempts++
+		return []eh.Event{eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "command"},
+			timestamp, mocks.AggregateType, id, 4)}, nil
+	})
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 1, attempts)
+
+	version, err := store.AggregateVersion(suite.ctx, id)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 4, version)
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+)
+
+// ReadConsistency is the consistency of reads.
+type ReadConsistency int
+
+const (
+	// StronglyConsistent reads return all writes that succeeded before the
+	// read. It is the default.
+	StronglyConsistent ReadConsistency = iota
+	// EventuallyConsistent reads may not return the most recent writes, for
+	// half the read capacity of strongly consistent reads.
+	EventuallyConsistent
+)
+
+type readConsistencyKey int
+
+// readConsistencyCtxKey is the context key of the read consistency.
+const readConsistencyCtxKey readConsistencyKey = iota
+
+// WithReadConsistency sets the consistency of Load, LoadFrom, LoadEach,
+// LoadPage and LoadAll. Saves always read consistently.
+func WithReadConsistency(c ReadConsistency) Option {
+	return func(s *EventStore) error {
+		s.readConsistency = c
+		return nil
+	}
+}
+
+// WithRepoReadConsistency sets the consistency of Find, FindAll, FindAllPage
+// and FindWithFilter.
+func WithRepoReadConsistency(c ReadConsistency) OptionRepo {
+	return func(r *Repo) error {
+		r.readConsistency = c
+		return nil
+	}
+}
+
+// NewContextWithReadConsistency returns a context for reads of the event store
+// or a repo with a consistency that overrides the one of the store or repo.
+func NewContextWithReadConsistency(ctx context.Context, c ReadConsistency) context.Context {
+	return context.WithValue(ctx, readConsistencyCtxKey, c)
+}
+
+// consistentRead returns if a read should be strongly consistent, from the
+// consistency of the context or else the default.
+func consistentRead(ctx context.Context, def ReadConsistency) bool {
+	if c, ok := ctx.Value(readConsistencyCtxKey).(ReadConsistency); ok {
+		return c == StronglyConsistent
+	}
+	return def == StronglyConsistent
+}
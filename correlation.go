@@ -0,0 +1,196 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// ErrIndexNotEnabled is when a query needs an index that is not enabled.
+var ErrIndexNotEnabled = errors.New("index not enabled")
+
+const (
+	// CorrelationIDKey is the metadata key of the correlation ID of an event.
+	CorrelationIDKey = "correlation_id"
+	// CausationIDKey is the metadata key of the causation ID of an event.
+	CausationIDKey = "causation_id"
+
+	// correlationIndexName is the name of the correlation ID index.
+	correlationIndexName = "CorrelationIDIndex"
+)
+
+type correlationKey int
+
+const (
+	correlationIDCtxKey correlationKey = iota
+	causationIDCtxKey
+)
+
+// correlationIndex is the index used to query events by correlation ID.
+var correlationIndex = tableIndex{
+	name:         correlationIndexName,
+	hashKey:      "CorrelationID",
+	hashKeyType:  dynamodb.ScalarAttributeTypeS,
+	rangeKey:     "Timestamp",
+	rangeKeyType: dynamodb.ScalarAttributeTypeS,
+}
+
+// NewContextWithCorrelationID returns a context with a correlation ID, which
+// is stamped into the metadata of events saved with the context.
+func NewContextWithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationIDCtxKey, id)
+}
+
+// CorrelationIDFromContext returns the correlation ID of a context.
+func CorrelationIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(correlationIDCtxKey).(string)
+	return id
+}
+
+// NewContextWithCausationID returns a context with a causation ID, which is
+// stamped into the metadata of events saved with the context.
+func NewContextWithCausationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, causationIDCtxKey, id)
+}
+
+// CausationIDFromContext returns the causation ID of a context.
+func CausationIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(causationIDCtxKey).(string)
+	return id
+}
+
+// WithCorrelationIndex adds a global secondary index on the correlation ID of
+// events to the table in CreateTable, which is needed by QueryByCorrelationID
+// and used by FindByCorrelationID.
+func WithCorrelationIndex() Option {
+	return func(s *EventStore) error {
+		s.indexes = append(s.indexes, correlationIndex)
+		return nil
+	}
+}
+
+// QueryByCorrelationID loads all events with a correlation ID, across all
+// aggregates, ordered by timestamp. It needs the WithCorrelationIndex option.
+func (s *EventStore) QueryByCorrelationID(ctx context.Context, id string) ([]eh.Event, error) {
+	ctx, err := s.namespace(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if !s.hasIndex(correlationIndexName) {
+		return nil, eh.EventStoreError{
+			Err:       ErrIndexNotEnabled,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	return s.findByCorrelationID(ctx, id)
+}
+
+// FindByCorrelationID loads all events with a correlation ID, across all
+// aggregates, ordered by timestamp, to trace a command through the system.
+// The correlation ID index is used if enabled with WithCorrelationIndex,
+// otherwise the event tables are scanned, which is only suitable for small
+// tables or occasional use.
+func (s *EventStore) FindByCorrelationID(ctx context.Context, id string) ([]eh.Event, error) {
+	ctx, err := s.namespace(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.findByCorrelationID(ctx, id)
+}
+
+func (s *EventStore) findByCorrelationID(ctx context.Context, id string) ([]eh.Event, error) {
+	tables, err := s.eventTables(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	useIndex := s.hasIndex(correlationIndexName)
+	var dbEvents []dbEvent
+	for _, tableName := range tables {
+		table := s.service.Table(tableName)
+
+		var tableEvents []dbEvent
+		start := time.Now()
+		if useIndex {
+			err = table.Get("CorrelationID", id).Index(correlationIndexName).AllWithContext(ctx, &tableEvents)
+			observe(ctx, s.metrics, OperationQuery, tableName, start, err)
+		} else {
+			err = table.Scan().Filter("CorrelationID = ?", id).Consistent(true).AllWithContext(ctx, &tableEvents)
+			observe(ctx, s.metrics, OperationScan, tableName, start, err)
+		}
+		if err != nil {
+			return nil, eh.EventStoreError{
+				BaseErr:   withRequestID(err),
+				Err:       err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+		dbEvents = append(dbEvents, tableEvents...)
+	}
+
+	// A scan is unordered and every table is queried on its own.
+	sort.SliceStable(dbEvents, func(i, j int) bool {
+		return dbEvents[i].Timestamp.Before(dbEvents[j].Timestamp)
+	})
+
+	return s.buildEvents(ctx, dbEvents)
+}
+
+// hasIndex checks if an index is enabled.
+func (s *EventStore) hasIndex(name string) bool {
+	for _, index := range s.indexes {
+		if index.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// correlationMetadata returns the metadata of an event with the correlation
+// and causation IDs of the context added, unless already set. The metadata of
+// the event itself is never modified.
+func correlationMetadata(ctx context.Context, metadata map[string]interface{}) map[string]interface{} {
+	ids := map[string]string{
+		CorrelationIDKey: CorrelationIDFromContext(ctx),
+		CausationIDKey:   CausationIDFromContext(ctx),
+	}
+
+	var result map[string]interface{}
+	for key, id := range ids {
+		if _, ok := metadata[key]; ok || id == "" {
+			continue
+		}
+		if result == nil {
+			result = make(map[string]interface{}, len(metadata)+len(ids))
+			for k, v := range metadata {
+				result[k] = v
+			}
+		}
+		result[key] = id
+	}
+	if result == nil {
+		return metadata
+	}
+	return result
+}
@@ -0,0 +1,115 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCorrelationMetadata(t *testing.T) {
+	metadata := map[string]interface{}{"key": "value"}
+
+	// Without IDs in the context the metadata is used as is.
+	assert.Equal(t, metadata, correlationMetadata(context.Background(), metadata))
+
+	ctx := NewContextWithCorrelationID(context.Background(), "correlation")
+	ctx = NewContextWithCausationID(ctx, "causation")
+	assert.Equal(t, "correlation", CorrelationIDFromContext(ctx))
+	assert.Equal(t, "causation", CausationIDFromContext(ctx))
+
+	assert.Equal(t, map[string]interface{}{
+		"key":            "value",
+		CorrelationIDKey: "correlation",
+		CausationIDKey:   "causation",
+	}, correlationMetadata(ctx, metadata))
+	assert.Equal(t, map[string]interface{}{"key": "value"}, metadata, "metadata should not be modified")
+
+	// IDs already in the metadata are kept.
+	assert.Equal(t, map[string]interface{}{
+		CorrelationIDKey: "existing",
+		CausationIDKey:   "causation",
+	}, correlationMetadata(ctx, map[string]interface{}{CorrelationIDKey: "existing"}))
+}
+
+// TestQueryByCorrelationID will query the events of a correlation across aggregates
+func (suite *EventStoreTestSuite) TestQueryByCorrelationID() {
+	_, err := suite.store.QueryByCorrelationID(context.Background(), "correlation")
+	if esErr, ok := err.(eh.EventStoreError); !ok || !errors.Is(esErr.Err, ErrIndexNotEnabled) {
+		suite.T().Fatal("there should be an index not enabled error:", err)
+	}
+
+	store := suite.newStore(WithCorrelationIndex())
+
+	ctx := eh.NewContextWithNamespace(context.Background(), "correlation")
+	assert.Nil(suite.T(), store.CreateTable(ctx))
+	defer store.DeleteTable(ctx)
+
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	event1 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event1"},
+		timestamp, mocks.AggregateType, uuid.New(), 1)
+	event2 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event2"},
+		timestamp.Add(time.Second), mocks.AggregateType, uuid.New(), 1)
+	other := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "other"},
+		timestamp, mocks.AggregateType, uuid.New(), 1)
+
+	correlated := NewContextWithCorrelationID(ctx, "correlation")
+	assert.Nil(suite.T(), store.Save(correlated, []eh.Event{event1}, 0))
+	assert.Nil(suite.T(), store.Save(NewContextWithCausationID(correlated, event1.AggregateID().String()), []eh.Event{event2}, 0))
+	assert.Nil(suite.T(), store.Save(ctx, []eh.Event{other}, 0))
+
+	events, err := store.QueryByCorrelationID(ctx, "correlation")
+	assert.Nil(suite.T(), err)
+	if assert.Len(suite.T(), events, 2) {
+		assert.Equal(suite.T(), event1.Data(), events[0].Data())
+		assert.Equal(suite.T(), event2.Data(), events[1].Data())
+		assert.Equal(suite.T(), "correlation", events[1].Metadata()[CorrelationIDKey])
+		assert.Equal(suite.T(), event1.AggregateID().String(), events[1].Metadata()[CausationIDKey])
+	}
+}
+
+// TestFindByCorrelationID will find the events of a correlation without an index
+func (suite *EventStoreTestSuite) TestFindByCorrelationID() {
+	ctx := eh.NewContextWithNamespace(context.Background(), "find_correlation")
+	assert.Nil(suite.T(), suite.store.CreateTable(ctx))
+	defer suite.store.DeleteTable(ctx)
+
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	event1 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event1"},
+		timestamp.Add(time.Second), mocks.AggregateType, uuid.New(), 1)
+	event2 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event2"},
+		timestamp, mocks.AggregateType, uuid.New(), 1)
+	other := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "other"},
+		timestamp, mocks.AggregateType, uuid.New(), 1)
+
+	correlated := NewContextWithCorrelationID(ctx, "correlation")
+	assert.Nil(suite.T(), suite.store.Save(correlated, []eh.Event{event1}, 0))
+	assert.Nil(suite.T(), suite.store.Save(correlated, []eh.Event{event2}, 0))
+	assert.Nil(suite.T(), suite.store.Save(ctx, []eh.Event{other}, 0))
+
+	events, err := suite.store.FindByCorrelationID(ctx, "correlation")
+	assert.Nil(suite.T(), err)
+	if assert.Len(suite.T(), events, 2) {
+		assert.Equal(suite.T(), event2.Data(), events[0].Data())
+		assert.Equal(suite.T(), event1.Data(), events[1].Data())
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// expiredCredentialsCodes are the error codes from AWS when temporary
+// credentials, for example from STS, expired during a request.
+var expiredCredentialsCodes = []string{
+	"ExpiredToken",
+	"ExpiredTokenException",
+	"RequestExpired",
+}
+
+// isExpiredCredentials checks if an error is from expired credentials.
+func isExpiredCredentials(err error) bool {
+	for _, code := range expiredCredentialsCodes {
+		if isAWSErrorCode(err, code) {
+			return true
+		}
+	}
+	return false
+}
+
+// refreshExpiredCredentials expires the credentials of a client if err is
+// from expired credentials, so that they are retrieved again on the next
+// request. It returns true if the failed request should be retried. Long
+// operations like scans and batch writes retry once, resuming where they
+// failed, instead of aborting when credentials expire mid-operation.
+func refreshExpiredCredentials(client dynamodbiface.DynamoDBAPI, err error) bool {
+	if !isExpiredCredentials(err) {
+		return false
+	}
+	c, ok := client.(*dynamodb.DynamoDB)
+	if !ok || c.Config.Credentials == nil {
+		return false
+	}
+	c.Config.Credentials.Expire()
+	return true
+}
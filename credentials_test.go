@@ -0,0 +1,72 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingProvider is a credentials provider that counts retrievals.
+type countingProvider struct {
+	retrieved int
+}
+
+func (p *countingProvider) Retrieve() (credentials.Value, error) {
+	p.retrieved++
+	return credentials.Value{AccessKeyID: "id", SecretAccessKey: "secret"}, nil
+}
+
+func (p *countingProvider) IsExpired() bool {
+	return false
+}
+
+func TestRefreshExpiredCredentials(t *testing.T) {
+	provider := &countingProvider{}
+	creds := credentials.NewCredentials(provider)
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String("us-west-2"),
+		Credentials: creds,
+	}))
+	client := dynamodb.New(sess)
+
+	_, err := creds.Get()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, provider.retrieved)
+
+	// Other errors are not retried.
+	assert.False(t, refreshExpiredCredentials(client, errors.New("other")))
+	assert.False(t, refreshExpiredCredentials(client, awserr.New("ThrottlingException", "slow down", nil)))
+	_, err = creds.Get()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, provider.retrieved)
+
+	// Expired credentials are retrieved again.
+	expired := awserr.NewRequestFailure(awserr.New("ExpiredTokenException", "expired", nil), 400, "req-1")
+	assert.True(t, refreshExpiredCredentials(client, withRequestID(expired)))
+	_, err = creds.Get()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, provider.retrieved)
+
+	// Other clients can not be refreshed.
+	assert.False(t, refreshExpiredCredentials(nil, expired))
+}
@@ -0,0 +1,146 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cursor implements the pagination tokens of the paged APIs of the
+// event store and repo. A token is opaque to clients: it is versioned and
+// signed with HMAC-SHA256, so that it can round-trip through HTTP clients
+// without exposing the DynamoDB keys it continues from or allowing them to be
+// tampered with.
+package cursor
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// ErrInvalidCursor is when a token could not be decoded, was not signed with
+// the key of the signer or is of another kind or namespace.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Version is the version of the token format.
+const Version = 1
+
+// Cursor is the position of a page in a paged API.
+type Cursor struct {
+	// Kind is the API that the cursor is for, so that a cursor of one API can
+	// not be used with another.
+	Kind string
+	// Namespace is the namespace that the cursor is for.
+	Namespace string
+	// Key is the DynamoDB key to continue after, like a LastEvaluatedKey.
+	Key map[string]*dynamodb.AttributeValue
+}
+
+// payload is the signed part of a token.
+type payload struct {
+	Kind      string                `json:"k"`
+	Namespace string                `json:"n,omitempty"`
+	Key       map[string]keyElement `json:"p"`
+}
+
+// keyElement is an attribute of a key, which is always a string, number or
+// binary.
+type keyElement struct {
+	S *string `json:"s,omitempty"`
+	N *string `json:"n,omitempty"`
+	B []byte  `json:"b,omitempty"`
+}
+
+// Signer encodes and decodes tokens, signed with a secret key. All the
+// processes that serve the same clients must use the same key.
+type Signer struct {
+	key []byte
+}
+
+// NewSigner creates a signer with a secret key, which should be at least 32
+// random bytes.
+func NewSigner(key []byte) *Signer {
+	return &Signer{key: key}
+}
+
+// Encode returns the token of a cursor.
+func (s *Signer) Encode(c Cursor) (string, error) {
+	p := payload{
+		Kind:      c.Kind,
+		Namespace: c.Namespace,
+		Key:       make(map[string]keyElement, len(c.Key)),
+	}
+	for name, av := range c.Key {
+		if av == nil || (av.S == nil && av.N == nil && av.B == nil) {
+			return "", ErrInvalidCursor
+		}
+		p.Key[name] = keyElement{S: av.S, N: av.N, B: av.B}
+	}
+
+	b, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+
+	msg := append([]byte{Version}, b...)
+	return base64.RawURLEncoding.EncodeToString(append(msg, s.sign(msg)...)), nil
+}
+
+// Decode returns the cursor of a token, which must be of a kind and
+// namespace.
+func (s *Signer) Decode(token, kind, namespace string) (Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(b) < 1+sha256.Size {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	msg, mac := b[:len(b)-sha256.Size], b[len(b)-sha256.Size:]
+	if msg[0] != Version || !hmac.Equal(mac, s.sign(msg)) {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	var p payload
+	if err := json.Unmarshal(msg[1:], &p); err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	if p.Kind != kind || p.Namespace != namespace {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	c := Cursor{
+		Kind:      p.Kind,
+		Namespace: p.Namespace,
+		Key:       make(map[string]*dynamodb.AttributeValue, len(p.Key)),
+	}
+	for name, e := range p.Key {
+		c.Key[name] = &dynamodb.AttributeValue{S: e.S, N: e.N, B: e.B}
+	}
+	return c, nil
+}
+
+// sign returns the HMAC of a message.
+func (s *Signer) sign(msg []byte) []byte {
+	h := hmac.New(sha256.New, s.key)
+	h.Write(msg)
+	return h.Sum(nil)
+}
+
+// String returns a string attribute of a key, or "" if it has none.
+func (c Cursor) String(name string) string {
+	if av, ok := c.Key[name]; ok {
+		return aws.StringValue(av.S)
+	}
+	return ""
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cursor
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSigner(t *testing.T) {
+	s := NewSigner([]byte("secret"))
+	c := Cursor{
+		Kind:      "events",
+		Namespace: "ns",
+		Key: map[string]*dynamodb.AttributeValue{
+			"AggregateID": {S: aws.String("id")},
+			"Version":     {N: aws.String("3")},
+		},
+	}
+
+	token, err := s.Encode(c)
+	assert.Nil(t, err)
+	decoded, err := s.Decode(token, "events", "ns")
+	assert.Nil(t, err)
+	assert.Equal(t, c, decoded)
+	assert.Equal(t, "id", decoded.String("AggregateID"))
+
+	// Another kind, namespace or key is refused.
+	_, err = s.Decode(token, "entities", "ns")
+	assert.Equal(t, ErrInvalidCursor, err)
+	_, err = s.Decode(token, "events", "other")
+	assert.Equal(t, ErrInvalidCursor, err)
+	_, err = NewSigner([]byte("other")).Decode(token, "events", "ns")
+	assert.Equal(t, ErrInvalidCursor, err)
+
+	// A tampered token is refused.
+	b, _ := base64.RawURLEncoding.DecodeString(token)
+	b[5] ^= 1
+	_, err = s.Decode(base64.RawURLEncoding.EncodeToString(b), "events", "ns")
+	assert.Equal(t, ErrInvalidCursor, err)
+
+	// Tokens of another version are refused.
+	b, _ = base64.RawURLEncoding.DecodeString(token)
+	b[0] = Version + 1
+	_, err = s.Decode(base64.RawURLEncoding.EncodeToString(b), "events", "ns")
+	assert.Equal(t, ErrInvalidCursor, err)
+
+	for _, token := range []string{"", "not base64!", "c2hvcnQ"} {
+		_, err = s.Decode(token, "events", "ns")
+		assert.Equal(t, ErrInvalidCursor, err)
+	}
+}
@@ -0,0 +1,296 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/google/uuid"
+	"github.com/guregu/dynamo"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/sysbot/eh-dynamodb/cursor"
+)
+
+// The kinds of cursors of the paged APIs.
+const (
+	cursorKindEvents    = "events"
+	cursorKindAllEvents = "all-events"
+	cursorKindEntities  = "entities"
+	cursorKindIndex     = "index"
+)
+
+// WithCursorKey signs the cursors of the paged APIs with a secret key. It
+// must be the same for all processes that serve the same clients; without it
+// a random key is used and cursors are only valid in the process that
+// created them.
+func WithCursorKey(key []byte) Option {
+	return func(s *EventStore) error {
+		s.cursors = cursor.NewSigner(key)
+		return nil
+	}
+}
+
+// WithRepoCursorKey signs the cursors of the paged APIs with a secret key, as
+// WithCursorKey.
+func WithRepoCursorKey(key []byte) OptionRepo {
+	return func(r *Repo) error {
+		r.cursors = cursor.NewSigner(key)
+		return nil
+	}
+}
+
+// newCursorSigner returns a signer with a random key.
+func newCursorSigner() (*cursor.Signer, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return cursor.NewSigner(key), nil
+}
+
+// encodeCursor returns the cursor of a key for the namespace of the context,
+// or "" for a nil key.
+func encodeCursor(ctx context.Context, signer *cursor.Signer, kind string, key map[string]*dynamodb.AttributeValue) (string, error) {
+	if key == nil {
+		return "", nil
+	}
+	return signer.Encode(cursor.Cursor{
+		Kind:      kind,
+		Namespace: eh.NamespaceFromContext(ctx),
+		Key:       key,
+	})
+}
+
+// decodeCursor returns the key of a cursor for the namespace of the context,
+// or nil for an empty cursor.
+func decodeCursor(ctx context.Context, signer *cursor.Signer, kind, token string) (map[string]*dynamodb.AttributeValue, error) {
+	if token == "" {
+		return nil, nil
+	}
+	c, err := signer.Decode(token, kind, eh.NamespaceFromContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return c.Key, nil
+}
+
+// key returns the key of an event item.
+func (e dbEvent) key() map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"AggregateID": {S: aws.String(e.AggregateID.String())},
+		"Version":     {N: aws.String(strconv.Itoa(e.Version))},
+	}
+}
+
+// eventsCursor returns the cursor of a page of events of an aggregate after
+// an event.
+func (s *EventStore) eventsCursor(ctx context.Context, after dbEvent) (string, error) {
+	return encodeCursor(ctx, s.cursors, cursorKindEvents, after.key())
+}
+
+// eventsCursorVersion returns the version to continue loading the events of
+// an aggregate at, from a cursor.
+func (s *EventStore) eventsCursorVersion(ctx context.Context, id uuid.UUID, token string) (int, error) {
+	key, err := decodeCursor(ctx, s.cursors, cursorKindEvents, token)
+	if err != nil || key == nil {
+		return 1, err
+	}
+
+	if aws.StringValue(key["AggregateID"].S) != id.String() {
+		return 0, cursor.ErrInvalidCursor
+	}
+	version, err := strconv.Atoi(aws.StringValue(key["Version"].N))
+	if err != nil {
+		return 0, cursor.ErrInvalidCursor
+	}
+	return version + 1, nil
+}
+
+// entitiesCursor returns the cursor of a page of entities after an entity.
+func (r *Repo) entitiesCursor(ctx context.Context, after eh.Entity) (string, error) {
+	return encodeCursor(ctx, r.cursors, cursorKindEntities, map[string]*dynamodb.AttributeValue{
+		"ID": {S: aws.String(after.EntityID().String())},
+	})
+}
+
+// LoadAllPage loads a page of at most limit events of all aggregates,
+// starting after the cursor, or at the start for an empty cursor. It returns
+// the cursor of the next page, which is empty when there are no more events.
+// The events are in table order, not in order of aggregate or time.
+func (s *EventStore) LoadAllPage(ctx context.Context, token string, limit int) ([]eh.Event, string, error) {
+	ctx, err := s.namespace(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	key, err := decodeCursor(ctx, s.cursors, cursorKindAllEvents, token)
+	if err != nil {
+		return nil, "", eh.EventStoreError{
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	tables, err := s.eventTables(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Skip the tables before the one of the cursor.
+	var startKey dynamo.PagingKey
+	if key != nil {
+		tableName := aws.StringValue(key["Table"].S)
+		for i, t := range tables {
+			if t == tableName {
+				tables = tables[i:]
+				break
+			}
+		}
+		if len(tables) == 0 || tables[0] != tableName {
+			return nil, "", eh.EventStoreError{
+				Err:       cursor.ErrInvalidCursor,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+		startKey = dynamo.PagingKey{s.hashKey(): key[s.hashKey()], "Version": key["Version"]}
+	}
+
+	var dbEvents []dbEvent
+	var lastTable string
+	for _, tableName := range tables {
+		scan := s.scanNamespace(ctx, s.readService().Table(tableName).Scan()).
+			Filter("Version > ?", aggregateHeadVersion).
+			Consistent(consistentRead(ctx, s.readConsistency)).
+			Limit(int64(limit + 1 - len(dbEvents))).
+			StartFrom(startKey)
+		startKey = nil
+
+		start := time.Now()
+		iter := scan.Iter()
+		var e dbEvent
+		for iter.NextWithContext(ctx, &e) {
+			dbEvents = append(dbEvents, e)
+			e = dbEvent{}
+		}
+		err := iter.Err()
+		observe(ctx, s.metrics, OperationScan, tableName, start, err)
+		if err != nil {
+			return nil, "", eh.EventStoreError{
+				BaseErr:   withRequestID(err),
+				Err:       err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+
+		lastTable = tableName
+		if len(dbEvents) > limit {
+			break
+		}
+	}
+
+	next := ""
+	if len(dbEvents) > limit {
+		dbEvents = dbEvents[:limit]
+		key := dbEvents[limit-1].key()
+		key["Table"] = &dynamodb.AttributeValue{S: aws.String(lastTable)}
+		if s.sharedTable {
+			key[sharedHashKey] = &dynamodb.AttributeValue{S: aws.String(dbEvents[limit-1].PK)}
+		}
+		if next, err = encodeCursor(ctx, s.cursors, cursorKindAllEvents, key); err != nil {
+			return nil, "", eh.EventStoreError{
+				Err:       err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+	}
+
+	events, err := s.buildEvents(ctx, dbEvents)
+	if err != nil {
+		return nil, "", err
+	}
+	return events, next, nil
+}
+
+// QueryIndexPage queries a page of at most limit items of an index, as
+// QueryIndex, starting after the cursor, or at the start for an empty cursor.
+// It returns the cursor of the next page, which is empty when there are no
+// more items.
+func (r *Repo) QueryIndexPage(ctx context.Context, indexInput IndexInput, token string, limit int, out interface{}, filterQuery string, filterArgs ...interface{}) (string, error) {
+	ctx, err := r.namespace(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	key, err := decodeCursor(ctx, r.cursors, cursorKindIndex, token)
+	if err != nil {
+		return "", eh.RepoError{
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	slice := reflect.ValueOf(out)
+	if slice.Kind() != reflect.Ptr || slice.Elem().Kind() != reflect.Slice {
+		return "", eh.RepoError{
+			Err:       fmt.Errorf("out must be a pointer to a slice, not %T", out),
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+	slice = slice.Elem()
+
+	tableName := r.tableName(ctx)
+	query := r.readService().Table(tableName).
+		Get(indexInput.PartitionKey, indexInput.PartitionKeyValue).
+		Index(indexInput.IndexName)
+	if indexInput.SortKey != "" {
+		query = query.Range(indexInput.SortKey, dynamo.Equal, indexInput.SortKeyValue)
+	}
+	if filterQuery != "" {
+		query = query.Filter(filterQuery, filterArgs...)
+	}
+
+	start := time.Now()
+	iter := query.Limit(int64(limit)).StartFrom(key).Iter()
+	item := reflect.New(slice.Type().Elem())
+	for iter.NextWithContext(ctx, item.Interface()) {
+		slice.Set(reflect.Append(slice, item.Elem()))
+		item = reflect.New(slice.Type().Elem())
+	}
+	err = iter.Err()
+	observe(ctx, r.metrics, OperationQuery, tableName, start, err)
+	if err != nil {
+		return "", eh.RepoError{
+			Err:       err,
+			BaseErr:   withRequestID(err),
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	next, err := encodeCursor(ctx, r.cursors, cursorKindIndex, iter.LastEvaluatedKey())
+	if err != nil {
+		return "", eh.RepoError{
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+	return next, nil
+}
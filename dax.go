@@ -0,0 +1,61 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/guregu/dynamo"
+)
+
+// WithRepoDAX reads entities through a DAX cluster, for hot read model
+// lookups at microsecond latency. The client is a DAX client, such as the one
+// of github.com/aws/aws-dax-go, which implements the DynamoDB API. Writes and
+// table management still go to DynamoDB.
+//
+// DAX passes strongly consistent reads through to DynamoDB, so reads must be
+// eventually consistent to be served by the cache, see
+// WithRepoReadConsistency.
+func WithRepoDAX(client dynamodbiface.DynamoDBAPI) OptionRepo {
+	return func(r *Repo) error {
+		r.dax = dynamo.NewFromIface(client)
+		return nil
+	}
+}
+
+// WithDAX loads events through a DAX cluster, as WithRepoDAX. Saves and
+// table management still go to DynamoDB. Loads must be eventually consistent
+// to be served by the cache, see WithReadConsistency.
+func WithDAX(client dynamodbiface.DynamoDBAPI) Option {
+	return func(s *EventStore) error {
+		s.dax = dynamo.NewFromIface(client)
+		return nil
+	}
+}
+
+// readService returns the service to read entities with.
+func (r *Repo) readService() *dynamo.DB {
+	if r.dax != nil {
+		return r.dax
+	}
+	return r.service
+}
+
+// readService returns the service to load events with.
+func (s *EventStore) readService() *dynamo.DB {
+	if s.dax != nil {
+		return s.dax
+	}
+	return s.service
+}
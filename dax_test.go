@@ -0,0 +1,44 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithDAX(t *testing.T) {
+	sess := session.Must(session.NewSession(&aws.Config{Region: aws.String("us-west-2")}))
+	dax := dynamodb.New(sess)
+
+	r, err := NewRepo("models", WithRepoDynamoDB(sess), WithRepoDAX(dax))
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, dax, r.readService().Client())
+	assert.True(t, r.service != r.readService())
+
+	s, err := NewEventStore("events", WithDynamoDB(sess))
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.True(t, s.service == s.readService())
+	assert.Nil(t, WithDAX(dax)(s))
+	assert.Equal(t, dax, s.readService().Client())
+}
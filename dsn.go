@@ -0,0 +1,209 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// ErrInvalidDSN is when a DSN could not be parsed.
+var ErrInvalidDSN = errors.New("invalid DSN")
+
+// dsnScheme is the scheme of DSNs.
+const dsnScheme = "dynamodb"
+
+// NewEventStoreFromDSN creates a new EventStore from a DSN of the form
+//
+//	dynamodb://prefix?region=eu-west-1&endpoint=http://localhost:8000&billing=on-demand&codec=json
+//
+// where prefix is the table prefix. The parameters are all optional:
+//
+//	region       the AWS region
+//	endpoint     a custom DynamoDB endpoint
+//	billing      the billing mode of new tables, on-demand or provisioned
+//	rcu, wcu     the provisioned read and write capacity of new tables
+//	codec        json to encode event data as JSON, or attributes for a
+//	             DynamoDB attribute map, which is the default
+//	consistency  the read consistency, strong or eventual
+//	env          the environment suffix of the table names, like staging
+//
+// The options are applied after the ones of the DSN.
+func NewEventStoreFromDSN(dsn string, options ...Option) (*EventStore, error) {
+	prefix, params, err := parseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	var dsnOptions []Option
+	for key, value := range params {
+		switch key {
+		case "region":
+			dsnOptions = append(dsnOptions, WithRegion(value))
+		case "endpoint":
+			dsnOptions = append(dsnOptions, WithEndpoint(value))
+		case "codec":
+			switch value {
+			case "json":
+				dsnOptions = append(dsnOptions, WithEventCodec(JSONCodec{}))
+			case "attributes":
+			default:
+				return nil, dsnParamError(key, value)
+			}
+		case "consistency":
+			c, err := parseReadConsistency(value)
+			if err != nil {
+				return nil, err
+			}
+			dsnOptions = append(dsnOptions, WithReadConsistency(c))
+		case "env":
+			dsnOptions = append(dsnOptions, WithEnvironmentSuffix(value))
+		case "billing", "rcu", "wcu":
+		default:
+			return nil, fmt.Errorf("%w: unknown parameter %q", ErrInvalidDSN, key)
+		}
+	}
+
+	cfg, err := parseDSNTableConfig(params)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.BillingMode != "" {
+		dsnOptions = append(dsnOptions, WithNamespaceConfigs(NewNamespaceConfigs(cfg)))
+	}
+
+	return NewEventStore(prefix, append(dsnOptions, options...)...)
+}
+
+// NewRepoFromDSN creates a new Repo from a DSN of the same form as for
+// NewEventStoreFromDSN, with the region, endpoint, billing, rcu, wcu,
+// consistency and env parameters. The options are applied after the ones of the DSN.
+func NewRepoFromDSN(dsn string, options ...OptionRepo) (*Repo, error) {
+	prefix, params, err := parseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	var dsnOptions []OptionRepo
+	for key, value := range params {
+		switch key {
+		case "region":
+			dsnOptions = append(dsnOptions, WithRepoRegion(value))
+		case "endpoint":
+			dsnOptions = append(dsnOptions, WithRepoEndpoint(value))
+		case "consistency":
+			c, err := parseReadConsistency(value)
+			if err != nil {
+				return nil, err
+			}
+			dsnOptions = append(dsnOptions, WithRepoReadConsistency(c))
+		case "env":
+			dsnOptions = append(dsnOptions, WithRepoEnvironmentSuffix(value))
+		case "billing", "rcu", "wcu":
+		default:
+			return nil, fmt.Errorf("%w: unknown parameter %q", ErrInvalidDSN, key)
+		}
+	}
+
+	cfg, err := parseDSNTableConfig(params)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.BillingMode != "" {
+		dsnOptions = append(dsnOptions, WithRepoNamespaceConfigs(NewNamespaceConfigs(cfg)))
+	}
+
+	return NewRepo(prefix, append(dsnOptions, options...)...)
+}
+
+// parseDSN returns the table prefix and the parameters of a DSN. Parameters
+// can only be given once.
+func parseDSN(dsn string) (string, map[string]string, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %v", ErrInvalidDSN, err)
+	}
+	if u.Scheme != dsnScheme {
+		return "", nil, fmt.Errorf("%w: scheme must be %s", ErrInvalidDSN, dsnScheme)
+	}
+	if u.Host == "" || (u.Path != "" && u.Path != "/") {
+		return "", nil, fmt.Errorf("%w: missing or invalid table prefix", ErrInvalidDSN)
+	}
+
+	params := map[string]string{}
+	for key, values := range u.Query() {
+		if len(values) != 1 {
+			return "", nil, fmt.Errorf("%w: parameter %q given more than once", ErrInvalidDSN, key)
+		}
+		params[key] = values[0]
+	}
+	return u.Host, params, nil
+}
+
+// parseDSNTableConfig returns the table config of the billing parameters.
+func parseDSNTableConfig(params map[string]string) (NamespaceConfig, error) {
+	var cfg NamespaceConfig
+	switch params["billing"] {
+	case "":
+		if params["rcu"] != "" || params["wcu"] != "" {
+			cfg.BillingMode = dynamodb.BillingModeProvisioned
+		}
+	case "on-demand":
+		cfg.BillingMode = dynamodb.BillingModePayPerRequest
+	case "provisioned":
+		cfg.BillingMode = dynamodb.BillingModeProvisioned
+	default:
+		return cfg, dsnParamError("billing", params["billing"])
+	}
+
+	for key, capacity := range map[string]*int64{"rcu": &cfg.ReadCapacity, "wcu": &cfg.WriteCapacity} {
+		value, ok := params[key]
+		if !ok {
+			continue
+		}
+		if cfg.BillingMode != dynamodb.BillingModeProvisioned {
+			return cfg, fmt.Errorf("%w: %s needs provisioned billing", ErrInvalidDSN, key)
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 1 {
+			return cfg, dsnParamError(key, value)
+		}
+		*capacity = n
+	}
+	if cfg.BillingMode == dynamodb.BillingModeProvisioned && (cfg.ReadCapacity == 0 || cfg.WriteCapacity == 0) {
+		return cfg, fmt.Errorf("%w: provisioned billing needs rcu and wcu", ErrInvalidDSN)
+	}
+	return cfg, nil
+}
+
+// parseReadConsistency parses the consistency parameter of a DSN.
+func parseReadConsistency(value string) (ReadConsistency, error) {
+	switch value {
+	case "strong":
+		return StronglyConsistent, nil
+	case "eventual":
+		return EventuallyConsistent, nil
+	}
+	return 0, dsnParamError("consistency", value)
+}
+
+// dsnParamError is the error of an invalid parameter value.
+func dsnParamError(key, value string) error {
+	return fmt.Errorf("%w: invalid %s %q", ErrInvalidDSN, key, value)
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewEventStoreFromDSN(t *testing.T) {
+	s, err := NewEventStoreFromDSN("dynamodb://events?region=eu-west-1&endpoint=http://localhost:8000&billing=on-demand&codec=json&consistency=eventual")
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, "events", s.tablePrefix)
+	assert.Equal(t, "eu-west-1", aws.StringValue(s.awsConfig.Region))
+	assert.Equal(t, "http://localhost:8000", aws.StringValue(s.awsConfig.Endpoint))
+	assert.Equal(t, dynamodb.BillingModePayPerRequest, s.namespaceConfigs.Get("ns").BillingMode)
+	assert.Equal(t, JSONCodec{}, s.codec)
+	assert.Equal(t, EventuallyConsistent, s.readConsistency)
+
+	// Options are applied after the DSN.
+	s, err = NewEventStoreFromDSN("dynamodb://events?region=eu-west-1", WithRegion("us-west-2"))
+	assert.Nil(t, err)
+	assert.Equal(t, "us-west-2", aws.StringValue(s.awsConfig.Region))
+}
+
+func TestNewRepoFromDSN(t *testing.T) {
+	r, err := NewRepoFromDSN("dynamodb://models?region=eu-west-1&rcu=5&wcu=10&env=staging")
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, "models", r.tablePrefix)
+	cfg := r.namespaceConfigs.Get("ns")
+	assert.Equal(t, dynamodb.BillingModeProvisioned, cfg.BillingMode)
+	assert.Equal(t, int64(5), cfg.ReadCapacity)
+	assert.Equal(t, int64(10), cfg.WriteCapacity)
+	assert.Equal(t, "models_ns-staging", r.tableName(eh.NewContextWithNamespace(context.Background(), "ns")))
+
+	_, err = NewRepoFromDSN("dynamodb://models?codec=json")
+	assert.True(t, errors.Is(err, ErrInvalidDSN))
+}
+
+func TestInvalidDSN(t *testing.T) {
+	for _, dsn := range []string{
+		"postgres://events",
+		"dynamodb://",
+		"dynamodb://events/table",
+		"dynamodb://events?unknown=1",
+		"dynamodb://events?region=a&region=b",
+		"dynamodb://events?billing=free",
+		"dynamodb://events?billing=on-demand&rcu=5",
+		"dynamodb://events?billing=provisioned&rcu=5",
+		"dynamodb://events?rcu=five&wcu=5",
+		"dynamodb://events?codec=xml",
+		"dynamodb://events?consistency=sometimes",
+	} {
+		_, err := NewEventStoreFromDSN(dsn)
+		assert.True(t, errors.Is(err, ErrInvalidDSN), dsn)
+	}
+}
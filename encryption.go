@@ -0,0 +1,328 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// ErrCouldNotEncryptEvent is when an event could not be encrypted.
+var ErrCouldNotEncryptEvent = errors.New("could not encrypt event")
+
+// ErrNoEncryption is when an event is encrypted but encryption is not enabled.
+var ErrNoEncryption = errors.New("no encryption set")
+
+const (
+	// dataKeyMaxAge is how long a data key is used to encrypt events before a
+	// new one is generated.
+	dataKeyMaxAge = 5 * time.Minute
+	// maxCachedDataKeys is the number of decrypted data keys that are cached.
+	maxCachedDataKeys = 1000
+)
+
+// encryption is the client-side envelope encryption of event data.
+type encryption struct {
+	client   kmsiface.KMSAPI
+	kmsKeyID string
+
+	mu       sync.Mutex
+	dataKeys map[string]dataKey
+	keys     map[string][]byte
+}
+
+// dataKey is a data key that is used to encrypt events, with its KMS
+// encrypted form.
+type dataKey struct {
+	key       []byte
+	wrapped   []byte
+	generated time.Time
+}
+
+// encryptedPayload is the encrypted part of an event item.
+type encryptedPayload struct {
+	// Data is the event data encoded by the codec, or as JSON.
+	Data    []byte
+	Encoded bool
+	// Metadata is the event metadata.
+	Metadata map[string]interface{}
+}
+
+// WithEncryption encrypts the data and metadata of events client-side before
+// they are written, with envelope encryption: data keys are generated by KMS
+// under the KMS key and used with AES-GCM. Namespaces with a KMSKeyID in
+// their NamespaceConfig use that key instead, and kmsKeyID can be empty to
+// only encrypt those namespaces. A data key is reused for the events of an
+// aggregate for a few minutes and decrypted data keys are cached, to limit the
+// number of KMS calls. Events are decrypted transparently when loaded.
+//
+// The namespace and aggregate are bound to the data keys as KMS encryption
+// context, and the namespace, aggregate and version to the encrypted data as
+// additional authenticated data, so that encrypted data that is moved to
+// another event or aggregate in the table can not be decrypted.
+//
+// The event type, aggregate, version, timestamp and the correlation and
+// causation IDs are not encrypted, as they are needed to query the events.
+func WithEncryption(kmsKeyID string) Option {
+	return func(s *EventStore) error {
+		if s.encryption == nil {
+			s.encryption = &encryption{}
+		}
+		s.encryption.kmsKeyID = kmsKeyID
+		s.encryption.dataKeys = map[string]dataKey{}
+		s.encryption.keys = map[string][]byte{}
+		return nil
+	}
+}
+
+// WithEncryptionClient uses a custom KMS client for encryption. Without it a
+// client is created from the AWS session of the store.
+func WithEncryptionClient(client kmsiface.KMSAPI) Option {
+	return func(s *EventStore) error {
+		if s.encryption == nil {
+			s.encryption = &encryption{dataKeys: map[string]dataKey{}, keys: map[string][]byte{}}
+		}
+		s.encryption.client = client
+		return nil
+	}
+}
+
+// encryptEvent encrypts the data and metadata of an event item, with the key
+// of the aggregate when crypto-shredding is enabled.
+func (s *EventStore) encryptEvent(ctx context.Context, event eh.Event, e *dbEvent) error {
+	var kmsKeyID string
+	if s.shredding == nil {
+		if kmsKeyID = s.encryptionKeyID(ctx); kmsKeyID == "" {
+			return nil
+		}
+	}
+
+	payload := encryptedPayload{
+		Data:     e.EncodedData,
+		Encoded:  e.EncodedData != nil,
+		Metadata: e.Metadata,
+	}
+	if event.Data() != nil && !payload.Encoded {
+		var err error
+		if payload.Data, err = (JSONCodec{}).Marshal(event.Data()); err != nil {
+			return err
+		}
+	}
+	plaintext, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	var key, wrapped []byte
+	if s.shredding != nil {
+		key, err = s.aggregateKey(ctx, event.AggregateID(), true)
+	} else {
+		key, wrapped, err = s.encryption.dataKey(ctx, kmsKeyID, event.AggregateID())
+	}
+	if err != nil {
+		return err
+	}
+	if e.Encrypted, err = seal(key, plaintext, encryptionAAD(ctx, event.AggregateID(), event.Version())); err != nil {
+		return err
+	}
+
+	e.EncryptedKey = wrapped
+	e.AggregateKey = s.shredding != nil
+	e.RawData = nil
+	e.EncodedData = nil
+	e.Metadata = nil
+	return nil
+}
+
+// decryptEvent decrypts the data and metadata of an event item, and returns
+// the codec to decode the data with.
+func (s *EventStore) decryptEvent(ctx context.Context, e *dbEvent) (Codec, error) {
+	var key []byte
+	var err error
+	if e.AggregateKey {
+		if s.shredding == nil {
+			return nil, ErrNoEncryption
+		}
+		key, err = s.aggregateKey(ctx, e.AggregateID, false)
+	} else {
+		if s.encryption == nil || s.encryption.client == nil {
+			return nil, ErrNoEncryption
+		}
+		key, err = s.encryption.unwrap(ctx, e.EncryptedKey, e.AggregateID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := open(key, e.Encrypted, encryptionAAD(ctx, e.AggregateID, e.Version))
+	if err != nil {
+		return nil, err
+	}
+
+	var payload encryptedPayload
+	if err := json.Unmarshal(plaintext, &payload); err != nil {
+		return nil, err
+	}
+
+	e.EncodedData = payload.Data
+	e.Metadata = payload.Metadata
+	e.Encrypted = nil
+	e.EncryptedKey = nil
+	e.AggregateKey = false
+	if !payload.Encoded {
+		return JSONCodec{}, nil
+	}
+	return s.codec, nil
+}
+
+// encryptionKeyID returns the KMS key of the namespace of the context, which
+// is the key of its NamespaceConfig or the key of WithEncryption. It is empty
+// when events of the namespace are not encrypted.
+func (s *EventStore) encryptionKeyID(ctx context.Context) string {
+	if s.encryption == nil {
+		return ""
+	}
+	if cfg := s.namespaceConfigs.Get(eh.NamespaceFromContext(ctx)); cfg.KMSKeyID != "" {
+		return cfg.KMSKeyID
+	}
+	return s.encryption.kmsKeyID
+}
+
+// encryptionContext returns the KMS encryption context of the data keys of
+// an aggregate.
+func encryptionContext(ctx context.Context, id uuid.UUID) map[string]*string {
+	return map[string]*string{
+		"namespace":   aws.String(eh.NamespaceFromContext(ctx)),
+		"aggregateID": aws.String(id.String()),
+	}
+}
+
+// encryptionAAD returns the additional authenticated data of the encrypted
+// data of an event, which binds it to the namespace, aggregate and version.
+func encryptionAAD(ctx context.Context, id uuid.UUID, version int) []byte {
+	return []byte(eh.NamespaceFromContext(ctx) + "/" + id.String() + "/" + strconv.Itoa(version))
+}
+
+// dataKey returns the current data key of an aggregate under a KMS key and its
+// KMS encrypted form, generating a new one when it is too old.
+func (enc *encryption) dataKey(ctx context.Context, kmsKeyID string, id uuid.UUID) ([]byte, []byte, error) {
+	enc.mu.Lock()
+	defer enc.mu.Unlock()
+
+	cacheKey := kmsKeyID + "/" + eh.NamespaceFromContext(ctx) + "/" + id.String()
+	if k, ok := enc.dataKeys[cacheKey]; ok && time.Since(k.generated) < dataKeyMaxAge {
+		return k.key, k.wrapped, nil
+	}
+
+	out, err := enc.client.GenerateDataKeyWithContext(ctx, &kms.GenerateDataKeyInput{
+		KeyId:             aws.String(kmsKeyID),
+		KeySpec:           aws.String(kms.DataKeySpecAes256),
+		EncryptionContext: encryptionContext(ctx, id),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(enc.dataKeys) >= maxCachedDataKeys {
+		enc.dataKeys = map[string]dataKey{}
+	}
+	enc.dataKeys[cacheKey] = dataKey{key: out.Plaintext, wrapped: out.CiphertextBlob, generated: time.Now()}
+	enc.cache(out.CiphertextBlob, out.Plaintext)
+	return out.Plaintext, out.CiphertextBlob, nil
+}
+
+// unwrap decrypts a data key of an aggregate with KMS, or returns it from the
+// cache.
+func (enc *encryption) unwrap(ctx context.Context, wrapped []byte, id uuid.UUID) ([]byte, error) {
+	enc.mu.Lock()
+	key, ok := enc.keys[string(wrapped)]
+	enc.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	out, err := enc.client.DecryptWithContext(ctx, &kms.DecryptInput{
+		CiphertextBlob:    wrapped,
+		EncryptionContext: encryptionContext(ctx, id),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	enc.mu.Lock()
+	enc.cache(wrapped, out.Plaintext)
+	enc.mu.Unlock()
+	return out.Plaintext, nil
+}
+
+// cache caches a decrypted data key, starting over when the cache is full.
+// The caller must hold the lock.
+func (enc *encryption) cache(wrapped, key []byte) {
+	if len(enc.keys) >= maxCachedDataKeys {
+		enc.keys = map[string][]byte{}
+	}
+	enc.keys[string(wrapped)] = key
+}
+
+// seal encrypts plaintext with AES-GCM, prefixing the random nonce, and
+// authenticates it together with the additional data aad.
+func seal(key, plaintext, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+// open decrypts ciphertext that was encrypted by seal with the same
+// additional data.
+func open(key, ciphertext, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, aad)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
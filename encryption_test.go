@@ -0,0 +1,181 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryKMS is an in-memory KMS client for the encryption tests, which wraps
+// data keys by prefixing them and appending the KMS key and encryption
+// context.
+type memoryKMS struct {
+	kmsiface.KMSAPI
+
+	mu        sync.Mutex
+	generated int
+	decrypted int
+}
+
+var wrappedPrefix = []byte("wrapped:")
+
+func (m *memoryKMS) GenerateDataKeyWithContext(ctx aws.Context, in *kms.GenerateDataKeyInput, opts ...request.Option) (*kms.GenerateDataKeyOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.generated++
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	blob := append(append([]byte{}, wrappedPrefix...), key...)
+	blob = append(blob, aws.StringValue(in.KeyId)+"|"+memoryKMSContext(in.EncryptionContext)...)
+	return &kms.GenerateDataKeyOutput{
+		Plaintext:      key,
+		CiphertextBlob: blob,
+	}, nil
+}
+
+// memoryKMSContext returns an encryption context as a string.
+func memoryKMSContext(c map[string]*string) string {
+	return aws.StringValue(c["namespace"]) + "/" + aws.StringValue(c["aggregateID"])
+}
+
+func (m *memoryKMS) DecryptWithContext(ctx aws.Context, in *kms.DecryptInput, opts ...request.Option) (*kms.DecryptOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.decrypted++
+
+	if !bytes.HasPrefix(in.CiphertextBlob, wrappedPrefix) ||
+		!bytes.HasSuffix(in.CiphertextBlob, []byte("|"+memoryKMSContext(in.EncryptionContext))) {
+		return nil, errors.New("invalid ciphertext")
+	}
+	key := in.CiphertextBlob[len(wrappedPrefix) : len(wrappedPrefix)+32]
+	return &kms.DecryptOutput{Plaintext: key}, nil
+}
+
+func TestEncryption(t *testing.T) {
+	keys := &memoryKMS{}
+	s := &EventStore{tableName: func(context.Context) string { return "test" }}
+	assert.Nil(t, WithEncryption("key")(s))
+	assert.Nil(t, WithEncryptionClient(keys)(s))
+
+	ctx := context.Background()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	event := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "secret"},
+		timestamp, mocks.AggregateType, uuid.New(), 1)
+	e, err := s.newDBEvent(ctx, event)
+	assert.Nil(t, err)
+	assert.Nil(t, e.RawData)
+	assert.Nil(t, e.Metadata)
+	assert.NotContains(t, string(e.Encrypted), "secret")
+
+	// The data key is reused within its max age.
+	_, err = s.newDBEvent(ctx, event)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, keys.generated)
+
+	loaded, err := s.buildEvent(ctx, *e)
+	assert.Nil(t, err)
+	assert.Equal(t, event.Data(), loaded.Data())
+
+	// A store without the data key in its cache decrypts it with KMS.
+	s2 := &EventStore{}
+	assert.Nil(t, WithEncryption("key")(s2))
+	assert.Nil(t, WithEncryptionClient(keys)(s2))
+	loaded, err = s2.buildEvent(ctx, *e)
+	assert.Nil(t, err)
+	assert.Equal(t, event.Data(), loaded.Data())
+	assert.Equal(t, 1, keys.decrypted)
+
+	// Without encryption the event can not be decoded.
+	_, err = (&EventStore{}).buildEvent(ctx, *e)
+	if esErr, ok := err.(eh.EventStoreError); !ok || !errors.Is(esErr.Err, ErrCouldNotUnmarshalEvent) {
+		t.Fatal("there should be an unmarshal error:", err)
+	}
+
+	// Encrypted data that is moved to another event, aggregate or namespace
+	// can not be decrypted.
+	moved := *e
+	moved.Version = 2
+	_, err = s2.buildEvent(ctx, moved)
+	assert.NotNil(t, err)
+	moved = *e
+	moved.AggregateID = uuid.New()
+	_, err = s2.buildEvent(ctx, moved)
+	assert.NotNil(t, err)
+	_, err = (&EventStore{encryption: &encryption{client: keys, keys: map[string][]byte{}}}).
+		buildEvent(eh.NewContextWithNamespace(ctx, "other"), *e)
+	assert.NotNil(t, err)
+}
+
+func TestEncryptionNamespaceKeys(t *testing.T) {
+	keys := &memoryKMS{}
+	configs := NewNamespaceConfigs(NamespaceConfig{})
+	configs.Set("tenant", NamespaceConfig{KMSKeyID: "tenant-key"})
+	s := &EventStore{namespaceConfigs: configs}
+	assert.Nil(t, WithEncryption("")(s))
+	assert.Nil(t, WithEncryptionClient(keys)(s))
+
+	// Only namespaces with a key are encrypted, with their own key.
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	event := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "secret"},
+		timestamp, mocks.AggregateType, uuid.New(), 1)
+	e, err := s.newDBEvent(context.Background(), event)
+	assert.Nil(t, err)
+	assert.Nil(t, e.Encrypted)
+
+	ctx := eh.NewContextWithNamespace(context.Background(), "tenant")
+	e, err = s.newDBEvent(ctx, event)
+	assert.Nil(t, err)
+	assert.NotNil(t, e.Encrypted)
+	assert.Contains(t, string(e.EncryptedKey), "tenant-key|")
+
+	loaded, err := s.buildEvent(ctx, *e)
+	assert.Nil(t, err)
+	assert.Equal(t, event.Data(), loaded.Data())
+}
+
+func TestSealOpen(t *testing.T) {
+	key := make([]byte, 32)
+	ciphertext, err := seal(key, []byte("plaintext"), []byte("aad"))
+	assert.Nil(t, err)
+
+	plaintext, err := open(key, ciphertext, []byte("aad"))
+	assert.Nil(t, err)
+	assert.Equal(t, "plaintext", string(plaintext))
+
+	_, err = open(key, ciphertext, []byte("other"))
+	assert.NotNil(t, err)
+
+	ciphertext[len(ciphertext)-1] ^= 1
+	_, err = open(key, ciphertext, []byte("aad"))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,166 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrEnvironmentCollision is when a table name of an environment could also
+// be a table name of another environment.
+var ErrEnvironmentCollision = errors.New("table name collides with another environment")
+
+// environment is the environment that the tables are scoped to.
+type environment struct {
+	name string
+	// others are the other environments, which tables must not be used.
+	others []string
+}
+
+// WithEnvironmentSuffix scopes all tables of the store to an environment, like
+// "staging", by suffixing the table names with "-staging". That includes the
+// event tables of custom table names, partitions, outbox and payload tables
+// and the tables of quarantines and replayers of the store.
+//
+// The other environments that share the AWS account, with "" for one without
+// a suffix, are checked on creation: the store is not created if any of its
+// table names could be a table name of another environment.
+func WithEnvironmentSuffix(env string, others ...string) Option {
+	return func(s *EventStore) error {
+		s.environment = &environment{name: env, others: others}
+		return nil
+	}
+}
+
+// WithRepoEnvironmentSuffix scopes the tables of the repo to an environment,
+// as WithEnvironmentSuffix.
+func WithRepoEnvironmentSuffix(env string, others ...string) OptionRepo {
+	return func(r *Repo) error {
+		r.environment = &environment{name: env, others: others}
+		return nil
+	}
+}
+
+// WithSnapshotEnvironmentSuffix scopes the tables of the snapshot store to an
+// environment, as WithEnvironmentSuffix.
+func WithSnapshotEnvironmentSuffix(env string, others ...string) OptionSnapshotStore {
+	return func(s *SnapshotStore) error {
+		s.environment = &environment{name: env, others: others}
+		return nil
+	}
+}
+
+// applyEnvironment suffixes the table names of the store and checks them.
+func (s *EventStore) applyEnvironment() error {
+	if s.environment == nil {
+		return nil
+	}
+
+	s.tableName = s.environment.resolver(s.tableName)
+	names := []string{s.tableName(context.Background())}
+	if s.outbox != nil {
+		s.outbox.tableName = s.environment.tableName(s.outbox.tableName)
+		names = append(names, s.outbox.tableName)
+	}
+	if s.payloads != nil {
+		s.payloads.tableName = s.environment.tableName(s.payloads.tableName)
+		names = append(names, s.payloads.tableName)
+	}
+	if s.shredding != nil {
+		s.shredding.tableName = s.environment.tableName(s.shredding.tableName)
+		names = append(names, s.shredding.tableName)
+	}
+	return s.environment.check(names...)
+}
+
+// applyEnvironment suffixes the table names of the repo and checks them.
+func (r *Repo) applyEnvironment() error {
+	if r.environment == nil {
+		return nil
+	}
+
+	r.tableName = r.environment.resolver(r.tableName)
+	return r.environment.check(r.tableName(context.Background()))
+}
+
+// applyEnvironment suffixes the table names of the snapshot store and checks
+// them.
+func (s *SnapshotStore) applyEnvironment() error {
+	if s.environment == nil {
+		return nil
+	}
+
+	s.tableName = s.environment.resolver(s.tableName)
+	return s.environment.check(s.tableName(context.Background()))
+}
+
+// tableName returns a table name with the environment suffix, if any.
+func (e *environment) tableName(name string) string {
+	if e == nil || e.name == "" {
+		return name
+	}
+	return name + "-" + e.name
+}
+
+// resolver returns a table name resolver that suffixes the names of another.
+func (e *environment) resolver(tableName func(context.Context) string) func(context.Context) string {
+	return func(ctx context.Context) string {
+		return e.tableName(tableName(ctx))
+	}
+}
+
+// trim returns a table name without the environment suffix, and if it is a
+// table name of the environment. Without a suffix, the names with the suffix
+// of another environment are not.
+func (e *environment) trim(name string) (string, bool) {
+	if e == nil {
+		return name, true
+	}
+	if e.name == "" {
+		for _, other := range e.others {
+			if other != "" && strings.HasSuffix(name, "-"+other) {
+				return name, false
+			}
+		}
+		return name, true
+	}
+	if !strings.HasSuffix(name, "-"+e.name) {
+		return name, false
+	}
+	return strings.TrimSuffix(name, "-"+e.name), true
+}
+
+// check checks that the table names of the environment can not be table
+// names of the other environments, and the other way around.
+func (e *environment) check(names ...string) error {
+	for _, other := range e.others {
+		if other == e.name {
+			return fmt.Errorf("%w: environment %q is configured twice", ErrEnvironmentCollision, other)
+		}
+
+		for _, name := range names {
+			base, _ := e.trim(name)
+			otherName := (&environment{name: other}).tableName(base)
+			if (other != "" && strings.HasSuffix(name, "-"+other)) ||
+				(e.name != "" && strings.HasSuffix(otherName, "-"+e.name)) {
+				return fmt.Errorf("%w: %s could be table %s of environment %q", ErrEnvironmentCollision, name, otherName, other)
+			}
+		}
+	}
+	return nil
+}
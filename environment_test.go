@@ -0,0 +1,95 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithEnvironmentSuffix(t *testing.T) {
+	sess := session.Must(session.NewSession(&aws.Config{Region: aws.String("us-west-2")}))
+	ctx := eh.NewContextWithNamespace(context.Background(), "ns")
+
+	s, err := NewEventStore("events",
+		WithDynamoDB(sess),
+		WithOutbox("outbox", time.Hour, nil),
+		WithEnvironmentSuffix("staging", "", "prod"),
+	)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, "events_ns-staging", s.tableName(ctx))
+	assert.Equal(t, "outbox-staging", s.outbox.tableName)
+
+	q := NewQuarantine(s, "quarantine", nil, 1)
+	assert.Equal(t, "quarantine-staging", q.tableName)
+
+	r, err := NewRepo("models", WithRepoDynamoDB(sess), WithRepoPrefixAsTableName(), WithRepoEnvironmentSuffix("staging"))
+	assert.Nil(t, err)
+	assert.Equal(t, "models-staging", r.tableName(ctx))
+
+	ss, err := NewSnapshotStore("snapshots", WithSnapshotDynamoDB(sess), WithSnapshotEnvironmentSuffix("staging"))
+	assert.Nil(t, err)
+	assert.Equal(t, "snapshots_ns-staging", ss.tableName(ctx))
+}
+
+func TestEnvironmentCollision(t *testing.T) {
+	sess := session.Must(session.NewSession(&aws.Config{Region: aws.String("us-west-2")}))
+
+	for _, env := range []*environment{
+		{name: "staging", others: []string{"staging"}},
+		{name: "", others: []string{""}},
+		// The names of one environment end with the suffix of the other.
+		{name: "eu-staging", others: []string{"staging"}},
+		{name: "staging", others: []string{"eu-staging"}},
+	} {
+		_, err := NewEventStore("events", WithDynamoDB(sess), WithEnvironmentSuffix(env.name, env.others...))
+		assert.True(t, errors.Is(err, ErrEnvironmentCollision), env)
+	}
+
+	// An unsuffixed environment with a custom table name of another one.
+	_, err := NewRepo("models", WithRepoDynamoDB(sess),
+		WithRepoTableName(func(context.Context) string { return "models-staging" }),
+		WithRepoEnvironmentSuffix("", "staging"),
+	)
+	assert.True(t, errors.Is(err, ErrEnvironmentCollision))
+
+	_, err = NewEventStore("events", WithDynamoDB(sess), WithEnvironmentSuffix("staging", "", "prod"))
+	assert.Nil(t, err)
+}
+
+func TestEnvironmentTrim(t *testing.T) {
+	env := &environment{name: "staging"}
+	name, ok := env.trim("events_ns-staging")
+	assert.True(t, ok)
+	assert.Equal(t, "events_ns", name)
+	_, ok = env.trim("events_ns")
+	assert.False(t, ok)
+
+	env = &environment{others: []string{"staging"}}
+	_, ok = env.trim("events_ns-staging")
+	assert.False(t, ok)
+	name, ok = env.trim("events_ns")
+	assert.True(t, ok)
+	assert.Equal(t, "events_ns", name)
+}
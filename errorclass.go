@@ -0,0 +1,156 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// ErrorClass is the class of an error of a DynamoDB request, which decides
+// if the request is retried, how the error is reported in metrics and how it
+// is handled, like buffering saves with WithStoreAndForward.
+type ErrorClass int
+
+const (
+	// ErrorPermanent is an error that would fail the same way again, like a
+	// failed condition or an invalid request. It is not retried.
+	ErrorPermanent ErrorClass = iota
+	// ErrorThrottled is when the request was throttled.
+	ErrorThrottled
+	// ErrorTimeout is when the request timed out or DynamoDB could not be
+	// reached. Saves are buffered on it with WithStoreAndForward.
+	ErrorTimeout
+	// ErrorServer is an internal error of DynamoDB, with a 5xx status.
+	ErrorServer
+)
+
+// String returns the name of the error class.
+func (c ErrorClass) String() string {
+	switch c {
+	case ErrorThrottled:
+		return "throttled"
+	case ErrorTimeout:
+		return "timeout"
+	case ErrorServer:
+		return "server"
+	default:
+		return "permanent"
+	}
+}
+
+// Retryable returns if requests that failed with an error of the class are
+// retried.
+func (c ErrorClass) Retryable() bool {
+	return c != ErrorPermanent
+}
+
+// ErrorClassifier classifies the errors of DynamoDB requests.
+type ErrorClassifier func(err error) ErrorClass
+
+// DefaultErrorClassifier is the classifier of errors without the
+// WithErrorClassifier option.
+func DefaultErrorClassifier(err error) ErrorClass {
+	switch {
+	case isUnreachable(err):
+		return ErrorTimeout
+	case isThrottled(err):
+		return ErrorThrottled
+	case isUnavailable(err):
+		return ErrorServer
+	}
+	return ErrorPermanent
+}
+
+// WithErrorClassifier classifies the errors of DynamoDB requests with a
+// custom classifier instead of DefaultErrorClassifier. The class decides if
+// requests are retried, with the retry policy of WithRetryPolicy or else
+// DefaultRetryPolicy, which load errors LoadWithFallback falls back on, which
+// save errors WithStoreAndForward buffers on, and the ErrorClass of the
+// operation metrics.
+func WithErrorClassifier(c ErrorClassifier) Option {
+	return func(s *EventStore) error {
+		s.errorClassifier = c
+		return nil
+	}
+}
+
+// WithRepoErrorClassifier classifies the errors of DynamoDB requests with a
+// custom classifier, which decides if requests are retried and the
+// ErrorClass of the operation metrics.
+func WithRepoErrorClassifier(c ErrorClassifier) OptionRepo {
+	return func(r *Repo) error {
+		r.errorClassifier = c
+		return nil
+	}
+}
+
+type errorClassifierKey int
+
+// errorClassifierCtxKey is the context key of the error classifier.
+const errorClassifierCtxKey errorClassifierKey = iota
+
+// withErrorClassifier returns a context with an error classifier, if set, for
+// the metrics of the operations that are called with it.
+func withErrorClassifier(ctx context.Context, c ErrorClassifier) context.Context {
+	if c == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, errorClassifierCtxKey, c)
+}
+
+// errorClassifierFromContext returns the error classifier of a context, or
+// nil if it has none.
+func errorClassifierFromContext(ctx context.Context) ErrorClassifier {
+	c, _ := ctx.Value(errorClassifierCtxKey).(ErrorClassifier)
+	return c
+}
+
+// classifyError classifies an error with a classifier, or the default
+// classifier if it is nil. The error from DynamoDB of an
+// eventhorizon.EventStoreError or eventhorizon.RepoError is classified.
+func classifyError(c ErrorClassifier, err error) ErrorClass {
+	switch e := err.(type) {
+	case eh.EventStoreError:
+		if e.BaseErr != nil {
+			err = e.BaseErr
+		}
+	case eh.RepoError:
+		if e.BaseErr != nil {
+			err = e.BaseErr
+		}
+	}
+	if err == nil {
+		return ErrorPermanent
+	}
+	if c == nil {
+		c = DefaultErrorClassifier
+	}
+	return c(err)
+}
+
+// isThrottled checks if an error means that a request or the items of a
+// transaction were throttled.
+func isThrottled(err error) bool {
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && request.IsErrorThrottle(awsErr) {
+		return true
+	}
+	return isTransactionThrottled(err)
+}
@@ -0,0 +1,74 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultErrorClassifier(t *testing.T) {
+	for err, class := range map[error]ErrorClass{
+		awserr.New(request.ErrCodeRequestError, "send request failed", nil):                                 ErrorTimeout,
+		awserr.New(request.ErrCodeResponseTimeout, "timeout", nil):                                          ErrorTimeout,
+		withRequestID(awserr.New(dynamodb.ErrCodeProvisionedThroughputExceededException, "throttled", nil)): ErrorThrottled,
+		awserr.NewRequestFailure(awserr.New("Unknown", "internal", nil), 503, "req-1"):                      ErrorServer,
+		awserr.New(dynamodb.ErrCodeResourceNotFoundException, "no table", nil):                              ErrorPermanent,
+		errors.New("failed"): ErrorPermanent,
+	} {
+		assert.Equal(t, class, DefaultErrorClassifier(err), err.Error())
+	}
+
+	assert.Equal(t, ErrorPermanent, classifyError(nil, nil))
+	internal := awserr.New(dynamodb.ErrCodeInternalServerError, "internal", nil)
+	assert.Equal(t, ErrorServer, classifyError(nil, eh.EventStoreError{Err: ErrCouldNotSaveAggregate, BaseErr: internal}))
+}
+
+func TestWithErrorClassifier(t *testing.T) {
+	// A classifier that never retries internal errors.
+	classify := func(err error) ErrorClass {
+		if isAWSErrorCode(err, dynamodb.ErrCodeInternalServerError) {
+			return ErrorPermanent
+		}
+		return DefaultErrorClassifier(err)
+	}
+	internal := awserr.New(dynamodb.ErrCodeInternalServerError, "internal", nil)
+
+	r := newRetryer(DefaultRetryPolicy)
+	r.classify = DefaultErrorClassifier
+	assert.True(t, r.ShouldRetry(&request.Request{Error: internal}))
+	r.classify = classify
+	assert.False(t, r.ShouldRetry(&request.Request{Error: internal}))
+	assert.True(t, r.ShouldRetry(&request.Request{Error: awserr.New(dynamodb.ErrCodeRequestLimitExceeded, "throttled", nil)}))
+
+	var metrics []OperationMetric
+	m := MetricsFunc(func(ctx context.Context, m OperationMetric) {
+		metrics = append(metrics, m)
+	})
+	observe(context.Background(), m, OperationQuery, "table", time.Now(), internal)
+	observe(withErrorClassifier(context.Background(), classify), m, OperationQuery, "table", time.Now(), internal)
+	if assert.Len(t, metrics, 2) {
+		assert.Equal(t, ErrorServer, metrics[0].ErrorClass)
+		assert.Equal(t, ErrorPermanent, metrics[1].ErrorClass)
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/google/uuid"
+	"github.com/guregu/dynamo"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// isAWSErrorCode checks if an error is an AWS error with the given code.
+func isAWSErrorCode(err error, code string) bool {
+	var awsErr awserr.Error
+	return errors.As(err, &awsErr) && awsErr.Code() == code
+}
+
+// isConditionalCheckFailed checks if an error is a failed condition, either
+// from a single write or from any of the items of a transaction.
+func isConditionalCheckFailed(err error) bool {
+	if isAWSErrorCode(err, dynamodb.ErrCodeConditionalCheckFailedException) {
+		return true
+	}
+
+	var txErr *dynamodb.TransactionCanceledException
+	if errors.As(err, &txErr) {
+		for _, reason := range txErr.CancellationReasons {
+			if aws.StringValue(reason.Code) == "ConditionalCheckFailed" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RequestError is an error from DynamoDB together with the ID of the failed
+// request, which is what AWS support asks for when investigating issues.
+type RequestError struct {
+	// Err is the error from DynamoDB.
+	Err error
+	// RequestID is the ID of the failed request.
+	RequestID string
+}
+
+// Error implements the Error method of the errors.Error interface.
+func (e RequestError) Error() string {
+	return e.Err.Error() + " (request id: " + e.RequestID + ")"
+}
+
+// Unwrap returns the error from DynamoDB.
+func (e RequestError) Unwrap() error {
+	return e.Err
+}
+
+// withRequestID wraps an error from a failed AWS request in a RequestError.
+// Other errors are returned as they are.
+func withRequestID(err error) error {
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) && reqErr.RequestID() != "" {
+		return RequestError{
+			Err:       err,
+			RequestID: reqErr.RequestID(),
+		}
+	}
+	return err
+}
+
+// wrappedError is a sentinel error, like ErrCouldNotSaveAggregate, together
+// with the error that caused it. It is used as the Err of the errors of the
+// event store and repo, so that errors.Is matches the sentinel error and
+// errors.As finds the cause, like an awserr.RequestFailure.
+type wrappedError struct {
+	err   error
+	cause error
+}
+
+// wrapError wraps a sentinel error with its cause, adding the request ID of
+// a failed AWS request.
+func wrapError(err, cause error) error {
+	if cause == nil {
+		return err
+	}
+	return wrappedError{
+		err:   err,
+		cause: withRequestID(cause),
+	}
+}
+
+// Error implements the Error method of the errors.Error interface. It is the
+// sentinel error, as the cause is in the BaseErr of the outer error.
+func (e wrappedError) Error() string {
+	return e.err.Error()
+}
+
+// Is returns true for the sentinel error.
+func (e wrappedError) Is(target error) bool {
+	return e.err == target
+}
+
+// Unwrap returns the cause.
+func (e wrappedError) Unwrap() error {
+	return e.cause
+}
+
+// VersionConflictError is the Err of the eventhorizon.EventStoreError from a
+// Save that failed because the aggregate was not at the expected version,
+// typically because of a concurrent save. The command can then be retried on
+// the reloaded aggregate. It matches eventhorizon.ErrIncorrectEventVersion,
+// the optimistic concurrency error of eventhorizon, and
+// ErrCouldNotSaveAggregate with errors.Is.
+type VersionConflictError struct {
+	// AggregateID is the ID of the aggregate.
+	AggregateID uuid.UUID
+	// Version is the version the aggregate was expected to be at.
+	Version int
+	// Err is the error from DynamoDB.
+	Err error
+}
+
+// Error implements the Error method of the errors.Error interface.
+func (e VersionConflictError) Error() string {
+	return ErrCouldNotSaveAggregate.Error() + ": " + e.AggregateID.String() +
+		" is not at version " + strconv.Itoa(e.Version)
+}
+
+// Is returns true for eventhorizon.ErrIncorrectEventVersion and
+// ErrCouldNotSaveAggregate.
+func (e VersionConflictError) Is(target error) bool {
+	return target == eh.ErrIncorrectEventVersion || target == ErrCouldNotSaveAggregate
+}
+
+// IsVersionConflict checks if an error is from a Save that failed on a version
+// conflict, also when wrapped in an eventhorizon.EventStoreError.
+func IsVersionConflict(err error) bool {
+	if esErr, ok := err.(eh.EventStoreError); ok {
+		err = esErr.Err
+	}
+
+	var conflict VersionConflictError
+	return errors.As(err, &conflict)
+}
+
+// Unwrap returns the error from DynamoDB.
+func (e VersionConflictError) Unwrap() error {
+	return e.Err
+}
+
+// versionConflict returns the version conflict of a failed write of events
+// that expected the aggregate at originalVersion.
+func versionConflict(input *dynamodb.TransactWriteItemsInput, originalVersion int, err error) VersionConflictError {
+	conflict := VersionConflictError{Version: originalVersion, Err: withRequestID(err)}
+	for _, item := range input.TransactItems {
+		if item.Put == nil {
+			continue
+		}
+		var e dbEvent
+		if dynamo.UnmarshalItem(item.Put.Item, &e) == nil && e.Version > 0 {
+			conflict.AggregateID = e.AggregateID
+			break
+		}
+	}
+	return conflict
+}
+
+// RequestID returns the ID of the failed DynamoDB request of an error, also
+// when wrapped in an eventhorizon.EventStoreError or eventhorizon.RepoError.
+// It returns an empty string if there is no request ID.
+func RequestID(err error) string {
+	switch e := err.(type) {
+	case eh.EventStoreError:
+		err = e.BaseErr
+	case eh.RepoError:
+		err = e.BaseErr
+	}
+
+	var reqErr RequestError
+	if errors.As(err, &reqErr) {
+		return reqErr.RequestID
+	}
+	var failure awserr.RequestFailure
+	if errors.As(err, &failure) {
+		return failure.RequestID()
+	}
+	return ""
+}
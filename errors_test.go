@@ -0,0 +1,87 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/google/uuid"
+	"github.com/guregu/dynamo"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestID(t *testing.T) {
+	awsErr := awserr.NewRequestFailure(awserr.New("ThrottlingException", "slow down", nil), 400, "req-1")
+
+	err := withRequestID(awsErr)
+	assert.EqualError(t, err, awsErr.Error()+" (request id: req-1)")
+	assert.Equal(t, "req-1", RequestID(err))
+	assert.Equal(t, "req-1", RequestID(eh.EventStoreError{Err: awsErr, BaseErr: err}))
+	assert.Equal(t, "req-1", RequestID(eh.RepoError{Err: eh.ErrEntityNotFound, BaseErr: err}))
+	assert.Equal(t, "req-1", RequestID(awsErr))
+
+	other := errors.New("other")
+	assert.Equal(t, other, withRequestID(other))
+	assert.Equal(t, "", RequestID(other))
+}
+
+func TestWrapError(t *testing.T) {
+	awsErr := awserr.NewRequestFailure(awserr.New("ThrottlingException", "slow down", nil), 400, "req-1")
+
+	err := wrapError(ErrCouldNotUnmarshalEvent, awsErr)
+	assert.EqualError(t, err, ErrCouldNotUnmarshalEvent.Error())
+	assert.True(t, errors.Is(err, ErrCouldNotUnmarshalEvent))
+	assert.False(t, errors.Is(err, ErrCouldNotMarshalEvent))
+	var failure awserr.RequestFailure
+	if assert.True(t, errors.As(err, &failure)) {
+		assert.Equal(t, "req-1", failure.RequestID())
+	}
+
+	assert.Equal(t, ErrCouldNotUnmarshalEvent, wrapError(ErrCouldNotUnmarshalEvent, nil))
+}
+
+func TestVersionConflictError(t *testing.T) {
+	awsErr := awserr.New("ConditionalCheckFailedException", "conflict", nil)
+	id := uuid.New()
+	err := error(VersionConflictError{AggregateID: id, Version: 2, Err: awsErr})
+	assert.True(t, errors.Is(err, ErrCouldNotSaveAggregate))
+	assert.True(t, errors.Is(err, awsErr))
+	assert.Contains(t, err.Error(), id.String())
+
+	assert.True(t, errors.Is(err, eh.ErrIncorrectEventVersion))
+	assert.True(t, IsVersionConflict(err))
+	assert.True(t, IsVersionConflict(eh.EventStoreError{Err: err, BaseErr: awsErr}))
+	assert.False(t, IsVersionConflict(eh.EventStoreError{Err: ErrCouldNotSaveAggregate}))
+}
+
+func TestVersionConflict(t *testing.T) {
+	awsErr := awserr.New("ConditionalCheckFailedException", "conflict", nil)
+	id := uuid.New()
+	item, err := dynamo.MarshalItem(dbEvent{AggregateID: id, Version: 5})
+	assert.Nil(t, err)
+
+	// The expected version is the one of the save, also with version gaps.
+	conflict := versionConflict(&dynamodb.TransactWriteItemsInput{TransactItems: []*dynamodb.TransactWriteItem{
+		{Put: &dynamodb.Put{TableName: aws.String("events"), Item: item}},
+	}}, 3, awsErr)
+	assert.Equal(t, id, conflict.AggregateID)
+	assert.Equal(t, 3, conflict.Version)
+	assert.True(t, errors.Is(conflict, awsErr))
+}
@@ -0,0 +1,315 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/guregu/dynamo"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// ErrUnknownExportFormat is when an export format is not supported.
+var ErrUnknownExportFormat = errors.New("unknown export format")
+
+// ExportFormat is the file format of Export.
+type ExportFormat string
+
+const (
+	// ExportNDJSON writes one JSON encoded ExportedEvent per line.
+	ExportNDJSON ExportFormat = "ndjson"
+	// ExportParquet writes an uncompressed Parquet file with a column per
+	// field of ExportedEvent. The timestamp is in milliseconds, and the data
+	// and metadata are JSON strings.
+	ExportParquet ExportFormat = "parquet"
+)
+
+// ExportedEvent is the schema of exported events. Fields are only added at
+// the end, so that the schema stays stable for readers of older exports.
+type ExportedEvent struct {
+	Namespace     string          `json:"namespace"`
+	AggregateType string          `json:"aggregate_type"`
+	AggregateID   string          `json:"aggregate_id"`
+	Version       int             `json:"version"`
+	EventType     string          `json:"event_type"`
+	Timestamp     time.Time       `json:"timestamp"`
+	Position      int64           `json:"position"`
+	Data          json.RawMessage `json:"data"`
+	Metadata      json.RawMessage `json:"metadata"`
+}
+
+// Export writes the events that match a filter as NDJSON or Parquet, for
+// analytics in Athena and offline backups. The events of the namespaces of
+// the filter are exported, or of all namespaces found by Namespaces if it has
+// none. Within a namespace the events are in table order, or in version order
+// per aggregate when the filter has aggregate IDs, which are then queried
+// instead of scanning the tables.
+func (s *EventStore) Export(ctx context.Context, w io.Writer, format ExportFormat, filter EventFilter) error {
+	var ew exportWriter
+	var err error
+	switch format {
+	case ExportNDJSON:
+		ew = &ndjsonExport{enc: json.NewEncoder(w)}
+	case ExportParquet:
+		ew, err = newParquetExport(w)
+	default:
+		err = ErrUnknownExportFormat
+	}
+	if err != nil {
+		return eh.EventStoreError{
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	namespaces := filter.Namespaces
+	if len(namespaces) == 0 {
+		if namespaces, err = s.Namespaces(ctx); err != nil {
+			return err
+		}
+	}
+	for _, ns := range namespaces {
+		if err := s.exportNamespace(NewContextWithExplicitNamespace(ctx, ns), ew, filter); err != nil {
+			return err
+		}
+	}
+
+	if err := ew.close(); err != nil {
+		return eh.EventStoreError{
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+	return nil
+}
+
+// exportNamespace exports the matching events of one namespace.
+func (s *EventStore) exportNamespace(ctx context.Context, ew exportWriter, filter EventFilter) error {
+	ctx, err := s.namespace(ctx)
+	if err != nil {
+		return err
+	}
+	ns := eh.NamespaceFromContext(ctx)
+
+	export := func(e dbEvent) error {
+		if !filter.match(s.typeNames, ns, eventFilterItem(e)) {
+			return nil
+		}
+		event, err := s.buildEvent(ctx, e)
+		if err != nil {
+			return err
+		}
+		exported, err := newExportedEvent(ns, event, e.Position)
+		if err == nil {
+			err = ew.write(exported)
+		}
+		if err != nil {
+			return eh.EventStoreError{
+				Err:       err,
+				Namespace: ns,
+			}
+		}
+		return nil
+	}
+
+	tables, err := s.eventTables(ctx)
+	if err != nil {
+		return err
+	}
+	for _, tableName := range tables {
+		if len(filter.AggregateIDs) > 0 {
+			err = s.exportAggregates(ctx, tableName, filter, export)
+		} else {
+			err = s.exportTable(ctx, tableName, filter, export)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportTable scans a table for the events to export.
+func (s *EventStore) exportTable(ctx context.Context, tableName string, filter EventFilter, export func(dbEvent) error) error {
+	scan := s.readService().Table(tableName).
+		Scan().
+		Filter("Version > ?", aggregateHeadVersion).
+		Consistent(consistentRead(ctx, s.readConsistency))
+	if expr, args := filter.typesExpression(s.typeNames); expr != "" {
+		scan = scan.Filter(expr, args...)
+	}
+
+	start := time.Now()
+	throttle := s.newScanThrottle()
+	iter := throttle.scan(s.scanNamespace(ctx, scan)).Iter()
+	var e dbEvent
+	var exportErr error
+	for exportErr == nil && iter.NextWithContext(ctx, &e) {
+		exportErr = export(e)
+		e = dbEvent{}
+		if exportErr == nil {
+			exportErr = throttle.wait(ctx)
+		}
+	}
+	err := iter.Err()
+	observe(ctx, s.metrics, OperationScan, tableName, start, err)
+	if exportErr != nil {
+		return exportErr
+	} else if err != nil && !isAWSErrorCode(err, dynamodb.ErrCodeResourceNotFoundException) {
+		return eh.EventStoreError{
+			BaseErr:   withRequestID(err),
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+	return nil
+}
+
+// exportAggregates queries a table for the events of the aggregates to
+// export.
+func (s *EventStore) exportAggregates(ctx context.Context, tableName string, filter EventFilter, export func(dbEvent) error) error {
+	for _, id := range filter.AggregateIDs {
+		query := s.readService().Table(tableName).
+			Get(s.hashKey(), s.hashValue(ctx, id)).
+			Range("Version", dynamo.Greater, aggregateHeadVersion).
+			Consistent(consistentRead(ctx, s.readConsistency))
+		if expr, args := filter.typesExpression(s.typeNames); expr != "" {
+			query = query.Filter(expr, args...)
+		}
+
+		start := time.Now()
+		iter := query.Iter()
+		var e dbEvent
+		var exportErr error
+		for exportErr == nil && iter.NextWithContext(ctx, &e) {
+			exportErr = export(e)
+			e = dbEvent{}
+		}
+		err := iter.Err()
+		observe(ctx, s.metrics, OperationQuery, tableName, start, err)
+		if exportErr != nil {
+			return exportErr
+		} else if err != nil && !isAWSErrorCode(err, dynamodb.ErrCodeResourceNotFoundException) {
+			return eh.EventStoreError{
+				BaseErr:   withRequestID(err),
+				Err:       err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+	}
+	return nil
+}
+
+// newExportedEvent returns the exported form of an event.
+func newExportedEvent(namespace string, event eh.Event, position int64) (ExportedEvent, error) {
+	data, err := json.Marshal(event.Data())
+	if err != nil {
+		return ExportedEvent{}, err
+	}
+	metadata, err := json.Marshal(event.Metadata())
+	if err != nil {
+		return ExportedEvent{}, err
+	}
+
+	return ExportedEvent{
+		Namespace:     namespace,
+		AggregateType: string(event.AggregateType()),
+		AggregateID:   event.AggregateID().String(),
+		Version:       event.Version(),
+		EventType:     string(event.EventType()),
+		Timestamp:     event.Timestamp(),
+		Position:      position,
+		Data:          data,
+		Metadata:      metadata,
+	}, nil
+}
+
+// exportWriter writes exported events in a format.
+type exportWriter interface {
+	write(e ExportedEvent) error
+	close() error
+}
+
+// ndjsonExport writes exported events as NDJSON.
+type ndjsonExport struct {
+	enc *json.Encoder
+}
+
+func (x *ndjsonExport) write(e ExportedEvent) error {
+	return x.enc.Encode(e)
+}
+
+func (x *ndjsonExport) close() error {
+	return nil
+}
+
+// parquetExport writes exported events as Parquet.
+type parquetExport struct {
+	p *parquetWriter
+
+	namespace, aggregateType, aggregateID, version, eventType,
+	timestamp, position, data, metadata *parquetColumn
+}
+
+// newParquetExport starts a Parquet file with the columns of ExportedEvent,
+// in field order.
+func newParquetExport(w io.Writer) (*parquetExport, error) {
+	str := func(name string) *parquetColumn {
+		return &parquetColumn{name: name, physicalType: parquetByteArray, convertedType: parquetUTF8}
+	}
+	x := &parquetExport{
+		namespace:     str("namespace"),
+		aggregateType: str("aggregate_type"),
+		aggregateID:   str("aggregate_id"),
+		version:       &parquetColumn{name: "version", physicalType: parquetInt64, convertedType: parquetNoConvertedType},
+		eventType:     str("event_type"),
+		timestamp:     &parquetColumn{name: "timestamp", physicalType: parquetInt64, convertedType: parquetTimestampMillis},
+		position:      &parquetColumn{name: "position", physicalType: parquetInt64, convertedType: parquetNoConvertedType},
+		data:          str("data"),
+		metadata:      str("metadata"),
+	}
+
+	var err error
+	x.p, err = newParquetWriter(w, []*parquetColumn{
+		x.namespace, x.aggregateType, x.aggregateID, x.version, x.eventType,
+		x.timestamp, x.position, x.data, x.metadata,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+func (x *parquetExport) write(e ExportedEvent) error {
+	x.namespace.bytesValue([]byte(e.Namespace))
+	x.aggregateType.bytesValue([]byte(e.AggregateType))
+	x.aggregateID.bytesValue([]byte(e.AggregateID))
+	x.version.int64Value(int64(e.Version))
+	x.eventType.bytesValue([]byte(e.EventType))
+	x.timestamp.int64Value(e.Timestamp.UnixNano() / int64(time.Millisecond))
+	x.position.int64Value(e.Position)
+	x.data.bytesValue(e.Data)
+	x.metadata.bytesValue(e.Metadata)
+	return x.p.endRow()
+}
+
+func (x *parquetExport) close() error {
+	return x.p.close()
+}
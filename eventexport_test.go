@@ -0,0 +1,78 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestExport will export the selected events as NDJSON and Parquet
+func (suite *EventStoreTestSuite) TestExport() {
+	id, other := uuid.New(), uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	event1 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event1"}, timestamp, mocks.AggregateType, id, 1)
+	event2 := eh.NewEventForAggregate(mocks.EventOtherType, &mocks.EventData{Content: "event2"}, timestamp, mocks.AggregateType, id, 2)
+	assert.Nil(suite.T(), suite.store.Save(suite.ctx, []eh.Event{event1, event2}, 0))
+	otherEvent := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "other"}, timestamp, mocks.AggregateType, other, 1)
+	assert.Nil(suite.T(), suite.store.Save(suite.ctx, []eh.Event{otherEvent}, 0))
+
+	var buf bytes.Buffer
+	assert.Nil(suite.T(), suite.store.Export(suite.ctx, &buf, ExportNDJSON, EventFilter{
+		Namespaces:   []string{"ns"},
+		AggregateIDs: []uuid.UUID{id},
+	}))
+	var exported []ExportedEvent
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var e ExportedEvent
+		assert.Nil(suite.T(), dec.Decode(&e))
+		exported = append(exported, e)
+	}
+	if assert.Len(suite.T(), exported, 2) {
+		assert.Equal(suite.T(), "ns", exported[0].Namespace)
+		assert.Equal(suite.T(), id.String(), exported[0].AggregateID)
+		assert.Equal(suite.T(), 1, exported[0].Version)
+		assert.Equal(suite.T(), string(mocks.EventType), exported[0].EventType)
+		assert.True(suite.T(), timestamp.Equal(exported[0].Timestamp))
+		assert.Contains(suite.T(), string(exported[0].Data), "event1")
+		assert.Equal(suite.T(), string(mocks.EventOtherType), exported[1].EventType)
+	}
+
+	// The event types are filtered by the table scan.
+	buf.Reset()
+	assert.Nil(suite.T(), suite.store.Export(suite.ctx, &buf, ExportNDJSON, EventFilter{
+		Namespaces: []string{"ns"},
+		EventTypes: []eh.EventType{mocks.EventType},
+	}))
+	assert.Equal(suite.T(), 2, strings.Count(buf.String(), "\n"))
+
+	buf.Reset()
+	assert.Nil(suite.T(), suite.store.Export(suite.ctx, &buf, ExportParquet, EventFilter{Namespaces: []string{"ns"}}))
+	assert.True(suite.T(), bytes.HasPrefix(buf.Bytes(), []byte(parquetMagic)))
+	assert.True(suite.T(), bytes.HasSuffix(buf.Bytes(), []byte(parquetMagic)))
+
+	err := suite.store.Export(suite.ctx, &buf, "csv", EventFilter{})
+	if esErr, ok := err.(eh.EventStoreError); !ok || esErr.Err != ErrUnknownExportFormat {
+		suite.T().Error("there should be an unknown format error:", err)
+	}
+}
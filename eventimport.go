@@ -0,0 +1,459 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/google/uuid"
+	"github.com/guregu/dynamo"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// defaultImportWorkers is the default number of batches that Import writes
+// at once.
+const defaultImportWorkers = 4
+
+// EventIterator is a source of events to import. Next returns the next
+// event, or io.EOF when there are no more events.
+type EventIterator interface {
+	Next(ctx context.Context) (eh.Event, error)
+}
+
+// EventIteratorFunc is a function that is an EventIterator.
+type EventIteratorFunc func(ctx context.Context) (eh.Event, error)
+
+// Next implements the Next method of the EventIterator interface.
+func (f EventIteratorFunc) Next(ctx context.Context) (eh.Event, error) {
+	return f(ctx)
+}
+
+// ImportReport is the report of Import.
+type ImportReport struct {
+	Namespace string
+	Started   time.Time
+	Finished  time.Time
+	// Read is the number of events read from the iterator, Imported the
+	// number of them that were written and Skipped the number of them that
+	// already existed.
+	Read     int
+	Imported int
+	Skipped  int
+	// Aggregates is the number of aggregates of the imported events.
+	Aggregates int
+}
+
+// ImportOption is an option setter used to configure an import.
+type ImportOption func(*importConfig) error
+
+// importConfig is the config of an import.
+type importConfig struct {
+	workers  int
+	progress func(ImportReport)
+}
+
+// WithImportWorkers sets the number of batches that are written at once. The
+// default is 4.
+func WithImportWorkers(n int) ImportOption {
+	return func(c *importConfig) error {
+		if n < 1 {
+			return fmt.Errorf("invalid import workers %d", n)
+		}
+		c.workers = n
+		return nil
+	}
+}
+
+// WithImportProgress calls fn with the report so far after every written
+// batch of events.
+func WithImportProgress(fn func(ImportReport)) ImportOption {
+	return func(c *importConfig) error {
+		c.progress = fn
+		return nil
+	}
+}
+
+// importBatch is a batch of events to write in one BatchWriteItem.
+type importBatch struct {
+	events []*dbEvent
+	tables []string
+}
+
+// Import writes historical events from an iterator into the namespace of the
+// context, for migrations from other event stores. The events are written in
+// batches by parallel workers, without the version checks of Save; instead
+// the versions of each aggregate must increase in the order of the iterator,
+// which is validated before writing. The version of each aggregate is set to
+// its highest imported version at the end, unless it is already higher, so
+// that Save can continue the imported streams.
+//
+// Events that already exist with the same versions, from an earlier run or
+// saved by live writers, are skipped and never overwritten, which makes it
+// safe to run an import again after a failure and to backfill aggregates
+// that are in use. Each batch is written in a transaction with conditional
+// puts, and event by event when some of its events were saved concurrently.
+// The imported events are not passed
+// to the event handler. The report is returned also on error, with the events
+// that were imported until then; the versions of the aggregates are only set
+// when all events were imported.
+func (s *EventStore) Import(ctx context.Context, it EventIterator, options ...ImportOption) (*ImportReport, error) {
+	ctx, err := s.namespace(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &importConfig{workers: defaultImportWorkers}
+	for _, option := range options {
+		if err := option(c); err != nil {
+			return nil, eh.EventStoreError{
+				Err:       err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+	}
+
+	report := &ImportReport{
+		Namespace: eh.NamespaceFromContext(ctx),
+		Started:   time.Now(),
+	}
+	defer func() {
+		report.Finished = time.Now()
+	}()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var mu sync.Mutex
+	var firstErr error
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+
+	batches := make(chan importBatch)
+	var wg sync.WaitGroup
+	for i := 0; i < c.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b := range batches {
+				imported, err := s.importBatch(ctx, b)
+				if err != nil {
+					fail(err)
+					continue
+				}
+				mu.Lock()
+				report.Imported += imported
+				report.Skipped += len(b.events) - imported
+				if c.progress != nil {
+					c.progress(*report)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	versions, err := s.readImport(ctx, it, batches, report, &mu)
+	close(batches)
+	wg.Wait()
+	if err != nil {
+		fail(err)
+	}
+	mu.Lock()
+	report.Aggregates = len(versions)
+	err = firstErr
+	mu.Unlock()
+	if err != nil {
+		return report, err
+	}
+
+	if err := s.importVersions(ctx, versions, c.workers); err != nil {
+		return report, err
+	}
+	if s.cache != nil {
+		s.cache.clear()
+	}
+	return report, nil
+}
+
+// readImport reads and validates the events of an import and sends them to
+// the workers in batches. It returns the highest version of each aggregate.
+func (s *EventStore) readImport(ctx context.Context, it EventIterator, batches chan<- importBatch, report *ImportReport, mu *sync.Mutex) (map[uuid.UUID]int, error) {
+	versions := map[uuid.UUID]int{}
+	var b importBatch
+	send := func() error {
+		if len(b.events) == 0 {
+			return nil
+		}
+		if s.globalPosition {
+			position, err := s.reservePositions(ctx, len(b.events))
+			if err != nil {
+				return err
+			}
+			positionedAt := s.now().UnixNano()
+			for _, e := range b.events {
+				e.Feed = globalFeed
+				e.Position = position
+				e.PositionedAt = positionedAt
+				position++
+			}
+		}
+
+		select {
+		case batches <- b:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		b = importBatch{}
+		return nil
+	}
+
+	for {
+		event, err := it.Next(ctx)
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return versions, eh.EventStoreError{
+				Err:       err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+		mu.Lock()
+		report.Read++
+		mu.Unlock()
+
+		// The versions of an aggregate must increase, as there is no
+		// version check when writing.
+		if event.Version() <= versions[event.AggregateID()] {
+			return versions, eh.EventStoreError{
+				Err:       eh.ErrIncorrectEventVersion,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+		versions[event.AggregateID()] = event.Version()
+
+		if err := s.validateEvent(ctx, event); err != nil {
+			return versions, err
+		}
+		e, err := s.newDBEvent(ctx, event)
+		if err != nil {
+			return versions, err
+		}
+		e.ReceivedAt = s.now()
+		tableName := s.eventTableName(ctx, event.Timestamp())
+		if s.partitions != nil {
+			if err := s.ensurePartition(ctx, tableName); err != nil {
+				return versions, err
+			}
+		}
+		b.events = append(b.events, e)
+		b.tables = append(b.tables, tableName)
+
+		if len(b.events) == maxBatchWriteItems {
+			if err := send(); err != nil {
+				return versions, err
+			}
+		}
+	}
+
+	return versions, send()
+}
+
+// importBatch writes the events of a batch that don't exist yet and returns
+// the number of written events. The payload references are added in the
+// same transactions, so that they are only counted for new events.
+func (s *EventStore) importBatch(ctx context.Context, b importBatch) (int, error) {
+	existing, err := s.existingEvents(ctx, b)
+	if err != nil {
+		return 0, err
+	}
+
+	var events []*dbEvent
+	var items [][]*dynamodb.TransactWriteItem
+	for i, e := range b.events {
+		if existing[eventKey{AggregateID: e.AggregateID, Version: e.Version}] {
+			continue
+		}
+		item, err := dynamo.MarshalItem(e)
+		if err != nil {
+			return 0, eh.EventStoreError{
+				BaseErr:   err,
+				Err:       wrapError(ErrCouldNotMarshalEvent, err),
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+		eventItems := []*dynamodb.TransactWriteItem{{
+			Put: &dynamodb.Put{
+				TableName:           aws.String(b.tables[i]),
+				Item:                item,
+				ConditionExpression: aws.String(s.keyNotExists()),
+			},
+		}}
+		eventItems = append(eventItems, s.chunkItems(ctx, b.tables[i], e, nil)...)
+		events = append(events, e)
+		items = append(items, eventItems)
+	}
+
+	// Write the whole batch at once, unless any of its events was saved
+	// since it was checked.
+	var all []*dynamodb.TransactWriteItem
+	for _, eventItems := range items {
+		all = append(all, eventItems...)
+	}
+	if s.payloads != nil {
+		all = append(all, s.payloadRefItems(events)...)
+	}
+	if len(events) > 1 && len(all) <= maxTransactItems {
+		if err := s.importWrite(ctx, all); err == nil {
+			return len(events), nil
+		} else if !isConditionalCheckFailed(err) {
+			return 0, importError(ctx, err)
+		}
+	}
+
+	imported := 0
+	for i, e := range events {
+		eventItems := items[i]
+		if s.payloads != nil {
+			eventItems = append(eventItems, s.payloadRefItems([]*dbEvent{e})...)
+		}
+		if err := s.importWrite(ctx, eventItems); isConditionalCheckFailed(err) {
+			continue
+		} else if err != nil {
+			return imported, importError(ctx, err)
+		}
+		imported++
+	}
+	return imported, nil
+}
+
+// existingEvents returns the keys of the events of a batch that exist.
+func (s *EventStore) existingEvents(ctx context.Context, b importBatch) (map[eventKey]bool, error) {
+	keys := map[string][]dynamo.Keyed{}
+	var tables []string
+	for i, e := range b.events {
+		if _, ok := keys[b.tables[i]]; !ok {
+			tables = append(tables, b.tables[i])
+		}
+		keys[b.tables[i]] = append(keys[b.tables[i]], dynamo.Keys{s.hashValue(ctx, e.AggregateID), e.Version})
+	}
+
+	existing := map[eventKey]bool{}
+	for _, tableName := range tables {
+		var found []eventKey
+		start := time.Now()
+		err := s.service.Table(tableName).
+			Batch(s.hashKey(), "Version").
+			Get(keys[tableName]...).
+			Consistent(true).
+			AllWithContext(ctx, &found)
+		observe(ctx, s.metrics, OperationBatchGetItem, tableName, start, err)
+		if err != nil && err != dynamo.ErrNotFound {
+			return nil, importError(ctx, err)
+		}
+		for _, k := range found {
+			existing[k] = true
+		}
+	}
+	return existing, nil
+}
+
+// importWrite writes items of imported events in a transaction.
+func (s *EventStore) importWrite(ctx context.Context, items []*dynamodb.TransactWriteItem) error {
+	tableName := aws.StringValue(items[0].Put.TableName)
+	start := time.Now()
+	_, err := s.service.Client().TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+	observe(ctx, s.metrics, OperationTransactWriteItems, tableName, start, err)
+	return err
+}
+
+// importError returns an error of an import as an event store error.
+func importError(ctx context.Context, err error) error {
+	return eh.EventStoreError{
+		BaseErr:   withRequestID(err),
+		Err:       err,
+		Namespace: eh.NamespaceFromContext(ctx),
+	}
+}
+
+// importVersions sets the versions of the imported aggregates in their head
+// items, unless they are already higher, with parallel workers.
+func (s *EventStore) importVersions(ctx context.Context, versions map[uuid.UUID]int, workers int) error {
+	tableName := s.tableName(ctx)
+	ids := make(chan uuid.UUID)
+	errs := make(chan error, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range ids {
+				version := strconv.Itoa(versions[id])
+				start := time.Now()
+				_, err := s.service.Client().UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+					TableName:           aws.String(tableName),
+					Key:                 s.itemKey(ctx, id, aggregateHeadVersion),
+					UpdateExpression:    aws.String("SET CurrentVersion = :version"),
+					ConditionExpression: aws.String(s.keyNotExists() + " OR CurrentVersion < :version"),
+					ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+						":version": {N: aws.String(version)},
+					},
+				})
+				observe(ctx, s.metrics, OperationUpdateItem, tableName, start, err)
+				if err != nil && !isConditionalCheckFailed(err) {
+					errs <- eh.EventStoreError{
+						BaseErr:   withRequestID(err),
+						Err:       err,
+						Namespace: eh.NamespaceFromContext(ctx),
+					}
+					return
+				}
+			}
+		}()
+	}
+
+	var err error
+	for id := range versions {
+		select {
+		case ids <- id:
+			continue
+		case err = <-errs:
+		}
+		break
+	}
+	close(ids)
+	wg.Wait()
+	if err == nil {
+		select {
+		case err = <-errs:
+		default:
+		}
+	}
+	return err
+}
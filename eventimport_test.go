@@ -0,0 +1,93 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestImport will write historical events in bulk
+func (suite *EventStoreTestSuite) TestImport() {
+	id1, id2 := uuid.New(), uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	var events []eh.Event
+	for i := 1; i <= 30; i++ {
+		events = append(events, eh.NewEventForAggregate(mocks.EventType,
+			&mocks.EventData{Content: fmt.Sprintf("event%d", i)}, timestamp, mocks.AggregateType, id1, i))
+		if i <= 5 {
+			events = append(events, eh.NewEventForAggregate(mocks.EventType,
+				&mocks.EventData{Content: fmt.Sprintf("event%d", i)}, timestamp, mocks.AggregateType, id2, i*2))
+		}
+	}
+	iterator := func(events []eh.Event) EventIterator {
+		return EventIteratorFunc(func(ctx context.Context) (eh.Event, error) {
+			if len(events) == 0 {
+				return nil, io.EOF
+			}
+			e := events[0]
+			events = events[1:]
+			return e, nil
+		})
+	}
+
+	var progress []int
+	report, err := suite.store.Import(suite.ctx, iterator(events), WithImportWorkers(2),
+		WithImportProgress(func(r ImportReport) {
+			progress = append(progress, r.Imported)
+		}))
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 35, report.Read)
+	assert.Equal(suite.T(), 35, report.Imported)
+	assert.Equal(suite.T(), 2, report.Aggregates)
+	if assert.Len(suite.T(), progress, 2) {
+		assert.Equal(suite.T(), 35, progress[1])
+	}
+
+	loaded, err := suite.store.Load(suite.ctx, id1)
+	assert.Nil(suite.T(), err)
+	assert.Len(suite.T(), loaded, 30)
+	version, err := suite.store.AggregateVersion(suite.ctx, id2)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 10, version)
+
+	// Save continues the imported stream.
+	event31 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event31"},
+		timestamp, mocks.AggregateType, id1, 31)
+	assert.Nil(suite.T(), suite.store.Save(suite.ctx, []eh.Event{event31}, 30))
+
+	// Importing again skips the existing events and doesn't lower the
+	// version of the aggregate.
+	report, err = suite.store.Import(suite.ctx, iterator(events[:1]))
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 0, report.Imported)
+	assert.Equal(suite.T(), 1, report.Skipped)
+	version, err = suite.store.AggregateVersion(suite.ctx, id1)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 31, version)
+
+	// Versions must increase per aggregate.
+	_, err = suite.store.Import(suite.ctx, iterator([]eh.Event{events[2], events[0]}))
+	if esErr, ok := err.(eh.EventStoreError); !ok || esErr.Err != eh.ErrIncorrectEventVersion {
+		suite.T().Error("there should be an incorrect version error:", err)
+	}
+}
@@ -18,6 +18,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sort"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -25,9 +28,13 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/google/uuid"
 	"github.com/guregu/dynamo"
 	eh "github.com/looplab/eventhorizon"
+	"github.com/sysbot/eh-dynamodb/cursor"
 )
 
 // ErrCouldNotClearDB is when the database could not be cleared.
@@ -42,19 +49,97 @@ var ErrCouldNotUnmarshalEvent = errors.New("could not unmarshal event")
 // ErrCouldNotSaveAggregate is when an aggregate could not be saved.
 var ErrCouldNotSaveAggregate = errors.New("could not save aggregate")
 
+// ErrNoEventCodec is when an event is encoded by a codec but no codec is set.
+var ErrNoEventCodec = errors.New("no event codec set")
+
+// ErrTooManyEvents is when more events are saved at once than fit in a transaction.
+var ErrTooManyEvents = errors.New("too many events to save in one transaction")
+
+// maxTransactItems is the maximum number of items in a DynamoDB transaction.
+const maxTransactItems = 100
+
+// renameBatchSize is the number of events that are renamed in a transaction.
+const renameBatchSize = 25
+
+// loadManyParallelism is the number of aggregates that LoadMany loads at once.
+const loadManyParallelism = 8
+
+// eventTypeIndexName is the name of the event type index.
+const eventTypeIndexName = "EventTypeIndex"
+
+// eventTypeIndex is the index used to find events by event type.
+var eventTypeIndex = tableIndex{
+	name:        eventTypeIndexName,
+	hashKey:     "EventType",
+	hashKeyType: dynamodb.ScalarAttributeTypeS,
+	projection:  dynamodb.ProjectionTypeKeysOnly,
+}
+
 // EventStore implements an EventStore for DynamoDB.
 type EventStore struct {
 	tablePrefix  string
 	service      *dynamo.DB
+	session      *session.Session
+	awsConfig    *aws.Config
 	eventHandler eh.EventHandler
 	tableName    func(context.Context) string
+	typeNames    *TypeNames
+	metrics      Metrics
+
+	namespaceConfigs *NamespaceConfigs
+	capacityMetrics  CapacityMetrics
+	forward          *forwardBuffer
+	indexes          []tableIndex
+	codec            Codec
+	upcasters        map[eh.EventType][]UpcastFunc
+	overflow         *s3Overflow
+	archive          *eventArchive
+	chunking         *eventChunking
+	scanLimit        *scanLimit
+	sharedTable      bool
+	autoCreateTable  bool
+	saveInterceptors []SaveInterceptor
+	tableWaiter      *TableWaiter
+	encryption       *encryption
+	shredding        *shredding
+	partitions       *partitions
+
+	compression        Compression
+	compressionMinSize int
+	globalPosition     bool
+	payloads           *payloadDedup
+	cache              *loadCache
+	outbox             *outbox
+	handlerQueue       *handlerQueue
+	loadFilter         *EventFilter
+	validators         []EventValidator
+	logger             *logger
+	kinesis            *kinesisPublisher
+	namespaceProvider  NamespaceProvider
+	loadLimit          int
+	retryPolicy        *RetryPolicy
+	activityBucket     time.Duration
+	readConsistency    ReadConsistency
+	errorClassifier    ErrorClassifier
+	clock              func() time.Time
+	versionValidation  VersionValidation
+	eventTTL           time.Duration
+	ttlEnabled         bool
+	snapshotFallback   *SnapshotStore
+	scheduler          *Scheduler
+	environment        *environment
+	dax                *dynamo.DB
+	cursors            *cursor.Signer
+	lifecycle          *lifecycle
 }
 
 // Option is an option setter used to configure creation.
 type Option func(*EventStore) error
 
 // WithEventHandler adds an event handler that will be called when saving events.
-// An example would be to add an event bus to publish events.
+// An example would be to add an event bus to publish events. The events carry
+// their global position, see GlobalPosition. Save waits for the handler, see
+// WithAsyncEventHandler to handle the events in the background.
 func WithEventHandler(h eh.EventHandler) Option {
 	return func(s *EventStore) error {
 		s.eventHandler = h
@@ -62,29 +147,34 @@ func WithEventHandler(h eh.EventHandler) Option {
 	}
 }
 
-// WithDBName uses a custom DB name function.
+// WithDynamoDB uses a custom AWS session. Without it a session is created
+// from the standard AWS config chain and the config options.
 func WithDynamoDB(sess *session.Session) Option {
 	return func(r *EventStore) error {
 		r.service = dynamo.New(sess)
+		r.session = sess
 		return nil
 	}
 }
 
-// NewEventStore creates a new EventStore.
-func NewEventStore(tablePrefix string, options ...Option) (*EventStore, error) {
-	awsConfig := &aws.Config{
-		Region:   aws.String("us-west-2"),
-		Endpoint: aws.String("http://localhost:8000"),
-	}
-
-	sess, err := session.NewSession(awsConfig)
-	if err != nil {
-		return nil, ErrCouldNotDialDB
+// WithEventTypeIndex adds a global secondary index on the event type of
+// events to the table in CreateTable, which RenameEvent uses instead of
+// scanning the whole table and which is needed by LoadByEventType. As the
+// index is eventually consistent, events that are saved while renaming may
+// not be renamed.
+func WithEventTypeIndex() Option {
+	return func(s *EventStore) error {
+		s.indexes = append(s.indexes, eventTypeIndex)
+		return nil
 	}
+}
 
+// NewEventStore creates a new EventStore.
+func NewEventStore(tablePrefix string, options ...Option) (*EventStore, error) {
 	s := &EventStore{
-		tablePrefix: "eventhorizonEvents",
-		service:     dynamo.New(sess),
+		tablePrefix: tablePrefix,
+		awsConfig:   aws.NewConfig(),
+		lifecycle:   &lifecycle{},
 	}
 
 	s.tableName = func(ctx context.Context) string {
@@ -97,68 +187,327 @@ func NewEventStore(tablePrefix string, options ...Option) (*EventStore, error) {
 			return nil, fmt.Errorf("error while applying option: %v", err)
 		}
 	}
+	if err := s.applyEnvironment(); err != nil {
+		return nil, err
+	}
+	if err := s.checkSharedTable(); err != nil {
+		return nil, err
+	}
+	if s.cursors == nil {
+		var err error
+		if s.cursors, err = newCursorSigner(); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.service == nil {
+		sess, err := newSession(s.awsConfig)
+		if err != nil {
+			return nil, ErrCouldNotDialDB
+		}
+		s.service = dynamo.New(sess)
+		s.session = sess
+	}
+	applyRetryPolicy(s.service, s.retryPolicy, s.errorClassifier)
+	applyCapacityMetrics(s.service, s.capacityMetrics)
+	applyLogger(s.service, s.logger)
+	applyTableClass(s.service)
+
+	if s.overflow != nil && s.overflow.client == nil {
+		// Use the default S3 endpoint, the session may have a custom endpoint
+		// for DynamoDB.
+		s.overflow.client = s3.New(s.session, aws.NewConfig().WithEndpoint(""))
+	}
+	if s.archive != nil && s.archive.client == nil {
+		s.archive.client = s3.New(s.session, aws.NewConfig().WithEndpoint(""))
+	}
+	if s.encryption != nil && s.encryption.client == nil {
+		s.encryption.client = kms.New(s.session, aws.NewConfig().WithEndpoint(""))
+	}
+	if s.kinesis != nil && s.kinesis.client == nil {
+		s.kinesis.client = kinesis.New(s.session, aws.NewConfig().WithEndpoint(""))
+	}
+
+	if s.forward != nil {
+		if s.scheduler != nil {
+			if err := s.scheduleJob("forward", s.forward.interval, s.forward.onError, s.Flush); err != nil {
+				return nil, err
+			}
+		} else {
+			s.lifecycle.goroutine(func() { s.forward.run(s) })
+		}
+	}
+	if s.outbox != nil {
+		if s.scheduler != nil {
+			if err := s.scheduleJob("outbox", s.outbox.interval, s.outbox.onError, s.RelayOutbox); err != nil {
+				return nil, err
+			}
+		} else {
+			s.lifecycle.goroutine(s.runOutbox)
+		}
+	}
+	if s.handlerQueue != nil {
+		s.handlerQueue.start(s)
+	}
 
 	return s, nil
 }
 
 // Save implements the Save method of the eventhorizon.EventStore interface.
 func (s *EventStore) Save(ctx context.Context, events []eh.Event, originalVersion int) error {
+	_, err := s.SaveWithResult(ctx, events, originalVersion)
+	return err
+}
+
+// save saves events to the event table.
+func (s *EventStore) save(ctx context.Context, events []eh.Event, originalVersion int) ([]SavedEvent, error) {
 	if len(events) == 0 {
-		return eh.EventStoreError{
+		return nil, eh.EventStoreError{
 			Err:       eh.ErrNoEventsToAppend,
 			Namespace: eh.NamespaceFromContext(ctx),
 		}
 	}
 
+	checks, err := conditionCheckItems(ctx)
+	if err != nil {
+		return nil, eh.EventStoreError{
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+	if len(events) > maxTransactItems-1-len(checks) {
+		return nil, eh.EventStoreError{
+			Err:       ErrTooManyEvents,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	// Reserve the global positions of the events, if enabled.
+	var position int64
+	if s.globalPosition {
+		if position, err = s.reservePositions(ctx, len(events)); err != nil {
+			return nil, err
+		}
+	}
+	receivedAt := s.now()
+	positionedAt := receivedAt.UnixNano()
+
 	// Build all event records, with incrementing versions starting from the
 	// original aggregate version.
 	aggregateID := events[0].AggregateID()
 	version := originalVersion
-	table := s.service.Table(s.tableName(ctx))
+	tableName := s.tableName(ctx)
+	items := make([]*dynamodb.TransactWriteItem, 0, len(events))
+	dbEvents := make([]*dbEvent, 0, len(events))
+	saved := make([]SavedEvent, 0, len(events))
 	for _, event := range events {
 		// Only accept events belonging to the same aggregate.
 		if event.AggregateID() != aggregateID {
-			return eh.EventStoreError{
+			return nil, eh.EventStoreError{
 				Err:       eh.ErrInvalidEvent,
 				Namespace: eh.NamespaceFromContext(ctx),
 			}
 		}
 
 		// Only accept events that apply to the correct aggregate version.
-		if event.Version() != version+1 {
-			return eh.EventStoreError{
+		if !s.validVersion(event.Version(), version) {
+			return nil, eh.EventStoreError{
 				Err:       eh.ErrIncorrectEventVersion,
 				Namespace: eh.NamespaceFromContext(ctx),
 			}
 		}
 
+		if err := s.validateEvent(ctx, event); err != nil {
+			return nil, err
+		}
+
 		// Create the event record for the DB.
-		e, err := newDBEvent(ctx, event)
+		e, err := s.newDBEvent(ctx, event)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		version++
-
-		// TODO: Implement atomic version counter for the aggregate.
-		// TODO: Batch write all events.
-		// TODO: Support translating not found to not be an error but an
-		// empty list.
-		if err := table.Put(e).If("attribute_not_exists(AggregateID) AND attribute_not_exists(Version)").Run(); err != nil {
-			if err, ok := err.(awserr.RequestFailure); ok && err.Code() == "ConditionalCheckFailedException" {
-				return eh.EventStoreError{
-					BaseErr:   err,
-					Err:       ErrCouldNotSaveAggregate,
-					Namespace: eh.NamespaceFromContext(ctx),
-				}
+		if event.Version() > version {
+			version = event.Version()
+		}
+		e.ReceivedAt = receivedAt
+		dbEvents = append(dbEvents, e)
+		if s.globalPosition {
+			e.Feed = globalFeed
+			e.Position = position
+			e.PositionedAt = positionedAt
+			position++
+		}
+
+		item, err := dynamo.MarshalItem(e)
+		if err != nil {
+			return nil, eh.EventStoreError{
+				BaseErr:   withRequestID(err),
+				Err:       wrapError(ErrCouldNotMarshalEvent, err),
+				Namespace: eh.NamespaceFromContext(ctx),
 			}
-			return eh.EventStoreError{
+		}
+		saved = append(saved, SavedEvent{
+			Event:    newSavedEvent(ctx, event, e),
+			Position: e.Position,
+			ItemSize: itemSize(item),
+		})
+		eventTableName := s.eventTableName(ctx, event.Timestamp())
+		if s.partitions != nil {
+			if err := s.ensurePartition(ctx, eventTableName); err != nil {
+				return nil, err
+			}
+		}
+		items = append(items, &dynamodb.TransactWriteItem{
+			Put: &dynamodb.Put{
+				TableName:           aws.String(eventTableName),
+				Item:                item,
+				ConditionExpression: aws.String(s.keyNotExists() + " AND attribute_not_exists(Version)"),
+			},
+		})
+		items = append(items, s.chunkItems(ctx, eventTableName, e, nil)...)
+	}
+
+	// Update the version counter of the aggregate in the same transaction, so
+	// that concurrent writers fail on the counter instead of interleaving
+	// versions. Streams written before the counter existed have no head item.
+	head := &dynamodb.Update{
+		TableName:           aws.String(tableName),
+		Key:                 s.itemKey(ctx, aggregateID, aggregateHeadVersion),
+		UpdateExpression:    aws.String("SET CurrentVersion = :version"),
+		ConditionExpression: aws.String(s.keyNotExists()),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":version": {N: aws.String(strconv.Itoa(version))},
+		},
+	}
+	if originalVersion > 0 {
+		head.ConditionExpression = aws.String(s.keyNotExists() + " OR CurrentVersion = :original")
+		head.ExpressionAttributeValues[":original"] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(originalVersion))}
+	}
+	if hash, ok := stateHashFromContext(ctx); ok {
+		hash.apply(head)
+	}
+	items = append(items, &dynamodb.TransactWriteItem{Update: head})
+	items = append(items, checks...)
+	if s.payloads != nil {
+		items = append(items, s.payloadRefItems(dbEvents)...)
+	}
+	var dispatchKey string
+	if s.outbox != nil {
+		var item *dynamodb.TransactWriteItem
+		if item, dispatchKey, err = s.outboxItem(ctx, aggregateID, originalVersion+1, version); err != nil {
+			return nil, eh.EventStoreError{
 				BaseErr:   err,
-				Err:       err,
+				Err:       wrapError(ErrCouldNotMarshalEvent, err),
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+		items = append(items, item)
+	}
+	if len(items) > maxTransactItems {
+		return nil, eh.EventStoreError{
+			Err:       ErrTooManyEvents,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	// TODO: Support translating not found to not be an error but an
+	// empty list.
+
+	// Write all events in one transaction, either all or none are persisted.
+	// In store-and-forward mode the events may instead be buffered, and are
+	// handled once they are forwarded.
+	input := &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	}
+	if s.forward != nil {
+		buffered, err := s.forward.write(ctx, s, forwardRecord{
+			TableName:       tableName,
+			Input:           input,
+			AggregateID:     aggregateID,
+			OriginalVersion: originalVersion,
+			Version:         version,
+			DispatchKey:     dispatchKey,
+		})
+		if err != nil {
+			return nil, err
+		} else if buffered {
+			return saved, nil
+		}
+	} else if err := s.writeEvents(ctx, tableName, input, originalVersion); err != nil {
+		return nil, err
+	}
+	writtenAt := s.now()
+	for i := range saved {
+		saved[i].WrittenAt = writtenAt
+	}
+
+	// The handler gets the events as saved, with their global position.
+	if err := s.written(ctx, aggregateID, originalVersion, version, events, savedEvents(saved), dispatchKey); err != nil {
+		return nil, err
+	}
+	return saved, nil
+}
+
+// written runs everything that follows the write of saved events: it updates
+// the load cache, publishes the events and lets the event handler, or the
+// handler queue, handle the saved events before removing their dispatch
+// record.
+func (s *EventStore) written(ctx context.Context, aggregateID uuid.UUID, originalVersion, version int, events, saved []eh.Event, dispatchKey string) error {
+	if s.cache != nil {
+		s.cache.saved(loadCacheKey(ctx, aggregateID), originalVersion, version, events)
+	}
+
+	if err := s.publishEvents(ctx, events); err != nil {
+		return err
+	}
+
+	if s.handlerQueue != nil {
+		return s.handlerQueue.enqueue(ctx, aggregateID, saved, dispatchKey)
+	}
+	if err := s.handleEvents(ctx, saved); err != nil {
+		return err
+	}
+	if s.outbox != nil {
+		return s.dispatched(ctx, dispatchKey)
+	}
+	return nil
+}
+
+// writeEvents runs the transaction with the event writes of a save with the
+// expected version originalVersion.
+func (s *EventStore) writeEvents(ctx context.Context, tableName string, input *dynamodb.TransactWriteItemsInput, originalVersion int) error {
+	err := s.autoCreate(ctx, func() error {
+		start := time.Now()
+		_, err := s.service.Client().TransactWriteItemsWithContext(ctx, input)
+		observe(ctx, s.metrics, OperationTransactWriteItems, tableName, start, err)
+		return err
+	})
+	if err != nil {
+		if isConditionCheckFailed(ctx, err, input.TransactItems) {
+			return eh.EventStoreError{
+				BaseErr:   withRequestID(err),
+				Err:       wrapError(ErrConditionCheckFailed, err),
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		} else if isConditionalCheckFailed(err) {
+			return eh.EventStoreError{
+				BaseErr:   withRequestID(err),
+				Err:       versionConflict(input, originalVersion, err),
 				Namespace: eh.NamespaceFromContext(ctx),
 			}
 		}
+		return eh.EventStoreError{
+			BaseErr:   withRequestID(err),
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
 	}
 
+	return nil
+}
+
+// handleEvents lets the optional event handler handle saved events.
+func (s *EventStore) handleEvents(ctx context.Context, events []eh.Event) error {
 	// Let the optional event handler handle the events. Aborts the transaction
 	// in case of error.
 	if s.eventHandler != nil {
@@ -177,34 +526,61 @@ func (s *EventStore) Save(ctx context.Context, events []eh.Event, originalVersio
 }
 
 // Load implements the Load method of the eventhorizon.EventStore interface.
-func (s *EventStore) Load(ctx context.Context, id uuid.UUID) ([]eh.Event, error) {
-	table := s.service.Table(s.tableName(ctx))
+func (s *EventStore) Load(ctx context.Context, id uuid.UUID) (_ []eh.Event, err error) {
+	called := time.Now()
+	defer func() { s.observeMethod(ctx, MethodLoad, called, err) }()
 
-	var dbEvents []dbEvent
-	err := table.Get("AggregateID", id.String()).Consistent(true).All(&dbEvents)
-	if err, ok := err.(awserr.RequestFailure); ok && err.Code() == "ResourceNotFoundException" {
-		return []eh.Event{}, nil
-	} else if err != nil {
-		return nil, eh.EventStoreError{
-			BaseErr:   err,
-			Err:       err,
-			Namespace: eh.NamespaceFromContext(ctx),
-		}
+	ctx, err = s.namespace(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	return s.buildEvents(ctx, dbEvents)
+	if s.cache != nil {
+		return s.loadCached(ctx, id)
+	}
+	return s.LoadFrom(ctx, id, 1)
 }
 
-// LoadAll will load all the events from the event store (useful to replay events)
-func (s *EventStore) LoadAll(ctx context.Context) ([]eh.Event, error) {
-	table := s.service.Table(s.tableName(ctx))
+// LoadFrom loads the events of an aggregate starting at a version, using a
+// range key condition to only read the tail of the stream. Useful for
+// aggregates that are rehydrated from a snapshot.
+func (s *EventStore) LoadFrom(ctx context.Context, id uuid.UUID, version int) ([]eh.Event, error) {
+	return s.loadFrom(ctx, id, version, true)
+}
 
-	var dbEvents []dbEvent
-	err := table.Scan().Consistent(true).All(&dbEvents)
+// loadFrom loads the events of an aggregate starting at a version, with or
+// without the load filter.
+func (s *EventStore) loadFrom(ctx context.Context, id uuid.UUID, version int, filtered bool) ([]eh.Event, error) {
+	ctx, err := s.namespace(ctx)
 	if err != nil {
-		return nil, eh.EventStoreError{
-			BaseErr:   err,
-			Err:       err,
+		return nil, err
+	}
+
+	// Never include the head item of the aggregate.
+	if version < 1 {
+		version = 1
+	}
+
+	limit := 0
+	if s.loadLimit > 0 {
+		limit = s.loadLimit + 1
+	}
+	dbEvents, err := s.queryEvents(ctx, id, version, limit, filtered)
+	if err != nil {
+		return nil, err
+	}
+	if s.loadLimit > 0 && len(dbEvents) > s.loadLimit {
+		cursor, err := s.eventsCursor(ctx, dbEvents[s.loadLimit-1])
+		if err != nil {
+			return nil, eh.EventStoreError{
+				Err:       err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+		return nil, LimitError{
+			Limit:     s.loadLimit,
+			Count:     s.loadLimit,
+			Cursor:    cursor,
 			Namespace: eh.NamespaceFromContext(ctx),
 		}
 	}
@@ -212,170 +588,1109 @@ func (s *EventStore) LoadAll(ctx context.Context) ([]eh.Event, error) {
 	return s.buildEvents(ctx, dbEvents)
 }
 
-func (s *EventStore) buildEvents(ctx context.Context, dbEvents []dbEvent) ([]eh.Event, error) {
-	events := make([]eh.Event, len(dbEvents))
-	for i, dbEvent := range dbEvents {
+// LoadMany loads the events of several aggregates, with a few queries at a
+// time, for projectors that hydrate many aggregates at once. Aggregates
+// without events have an empty stream in the result. It returns the first
+// error, without the other streams.
+func (s *EventStore) LoadMany(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID][]eh.Event, error) {
+	ctx, err := s.namespace(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-		// Create an event of the correct type.
-		if data, err := eh.CreateEventData(dbEvent.EventType); err == nil {
-			// Manually decode the raw event.
-			if err := dynamodbattribute.UnmarshalMap(dbEvent.RawData, data); err != nil {
-				return nil, eh.EventStoreError{
-					BaseErr:   err,
-					Err:       ErrCouldNotUnmarshalEvent,
-					Namespace: eh.NamespaceFromContext(ctx),
-				}
+	streams := make([][]eh.Event, len(ids))
+	errs := make([]error, len(ids))
+	sem := make(chan struct{}, loadManyParallelism)
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, id uuid.UUID) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			streams[i], errs[i] = s.Load(ctx, id)
+		}(i, id)
+	}
+	wg.Wait()
+
+	events := make(map[uuid.UUID][]eh.Event, len(ids))
+	for i, id := range ids {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		events[id] = streams[i]
+	}
+	return events, nil
+}
+
+// queryEvents queries the events of an aggregate starting at a version in
+// all partitions, in version order. At most limit events are read, or all
+// events if limit is 0. When filtered the load filter is applied, but the
+// latest event of the aggregate is always kept, see WithLoadFilter.
+func (s *EventStore) queryEvents(ctx context.Context, id uuid.UUID, version, limit int, filtered bool) ([]dbEvent, error) {
+	tables, err := s.eventTables(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var dbEvents []dbEvent
+	for _, tableName := range tables {
+		table := s.readService().Table(tableName)
+		query := table.Get(s.hashKey(), s.hashValue(ctx, id)).Range("Version", dynamo.GreaterOrEqual, version).Consistent(consistentRead(ctx, s.readConsistency))
+		if filtered {
+			query = s.filterLoad(query)
+		}
+		if limit > 0 {
+			if len(dbEvents) >= limit {
+				break
 			}
+			query = query.Limit(int64(limit - len(dbEvents)))
+		}
 
-			// Set concrete event and zero out the decoded event.
-			dbEvent.data = data
-			dbEvent.RawData = nil
+		var tableEvents []dbEvent
+		start := time.Now()
+		err := query.AllWithContext(ctx, &tableEvents)
+		observe(ctx, s.metrics, OperationQuery, tableName, start, err)
+		if isAWSErrorCode(err, dynamodb.ErrCodeResourceNotFoundException) {
+			continue
+		} else if err != nil {
+			return nil, eh.EventStoreError{
+				BaseErr:   withRequestID(err),
+				Err:       err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
 		}
+		dbEvents = append(dbEvents, tableEvents...)
+	}
 
-		events[i] = event{dbEvent: dbEvent}
+	// Merge the events of all partitions in version order.
+	if len(tables) > 1 {
+		sort.SliceStable(dbEvents, func(i, j int) bool {
+			return dbEvents[i].Version < dbEvents[j].Version
+		})
 	}
 
-	return events, nil
-}
+	// Fetch the events before the first event in the table from the archive,
+	// if they were archived and deleted.
+	if s.archive != nil && len(dbEvents) > 0 && dbEvents[0].Version > version {
+		archived, err := s.loadArchived(ctx, id, version, dbEvents[0].Version)
+		if err != nil {
+			return nil, err
+		}
+		if filtered {
+			archived = s.filterLoaded(archived)
+		}
+		dbEvents = append(archived, dbEvents...)
+		if limit > 0 && len(dbEvents) > limit {
+			dbEvents = dbEvents[:limit]
+		}
+	}
 
-// Replace implements the Replace method of the eventhorizon.EventStore interface.
-func (s *EventStore) Replace(ctx context.Context, event eh.Event) error {
-	table := s.service.Table(s.tableName(ctx))
+	// Keep the latest event if it was filtered, the version of a rehydrated
+	// aggregate is taken from its last event.
+	if filtered && s.loadFilter != nil && (limit == 0 || len(dbEvents) < limit) {
+		latest, err := s.latestEvent(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if latest != nil && latest.Version >= version &&
+			(len(dbEvents) == 0 || latest.Version > dbEvents[len(dbEvents)-1].Version) {
+			dbEvents = append(dbEvents, *latest)
+		}
+	}
+
+	return dbEvents, nil
+}
 
-	count, err := table.Get("AggregateID", event.AggregateID().String()).Consistent(true).Count()
+// AggregateVersion returns the current version of an aggregate, or 0 if it
+// has no events, without loading its events. Only the latest event is read,
+// which makes it cheap enough for command handlers and health checks.
+func (s *EventStore) AggregateVersion(ctx context.Context, id uuid.UUID) (int, error) {
+	ctx, err := s.namespace(ctx)
 	if err != nil {
-		return eh.EventStoreError{
-			BaseErr:   err,
-			Err:       err,
-			Namespace: eh.NamespaceFromContext(ctx),
-		}
-	} else if count == 0 {
-		return eh.ErrAggregateNotFound
+		return 0, err
 	}
 
-	// Create the event record for the DB.
-	e, err := newDBEvent(ctx, event)
+	latest, err := s.latestEvent(ctx, id, "AggregateID", "Version")
+	if err != nil || latest == nil {
+		return 0, err
+	}
+	return latest.Version, nil
+}
+
+// latestEvent reads the latest event of an aggregate, or nil if it has no
+// events. Only the attributes are read, if any are given.
+func (s *EventStore) latestEvent(ctx context.Context, id uuid.UUID, attributes ...string) (*dbEvent, error) {
+	tables, err := s.eventTables(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if err := table.Put(e).If("attribute_exists(AggregateID) AND attribute_exists(Version)").Run(); err != nil {
-		if err, ok := err.(awserr.RequestFailure); ok && err.Code() == "ConditionalCheckFailedException" {
-			return eh.ErrInvalidEvent
+	// The latest event is in the latest partition that has events.
+	for i := len(tables) - 1; i >= 0; i-- {
+		tableName := tables[i]
+		var latest []dbEvent
+		start := time.Now()
+		query := s.readService().Table(tableName).
+			Get(s.hashKey(), s.hashValue(ctx, id)).
+			Range("Version", dynamo.Greater, aggregateHeadVersion).
+			Order(dynamo.Descending).
+			Limit(1).
+			Consistent(consistentRead(ctx, s.readConsistency))
+		if len(attributes) > 0 {
+			query = query.Project(attributes...)
 		}
-		return eh.EventStoreError{
-			BaseErr:   err,
-			Err:       err,
-			Namespace: eh.NamespaceFromContext(ctx),
+		err := query.AllWithContext(ctx, &latest)
+		observe(ctx, s.metrics, OperationQuery, tableName, start, err)
+		if isAWSErrorCode(err, dynamodb.ErrCodeResourceNotFoundException) {
+			continue
+		} else if err != nil {
+			return nil, eh.EventStoreError{
+				BaseErr:   withRequestID(err),
+				Err:       err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+		if len(latest) > 0 {
+			return &latest[0], nil
 		}
 	}
 
-	return nil
+	return nil, nil
 }
 
-// RenameEvent implements the RenameEvent method of the eventhorizon.EventStore interface.
-func (s *EventStore) RenameEvent(ctx context.Context, from, to eh.EventType) error {
-	table := s.service.Table(s.tableName(ctx))
+// LoadAll will load all the events from the event store (useful to replay events)
+func (s *EventStore) LoadAll(ctx context.Context) (_ []eh.Event, err error) {
+	called := time.Now()
+	defer func() { s.observeMethod(ctx, MethodLoadAll, called, err) }()
 
-	var dbEvents []dbEvent
-	err := table.Scan().Filter("EventType = ?", from).Consistent(true).All(&dbEvents)
+	ctx, err = s.namespace(ctx)
 	if err != nil {
-		return eh.EventStoreError{
-			BaseErr:   err,
-			Err:       err,
-			Namespace: eh.NamespaceFromContext(ctx),
-		}
+		return nil, err
 	}
 
-	for _, dbEvent := range dbEvents {
-		if err := table.Update("AggregateID", dbEvent.AggregateID).Range("Version", dbEvent.Version).If("EventType = ?", from).Set("EventType", to).Run(); err != nil {
-			return eh.EventStoreError{
-				BaseErr:   err,
+	tables, err := s.eventTables(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var dbEvents []dbEvent
+	for _, tableName := range tables {
+		table := s.readService().Table(tableName)
+
+		// Resume the scan once if the credentials expire.
+		start := time.Now()
+		throttle := s.newScanThrottle()
+		var key dynamo.PagingKey
+		for retried := false; ; retried = true {
+			scan := table.Scan().Filter("Version > ?", aggregateHeadVersion).Consistent(consistentRead(ctx, s.readConsistency))
+			iter := throttle.scan(s.scanNamespace(ctx, scan)).StartFrom(key).Iter()
+			var e dbEvent
+			var waitErr error
+			for waitErr == nil && iter.NextWithContext(ctx, &e) {
+				dbEvents = append(dbEvents, e)
+				e = dbEvent{}
+				waitErr = throttle.wait(ctx)
+			}
+			if err = iter.Err(); waitErr != nil {
+				err = waitErr
+			}
+			if retried || !refreshExpiredCredentials(s.service.Client(), err) {
+				break
+			}
+			key = iter.LastEvaluatedKey()
+		}
+		observe(ctx, s.metrics, OperationScan, tableName, start, err)
+		if err != nil {
+			return nil, eh.EventStoreError{
+				BaseErr:   withRequestID(err),
 				Err:       err,
 				Namespace: eh.NamespaceFromContext(ctx),
 			}
 		}
 	}
 
-	return nil
+	return s.buildEvents(ctx, dbEvents)
 }
 
-// CreateTable creates the table if it is not already existing and correct.
-func (s *EventStore) CreateTable(ctx context.Context) error {
-	if err := s.service.CreateTable(s.tableName(ctx), dbEvent{}).Run(); err != nil {
+// LoadEach loads the events of an aggregate one by one, calling fn for each
+// event. The stream is read page by page and events are decoded lazily, so
+// that very long streams are never held in memory at once. It stops at the
+// first error from fn or when the context is canceled.
+func (s *EventStore) LoadEach(ctx context.Context, id uuid.UUID, fn func(eh.Event) error) error {
+	ctx, err := s.namespace(ctx)
+	if err != nil {
 		return err
 	}
 
-	describeParams := &dynamodb.DescribeTableInput{
-		TableName: aws.String(s.tableName(ctx)),
-	}
-	if err := s.service.Client().WaitUntilTableExists(describeParams); err != nil {
+	tables, err := s.eventTables(ctx)
+	if err != nil {
 		return err
 	}
 
+	for _, tableName := range tables {
+		if err := s.loadEach(ctx, tableName, id, fn); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-// DeleteTable deletes the event table.
-func (s *EventStore) DeleteTable(ctx context.Context) error {
-	table := s.service.Table(s.tableName(ctx))
-	err := table.DeleteTable().Run()
+// loadEach loads the events of an aggregate in one table one by one.
+func (s *EventStore) loadEach(ctx context.Context, tableName string, id uuid.UUID, fn func(eh.Event) error) error {
+	table := s.readService().Table(tableName)
+
+	// Resume the query once if the credentials expire.
+	start := time.Now()
+	var key dynamo.PagingKey
+	var err error
+	for retried := false; ; retried = true {
+		iter := table.Get(s.hashKey(), s.hashValue(ctx, id)).Range("Version", dynamo.Greater, aggregateHeadVersion).Consistent(consistentRead(ctx, s.readConsistency)).StartFrom(key).Iter()
+		var e dbEvent
+		for ctx.Err() == nil && iter.NextWithContext(ctx, &e) {
+			event, err := s.buildEvent(ctx, e)
+			if err != nil {
+				return err
+			}
+			if err := fn(event); err != nil {
+				return err
+			}
+			e = dbEvent{}
+		}
+		err = iter.Err()
+		if retried || !refreshExpiredCredentials(s.service.Client(), err) {
+			break
+		}
+		key = iter.LastEvaluatedKey()
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+	observe(ctx, s.metrics, OperationQuery, tableName, start, err)
+	if err != nil && !isAWSErrorCode(err, dynamodb.ErrCodeResourceNotFoundException) {
+		return eh.EventStoreError{
+			BaseErr:   withRequestID(err),
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	return nil
+}
+
+func (s *EventStore) buildEvents(ctx context.Context, dbEvents []dbEvent) ([]eh.Event, error) {
+	events := make([]eh.Event, len(dbEvents))
+	for i, dbEvent := range dbEvents {
+		event, err := s.buildEvent(ctx, dbEvent)
+		if err != nil {
+			return nil, err
+		}
+		events[i] = event
+	}
+
+	return events, nil
+}
+
+func (s *EventStore) buildEvent(ctx context.Context, dbEvent dbEvent) (eh.Event, error) {
+	// Map the storage names back to the registered types.
+	dbEvent.EventType = s.typeNames.EventType(string(dbEvent.EventType))
+	dbEvent.AggregateType = s.typeNames.AggregateType(string(dbEvent.AggregateType))
+
+	// Fetch event data that was offloaded to S3 or deduplicated.
+	codec := s.codec
+	if dbEvent.PayloadHash != "" {
+		if err := s.resolvePayload(ctx, &dbEvent); err != nil {
+			return nil, eh.EventStoreError{
+				BaseErr:   withRequestID(err),
+				Err:       wrapError(ErrCouldNotUnmarshalEvent, err),
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+		if !dbEvent.PayloadEncoded {
+			codec = JSONCodec{}
+		}
+	}
+	if dbEvent.DataRef != "" {
+		if err := s.rehydrateData(ctx, &dbEvent); err != nil {
+			return nil, eh.EventStoreError{
+				BaseErr:   withRequestID(err),
+				Err:       wrapError(ErrCouldNotUnmarshalEvent, err),
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+		if !dbEvent.DataRefEncoded {
+			codec = JSONCodec{}
+		}
+	}
+	if dbEvent.Chunks > 0 {
+		if err := s.assembleData(ctx, &dbEvent); err != nil {
+			return nil, eh.EventStoreError{
+				BaseErr:   err,
+				Err:       wrapError(ErrCouldNotUnmarshalEvent, err),
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+		if !dbEvent.ChunksEncoded {
+			codec = JSONCodec{}
+		}
+	}
+
+	// Decrypt the event data and metadata. The data and metadata of a
+	// shredded aggregate are lost.
+	if dbEvent.encrypted() {
+		var err error
+		if codec, err = s.decryptEvent(ctx, &dbEvent); err == ErrAggregateShredded {
+			dbEvent.Encrypted = nil
+			dbEvent.DataRef = ""
+			return event{dbEvent: dbEvent}, nil
+		} else if err != nil {
+			return nil, eh.EventStoreError{
+				BaseErr:   withRequestID(err),
+				Err:       wrapError(ErrCouldNotUnmarshalEvent, err),
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+	}
+
+	// Decompress the event data.
+	if dbEvent.Compression != "" {
+		if err := s.decompressData(&dbEvent); err != nil {
+			return nil, eh.EventStoreError{
+				BaseErr:   err,
+				Err:       wrapError(ErrCouldNotUnmarshalEvent, err),
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+	}
+	if dbEvent.DataJSON {
+		codec = JSONCodec{}
+	}
+
+	// Migrate old event data to the current data version.
+	if err := s.upcastData(&dbEvent, codec); err != nil {
+		return nil, eh.EventStoreError{
+			BaseErr:   err,
+			Err:       wrapError(ErrCouldNotUnmarshalEvent, err),
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	// Create an event of the correct type.
+	if data, err := eh.CreateEventData(dbEvent.EventType); err == nil {
+		// Manually decode the raw event, with the codec if it was encoded by
+		// one or else from the attribute map.
+		var err error
+		if dbEvent.EncodedData != nil && codec != nil {
+			err = codec.Unmarshal(dbEvent.EncodedData, data)
+		} else if dbEvent.EncodedData != nil {
+			err = ErrNoEventCodec
+		} else {
+			err = dynamodbattribute.UnmarshalMap(dbEvent.RawData, data)
+		}
+		if err != nil {
+			return nil, eh.EventStoreError{
+				BaseErr:   withRequestID(err),
+				Err:       wrapError(ErrCouldNotUnmarshalEvent, err),
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+
+		// Set concrete event and zero out the decoded event.
+		dbEvent.data = data
+		dbEvent.RawData = nil
+		dbEvent.EncodedData = nil
+		dbEvent.DataRef = ""
+	}
+
+	return event{dbEvent: dbEvent}, nil
+}
+
+// Replace implements the Replace method of the eventhorizon.EventStore interface.
+func (s *EventStore) Replace(ctx context.Context, event eh.Event) (err error) {
+	called := time.Now()
+	defer func() { s.observeMethod(ctx, MethodReplace, called, err) }()
+
+	ctx, err = s.namespace(ctx)
 	if err != nil {
-		if err, ok := err.(awserr.RequestFailure); ok && err.Code() == "ResourceNotFoundException" {
-			return nil
+		return err
+	}
+
+	tableName := s.eventTableName(ctx, event.Timestamp())
+	table := s.service.Table(tableName)
+
+	start := time.Now()
+	count, err := table.Get(s.hashKey(), s.hashValue(ctx, event.AggregateID())).Range("Version", dynamo.Greater, aggregateHeadVersion).Consistent(true).CountWithContext(ctx)
+	observe(ctx, s.metrics, OperationQuery, tableName, start, err)
+	if err != nil {
+		return eh.EventStoreError{
+			BaseErr:   withRequestID(err),
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
 		}
-		return ErrCouldNotClearDB
+	} else if count == 0 {
+		return eh.ErrAggregateNotFound
 	}
 
-	describeParams := &dynamodb.DescribeTableInput{
-		TableName: aws.String(s.tableName(ctx)),
+	if s.cache != nil {
+		defer s.cache.remove(loadCacheKey(ctx, event.AggregateID()))
 	}
-	if err := s.service.Client().WaitUntilTableNotExists(describeParams); err != nil {
+
+	// Create the event record for the DB.
+	e, err := s.newDBEvent(ctx, event)
+	if err != nil {
 		return err
 	}
+	e.ReceivedAt = s.now()
+	if s.globalPosition {
+		if err := s.keepPosition(ctx, table, e); err != nil {
+			return err
+		}
+	}
+
+	if s.payloads != nil || s.chunking != nil {
+		return s.replaceExisting(ctx, tableName, e)
+	}
+
+	start = time.Now()
+	err = table.Put(e).If("attribute_exists($) AND attribute_exists(Version)", s.hashKey()).RunWithContext(ctx)
+	observe(ctx, s.metrics, OperationPutItem, tableName, start, err)
+	if err != nil {
+		if err, ok := err.(awserr.RequestFailure); ok && err.Code() == "ConditionalCheckFailedException" {
+			return eh.ErrInvalidEvent
+		}
+		return eh.EventStoreError{
+			BaseErr:   withRequestID(err),
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	return nil
+}
+
+// replaceExisting replaces an event in one transaction with the changes to
+// the references of the old and new payloads and to the continuation items of
+// chunked event data.
+func (s *EventStore) replaceExisting(ctx context.Context, tableName string, e *dbEvent) error {
+	table := s.service.Table(tableName)
+
+	var existing dbEvent
+	start := time.Now()
+	err := table.Get(s.hashKey(), s.hashValue(ctx, e.AggregateID)).
+		Range("Version", dynamo.Equal, e.Version).
+		Project("PayloadHash", "Chunks").
+		Consistent(true).
+		OneWithContext(ctx, &existing)
+	observe(ctx, s.metrics, OperationGetItem, tableName, start, err)
+	if err == dynamo.ErrNotFound {
+		return eh.ErrInvalidEvent
+	} else if err != nil {
+		return eh.EventStoreError{
+			BaseErr:   withRequestID(err),
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	item, err := dynamo.MarshalItem(e)
+	if err != nil {
+		return eh.EventStoreError{
+			BaseErr:   err,
+			Err:       wrapError(ErrCouldNotMarshalEvent, err),
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+	items := []*dynamodb.TransactWriteItem{{
+		Put: &dynamodb.Put{
+			TableName:           aws.String(tableName),
+			Item:                item,
+			ConditionExpression: aws.String("attribute_exists(" + s.hashKey() + ") AND attribute_exists(Version)"),
+		},
+	}}
+	items = append(items, s.chunkItems(ctx, tableName, e, &existing)...)
+	if e.PayloadHash != existing.PayloadHash {
+		if e.PayloadHash != "" {
+			items = append(items, s.payloadRefItem(e.PayloadHash, e.payload, 1))
+		}
+		if existing.PayloadHash != "" {
+			items = append(items, s.payloadRefItem(existing.PayloadHash, nil, -1))
+		}
+	}
+
+	start = time.Now()
+	_, err = s.service.Client().TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+	observe(ctx, s.metrics, OperationTransactWriteItems, tableName, start, err)
+	if isConditionalCheckFailed(err) {
+		return eh.ErrInvalidEvent
+	} else if err != nil {
+		return eh.EventStoreError{
+			BaseErr:   withRequestID(err),
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
 
 	return nil
 }
 
+// DeleteAggregate deletes all events of an aggregate, and its version counter,
+// with batch writes, and returns the number of events that were deleted. The
+// aggregate can then be saved again from version 1, which is useful for test
+// teardown and erasure requests. The references to deduplicated payloads are
+// removed, and the continuation items of chunked event data and the event
+// data offloaded to S3 are deleted with the events. Nothing is deleted if an
+// event has data in S3 but S3 overflow is not enabled.
+//
+// If some of the events could not be deleted a PartialFailureError is
+// returned in the EventStoreError, and the version counter is kept.
+func (s *EventStore) DeleteAggregate(ctx context.Context, id uuid.UUID) (int, error) {
+	ctx, err := s.namespace(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	tables, err := s.eventTables(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if s.partitions != nil {
+		// The version counter is in the base table.
+		tables = append(tables, s.tableName(ctx))
+	}
+
+	// Read the keys of all items first, to not delete anything if the data
+	// in S3 can not be deleted.
+	tableKeys := make([][]dbEvent, len(tables))
+	for t, tableName := range tables {
+		start := time.Now()
+		err := s.service.Table(tableName).
+			Get(s.hashKey(), s.hashValue(ctx, id)).
+			Project("AggregateID", "Version", "PayloadHash", "DataRef").
+			Consistent(true).
+			AllWithContext(ctx, &tableKeys[t])
+		observe(ctx, s.metrics, OperationQuery, tableName, start, err)
+		if isAWSErrorCode(err, dynamodb.ErrCodeResourceNotFoundException) {
+			continue
+		} else if err != nil {
+			return 0, eh.EventStoreError{
+				BaseErr:   withRequestID(err),
+				Err:       err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+		for _, e := range tableKeys[t] {
+			if e.DataRef != "" && (s.overflow == nil || s.overflow.client == nil) {
+				return 0, eh.EventStoreError{
+					Err:       ErrNoS3Overflow,
+					Namespace: eh.NamespaceFromContext(ctx),
+				}
+			}
+		}
+	}
+
+	deleted := 0
+	refs := map[string]int{}
+	var dataRefs []string
+	for t, tableName := range tables {
+		keys := tableKeys[t]
+		if len(keys) == 0 {
+			continue
+		}
+
+		// Delete the head item last, after all events of the table and the
+		// continuation items of their chunked data.
+		sort.Slice(keys, func(i, j int) bool {
+			if keys[i].Version == aggregateHeadVersion || keys[j].Version == aggregateHeadVersion {
+				return keys[j].Version == aggregateHeadVersion && keys[i].Version != aggregateHeadVersion
+			}
+			return keys[i].Version > keys[j].Version
+		})
+		reqs := make([]*dynamodb.WriteRequest, len(keys))
+		for i, e := range keys {
+			reqs[i] = &dynamodb.WriteRequest{DeleteRequest: &dynamodb.DeleteRequest{
+				Key: s.itemKey(ctx, id, e.Version),
+			}}
+		}
+
+		start := time.Now()
+		outcomes := batchWrite(ctx, s.service.Client(), tableName, []string{s.hashKey(), "Version"}, reqs)
+		err = batchResult(outcomes, eh.NamespaceFromContext(ctx))
+		observe(ctx, s.metrics, OperationBatchWriteItem, tableName, start, err)
+		for i, o := range outcomes {
+			if o.Err != nil || keys[i].Version <= 0 {
+				continue
+			}
+			deleted++
+			if keys[i].PayloadHash != "" {
+				refs[keys[i].PayloadHash]++
+			}
+			if keys[i].DataRef != "" {
+				dataRefs = append(dataRefs, keys[i].DataRef)
+			}
+		}
+		if err != nil {
+			return deleted, eh.EventStoreError{
+				BaseErr:   withRequestID(err),
+				Err:       err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+	}
+
+	if s.cache != nil {
+		s.cache.remove(loadCacheKey(ctx, id))
+	}
+
+	if err := s.removePayloadRefs(ctx, refs); err != nil {
+		return deleted, err
+	}
+
+	if err := s.deleteOffloadedData(ctx, dataRefs); err != nil {
+		return deleted, eh.EventStoreError{
+			BaseErr:   withRequestID(err),
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	return deleted, nil
+}
+
+// RenameEvent implements the RenameEvent method of the eventhorizon.EventStore interface.
+// The event types are translated to their storage names before renaming.
+func (s *EventStore) RenameEvent(ctx context.Context, from, to eh.EventType) error {
+	ctx, err := s.namespace(ctx)
+	if err != nil {
+		return err
+	}
+
+	tables, err := s.eventTables(ctx)
+	if err != nil {
+		return err
+	}
+
+	if s.cache != nil {
+		defer s.cache.clear()
+	}
+
+	for _, tableName := range tables {
+		if err := s.renameEvent(ctx, tableName, from, to); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// renameEvent renames an event type in one table.
+func (s *EventStore) renameEvent(ctx context.Context, tableName string, from, to eh.EventType) error {
+	table := s.service.Table(tableName)
+	fromName := s.typeNames.EventTypeName(from)
+	toName := s.typeNames.EventTypeName(to)
+
+	// Find the events with the event type index if enabled, or else by
+	// scanning the whole table.
+	var keys []eventKey
+	start := time.Now()
+	var err error
+	if s.hasIndex(eventTypeIndexName) {
+		err = table.Get("EventType", fromName).Index(eventTypeIndexName).AllWithContext(ctx, &keys)
+		observe(ctx, s.metrics, OperationQuery, tableName, start, err)
+	} else {
+		// Resume the scan once if the credentials expire.
+		throttle := s.newScanThrottle()
+		var key dynamo.PagingKey
+		for retried := false; ; retried = true {
+			scan := table.Scan().Filter("EventType = ?", fromName).Consistent(true)
+			iter := throttle.scan(s.scanNamespace(ctx, scan)).StartFrom(key).Iter()
+			var k eventKey
+			var waitErr error
+			for waitErr == nil && iter.NextWithContext(ctx, &k) {
+				keys = append(keys, k)
+				k = eventKey{}
+				waitErr = throttle.wait(ctx)
+			}
+			if err = iter.Err(); waitErr != nil {
+				err = waitErr
+			}
+			if retried || !refreshExpiredCredentials(s.service.Client(), err) {
+				break
+			}
+			key = iter.LastEvaluatedKey()
+		}
+		observe(ctx, s.metrics, OperationScan, tableName, start, err)
+	}
+	if err != nil {
+		return eh.EventStoreError{
+			BaseErr:   withRequestID(err),
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	for i := 0; i < len(keys); i += renameBatchSize {
+		end := i + renameBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		if err := s.renameBatch(ctx, tableName, keys[i:end], fromName, toName); err != nil {
+			return eh.EventStoreError{
+				BaseErr:   withRequestID(err),
+				Err:       err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+	}
+
+	return nil
+}
+
+// renameBatch renames the event type of a batch of events in a transaction.
+// If some of the events were already renamed concurrently the events are
+// renamed one by one instead, skipping the renamed ones.
+func (s *EventStore) renameBatch(ctx context.Context, tableName string, keys []eventKey, fromName, toName string) error {
+	items := make([]*dynamodb.TransactWriteItem, len(keys))
+	for i, key := range keys {
+		items[i] = &dynamodb.TransactWriteItem{
+			Update: &dynamodb.Update{
+				TableName:           aws.String(tableName),
+				Key:                 s.itemKey(ctx, key.AggregateID, key.Version),
+				UpdateExpression:    aws.String("SET EventType = :to"),
+				ConditionExpression: aws.String("EventType = :from"),
+				ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+					":from": {S: aws.String(fromName)},
+					":to":   {S: aws.String(toName)},
+				},
+			},
+		}
+	}
+
+	start := time.Now()
+	_, err := s.service.Client().TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+	observe(ctx, s.metrics, OperationTransactWriteItems, tableName, start, err)
+	if !isConditionalCheckFailed(err) {
+		return err
+	}
+
+	table := s.service.Table(tableName)
+	for _, key := range keys {
+		start := time.Now()
+		err := table.Update(s.hashKey(), s.hashValue(ctx, key.AggregateID)).Range("Version", key.Version).If("EventType = ?", fromName).Set("EventType", toName).RunWithContext(ctx)
+		observe(ctx, s.metrics, OperationUpdateItem, tableName, start, err)
+		if err != nil && !isConditionalCheckFailed(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CreateTable creates the table if it is not already existing and correct.
+// It is safe to call concurrently, a table that is already being created by
+// someone else is waited for until it is active.
+func (s *EventStore) CreateTable(ctx context.Context) error {
+	ctx, err := s.namespace(ctx)
+	if err != nil {
+		return err
+	}
+	ctx = withTableWaiter(ctx, s.tableWaiter)
+
+	if s.payloads != nil {
+		if err := createTable(ctx, s.service.Client(), s.payloads.tableName,
+			s.service.CreateTable(s.payloads.tableName, dbPayload{}), nil); err != nil {
+			return err
+		}
+	}
+	if s.outbox != nil {
+		if err := createTable(ctx, s.service.Client(), s.outbox.tableName,
+			s.service.CreateTable(s.outbox.tableName, dbOutboxRecord{}), nil); err != nil {
+			return err
+		}
+	}
+	if err := s.createKeyTable(ctx); err != nil {
+		return err
+	}
+
+	return s.createEventTable(ctx, s.tableName(ctx))
+}
+
+// createEventTable creates an event table with the config of the namespace.
+func (s *EventStore) createEventTable(ctx context.Context, tableName string) error {
+	ctx = withTableWaiter(ctx, s.tableWaiter)
+	cfg := s.namespaceConfigs.Get(eh.NamespaceFromContext(ctx))
+	var from interface{} = dbEvent{}
+	if s.sharedTable {
+		from = sharedTableKey{}
+	}
+	ct := cfg.applyCreateTable(s.service.CreateTable(tableName, from))
+	ttlAttr := ""
+	if cfg.Retention > 0 || s.ttlEnabled {
+		ttlAttr = expiresAtAttr
+	}
+	if err := createTable(withTableClass(ctx, cfg.TableClass), s.service.Client(), tableName, ct, func(ctx context.Context) error {
+		return cfg.configureTable(ctx, s.service.Client(), tableName, ttlAttr)
+	}); err != nil {
+		return err
+	}
+
+	return ensureIndexes(ctx, s.service.Client(), tableName, s.indexes)
+}
+
+// DeleteTable deletes the event table, and all partition tables when
+// partitioned by month. With a shared table the events of the namespace are
+// deleted instead.
+func (s *EventStore) DeleteTable(ctx context.Context) error {
+	ctx, err := s.namespace(ctx)
+	if err != nil {
+		return err
+	}
+
+	if s.sharedTable {
+		return s.deleteNamespaceItems(ctx)
+	}
+
+	if s.partitions != nil {
+		tables, err := s.eventTables(ctx)
+		if err != nil {
+			return err
+		}
+		for _, tableName := range tables {
+			s.partitions.created.Delete(tableName)
+			if err := s.deleteTable(ctx, tableName); err != nil {
+				return err
+			}
+		}
+	}
+
+	return s.deleteTable(ctx, s.tableName(ctx))
+}
+
+// deleteTable deletes a table and waits until it is deleted.
+func (s *EventStore) deleteTable(ctx context.Context, tableName string) error {
+	table := s.service.Table(tableName)
+	err := table.DeleteTable().RunWithContext(ctx)
+	if err != nil {
+		if err, ok := err.(awserr.RequestFailure); ok && err.Code() == "ResourceNotFoundException" {
+			return nil
+		}
+		return ErrCouldNotClearDB
+	}
+
+	return waitForTableDeleted(withTableWaiter(ctx, s.tableWaiter), s.service.Client(), tableName)
+}
+
+// eventKey is the key of an event item.
+type eventKey struct {
+	AggregateID uuid.UUID
+	Version     int
+}
+
 // dbEvent is the internal event record for the DynamoDB event store used
 // to save and load events from the DB.
 type dbEvent struct {
 	AggregateID uuid.UUID `dynamo:",hash"`
 	Version     int       `dynamo:",range"`
 
+	// PK is the hash key of the event in a shared table, see WithSharedTable.
+	PK string `dynamo:",omitempty"`
+
 	EventType     eh.EventType
 	RawData       map[string]*dynamodb.AttributeValue
+	EncodedData   []byte `dynamo:",omitempty"`
 	data          eh.EventData
 	Timestamp     time.Time
 	AggregateType eh.AggregateType
 	Metadata      map[string]interface{}
+	ExpiresAt     int64 `dynamo:",omitempty"`
+
+	// ReceivedAt is when the event was saved, by the clock of the store.
+	ReceivedAt time.Time `dynamo:",omitempty"`
+
+	// CorrelationID and CausationID are copied from the metadata to be
+	// queryable, see FindByCorrelationID.
+	CorrelationID string `dynamo:",omitempty"`
+	CausationID   string `dynamo:",omitempty"`
+
+	// DataVersion is the version of the shape of the event data, which is
+	// migrated by the upcasters of the event type when loading.
+	DataVersion int `dynamo:",omitempty"`
+
+	// Feed, Position and PositionedAt are the global position of the event,
+	// when enabled, and when the position was reserved in Unix nanoseconds.
+	Feed         string `dynamo:",omitempty"`
+	Position     int64  `dynamo:",omitempty"`
+	PositionedAt int64  `dynamo:",omitempty"`
+
+	// Bucket is the start of the time bucket of the event in Unix seconds,
+	// when activity buckets are enabled.
+	Bucket int64 `dynamo:",omitempty"`
+
+	// Day is the day of the event in UTC, when the timestamp index is
+	// enabled.
+	Day string `dynamo:",omitempty"`
+
+	// Compression is the name of the compression of EncodedData, and
+	// DataJSON is set if the data was encoded as JSON instead of by the codec.
+	Compression string `dynamo:",omitempty"`
+	DataJSON    bool   `dynamo:",omitempty"`
+
+	// DataRef is the S3 key of offloaded event data, which is JSON unless
+	// DataRefEncoded is set and it was encoded by the codec, or the encrypted
+	// payload if the event is encrypted.
+	DataRef        string `dynamo:",omitempty"`
+	DataRefEncoded bool   `dynamo:",omitempty"`
+
+	// Chunks is the number of continuation items that the event data is
+	// stored in, which is JSON unless ChunksEncoded is set, or the encrypted
+	// payload if the event is encrypted. The chunks are only kept in the
+	// event while saving.
+	Chunks        int  `dynamo:",omitempty"`
+	ChunksEncoded bool `dynamo:",omitempty"`
+	chunks        [][]byte
+
+	// Encrypted is the encrypted data and metadata, and EncryptedKey the KMS
+	// encrypted data key it was encrypted with. AggregateKey is set instead
+	// if it was encrypted with the key of the aggregate.
+	Encrypted    []byte `dynamo:",omitempty"`
+	EncryptedKey []byte `dynamo:",omitempty"`
+	AggregateKey bool   `dynamo:",omitempty"`
+
+	// PayloadHash is the hash of the event data in the payload table, which
+	// is encoded by the codec if PayloadEncoded is set. The payload is only
+	// kept in the event while saving.
+	PayloadHash    string `dynamo:",omitempty"`
+	PayloadEncoded bool   `dynamo:",omitempty"`
+	payload        []byte
+}
+
+// encrypted returns true if the data and metadata of the event are encrypted.
+func (e *dbEvent) encrypted() bool {
+	return e.EncryptedKey != nil || e.AggregateKey
 }
 
-// newDBEvent returns a new dbEvent for an event.
-func newDBEvent(ctx context.Context, event eh.Event) (*dbEvent, error) {
-	// Marshal event data if there is any.
+// aggregateHeadVersion is the range key of the head item of an aggregate,
+// which holds the current version of the aggregate.
+const aggregateHeadVersion = -1
+
+// dbAggregateHead is the head item of an aggregate, used as an atomic version
+// counter when saving events.
+type dbAggregateHead struct {
+	AggregateID uuid.UUID `dynamo:",hash"`
+	Version     int       `dynamo:",range"`
+
+	CurrentVersion int
+	StateHash      string `dynamo:",omitempty"`
+}
+
+// newDBEvent returns a new dbEvent for an event, using the storage names of
+// the event and aggregate types.
+func (s *EventStore) newDBEvent(ctx context.Context, event eh.Event) (*dbEvent, error) {
+	// Marshal event data if there is any, with the codec if set.
 	var rawData map[string]*dynamodb.AttributeValue
+	var data []byte
 	if event.Data() != nil {
 		var err error
-		rawData, err = dynamodbattribute.MarshalMap(event.Data())
+		if s.codec != nil {
+			data, err = s.codec.Marshal(event.Data())
+		} else {
+			rawData, err = dynamodbattribute.MarshalMap(event.Data())
+		}
 		if err != nil {
 			return nil, eh.EventStoreError{
-				BaseErr:   err,
-				Err:       ErrCouldNotMarshalEvent,
+				BaseErr:   withRequestID(err),
+				Err:       wrapError(ErrCouldNotMarshalEvent, err),
 				Namespace: eh.NamespaceFromContext(ctx),
 			}
 		}
 	}
 
-	return &dbEvent{
-		EventType:     event.EventType(),
+	// Stamp the correlation and causation IDs of the context, if any.
+	metadata := correlationMetadata(ctx, event.Metadata())
+	correlationID, _ := metadata[CorrelationIDKey].(string)
+	causationID, _ := metadata[CausationIDKey].(string)
+
+	e := &dbEvent{
+		ExpiresAt:     s.eventExpiresAt(ctx, event),
+		CorrelationID: correlationID,
+		CausationID:   causationID,
+		EventType:     eh.EventType(s.typeNames.EventTypeName(event.EventType())),
 		RawData:       rawData,
+		EncodedData:   data,
 		Timestamp:     event.Timestamp(),
-		AggregateType: event.AggregateType(),
+		AggregateType: eh.AggregateType(s.typeNames.AggregateTypeName(event.AggregateType())),
 		AggregateID:   event.AggregateID(),
 		Version:       event.Version(),
-		Metadata:      event.Metadata(),
-	}, nil
+		Metadata:      metadata,
+		DataVersion:   s.dataVersion(event.EventType()),
+	}
+	if s.activityBucket > 0 {
+		e.Bucket = activityBucket(event.Timestamp(), s.activityBucket)
+	}
+	if s.hasIndex(timestampIndexName) {
+		e.Day = eventDay(event.Timestamp())
+	}
+	if s.sharedTable {
+		e.PK = s.hashValue(ctx, event.AggregateID())
+	}
+
+	// Compress the event data, if enabled.
+	if err := s.compressData(event, e); err != nil {
+		return nil, eh.EventStoreError{
+			BaseErr:   err,
+			Err:       wrapError(ErrCouldNotMarshalEvent, err),
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	// Encrypt the event data and metadata, if enabled.
+	if err := s.encryptEvent(ctx, event, e); err != nil {
+		return nil, eh.EventStoreError{
+			BaseErr:   withRequestID(err),
+			Err:       wrapError(ErrCouldNotEncryptEvent, err),
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	// Move large event data to S3, if enabled.
+	if err := s.offloadData(ctx, event, e); err != nil {
+		return nil, eh.EventStoreError{
+			BaseErr:   withRequestID(err),
+			Err:       wrapError(ErrCouldNotOffloadEventData, err),
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	// Split large event data into chunks, if enabled.
+	if err := s.chunkData(ctx, event, e); err != nil {
+		return nil, eh.EventStoreError{
+			BaseErr:   withRequestID(err),
+			Err:       wrapError(ErrCouldNotOffloadEventData, err),
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	// Store the event data once per distinct payload, if enabled.
+	if err := s.dedupData(event, e); err != nil {
+		return nil, eh.EventStoreError{
+			BaseErr:   err,
+			Err:       wrapError(ErrCouldNotMarshalEvent, err),
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	// Fail with the size of the event if it is over the item size limit.
+	if err := s.checkItemSize(ctx, e); err != nil {
+		return nil, err
+	}
+
+	return e, nil
 }
 
 // event is the private implementation of the eventhorizon.Event
@@ -16,12 +16,14 @@ package dynamodb
 
 import (
 	"context"
+	"errors"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/google/uuid"
+	"github.com/guregu/dynamo"
 
 	"github.com/looplab/eventhorizon/mocks"
 
@@ -35,8 +37,9 @@ import (
 
 type EventStoreTestSuite struct {
 	suite.Suite
-	ctx   context.Context
-	store *EventStore
+	ctx     context.Context
+	session *session.Session
+	store   *EventStore
 }
 
 // SetupTest will create the store and dynamo table
@@ -49,6 +52,7 @@ func (suite *EventStoreTestSuite) SetupTest() {
 
 	awsSession, err := session.NewSession(awsConfig)
 	assert.Nil(suite.T(), err, "there should be no error")
+	suite.session = awsSession
 
 	suite.store, err = NewEventStore(
 		"test",
@@ -69,6 +73,17 @@ func (suite *EventStoreTestSuite) TearDownTest() {
 	assert.Nil(suite.T(), suite.store.DeleteTable(suite.ctx), "could not delete table")
 }
 
+// newStore will create a store with the options for a single test, which is
+// closed when the test is done. It uses the same tables as the suite store.
+func (suite *EventStoreTestSuite) newStore(options ...Option) *EventStore {
+	store, err := NewEventStore("test", append([]Option{WithDynamoDB(suite.session)}, options...)...)
+	if err != nil {
+		suite.T().Fatal("could not create store:", err)
+	}
+	suite.T().Cleanup(func() { store.Close(context.Background()) })
+	return store
+}
+
 // TestEventStore will run all the acceptance tests for event stores
 func (suite *EventStoreTestSuite) TestEventStore() {
 	suite.T().Log("event store with default namespace")
@@ -109,6 +124,205 @@ func (suite *EventStoreTestSuite) TestLoadAll() {
 	}
 }
 
+// TestSaveIsAtomic will make sure that no events are persisted when one of them conflicts
+func (suite *EventStoreTestSuite) TestSaveIsAtomic() {
+	id := uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+
+	err := suite.store.Save(context.Background(), []eh.Event{
+		eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event1"},
+			timestamp, mocks.AggregateType, id, 1),
+		eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event2"},
+			timestamp, mocks.AggregateType, id, 2),
+	}, 0)
+	assert.Nil(suite.T(), err)
+
+	// Version 2 already exists, so version 3 must not be persisted either.
+	err = suite.store.Save(context.Background(), []eh.Event{
+		eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "conflict"},
+			timestamp, mocks.AggregateType, id, 2),
+		eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event3"},
+			timestamp, mocks.AggregateType, id, 3),
+	}, 1)
+	if esErr, ok := err.(eh.EventStoreError); !ok || !errors.Is(esErr.Err, ErrCouldNotSaveAggregate) {
+		suite.T().Fatal("there should be a conflict error:", err)
+	} else {
+		var conflict VersionConflictError
+		if assert.True(suite.T(), errors.As(esErr.Err, &conflict)) {
+			assert.Equal(suite.T(), id, conflict.AggregateID)
+			assert.Equal(suite.T(), 1, conflict.Version)
+		}
+		assert.True(suite.T(), IsVersionConflict(err))
+	}
+
+	events, err := suite.store.Load(context.Background(), id)
+	assert.Nil(suite.T(), err)
+	assert.Len(suite.T(), events, 2)
+}
+
+// TestSaveVersionCounter will make sure that the aggregate version counter rejects stale writers
+func (suite *EventStoreTestSuite) TestSaveVersionCounter() {
+	id := uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+
+	err := suite.store.Save(context.Background(), []eh.Event{
+		eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event1"},
+			timestamp, mocks.AggregateType, id, 1),
+		eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event2"},
+			timestamp, mocks.AggregateType, id, 2),
+	}, 0)
+	assert.Nil(suite.T(), err)
+
+	// The aggregate is at version 2, saving from version 3 would leave a gap.
+	err = suite.store.Save(context.Background(), []eh.Event{
+		eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event4"},
+			timestamp, mocks.AggregateType, id, 4),
+	}, 3)
+	if esErr, ok := err.(eh.EventStoreError); !ok || !errors.Is(esErr.Err, ErrCouldNotSaveAggregate) {
+		suite.T().Fatal("there should be a conflict error:", err)
+	}
+
+	var head dbAggregateHead
+	err = suite.store.service.Table(suite.store.tableName(context.Background())).
+		Get("AggregateID", id.String()).Range("Version", dynamo.Equal, aggregateHeadVersion).
+		Consistent(true).One(&head)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 2, head.CurrentVersion)
+
+	events, err := suite.store.Load(context.Background(), id)
+	assert.Nil(suite.T(), err)
+	assert.Len(suite.T(), events, 2)
+}
+
+// TestLoadFrom will save a bunch of events and load the tail of the stream
+func (suite *EventStoreTestSuite) TestLoadFrom() {
+	id := uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+
+	expectedEvents := []eh.Event{
+		eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event1"},
+			timestamp, mocks.AggregateType, id, 1),
+		eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event2"},
+			timestamp, mocks.AggregateType, id, 2),
+		eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event3"},
+			timestamp, mocks.AggregateType, id, 3),
+	}
+	assert.Nil(suite.T(), suite.store.Save(context.Background(), expectedEvents, 0))
+
+	events, err := suite.store.LoadFrom(context.Background(), id, 2)
+	assert.Nil(suite.T(), err)
+	if assert.Len(suite.T(), events, 2) {
+		for i, event := range events {
+			if err := eh.CompareEvents(event, expectedEvents[i+1]); err != nil {
+				suite.T().Error("the event was incorrect:", err)
+			}
+		}
+	}
+
+	events, err = suite.store.LoadFrom(context.Background(), id, 4)
+	assert.Nil(suite.T(), err)
+	assert.Len(suite.T(), events, 0)
+}
+
+// TestLoadMany will load the events of several aggregates at once
+func (suite *EventStoreTestSuite) TestLoadMany() {
+	id1, id2 := uuid.New(), uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	assert.Nil(suite.T(), suite.store.Save(suite.ctx, []eh.Event{
+		eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event1"}, timestamp, mocks.AggregateType, id1, 1),
+		eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event2"}, timestamp, mocks.AggregateType, id1, 2),
+	}, 0))
+	assert.Nil(suite.T(), suite.store.Save(suite.ctx, []eh.Event{
+		eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event1"}, timestamp, mocks.AggregateType, id2, 1),
+	}, 0))
+
+	missing := uuid.New()
+	streams, err := suite.store.LoadMany(suite.ctx, []uuid.UUID{id1, id2, missing})
+	assert.Nil(suite.T(), err)
+	assert.Len(suite.T(), streams, 3)
+	assert.Len(suite.T(), streams[id1], 2)
+	assert.Len(suite.T(), streams[id2], 1)
+	assert.Len(suite.T(), streams[missing], 0)
+}
+
+// TestAggregateVersion will make sure that the version is read without the events
+func (suite *EventStoreTestSuite) TestAggregateVersion() {
+	id := uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	event1 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event1"}, timestamp, mocks.AggregateType, id, 1)
+	event2 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event2"}, timestamp, mocks.AggregateType, id, 2)
+	assert.Nil(suite.T(), suite.store.Save(suite.ctx, []eh.Event{event1, event2}, 0))
+
+	version, err := suite.store.AggregateVersion(suite.ctx, id)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 2, version)
+
+	version, err = suite.store.AggregateVersion(suite.ctx, uuid.New())
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 0, version)
+}
+
+// TestDeleteAggregate will make sure that all events of an aggregate are deleted
+func (suite *EventStoreTestSuite) TestDeleteAggregate() {
+	id := uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	event1 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event1"}, timestamp, mocks.AggregateType, id, 1)
+	event2 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event2"}, timestamp, mocks.AggregateType, id, 2)
+	assert.Nil(suite.T(), suite.store.Save(suite.ctx, []eh.Event{event1, event2}, 0))
+
+	deleted, err := suite.store.DeleteAggregate(suite.ctx, id)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 2, deleted)
+
+	events, err := suite.store.Load(suite.ctx, id)
+	assert.Nil(suite.T(), err)
+	assert.Len(suite.T(), events, 0)
+
+	// The aggregate can be saved again from the start.
+	assert.Nil(suite.T(), suite.store.Save(suite.ctx, []eh.Event{event1}, 0))
+
+	deleted, err = suite.store.DeleteAggregate(suite.ctx, uuid.New())
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 0, deleted)
+}
+
+// TestLoadEach will save a bunch of events and load them one by one
+func (suite *EventStoreTestSuite) TestLoadEach() {
+	id := uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+
+	expectedEvents := []eh.Event{
+		eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event1"},
+			timestamp, mocks.AggregateType, id, 1),
+		eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event2"},
+			timestamp, mocks.AggregateType, id, 2),
+	}
+	assert.Nil(suite.T(), suite.store.Save(context.Background(), expectedEvents, 0))
+
+	var events []eh.Event
+	err := suite.store.LoadEach(context.Background(), id, func(event eh.Event) error {
+		events = append(events, event)
+		return nil
+	})
+	assert.Nil(suite.T(), err)
+	if assert.Len(suite.T(), events, 2) {
+		for i, event := range events {
+			if err := eh.CompareEvents(event, expectedEvents[i]); err != nil {
+				suite.T().Error("the event was incorrect:", err)
+			}
+		}
+	}
+
+	// A canceled context stops the loading.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = suite.store.LoadEach(ctx, id, func(event eh.Event) error {
+		suite.T().Error("no event should be loaded")
+		return nil
+	})
+	assert.NotNil(suite.T(), err)
+}
+
 // TestSaveInvalidAggregateId will save an aggregate with an invalid event aggregate ID
 func (suite *EventStoreTestSuite) TestSaveInvalidAggregateId() {
 	id, _ := uuid.Parse("c1138e5f-f6fb-4dd0-8e79-255c6c8d3756")
@@ -126,6 +340,113 @@ func (suite *EventStoreTestSuite) TestSaveInvalidAggregateId() {
 	assert.EqualError(suite.T(), err, "invalid event (default)")
 }
 
+// TestCreateTableConcurrently will create the same table from several goroutines at once
+func (suite *EventStoreTestSuite) TestCreateTableConcurrently() {
+	ctx := eh.NewContextWithNamespace(context.Background(), "concurrent")
+	defer suite.store.DeleteTable(ctx)
+
+	errs := make(chan error, 3)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			errs <- suite.store.CreateTable(ctx)
+		}()
+	}
+	for i := 0; i < cap(errs); i++ {
+		assert.Nil(suite.T(), <-errs, "could not create table")
+	}
+
+	// Creating an existing table should not be an error.
+	assert.Nil(suite.T(), suite.store.CreateTable(ctx), "could not create existing table")
+}
+
+// TestCanceledContext will make sure that a canceled context aborts the requests
+func (suite *EventStoreTestSuite) TestCanceledContext() {
+	ctx, cancel := context.WithCancel(suite.ctx)
+	cancel()
+
+	id := uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	event := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event1"},
+		timestamp, mocks.AggregateType, id, 1)
+	assert.NotNil(suite.T(), suite.store.Save(ctx, []eh.Event{event}, 0))
+
+	_, err := suite.store.Load(ctx, id)
+	assert.NotNil(suite.T(), err)
+	_, err = suite.store.LoadAll(ctx)
+	assert.NotNil(suite.T(), err)
+
+	// Nothing should have been saved.
+	events, err := suite.store.Load(suite.ctx, id)
+	assert.Nil(suite.T(), err)
+	assert.Len(suite.T(), events, 0)
+}
+
+// TestRenameEventWithIndex will rename events found with the event type index in batches
+func (suite *EventStoreTestSuite) TestRenameEventWithIndex() {
+	store := suite.newStore(WithEventTypeIndex())
+
+	ctx := eh.NewContextWithNamespace(context.Background(), "eventtype")
+	assert.Nil(suite.T(), store.CreateTable(ctx))
+	defer store.DeleteTable(ctx)
+
+	id := uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	var events []eh.Event
+	for i := 1; i <= 2*renameBatchSize+1; i++ {
+		events = append(events, eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event"},
+			timestamp, mocks.AggregateType, id, i))
+	}
+	assert.Nil(suite.T(), store.Save(ctx, events, 0))
+
+	assert.Nil(suite.T(), store.RenameEvent(ctx, mocks.EventType, mocks.EventOtherType))
+
+	loaded, err := store.Load(ctx, id)
+	assert.Nil(suite.T(), err)
+	assert.Len(suite.T(), loaded, len(events))
+	for _, event := range loaded {
+		assert.Equal(suite.T(), mocks.EventOtherType, event.EventType())
+	}
+}
+
+type matchAll struct{}
+
+func (matchAll) Match(eh.Event) bool {
+	return true
+}
+
+type streamTestHandler struct {
+	events chan eh.Event
+}
+
+func (h *streamTestHandler) HandlerType() eh.EventHandlerType {
+	return "stream-test"
+}
+
+func (h *streamTestHandler) HandleEvent(ctx context.Context, event eh.Event) error {
+	h.events <- event
+	return nil
+}
+
+// poisonHandler is an event handler that fails on poison event versions.
+type poisonHandler struct {
+	poison  map[int]bool
+	handled []eh.Event
+	calls   int
+}
+
+func (h *poisonHandler) HandlerType() eh.EventHandlerType {
+	return "poison"
+}
+
+func (h *poisonHandler) HandleEvent(ctx context.Context, event eh.Event) error {
+	h.calls++
+	if h.poison[event.Version()] {
+		return errors.New("poison event")
+	}
+	h.handled = append(h.handled, event)
+	return nil
+}
+
 // TestEventStoreTestSuite starts the test suite
 func TestEventStoreTestSuite(t *testing.T) {
 	suite.Run(t, new(EventStoreTestSuite))
@@ -0,0 +1,213 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/guregu/dynamo"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/sysbot/eh-dynamodb/cursor"
+)
+
+// cursorKindEventTypes is the kind of cursors of LoadByEventTypePage.
+const cursorKindEventTypes = "event-types"
+
+// LoadByEventType loads all events of some event types, across all
+// aggregates, for projections that only need a few event types. The events
+// are ordered by event type, in the order of the arguments, and otherwise in
+// index order, not in order of aggregate or time. It needs the
+// WithEventTypeIndex option.
+func (s *EventStore) LoadByEventType(ctx context.Context, types ...eh.EventType) ([]eh.Event, error) {
+	ctx, err := s.namespace(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	dbEvents, _, err := s.loadByEventType(ctx, nil, 0, types)
+	if err != nil {
+		return nil, err
+	}
+	return s.buildEvents(ctx, dbEvents)
+}
+
+// LoadByEventTypePage loads a page of at most limit events of some event
+// types, as LoadByEventType, starting after the cursor, or at the start for an
+// empty cursor. It returns the cursor of the next page, which is empty when
+// there are no more events. The cursor is only valid for the same event
+// types.
+func (s *EventStore) LoadByEventTypePage(ctx context.Context, token string, limit int, types ...eh.EventType) ([]eh.Event, string, error) {
+	ctx, err := s.namespace(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	key, err := decodeCursor(ctx, s.cursors, cursorKindEventTypes, token)
+	if err != nil {
+		return nil, "", eh.EventStoreError{
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	dbEvents, last, err := s.loadByEventType(ctx, key, limit, types)
+	if err != nil {
+		return nil, "", err
+	}
+
+	next, err := encodeCursor(ctx, s.cursors, cursorKindEventTypes, last)
+	if err != nil {
+		return nil, "", eh.EventStoreError{
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	events, err := s.buildEvents(ctx, dbEvents)
+	if err != nil {
+		return nil, "", err
+	}
+	return events, next, nil
+}
+
+// loadByEventType loads at most limit events of some event types, or all
+// events if limit is 0, starting after a key. It returns the key of the last
+// event if there can be more events.
+func (s *EventStore) loadByEventType(ctx context.Context, after map[string]*dynamodb.AttributeValue, limit int, types []eh.EventType) ([]dbEvent, map[string]*dynamodb.AttributeValue, error) {
+	if !s.hasIndex(eventTypeIndexName) {
+		return nil, nil, eh.EventStoreError{
+			Err:       ErrIndexNotEnabled,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	tables, err := s.eventTables(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Skip the event types and tables before the ones of the cursor.
+	var startKey dynamo.PagingKey
+	skipping := after != nil
+	var dbEvents []dbEvent
+	for _, eventType := range types {
+		typeName := s.typeNames.EventTypeName(eventType)
+		for _, tableName := range tables {
+			if skipping {
+				if typeName != aws.StringValue(after["EventType"].S) ||
+					tableName != aws.StringValue(after["Table"].S) {
+					continue
+				}
+				skipping = false
+				startKey = dynamo.PagingKey{
+					"EventType":   after["EventType"],
+					"AggregateID": after["AggregateID"],
+					"Version":     after["Version"],
+				}
+			}
+
+			query := s.readService().Table(tableName).
+				Get("EventType", typeName).
+				Index(eventTypeIndexName).
+				StartFrom(startKey)
+			startKey = nil
+			requested := limit - len(dbEvents)
+			if limit > 0 {
+				query = query.Limit(int64(requested))
+			}
+
+			var keys []eventKey
+			start := time.Now()
+			err := query.AllWithContext(ctx, &keys)
+			observe(ctx, s.metrics, OperationQuery, tableName, start, err)
+			if err != nil {
+				return nil, nil, eh.EventStoreError{
+					BaseErr:   withRequestID(err),
+					Err:       err,
+					Namespace: eh.NamespaceFromContext(ctx),
+				}
+			}
+
+			// The index only has the keys of the events.
+			tableEvents, err := s.getEvents(ctx, tableName, keys)
+			if err != nil {
+				return nil, nil, err
+			}
+			dbEvents = append(dbEvents, tableEvents...)
+
+			// The page is full, continue after the last key next time. The
+			// events of keys that were deleted concurrently are missing.
+			if limit > 0 && len(keys) == requested {
+				k := keys[len(keys)-1]
+				last := dbEvent{AggregateID: k.AggregateID, Version: k.Version}.key()
+				last["EventType"] = &dynamodb.AttributeValue{S: aws.String(typeName)}
+				last["Table"] = &dynamodb.AttributeValue{S: aws.String(tableName)}
+				return dbEvents, last, nil
+			}
+		}
+	}
+	if skipping {
+		return nil, nil, eh.EventStoreError{
+			Err:       cursor.ErrInvalidCursor,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	return dbEvents, nil, nil
+}
+
+// getEvents gets the events of some keys from a table, in the order of the
+// keys. Events that no longer exist are skipped.
+func (s *EventStore) getEvents(ctx context.Context, tableName string, keys []eventKey) ([]dbEvent, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	batch := make([]dynamo.Keyed, len(keys))
+	for i, k := range keys {
+		batch[i] = dynamo.Keys{k.AggregateID.String(), k.Version}
+	}
+
+	var items []dbEvent
+	start := time.Now()
+	err := s.readService().Table(tableName).
+		Batch("AggregateID", "Version").
+		Get(batch...).
+		Consistent(consistentRead(ctx, s.readConsistency)).
+		AllWithContext(ctx, &items)
+	observe(ctx, s.metrics, OperationBatchGetItem, tableName, start, err)
+	if err != nil && err != dynamo.ErrNotFound {
+		return nil, eh.EventStoreError{
+			BaseErr:   withRequestID(err),
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	byKey := make(map[eventKey]dbEvent, len(items))
+	for _, e := range items {
+		byKey[eventKey{AggregateID: e.AggregateID, Version: e.Version}] = e
+	}
+	dbEvents := make([]dbEvent, 0, len(keys))
+	for _, k := range keys {
+		if e, ok := byKey[k]; ok {
+			dbEvents = append(dbEvents, e)
+		}
+	}
+	return dbEvents, nil
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestLoadByEventType will load the events of some event types across aggregates
+func (suite *EventStoreTestSuite) TestLoadByEventType() {
+	_, err := suite.store.LoadByEventType(context.Background(), mocks.EventType)
+	if esErr, ok := err.(eh.EventStoreError); !ok || !errors.Is(esErr.Err, ErrIndexNotEnabled) {
+		suite.T().Fatal("there should be an index not enabled error:", err)
+	}
+
+	store := suite.newStore(WithEventTypeIndex())
+
+	ctx := eh.NewContextWithNamespace(context.Background(), "event_types")
+	assert.Nil(suite.T(), store.CreateTable(ctx))
+	defer store.DeleteTable(ctx)
+
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	id1, id2 := uuid.New(), uuid.New()
+	assert.Nil(suite.T(), store.Save(ctx, []eh.Event{
+		eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event1"}, timestamp, mocks.AggregateType, id1, 1),
+		eh.NewEventForAggregate(mocks.EventOtherType, &mocks.EventData{Content: "other"}, timestamp, mocks.AggregateType, id1, 2),
+		eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event3"}, timestamp, mocks.AggregateType, id1, 3),
+	}, 0))
+	assert.Nil(suite.T(), store.Save(ctx, []eh.Event{
+		eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event1"}, timestamp, mocks.AggregateType, id2, 1),
+	}, 0))
+
+	events, err := store.LoadByEventType(ctx, mocks.EventType)
+	assert.Nil(suite.T(), err)
+	assert.Len(suite.T(), events, 3)
+	for _, event := range events {
+		assert.Equal(suite.T(), mocks.EventType, event.EventType())
+	}
+
+	events, err = store.LoadByEventType(ctx, mocks.EventOtherType, mocks.EventType)
+	assert.Nil(suite.T(), err)
+	if assert.Len(suite.T(), events, 4) {
+		assert.Equal(suite.T(), mocks.EventOtherType, events[0].EventType())
+	}
+
+	var all []eh.Event
+	next := ""
+	for {
+		var page []eh.Event
+		page, next, err = store.LoadByEventTypePage(ctx, next, 2, mocks.EventOtherType, mocks.EventType)
+		if !assert.Nil(suite.T(), err) {
+			break
+		}
+		assert.True(suite.T(), len(page) <= 2)
+		all = append(all, page...)
+		if next == "" {
+			break
+		}
+	}
+	assert.Len(suite.T(), all, 4)
+}
@@ -0,0 +1,183 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+const (
+	// sensitiveTag is the struct tag that marks sensitive entity fields,
+	// which are redacted on export: `eh:"sensitive"`.
+	sensitiveTag = "eh"
+	// importBatchSize is the number of entities that are saved at once on import.
+	importBatchSize = 100
+	// maxImportLineSize is the maximum size of an imported line, a bit over
+	// the DynamoDB item size limit to allow for JSON encoding.
+	maxImportLineSize = 1 << 20
+)
+
+// Export writes all entities of the table as NDJSON, one JSON encoded entity
+// per line, to capture a read model for local debugging or test fixtures.
+// Fields tagged with `eh:"sensitive"` are redacted to their zero value.
+func (r *Repo) Export(ctx context.Context, w io.Writer) error {
+	ctx, err := r.namespace(ctx)
+	if err != nil {
+		return err
+	}
+
+	entities, err := r.FindAll(ctx)
+	if err != nil {
+		return err
+	}
+	return r.export(ctx, w, entities)
+}
+
+// ExportWithFilter writes the entities that match a filter as NDJSON, like
+// Export. The filter is as in FindWithFilter.
+func (r *Repo) ExportWithFilter(ctx context.Context, w io.Writer, expr string, args ...interface{}) error {
+	ctx, err := r.namespace(ctx)
+	if err != nil {
+		return err
+	}
+
+	entities, err := r.FindWithFilter(ctx, expr, args...)
+	if err != nil {
+		return err
+	}
+	return r.export(ctx, w, entities)
+}
+
+// Import reads entities as NDJSON, as written by Export, and saves them in
+// batches. It is meant to load an export into a local table.
+func (r *Repo) Import(ctx context.Context, rd io.Reader) error {
+	ctx, err := r.namespace(ctx)
+	if err != nil {
+		return err
+	}
+
+	if r.factoryFn == nil {
+		return eh.RepoError{
+			Err:       ErrModelNotSet,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	scanner := bufio.NewScanner(rd)
+	scanner.Buffer(make([]byte, 64*1024), maxImportLineSize)
+
+	var batch []eh.Entity
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+
+		entity := r.factoryFn()
+		if err := json.Unmarshal(line, entity); err != nil {
+			return eh.RepoError{
+				Err:       wrapError(eh.ErrCouldNotSaveEntity, err),
+				BaseErr:   err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+
+		batch = append(batch, entity)
+		if len(batch) == importBatchSize {
+			if err := r.SaveMany(ctx, batch...); err != nil {
+				return err
+			}
+			batch = nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return eh.RepoError{
+			Err:       wrapError(eh.ErrCouldNotSaveEntity, err),
+			BaseErr:   err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	if len(batch) > 0 {
+		return r.SaveMany(ctx, batch...)
+	}
+	return nil
+}
+
+// export writes redacted entities as NDJSON.
+func (r *Repo) export(ctx context.Context, w io.Writer, entities []eh.Entity) error {
+	enc := json.NewEncoder(w)
+	for _, entity := range entities {
+		redact(reflect.ValueOf(entity))
+		if err := enc.Encode(entity); err != nil {
+			return eh.RepoError{
+				Err:       err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+	}
+	return nil
+}
+
+// redact zeroes the sensitive fields of a struct, and of its nested structs.
+func redact(v reflect.Value) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return
+	}
+
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := v.Field(i)
+		if !field.CanSet() {
+			continue
+		}
+		if isSensitive(t.Field(i)) {
+			field.Set(reflect.Zero(field.Type()))
+			continue
+		}
+
+		switch field.Kind() {
+		case reflect.Struct, reflect.Ptr, reflect.Interface:
+			redact(field)
+		case reflect.Slice, reflect.Array:
+			for j := 0; j < field.Len(); j++ {
+				redact(field.Index(j))
+			}
+		}
+	}
+}
+
+// isSensitive checks if a struct field is tagged as sensitive.
+func isSensitive(f reflect.StructField) bool {
+	for _, opt := range strings.Split(f.Tag.Get(sensitiveTag), ",") {
+		if opt == "sensitive" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedact(t *testing.T) {
+	type address struct {
+		Street string `eh:"sensitive"`
+		City   string
+	}
+	type user struct {
+		Name      string
+		Email     string `json:"email" eh:"sensitive"`
+		Age       int    `eh:"sensitive"`
+		Address   address
+		Previous  []address
+		Secondary *address
+	}
+
+	u := &user{
+		Name:      "name",
+		Email:     "name@example.com",
+		Age:       42,
+		Address:   address{Street: "street", City: "city"},
+		Previous:  []address{{Street: "old street", City: "old city"}},
+		Secondary: &address{Street: "second street", City: "second city"},
+	}
+	redact(reflect.ValueOf(u))
+	assert.Equal(t, &user{
+		Name:      "name",
+		Address:   address{City: "city"},
+		Previous:  []address{{City: "old city"}},
+		Secondary: &address{City: "second city"},
+	}, u)
+}
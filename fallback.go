@@ -0,0 +1,105 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// LoadResult is the result of LoadWithFallback: either the events of an
+// aggregate, or its latest snapshot when the events could not be loaded.
+type LoadResult struct {
+	// Events are the events of the aggregate, nil if Stale is set.
+	Events []eh.Event
+	// Snapshot is the latest snapshot of the aggregate if Stale is set.
+	Snapshot *Snapshot
+	// Stale is set if the event table was unavailable and the snapshot is
+	// returned instead, which can be older than the latest events.
+	Stale bool
+}
+
+// WithSnapshotFallback makes LoadWithFallback return the latest snapshot of
+// an aggregate from a snapshot store when the event table is unavailable,
+// for read-mostly services that prefer stale state over failing during an
+// outage.
+func WithSnapshotFallback(snapshots *SnapshotStore) Option {
+	return func(s *EventStore) error {
+		s.snapshotFallback = snapshots
+		return nil
+	}
+}
+
+// LoadWithFallback loads the events of an aggregate as Load. If that fails
+// because the event table is unreachable, throttled or has an internal error,
+// and the WithSnapshotFallback option is used, it returns the latest snapshot
+// of the aggregate marked as stale instead. The error of Load is returned if
+// there is no snapshot.
+func (s *EventStore) LoadWithFallback(ctx context.Context, id uuid.UUID) (*LoadResult, error) {
+	ctx, err := s.namespace(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := s.Load(ctx, id)
+	if err == nil {
+		return &LoadResult{Events: events}, nil
+	} else if s.snapshotFallback == nil || classifyError(s.errorClassifier, err) == ErrorPermanent {
+		return nil, err
+	}
+
+	snapshot, snapshotErr := s.snapshotFallback.LoadSnapshot(ctx, id)
+	if snapshotErr != nil || snapshot == nil {
+		return nil, err
+	}
+	return &LoadResult{Snapshot: snapshot, Stale: true}, nil
+}
+
+// unavailableCodes are the error codes of DynamoDB being unavailable, as
+// opposed to a request that is wrong.
+var unavailableCodes = []string{
+	dynamodb.ErrCodeInternalServerError,
+	dynamodb.ErrCodeProvisionedThroughputExceededException,
+	dynamodb.ErrCodeRequestLimitExceeded,
+	"ServiceUnavailable",
+	"ThrottlingException",
+}
+
+// isUnavailable checks if an error means that DynamoDB is unreachable or
+// temporarily unable to serve requests.
+func isUnavailable(err error) bool {
+	if isUnreachable(err) {
+		return true
+	}
+	if esErr, ok := err.(eh.EventStoreError); ok {
+		err = esErr.BaseErr
+	}
+
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) && reqErr.StatusCode() >= 500 {
+		return true
+	}
+	for _, code := range unavailableCodes {
+		if isAWSErrorCode(err, code) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,40 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsUnavailable(t *testing.T) {
+	unreachable := awserr.New(request.ErrCodeRequestError, "send request failed", nil)
+	assert.True(t, isUnavailable(eh.EventStoreError{Err: unreachable, BaseErr: unreachable}))
+
+	throttled := awserr.New(dynamodb.ErrCodeProvisionedThroughputExceededException, "throttled", nil)
+	assert.True(t, isUnavailable(eh.EventStoreError{Err: throttled, BaseErr: withRequestID(throttled)}))
+
+	internal := awserr.NewRequestFailure(awserr.New("Unknown", "internal", nil), 503, "req-1")
+	assert.True(t, isUnavailable(withRequestID(internal)))
+
+	notFound := awserr.New(dynamodb.ErrCodeResourceNotFoundException, "no table", nil)
+	assert.False(t, isUnavailable(eh.EventStoreError{Err: notFound, BaseErr: notFound}))
+	assert.False(t, isUnavailable(nil))
+}
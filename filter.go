@@ -0,0 +1,178 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// EventFilter selects events by their stored attributes. It is used by
+// stream consumers to skip events before they are decoded and dispatched,
+// see MatchImage. Empty criteria match all events.
+type EventFilter struct {
+	// AggregateTypes are the aggregate types to accept.
+	AggregateTypes []eh.AggregateType
+	// EventTypes are the event types to accept.
+	EventTypes []eh.EventType
+	// ExcludedEventTypes are the event types to skip.
+	ExcludedEventTypes []eh.EventType
+	// Namespaces are the namespaces to accept.
+	Namespaces []string
+	// AggregateIDs are the aggregate IDs to accept.
+	AggregateIDs []uuid.UUID
+	// AggregateIDPrefix is a prefix of the aggregate IDs to accept.
+	AggregateIDPrefix string
+}
+
+// MatchItem checks if a raw event item from a namespace matches the filter.
+func (f EventFilter) MatchItem(namespace string, item map[string]*dynamodb.AttributeValue) bool {
+	return f.match(nil, namespace, item)
+}
+
+// MatchImage checks if an item image from the event table, as in stream
+// records, matches a filter in the namespace of the context, with the types
+// translated to their storage names. Consumers use it to skip records before
+// decoding them with EventFromImage, which fetches, decrypts and upcasts the
+// event data.
+func (s *EventStore) MatchImage(ctx context.Context, f EventFilter, image map[string]*dynamodb.AttributeValue) (bool, error) {
+	ctx, err := s.namespace(ctx)
+	if err != nil {
+		return false, err
+	}
+	return f.match(s.typeNames, eh.NamespaceFromContext(ctx), image), nil
+}
+
+// match checks if a raw event item matches the filter, with the types
+// translated to their storage names.
+func (f EventFilter) match(names *TypeNames, namespace string, item map[string]*dynamodb.AttributeValue) bool {
+	attr := func(name string) string {
+		if av, ok := item[name]; ok {
+			return aws.StringValue(av.S)
+		}
+		return ""
+	}
+
+	if len(f.Namespaces) > 0 && !containsString(f.Namespaces, namespace) {
+		return false
+	}
+
+	if len(f.AggregateTypes) > 0 {
+		aggregateTypes := make([]string, len(f.AggregateTypes))
+		for i, t := range f.AggregateTypes {
+			aggregateTypes[i] = names.AggregateTypeName(t)
+		}
+		if !containsString(aggregateTypes, attr("AggregateType")) {
+			return false
+		}
+	}
+
+	if len(f.EventTypes) > 0 {
+		eventTypes := make([]string, len(f.EventTypes))
+		for i, t := range f.EventTypes {
+			eventTypes[i] = names.EventTypeName(t)
+		}
+		if !containsString(eventTypes, attr("EventType")) {
+			return false
+		}
+	}
+	for _, t := range f.ExcludedEventTypes {
+		if names.EventTypeName(t) == attr("EventType") {
+			return false
+		}
+	}
+
+	aggregateID := attr("AggregateID")
+	if len(f.AggregateIDs) > 0 {
+		ids := make([]string, len(f.AggregateIDs))
+		for i, id := range f.AggregateIDs {
+			ids[i] = id.String()
+		}
+		if !containsString(ids, aggregateID) {
+			return false
+		}
+	}
+
+	return strings.HasPrefix(aggregateID, f.AggregateIDPrefix)
+}
+
+// matchTypes checks if an event matches the types of the filter, with the
+// types translated to their storage names.
+func (f EventFilter) matchTypes(names *TypeNames, e dbEvent) bool {
+	item := map[string]*dynamodb.AttributeValue{
+		"AggregateType": {S: aws.String(string(e.AggregateType))},
+		"EventType":     {S: aws.String(string(e.EventType))},
+	}
+	return EventFilter{
+		AggregateTypes:     f.AggregateTypes,
+		EventTypes:         f.EventTypes,
+		ExcludedEventTypes: f.ExcludedEventTypes,
+	}.match(names, "", item)
+}
+
+// typesExpression returns a filter expression for the types of the filter,
+// with the types translated to their storage names, or an empty expression if
+// the filter has no types.
+func (f EventFilter) typesExpression(names *TypeNames) (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	in := func(attr string, values []string) string {
+		placeholders := make([]string, len(values))
+		for i, v := range values {
+			placeholders[i] = "?"
+			args = append(args, v)
+		}
+		return "'" + attr + "' IN (" + strings.Join(placeholders, ", ") + ")"
+	}
+
+	if len(f.AggregateTypes) > 0 {
+		aggregateTypes := make([]string, len(f.AggregateTypes))
+		for i, t := range f.AggregateTypes {
+			aggregateTypes[i] = names.AggregateTypeName(t)
+		}
+		conds = append(conds, in("AggregateType", aggregateTypes))
+	}
+	if len(f.EventTypes) > 0 {
+		eventTypes := make([]string, len(f.EventTypes))
+		for i, t := range f.EventTypes {
+			eventTypes[i] = names.EventTypeName(t)
+		}
+		conds = append(conds, in("EventType", eventTypes))
+	}
+	if len(f.ExcludedEventTypes) > 0 {
+		eventTypes := make([]string, len(f.ExcludedEventTypes))
+		for i, t := range f.ExcludedEventTypes {
+			eventTypes[i] = names.EventTypeName(t)
+		}
+		conds = append(conds, "NOT "+in("EventType", eventTypes))
+	}
+
+	return strings.Join(conds, " AND "), args
+}
+
+// containsString checks if a string is in a list.
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
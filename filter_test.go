@@ -0,0 +1,100 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventFilter(t *testing.T) {
+	id := uuid.MustParse("c1138e5f-f6fb-4dd0-8e79-255c6c8d3756")
+	item := map[string]*dynamodb.AttributeValue{
+		"AggregateID":   {S: aws.String(id.String())},
+		"AggregateType": {S: aws.String(string(mocks.AggregateType))},
+		"EventType":     {S: aws.String(string(mocks.EventType))},
+	}
+
+	assert.True(t, EventFilter{}.MatchItem("ns", item))
+	assert.True(t, EventFilter{AggregateTypes: []eh.AggregateType{mocks.AggregateType}}.MatchItem("ns", item))
+	assert.False(t, EventFilter{AggregateTypes: []eh.AggregateType{"other"}}.MatchItem("ns", item))
+	assert.True(t, EventFilter{EventTypes: []eh.EventType{mocks.EventOtherType, mocks.EventType}}.MatchItem("ns", item))
+	assert.False(t, EventFilter{EventTypes: []eh.EventType{mocks.EventOtherType}}.MatchItem("ns", item))
+	assert.True(t, EventFilter{Namespaces: []string{"ns"}}.MatchItem("ns", item))
+	assert.False(t, EventFilter{Namespaces: []string{"other"}}.MatchItem("ns", item))
+	assert.True(t, EventFilter{AggregateIDs: []uuid.UUID{id}}.MatchItem("ns", item))
+	assert.False(t, EventFilter{AggregateIDs: []uuid.UUID{uuid.New()}}.MatchItem("ns", item))
+	assert.True(t, EventFilter{AggregateIDPrefix: "c1138e5f"}.MatchItem("ns", item))
+	assert.False(t, EventFilter{AggregateIDPrefix: "d"}.MatchItem("ns", item))
+
+	// Types are compared by their storage names.
+	names := NewTypeNames()
+	assert.Nil(t, names.RegisterEventType("Renamed", string(mocks.EventType)))
+	assert.True(t, EventFilter{EventTypes: []eh.EventType{"Renamed"}}.match(names, "ns", item))
+	assert.False(t, EventFilter{ExcludedEventTypes: []eh.EventType{"Renamed"}}.match(names, "ns", item))
+}
+
+func TestMatchImage(t *testing.T) {
+	sess := session.Must(session.NewSession(&aws.Config{Region: aws.String("us-west-2")}))
+	names := NewTypeNames()
+	assert.Nil(t, names.RegisterEventType("Renamed", string(mocks.EventType)))
+	s, err := NewEventStore("events", WithDynamoDB(sess), WithTypeNames(names))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	image := map[string]*dynamodb.AttributeValue{
+		"AggregateID":   {S: aws.String(uuid.New().String())},
+		"AggregateType": {S: aws.String(string(mocks.AggregateType))},
+		"EventType":     {S: aws.String(string(mocks.EventType))},
+	}
+	ctx := eh.NewContextWithNamespace(context.Background(), "ns")
+	ok, err := s.MatchImage(ctx, EventFilter{EventTypes: []eh.EventType{"Renamed"}, Namespaces: []string{"ns"}}, image)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = s.MatchImage(ctx, EventFilter{ExcludedEventTypes: []eh.EventType{"Renamed"}}, image)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = s.MatchImage(context.Background(), EventFilter{Namespaces: []string{"ns"}}, image)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
+
+func TestEventFilterTypesExpression(t *testing.T) {
+	expr, args := EventFilter{AggregateIDPrefix: "c1138e5f"}.typesExpression(nil)
+	assert.Empty(t, expr)
+	assert.Empty(t, args)
+
+	names := NewTypeNames()
+	assert.Nil(t, names.RegisterEventType("Renamed", "stored"))
+	expr, args = EventFilter{
+		AggregateTypes:     []eh.AggregateType{mocks.AggregateType},
+		ExcludedEventTypes: []eh.EventType{mocks.EventOtherType, "Renamed"},
+	}.typesExpression(names)
+	assert.Equal(t, "'AggregateType' IN (?) AND NOT 'EventType' IN (?, ?)", expr)
+	assert.Equal(t, []interface{}{string(mocks.AggregateType), string(mocks.EventOtherType), "stored"}, args)
+
+	e := dbEvent{AggregateType: mocks.AggregateType, EventType: "stored"}
+	assert.False(t, EventFilter{ExcludedEventTypes: []eh.EventType{"Renamed"}}.matchTypes(names, e))
+	assert.True(t, EventFilter{ExcludedEventTypes: []eh.EventType{mocks.EventOtherType}}.matchTypes(names, e))
+}
@@ -0,0 +1,367 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/google/uuid"
+	"github.com/guregu/dynamo"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// forwardFailedDir is the directory, inside the buffer directory, where
+// buffered writes that failed to be forwarded are moved.
+const forwardFailedDir = "failed"
+
+// WithStoreAndForward enables store-and-forward mode for intermittent
+// connectivity. When DynamoDB is unreachable Save accepts the events into an
+// on-disk buffer in dir instead of failing, and the buffered writes are
+// forwarded in order, with the normal conditional checks, once connectivity
+// returns. Forwarding is attempted on every Save and every flushInterval.
+// Errors from forwarding in the background, like version conflicts as
+// eventhorizon.EventStoreError, are passed to onError if set; writes that fail
+// are moved to the "failed" subdirectory.
+//
+// Note that buffered events are not visible to Load until they are forwarded,
+// and that they are cached, published and handled, or dispatched with the
+// outbox, when they are forwarded, like the events of a direct save. A write
+// that timed out may have been committed; forwarding it again is not a
+// conflict if the aggregate already has its events.
+func WithStoreAndForward(dir string, flushInterval time.Duration, onError func(error)) Option {
+	return func(s *EventStore) error {
+		if err := os.MkdirAll(filepath.Join(dir, forwardFailedDir), 0700); err != nil {
+			return err
+		}
+
+		b := &forwardBuffer{
+			dir:      dir,
+			interval: flushInterval,
+			onError:  onError,
+			done:     make(chan struct{}),
+		}
+
+		// Continue the sequence of any writes buffered by a previous process.
+		files, err := b.pending()
+		if err != nil {
+			return err
+		}
+		if len(files) > 0 {
+			last := strings.TrimSuffix(files[len(files)-1], ".json")
+			if b.seq, err = strconv.ParseUint(last, 10, 64); err != nil {
+				return err
+			}
+		}
+
+		s.forward = b
+		s.onClose(func(context.Context) error {
+			close(b.done)
+			return nil
+		})
+		return nil
+	}
+}
+
+// Flush forwards the writes that are buffered in store-and-forward mode, in
+// order. It stops without error when DynamoDB is still unreachable. A write
+// that fails, for example with a version conflict, is moved aside and its
+// error is returned.
+func (s *EventStore) Flush(ctx context.Context) error {
+	if s.forward == nil {
+		return nil
+	}
+
+	s.forward.mu.Lock()
+	defer s.forward.mu.Unlock()
+
+	_, err := s.forward.flush(ctx, s)
+	return err
+}
+
+// forwardBuffer is the on-disk buffer of writes in store-and-forward mode.
+// Each buffered write is a file named by its sequence number.
+type forwardBuffer struct {
+	mu       sync.Mutex
+	dir      string
+	seq      uint64
+	interval time.Duration
+	onError  func(error)
+	done     chan struct{}
+}
+
+// forwardRecord is a buffered write. The client request token of the input
+// is set before the first attempt and kept in the record, so that forwarding
+// a write that timed out after it was committed does not write it twice.
+type forwardRecord struct {
+	Namespace       string
+	TableName       string
+	Input           *dynamodb.TransactWriteItemsInput
+	AggregateID     uuid.UUID
+	OriginalVersion int
+	Version         int
+	DispatchKey     string
+}
+
+// write writes the events, or buffers them if DynamoDB is unreachable or if
+// there are earlier writes that are still buffered.
+func (b *forwardBuffer) write(ctx context.Context, s *EventStore, record forwardRecord) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Keep the order of the writes by forwarding any buffered writes first.
+	// Their errors belong to earlier saves and are only reported.
+	empty, err := b.flush(ctx, s)
+	if err != nil && b.onError != nil {
+		b.onError(err)
+	}
+
+	record.Namespace = eh.NamespaceFromContext(ctx)
+	record.Input.ClientRequestToken = aws.String(uuid.New().String())
+	if empty {
+		if err := s.writeEvents(ctx, record.TableName, record.Input, record.OriginalVersion); err == nil || classifyError(s.errorClassifier, err) != ErrorTimeout {
+			return false, err
+		}
+	}
+
+	return true, b.append(record)
+}
+
+// flush forwards the buffered writes in order and reports if the buffer is
+// empty afterwards.
+func (b *forwardBuffer) flush(ctx context.Context, s *EventStore) (bool, error) {
+	files, err := b.pending()
+	if err != nil {
+		return false, err
+	}
+
+	for i, file := range files {
+		data, err := os.ReadFile(filepath.Join(b.dir, file))
+		if err != nil {
+			return false, err
+		}
+		var record forwardRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return false, err
+		}
+
+		nsCtx := eh.NewContextWithNamespace(ctx, record.Namespace)
+		err = s.writeEvents(nsCtx, record.TableName, record.Input, record.OriginalVersion)
+		if esErr, ok := err.(eh.EventStoreError); ok && isConditionalCheckFailed(esErr.BaseErr) {
+			// The write may have timed out after it was committed, and the
+			// client request token is only honored for a short time.
+			applied, appliedErr := s.forwardApplied(nsCtx, record)
+			if appliedErr != nil {
+				return false, appliedErr
+			} else if applied {
+				err = nil
+			}
+		}
+		if classifyError(s.errorClassifier, err) == ErrorTimeout {
+			return false, nil
+		} else if err != nil {
+			if moveErr := os.Rename(filepath.Join(b.dir, file), filepath.Join(b.dir, forwardFailedDir, file)); moveErr != nil {
+				return false, moveErr
+			}
+			return i == len(files)-1, err
+		}
+
+		if err := os.Remove(filepath.Join(b.dir, file)); err != nil {
+			return false, err
+		}
+
+		// Handle the forwarded events now that they are persisted, like the
+		// events of a save that is written directly.
+		events, err := forwardedEvents(nsCtx, s, record.TableName, record.Input)
+		if err != nil {
+			return i == len(files)-1, err
+		}
+		if err := s.written(nsCtx, record.AggregateID, record.OriginalVersion, record.Version, events, events, record.DispatchKey); err != nil {
+			return i == len(files)-1, err
+		}
+	}
+
+	return true, nil
+}
+
+// forwardApplied checks if a buffered write that failed its conditions was
+// already committed: the head item of the aggregate is at or past the version
+// of the write, and its last event is the one of the write.
+func (s *EventStore) forwardApplied(ctx context.Context, record forwardRecord) (bool, error) {
+	version, err := s.headVersion(ctx, record.TableName, record.AggregateID)
+	if err != nil || version < record.Version {
+		return false, err
+	}
+
+	for _, item := range record.Input.TransactItems {
+		if item.Put == nil || !isEventTable(record.TableName, aws.StringValue(item.Put.TableName)) {
+			continue
+		}
+		var put dbEvent
+		if err := dynamo.UnmarshalItem(item.Put.Item, &put); err != nil || put.Version != record.Version {
+			continue
+		}
+
+		var stored dbEvent
+		tableName := aws.StringValue(item.Put.TableName)
+		start := time.Now()
+		err := s.service.Table(tableName).
+			Get(s.hashKey(), s.hashValue(ctx, record.AggregateID)).
+			Range("Version", dynamo.Equal, record.Version).
+			Project("ReceivedAt").
+			Consistent(true).
+			OneWithContext(ctx, &stored)
+		observe(ctx, s.metrics, OperationGetItem, tableName, start, err)
+		if err == dynamo.ErrNotFound {
+			return false, nil
+		} else if err != nil {
+			return false, eh.EventStoreError{
+				BaseErr:   withRequestID(err),
+				Err:       err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+		return stored.ReceivedAt.Equal(put.ReceivedAt), nil
+	}
+	return false, nil
+}
+
+// append adds a write to the end of the buffer.
+func (b *forwardBuffer) append(record forwardRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	b.seq++
+	name := fmt.Sprintf("%020d.json", b.seq)
+
+	// Write to a temporary file first, so that a crash never leaves a partial
+	// record in the buffer.
+	tmp := filepath.Join(b.dir, name+".tmp")
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(b.dir, name))
+}
+
+// pending returns the file names of the buffered writes in order.
+func (b *forwardBuffer) pending() ([]string, error) {
+	entries, err := os.ReadDir(b.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
+			files = append(files, entry.Name())
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// run forwards the buffered writes every interval until the buffer is closed.
+func (b *forwardBuffer) run(s *EventStore) {
+	if b.interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+			if err := s.Flush(context.Background()); err != nil && b.onError != nil {
+				b.onError(err)
+			}
+		}
+	}
+}
+
+// forwardedEvents decodes the events of a buffered write to the event table
+// tableName. Puts to other tables, like the dispatch record of the outbox and
+// the transact items of the caller, are skipped. The continuation items of
+// chunked event data are taken from the write, as they are not stored yet.
+func forwardedEvents(ctx context.Context, s *EventStore, tableName string, input *dynamodb.TransactWriteItemsInput) ([]eh.Event, error) {
+	var dbEvents []dbEvent
+	chunks := map[int][]byte{}
+	for _, item := range input.TransactItems {
+		if item.Put == nil || !isEventTable(tableName, aws.StringValue(item.Put.TableName)) {
+			continue
+		}
+
+		var e dbEvent
+		if err := dynamo.UnmarshalItem(item.Put.Item, &e); err != nil {
+			return nil, eh.EventStoreError{
+				BaseErr:   err,
+				Err:       wrapError(ErrCouldNotUnmarshalEvent, err),
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+		if e.Version <= 0 {
+			if data := item.Put.Item["Data"]; data != nil {
+				chunks[e.Version] = data.B
+			}
+			continue
+		}
+		dbEvents = append(dbEvents, e)
+	}
+
+	var events []eh.Event
+	for _, e := range dbEvents {
+		for i := 0; i < e.Chunks; i++ {
+			e.chunks = append(e.chunks, chunks[chunkVersion(e.Version, i)])
+		}
+		event, err := s.buildEvent(ctx, e)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// isUnreachable checks if an error means that DynamoDB could not be reached.
+func isUnreachable(err error) bool {
+	if esErr, ok := err.(eh.EventStoreError); ok {
+		err = esErr.BaseErr
+	}
+	if err == nil {
+		return false
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		isAWSErrorCode(err, request.ErrCodeRequestError) ||
+		isAWSErrorCode(err, request.ErrCodeResponseTimeout)
+}
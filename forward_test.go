@@ -0,0 +1,189 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/google/uuid"
+	"github.com/guregu/dynamo"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForwardBuffer(t *testing.T) {
+	dir, err := os.MkdirTemp("", "forward")
+	if err != nil {
+		t.Fatal("could not create dir:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := &EventStore{}
+	assert.Nil(t, WithStoreAndForward(dir, 0, nil)(s))
+	assert.Nil(t, s.forward.append(forwardRecord{
+		Namespace: "ns",
+		TableName: "test_ns",
+		Input:     &dynamodb.TransactWriteItemsInput{},
+	}))
+
+	// A new buffer in the same dir continues the sequence.
+	s2 := &EventStore{}
+	assert.Nil(t, WithStoreAndForward(dir, 0, nil)(s2))
+	assert.Equal(t, uint64(1), s2.forward.seq)
+	assert.Nil(t, s2.forward.append(forwardRecord{Namespace: "ns"}))
+
+	files, err := s2.forward.pending()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"00000000000000000001.json", "00000000000000000002.json"}, files)
+}
+
+func TestIsUnreachable(t *testing.T) {
+	reqErr := awserr.New(request.ErrCodeRequestError, "send request failed", errors.New("connection refused"))
+	assert.True(t, isUnreachable(reqErr))
+	assert.True(t, isUnreachable(eh.EventStoreError{Err: reqErr, BaseErr: reqErr}))
+
+	conflict := awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "conflict", nil)
+	assert.False(t, isUnreachable(conflict))
+	assert.False(t, isUnreachable(eh.EventStoreError{Err: ErrCouldNotSaveAggregate, BaseErr: conflict}))
+	assert.False(t, isUnreachable(nil))
+}
+
+func TestForwardedEventsWithOutbox(t *testing.T) {
+	dir, err := os.MkdirTemp("", "forward")
+	if err != nil {
+		t.Fatal("could not create dir:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	sess := session.Must(session.NewSession(&aws.Config{Region: aws.String("us-west-2")}))
+	s, err := NewEventStore("test", WithDynamoDB(sess),
+		WithStoreAndForward(dir, 0, nil), WithOutbox("test_outbox", 0, nil))
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer s.Close(context.Background())
+
+	ctx := eh.NewContextWithNamespace(context.Background(), "ns")
+	id := uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	e, err := s.newDBEvent(ctx, eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event1"},
+		timestamp, mocks.AggregateType, id, 1))
+	assert.Nil(t, err)
+	item, err := dynamo.MarshalItem(e)
+	assert.Nil(t, err)
+	outboxItem, _, err := s.outboxItem(ctx, id, 1, 1)
+	assert.Nil(t, err)
+
+	// Only the puts to the event table are events.
+	input := &dynamodb.TransactWriteItemsInput{TransactItems: []*dynamodb.TransactWriteItem{
+		{Put: &dynamodb.Put{TableName: aws.String("test_ns"), Item: item}},
+		outboxItem,
+		{Put: &dynamodb.Put{TableName: aws.String("other"), Item: item}},
+	}}
+	events, err := forwardedEvents(ctx, s, "test_ns", input)
+	assert.Nil(t, err)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, id, events[0].AggregateID())
+		assert.Equal(t, &mocks.EventData{Content: "event1"}, events[0].Data())
+	}
+}
+
+// TestForwardApplied will forward a buffered write that was already committed
+// without a conflict, and handle its events once
+func (suite *EventStoreTestSuite) TestForwardApplied() {
+	dir, err := os.MkdirTemp("", "forward")
+	if err != nil {
+		suite.T().Fatal("could not create dir:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	handler := &poisonHandler{}
+	store := suite.newStore(WithEventHandler(handler),
+		WithStoreAndForward(dir, 0, nil), WithOutbox("test_outbox", 0, nil))
+	assert.Nil(suite.T(), store.CreateTable(suite.ctx))
+	defer store.deleteTable(suite.ctx, "test_outbox")
+
+	// record returns a buffered write of an event, with the head item update
+	// and dispatch record of a save.
+	id := uuid.New()
+	tableName := store.tableName(suite.ctx)
+	record := func(content string) forwardRecord {
+		e, err := store.newDBEvent(suite.ctx, eh.NewEventForAggregate(mocks.EventType,
+			&mocks.EventData{Content: content}, time.Now(), mocks.AggregateType, id, 1))
+		assert.Nil(suite.T(), err)
+		e.ReceivedAt = time.Now()
+		item, err := dynamo.MarshalItem(e)
+		assert.Nil(suite.T(), err)
+		outboxItem, key, err := store.outboxItem(suite.ctx, id, 1, 1)
+		assert.Nil(suite.T(), err)
+		return forwardRecord{
+			Namespace: eh.NamespaceFromContext(suite.ctx),
+			TableName: tableName,
+			Input: &dynamodb.TransactWriteItemsInput{TransactItems: []*dynamodb.TransactWriteItem{
+				{Put: &dynamodb.Put{
+					TableName:           aws.String(tableName),
+					Item:                item,
+					ConditionExpression: aws.String(store.keyNotExists()),
+				}},
+				{Update: &dynamodb.Update{
+					TableName:        aws.String(tableName),
+					Key:              store.itemKey(suite.ctx, id, aggregateHeadVersion),
+					UpdateExpression: aws.String("SET CurrentVersion = :version"),
+					ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+						":version": {N: aws.String("1")},
+					},
+					ConditionExpression: aws.String(store.keyNotExists()),
+				}},
+				outboxItem,
+			}},
+			AggregateID: id,
+			Version:     1,
+			DispatchKey: key,
+		}
+	}
+
+	// A write that timed out after it was committed is forwarded without
+	// error, its events are handled and its dispatch record is removed.
+	committed := record("event1")
+	assert.Nil(suite.T(), store.writeEvents(suite.ctx, tableName, committed.Input, 0))
+	assert.Nil(suite.T(), store.forward.append(committed))
+	assert.Nil(suite.T(), store.Flush(context.Background()))
+	if assert.Len(suite.T(), handler.handled, 1) {
+		assert.Equal(suite.T(), &mocks.EventData{Content: "event1"}, handler.handled[0].Data())
+	}
+	var records []dbOutboxRecord
+	assert.Nil(suite.T(), store.service.Table("test_outbox").Scan().All(&records))
+	assert.Len(suite.T(), records, 0)
+
+	// A write of the same version by another save is a conflict.
+	assert.Nil(suite.T(), store.forward.append(record("event2")))
+	_, ok := store.Flush(context.Background()).(eh.EventStoreError)
+	assert.True(suite.T(), ok)
+	assert.Len(suite.T(), handler.handled, 1)
+	failed, err := os.ReadDir(filepath.Join(dir, forwardFailedDir))
+	assert.Nil(suite.T(), err)
+	assert.Len(suite.T(), failed, 1)
+}
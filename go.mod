@@ -3,9 +3,12 @@ module github.com/sysbot/eh-dynamodb
 go 1.16
 
 require (
-	github.com/aws/aws-sdk-go v1.34.28
+	github.com/aws/aws-sdk-go v1.42.18
 	github.com/google/uuid v1.2.0
 	github.com/guregu/dynamo v1.2.0
 	github.com/looplab/eventhorizon v0.13.0
+	github.com/prometheus/client_golang v1.11.0
 	github.com/stretchr/testify v1.7.0
+	github.com/xitongsys/parquet-go v1.6.2
+	github.com/xitongsys/parquet-go-source v0.0.0-20200817004010-026bad9b25d0
 )
@@ -0,0 +1,176 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// ErrHandlerQueueFull is when saved events could not be queued for the async
+// event handler before the context was done.
+var ErrHandlerQueueFull = errors.New("event handler queue full")
+
+// handlerQueue is the config and queues of the async event handler.
+type handlerQueue struct {
+	queues  []chan handlerJob
+	onError func(error)
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// handlerJob is the saved events of one Save to handle.
+type handlerJob struct {
+	ctx         context.Context
+	events      []eh.Event
+	dispatchKey string
+}
+
+// WithAsyncEventHandler adds an event handler like WithEventHandler that is
+// called in the background instead of in Save, so that slow handlers don't add
+// to the latency of saves. The saved events are queued for a pool of workers,
+// with room for queueSize saves per worker. The events of an aggregate are
+// always handled by the same worker, in order. When the queue of a worker is
+// full Save waits for room, and returns ErrHandlerQueueFull in an
+// eventhorizon.CouldNotHandleEventError if the context is done first; the
+// events are saved in any case. Errors of the handler are passed to onError if
+// set. Close handles the queued events before returning. With WithOutbox the
+// dispatch record is removed after the worker has handled the events.
+func WithAsyncEventHandler(h eh.EventHandler, queueSize, workers int, onError func(error)) Option {
+	return func(s *EventStore) error {
+		if queueSize < 0 {
+			return fmt.Errorf("invalid event handler queue size %d", queueSize)
+		}
+		if workers < 1 {
+			return fmt.Errorf("invalid event handler workers %d", workers)
+		}
+
+		q := &handlerQueue{
+			queues:  make([]chan handlerJob, workers),
+			onError: onError,
+		}
+		for i := range q.queues {
+			q.queues[i] = make(chan handlerJob, queueSize)
+		}
+		s.eventHandler = h
+		s.handlerQueue = q
+		s.onClose(func(context.Context) error {
+			q.close()
+			return nil
+		})
+		return nil
+	}
+}
+
+// start starts the workers of the queue.
+func (q *handlerQueue) start(s *EventStore) {
+	for _, jobs := range q.queues {
+		jobs := jobs
+		s.lifecycle.goroutine(func() { q.run(s, jobs) })
+	}
+}
+
+// run handles the queued events of a worker until the queue is closed.
+func (q *handlerQueue) run(s *EventStore, jobs <-chan handlerJob) {
+	for j := range jobs {
+		err := s.handleEvents(j.ctx, j.events)
+		if err == nil && j.dispatchKey != "" {
+			err = s.dispatched(j.ctx, j.dispatchKey)
+		}
+		if err != nil && q.onError != nil {
+			q.onError(err)
+		}
+	}
+}
+
+// enqueue queues saved events of an aggregate for the worker of the
+// aggregate, waiting for room until the context is done.
+func (q *handlerQueue) enqueue(ctx context.Context, aggregateID uuid.UUID, events []eh.Event, dispatchKey string) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.closed {
+		return eh.EventStoreError{
+			Err:       ErrClosed,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	j := handlerJob{
+		// The handler runs after Save has returned, so it must not be
+		// canceled with the context of Save.
+		ctx:         detachedContext{ctx},
+		events:      events,
+		dispatchKey: dispatchKey,
+	}
+	select {
+	case q.queues[q.worker(aggregateID)] <- j:
+		return nil
+	case <-ctx.Done():
+		return eh.CouldNotHandleEventError{
+			Err:       ErrHandlerQueueFull,
+			Event:     events[0],
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+}
+
+// worker returns the index of the worker of an aggregate.
+func (q *handlerQueue) worker(aggregateID uuid.UUID) int {
+	h := fnv.New32a()
+	_, _ = h.Write(aggregateID[:])
+	return int(h.Sum32() % uint32(len(q.queues)))
+}
+
+// close closes the queues, which stops the workers once they have handled the
+// queued events.
+func (q *handlerQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return
+	}
+	q.closed = true
+	for _, jobs := range q.queues {
+		close(jobs)
+	}
+}
+
+// detachedContext keeps the values of a context, like the namespace, without
+// its deadline and cancelation.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
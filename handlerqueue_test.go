@@ -0,0 +1,107 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+// blockingHandler records the handled events once it is unblocked.
+type blockingHandler struct {
+	mu      sync.Mutex
+	unblock chan struct{}
+	events  []eh.Event
+	ns      []string
+	err     error
+}
+
+func (h *blockingHandler) HandlerType() eh.EventHandlerType {
+	return "blocking"
+}
+
+func (h *blockingHandler) HandleEvent(ctx context.Context, event eh.Event) error {
+	<-h.unblock
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.events = append(h.events, event)
+	h.ns = append(h.ns, eh.NamespaceFromContext(ctx))
+	return h.err
+}
+
+func TestAsyncEventHandler(t *testing.T) {
+	sess := session.Must(session.NewSession(&aws.Config{Region: aws.String("us-west-2")}))
+	if _, err := NewEventStore("events", WithDynamoDB(sess), WithAsyncEventHandler(&blockingHandler{}, 1, 0, nil)); err == nil {
+		t.Error("there should be an error for no workers")
+	}
+
+	h := &blockingHandler{unblock: make(chan struct{}), err: errors.New("handler error")}
+	var handlerErrs []error
+	var errMu sync.Mutex
+	s, err := NewEventStore("events", WithDynamoDB(sess), WithAsyncEventHandler(h, 1, 2, func(err error) {
+		errMu.Lock()
+		defer errMu.Unlock()
+		handlerErrs = append(handlerErrs, err)
+	}))
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.True(t, s.Capabilities().AsyncHandler)
+
+	ctx, cancel := context.WithCancel(eh.NewContextWithNamespace(context.Background(), "ns"))
+	id := uuid.New()
+	var events []eh.Event
+	for i := 1; i <= 3; i++ {
+		events = append(events, eh.NewEventForAggregate(mocks.EventType, nil, time.Now(), mocks.AggregateType, id, i))
+	}
+
+	// The first save is taken by the blocked worker, the second fills the
+	// queue and the third waits for room until the context is done.
+	assert.Nil(t, s.handlerQueue.enqueue(ctx, id, events[:1], ""))
+	time.Sleep(10 * time.Millisecond)
+	assert.Nil(t, s.handlerQueue.enqueue(ctx, id, events[1:2], ""))
+	timeoutCtx, timeoutCancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer timeoutCancel()
+	err = s.handlerQueue.enqueue(timeoutCtx, id, events[2:], "")
+	if hErr, ok := err.(eh.CouldNotHandleEventError); !ok || hErr.Err != ErrHandlerQueueFull {
+		t.Error("there should be a queue full error:", err)
+	}
+
+	// The handler is not canceled with the context of the save.
+	cancel()
+	close(h.unblock)
+	assert.Nil(t, s.Close(context.Background()))
+
+	assert.Equal(t, events[:2], h.events)
+	assert.Equal(t, []string{"ns", "ns"}, h.ns)
+	assert.Len(t, handlerErrs, 2)
+
+	err = s.handlerQueue.enqueue(context.Background(), id, events[2:], "")
+	if esErr, ok := err.(eh.EventStoreError); !ok || esErr.Err != ErrClosed {
+		t.Error("there should be a closed error:", err)
+	}
+}
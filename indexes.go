@@ -0,0 +1,195 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// IndexProjectionError is when an index used by a registered query is missing
+// or does not project all the attributes that the query needs.
+type IndexProjectionError struct {
+	// IndexName is the name of the index.
+	IndexName string
+	// ProjectionType is the projection type of the index, empty if the index
+	// was not found.
+	ProjectionType string
+	// Missing are the attributes that are not projected.
+	Missing []string
+}
+
+// Error implements the Error method of the errors.Error interface.
+func (e IndexProjectionError) Error() string {
+	if e.ProjectionType == "" {
+		return "index " + e.IndexName + " not found"
+	}
+	return "index " + e.IndexName + " with " + e.ProjectionType +
+		" projection is missing attributes: " + strings.Join(e.Missing, ", ")
+}
+
+// WithRepoIndexQuery registers a query on an index together with the
+// attributes it needs, to be checked by VerifyIndexes. If no attributes are
+// given all the attributes of the entity model are needed.
+func WithRepoIndexQuery(indexName string, attributes ...string) OptionRepo {
+	return func(r *Repo) error {
+		if r.indexQueries == nil {
+			r.indexQueries = map[string][]string{}
+		}
+		r.indexQueries[indexName] = append(r.indexQueries[indexName], attributes...)
+		return nil
+	}
+}
+
+// WithRepoIndexVerification runs VerifyIndexes when the repo is created, so
+// that NewRepo fails if an index of a registered query is missing or does not
+// project the attributes the query needs. It checks the table of the default
+// namespace, which must exist; call VerifyIndexes for other namespaces.
+func WithRepoIndexVerification() OptionRepo {
+	return func(r *Repo) error {
+		r.verifyIndexes = true
+		return nil
+	}
+}
+
+// VerifyIndexes checks that the indexes of all registered queries exist and
+// project the attributes the queries need, which would otherwise silently be
+// left empty. It is intended to be called at startup to fail fast, see
+// WithRepoIndexVerification.
+func (r *Repo) VerifyIndexes(ctx context.Context) error {
+	ctx, err := r.namespace(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(r.indexQueries) == 0 {
+		return nil
+	}
+
+	out, err := r.service.Client().DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(r.tableName(ctx)),
+	})
+	if err != nil {
+		return err
+	}
+
+	tableKeys := keySchemaAttributes(out.Table.KeySchema)
+	for indexName, attributes := range r.indexQueries {
+		if len(attributes) == 0 {
+			if r.factoryFn == nil {
+				return ErrModelNotSet
+			}
+			attributes = itemAttributes(reflect.TypeOf(r.factoryFn()))
+		}
+
+		keySchema, projection := findIndex(out.Table, indexName)
+		if projection == nil {
+			return IndexProjectionError{IndexName: indexName}
+		}
+
+		projectionType := aws.StringValue(projection.ProjectionType)
+		if projectionType == dynamodb.ProjectionTypeAll {
+			continue
+		}
+
+		projected := keySchemaAttributes(keySchema)
+		for name := range tableKeys {
+			projected[name] = true
+		}
+		if projectionType == dynamodb.ProjectionTypeInclude {
+			for _, name := range projection.NonKeyAttributes {
+				projected[aws.StringValue(name)] = true
+			}
+		}
+
+		var missing []string
+		for _, name := range attributes {
+			if !projected[name] {
+				missing = append(missing, name)
+			}
+		}
+		if len(missing) > 0 {
+			sort.Strings(missing)
+			return IndexProjectionError{
+				IndexName:      indexName,
+				ProjectionType: projectionType,
+				Missing:        missing,
+			}
+		}
+	}
+
+	return nil
+}
+
+// findIndex finds the key schema and projection of a global or local
+// secondary index of a table.
+func findIndex(table *dynamodb.TableDescription, indexName string) ([]*dynamodb.KeySchemaElement, *dynamodb.Projection) {
+	for _, index := range table.GlobalSecondaryIndexes {
+		if aws.StringValue(index.IndexName) == indexName {
+			return index.KeySchema, index.Projection
+		}
+	}
+	for _, index := range table.LocalSecondaryIndexes {
+		if aws.StringValue(index.IndexName) == indexName {
+			return index.KeySchema, index.Projection
+		}
+	}
+	return nil, nil
+}
+
+// keySchemaAttributes returns the attribute names of a key schema.
+func keySchemaAttributes(keySchema []*dynamodb.KeySchemaElement) map[string]bool {
+	names := map[string]bool{}
+	for _, key := range keySchema {
+		names[aws.StringValue(key.AttributeName)] = true
+	}
+	return names
+}
+
+// itemAttributes returns the attribute names of a struct type as they are
+// marshaled by the dynamo package.
+func itemAttributes(t reflect.Type) []string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous {
+			names = append(names, itemAttributes(field.Type)...)
+			continue
+		} else if field.PkgPath != "" {
+			continue
+		}
+
+		name := strings.Split(field.Tag.Get("dynamo"), ",")[0]
+		if name == "-" {
+			continue
+		} else if name == "" {
+			name = field.Name
+		}
+		names = append(names, name)
+	}
+	return names
+}
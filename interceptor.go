@@ -0,0 +1,115 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// ErrSaveRejected is when a save interceptor rejects the events of a save.
+var ErrSaveRejected = errors.New("save rejected by interceptor")
+
+// SaveRecord is an event that is being saved, as seen by a SaveInterceptor.
+// The Data and Metadata can be changed before the event is written, the
+// other fields are only for inspection.
+type SaveRecord struct {
+	EventType     eh.EventType
+	Data          eh.EventData
+	Metadata      map[string]interface{}
+	Timestamp     time.Time
+	AggregateType eh.AggregateType
+	AggregateID   uuid.UUID
+	Version       int
+}
+
+// SaveInterceptor is a middleware of Save, for cross-cutting concerns like
+// payload redaction, audit stamping and custom validation.
+type SaveInterceptor interface {
+	// BeforeSave is called with the events before they are encoded and
+	// written, and can change them. An error rejects the save.
+	BeforeSave(ctx context.Context, records []*SaveRecord) error
+	// AfterSave is called with the events and the result of the save, also
+	// when the save was rejected.
+	AfterSave(ctx context.Context, records []*SaveRecord, err error)
+}
+
+// WithSaveInterceptor adds a save interceptor. BeforeSave of the
+// interceptors is called in the order they are added and AfterSave in the
+// reverse order, so that the first interceptor wraps the others. The events
+// are saved, cached, published and handled as changed by the interceptors.
+func WithSaveInterceptor(i SaveInterceptor) Option {
+	return func(s *EventStore) error {
+		s.saveInterceptors = append(s.saveInterceptors, i)
+		return nil
+	}
+}
+
+// interceptSave saves events through the save interceptors.
+func (s *EventStore) interceptSave(ctx context.Context, events []eh.Event, originalVersion int) ([]SavedEvent, error) {
+	records := make([]*SaveRecord, len(events))
+	for i, event := range events {
+		records[i] = &SaveRecord{
+			EventType:     event.EventType(),
+			Data:          event.Data(),
+			Metadata:      event.Metadata(),
+			Timestamp:     event.Timestamp(),
+			AggregateType: event.AggregateType(),
+			AggregateID:   event.AggregateID(),
+			Version:       event.Version(),
+		}
+	}
+
+	// Only the interceptors that were called before are called after.
+	var saved []SavedEvent
+	var err error
+	called := 0
+	for _, i := range s.saveInterceptors {
+		called++
+		if err = i.BeforeSave(ctx, records); err != nil {
+			err = eh.EventStoreError{
+				BaseErr:   err,
+				Err:       wrapError(ErrSaveRejected, err),
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+			break
+		}
+	}
+
+	if err == nil {
+		intercepted := make([]eh.Event, len(records))
+		for i, r := range records {
+			intercepted[i] = event{dbEvent{
+				EventType:     r.EventType,
+				data:          r.Data,
+				Metadata:      r.Metadata,
+				Timestamp:     r.Timestamp,
+				AggregateType: r.AggregateType,
+				AggregateID:   r.AggregateID,
+				Version:       r.Version,
+			}}
+		}
+		saved, err = s.save(ctx, intercepted, originalVersion)
+	}
+
+	for i := called - 1; i >= 0; i-- {
+		s.saveInterceptors[i].AfterSave(ctx, records, err)
+	}
+	return saved, err
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+// redactInterceptor is a save interceptor that redacts the event data and
+// records the results of the saves.
+type redactInterceptor struct {
+	reject  error
+	results []error
+}
+
+func (i *redactInterceptor) BeforeSave(ctx context.Context, records []*SaveRecord) error {
+	for _, r := range records {
+		r.Data = &mocks.EventData{Content: "redacted"}
+		r.Metadata = map[string]interface{}{"audited": true}
+	}
+	return i.reject
+}
+
+func (i *redactInterceptor) AfterSave(ctx context.Context, records []*SaveRecord, err error) {
+	i.results = append(i.results, err)
+}
+
+// TestSaveInterceptor will change the events before saving them and observe
+// the results
+func (suite *EventStoreTestSuite) TestSaveInterceptor() {
+	interceptor := &redactInterceptor{}
+	store := suite.newStore(WithSaveInterceptor(interceptor))
+
+	id := uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	event1 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event1"}, timestamp, mocks.AggregateType, id, 1)
+	assert.Nil(suite.T(), store.Save(suite.ctx, []eh.Event{event1}, 0))
+
+	events, err := store.Load(suite.ctx, id)
+	assert.Nil(suite.T(), err)
+	if assert.Len(suite.T(), events, 1) {
+		assert.Equal(suite.T(), &mocks.EventData{Content: "redacted"}, events[0].Data())
+		assert.Equal(suite.T(), true, events[0].Metadata()["audited"])
+	}
+
+	// A rejected save is not written, and is observed with the error.
+	interceptor.reject = errors.New("invalid")
+	event2 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event2"}, timestamp, mocks.AggregateType, id, 2)
+	err = store.Save(suite.ctx, []eh.Event{event2}, 1)
+	assert.True(suite.T(), errors.Is(err.(eh.EventStoreError).Err, ErrSaveRejected))
+	if assert.Len(suite.T(), interceptor.results, 2) {
+		assert.Nil(suite.T(), interceptor.results[0])
+		assert.Equal(suite.T(), err, interceptor.results[1])
+	}
+
+	events, err = store.Load(suite.ctx, id)
+	assert.Nil(suite.T(), err)
+	assert.Len(suite.T(), events, 1)
+}
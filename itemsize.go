@@ -0,0 +1,158 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/google/uuid"
+	"github.com/guregu/dynamo"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// ErrEventTooLarge is when an event item is over the DynamoDB item size limit.
+var ErrEventTooLarge = errors.New("event too large")
+
+// maxItemSize is the DynamoDB item size limit.
+const maxItemSize = 400 * 1024
+
+// EventTooLargeError is the Err of the eventhorizon.EventStoreError from a
+// Save of an event that is over the DynamoDB item size limit. It matches
+// ErrEventTooLarge with errors.Is.
+type EventTooLargeError struct {
+	// AggregateID is the ID of the aggregate of the event.
+	AggregateID uuid.UUID
+	// Version is the version of the event.
+	Version int
+	// Size is the size of the event item in bytes.
+	Size int
+	// Hint is a suggestion to store the event, if any.
+	Hint string
+}
+
+// Error implements the Error method of the errors.Error interface.
+func (e EventTooLargeError) Error() string {
+	errStr := fmt.Sprintf("%s: %s version %d is %d bytes, over the %d bytes item limit",
+		ErrEventTooLarge, e.AggregateID, e.Version, e.Size, maxItemSize)
+	if e.Hint != "" {
+		errStr += ", " + e.Hint
+	}
+	return errStr
+}
+
+// Is returns true for ErrEventTooLarge.
+func (e EventTooLargeError) Is(target error) bool {
+	return target == ErrEventTooLarge
+}
+
+// checkItemSize returns an EventTooLargeError if the item of an event is over
+// the item size limit, instead of the validation error of DynamoDB.
+func (s *EventStore) checkItemSize(ctx context.Context, e *dbEvent) error {
+	item, err := dynamo.MarshalItem(e)
+	if err != nil {
+		return eh.EventStoreError{
+			BaseErr:   err,
+			Err:       wrapError(ErrCouldNotMarshalEvent, err),
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	size := itemSize(item)
+	if size <= maxItemSize {
+		return nil
+	}
+
+	tooLarge := EventTooLargeError{
+		AggregateID: e.AggregateID,
+		Version:     e.Version,
+		Size:        size,
+	}
+	if s.overflow == nil && s.chunking == nil {
+		tooLarge.Hint = "large event data can be stored in S3 with WithS3Overflow or in chunks with WithEventChunking"
+	}
+	return eh.EventStoreError{
+		Err:       tooLarge,
+		Namespace: eh.NamespaceFromContext(ctx),
+	}
+}
+
+// itemSize returns the size of an item as DynamoDB counts it against the
+// item size limit: the lengths of the attribute names and values.
+func itemSize(item map[string]*dynamodb.AttributeValue) int {
+	size := 0
+	for name, av := range item {
+		size += len(name) + attributeSize(av)
+	}
+	return size
+}
+
+// attributeSize returns the size of an attribute value.
+func attributeSize(av *dynamodb.AttributeValue) int {
+	switch {
+	case av == nil:
+		return 0
+	case av.S != nil:
+		return len(*av.S)
+	case av.N != nil:
+		return numberSize(*av.N)
+	case av.B != nil:
+		return len(av.B)
+	case av.BOOL != nil, av.NULL != nil:
+		return 1
+	case av.SS != nil:
+		size := 0
+		for _, s := range av.SS {
+			size += len(*s)
+		}
+		return size
+	case av.NS != nil:
+		size := 0
+		for _, n := range av.NS {
+			size += numberSize(*n)
+		}
+		return size
+	case av.BS != nil:
+		size := 0
+		for _, b := range av.BS {
+			size += len(b)
+		}
+		return size
+	case av.M != nil:
+		// Maps and lists have an overhead of 3 bytes plus 1 byte per element.
+		size := 3
+		for name, v := range av.M {
+			size += 1 + len(name) + attributeSize(v)
+		}
+		return size
+	case av.L != nil:
+		size := 3
+		for _, v := range av.L {
+			size += 1 + attributeSize(v)
+		}
+		return size
+	}
+	return 0
+}
+
+// numberSize returns the size of a number, which is about 1 byte per two
+// significant digits plus 1 byte.
+func numberSize(n string) int {
+	digits := strings.Trim(strings.NewReplacer("-", "", ".", "").Replace(n), "0")
+	return (len(digits)+1)/2 + 1
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestItemSize(t *testing.T) {
+	assert.Equal(t, 4+5, itemSize(map[string]*dynamodb.AttributeValue{
+		"Name": {S: aws.String("value")},
+	}))
+	assert.Equal(t, 7+2, itemSize(map[string]*dynamodb.AttributeValue{
+		"Version": {N: aws.String("1200")},
+	}))
+	assert.Equal(t, 1+3+1+1+2, itemSize(map[string]*dynamodb.AttributeValue{
+		"M": {M: map[string]*dynamodb.AttributeValue{"a": {B: []byte("xy")}}},
+	}))
+}
+
+func TestEventTooLarge(t *testing.T) {
+	s := &EventStore{}
+	ctx := context.Background()
+	id := uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+
+	_, err := s.newDBEvent(ctx, eh.NewEventForAggregate(mocks.EventType,
+		&mocks.EventData{Content: "small"}, timestamp, mocks.AggregateType, id, 1))
+	assert.Nil(t, err)
+
+	_, err = s.newDBEvent(ctx, eh.NewEventForAggregate(mocks.EventType,
+		&mocks.EventData{Content: strings.Repeat("x", maxItemSize)}, timestamp, mocks.AggregateType, id, 2))
+	esErr, ok := err.(eh.EventStoreError)
+	if !ok {
+		t.Fatal("there should be an event store error:", err)
+	}
+	assert.True(t, errors.Is(esErr.Err, ErrEventTooLarge))
+	var tooLarge EventTooLargeError
+	if assert.True(t, errors.As(esErr.Err, &tooLarge)) {
+		assert.Equal(t, id, tooLarge.AggregateID)
+		assert.Equal(t, 2, tooLarge.Version)
+		assert.Greater(t, tooLarge.Size, maxItemSize)
+		assert.Contains(t, tooLarge.Error(), "WithS3Overflow")
+	}
+}
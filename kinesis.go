@@ -0,0 +1,122 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go/service/kinesis/kinesisiface"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// ErrCouldNotPublishEvents is when saved events could not be published to
+// the Kinesis stream.
+var ErrCouldNotPublishEvents = errors.New("could not publish events")
+
+// kinesisPublisher is the config of the Kinesis publisher.
+type kinesisPublisher struct {
+	client     kinesisiface.KinesisAPI
+	streamName string
+}
+
+// KinesisEvent is the JSON record of a saved event in the Kinesis stream.
+type KinesisEvent struct {
+	Namespace     string                 `json:"namespace"`
+	AggregateID   uuid.UUID              `json:"aggregateId"`
+	AggregateType eh.AggregateType       `json:"aggregateType"`
+	EventType     eh.EventType           `json:"eventType"`
+	Version       int                    `json:"version"`
+	Timestamp     time.Time              `json:"timestamp"`
+	Data          eh.EventData           `json:"data,omitempty"`
+	Metadata      map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// WithKinesisPublisher publishes the events to a Kinesis Data Stream after
+// every successful save, as KinesisEvent JSON records with the aggregate ID
+// as partition key, in version order per aggregate. The events are already
+// saved when publishing fails, which is reported as ErrCouldNotPublishEvents.
+func WithKinesisPublisher(streamName string) Option {
+	return func(s *EventStore) error {
+		if s.kinesis == nil {
+			s.kinesis = &kinesisPublisher{}
+		}
+		s.kinesis.streamName = streamName
+		return nil
+	}
+}
+
+// WithKinesisClient uses a custom Kinesis client for the Kinesis publisher.
+// Without it a client is created from the AWS session of the store.
+func WithKinesisClient(client kinesisiface.KinesisAPI) Option {
+	return func(s *EventStore) error {
+		if s.kinesis == nil {
+			s.kinesis = &kinesisPublisher{}
+		}
+		s.kinesis.client = client
+		return nil
+	}
+}
+
+// publishEvents publishes saved events to the Kinesis stream, one record at
+// a time to keep their order within the shard of the aggregate.
+func (s *EventStore) publishEvents(ctx context.Context, events []eh.Event) error {
+	if s.kinesis == nil || s.kinesis.streamName == "" {
+		return nil
+	}
+
+	var sequence *string
+	for _, event := range events {
+		data, err := json.Marshal(KinesisEvent{
+			Namespace:     eh.NamespaceFromContext(ctx),
+			AggregateID:   event.AggregateID(),
+			AggregateType: event.AggregateType(),
+			EventType:     event.EventType(),
+			Version:       event.Version(),
+			Timestamp:     event.Timestamp(),
+			Data:          event.Data(),
+			Metadata:      event.Metadata(),
+		})
+		if err != nil {
+			return eh.EventStoreError{
+				BaseErr:   err,
+				Err:       wrapError(ErrCouldNotPublishEvents, err),
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+
+		out, err := s.kinesis.client.PutRecordWithContext(ctx, &kinesis.PutRecordInput{
+			StreamName:                aws.String(s.kinesis.streamName),
+			PartitionKey:              aws.String(event.AggregateID().String()),
+			Data:                      data,
+			SequenceNumberForOrdering: sequence,
+		})
+		if err != nil {
+			return eh.EventStoreError{
+				BaseErr:   withRequestID(err),
+				Err:       wrapError(ErrCouldNotPublishEvents, err),
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+		sequence = out.SequenceNumber
+	}
+
+	return nil
+}
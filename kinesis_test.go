@@ -0,0 +1,85 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go/service/kinesis/kinesisiface"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryKinesis is a Kinesis client that records the put records.
+type memoryKinesis struct {
+	kinesisiface.KinesisAPI
+	records []*kinesis.PutRecordInput
+}
+
+func (k *memoryKinesis) PutRecordWithContext(ctx aws.Context, input *kinesis.PutRecordInput, opts ...request.Option) (*kinesis.PutRecordOutput, error) {
+	k.records = append(k.records, input)
+	return &kinesis.PutRecordOutput{
+		SequenceNumber: aws.String(strconv.Itoa(len(k.records))),
+	}, nil
+}
+
+func TestPublishEvents(t *testing.T) {
+	client := &memoryKinesis{}
+	s := &EventStore{kinesis: &kinesisPublisher{client: client, streamName: "events"}}
+
+	id := uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	ctx := eh.NewContextWithNamespace(context.Background(), "ns")
+	assert.Nil(t, s.publishEvents(ctx, []eh.Event{
+		eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event1"},
+			timestamp, mocks.AggregateType, id, 1),
+		eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event2"},
+			timestamp, mocks.AggregateType, id, 2),
+	}))
+
+	if assert.Len(t, client.records, 2) {
+		for i, record := range client.records {
+			assert.Equal(t, "events", aws.StringValue(record.StreamName))
+			assert.Equal(t, id.String(), aws.StringValue(record.PartitionKey))
+
+			var e struct {
+				KinesisEvent
+				Data mocks.EventData `json:"data"`
+			}
+			assert.Nil(t, json.Unmarshal(record.Data, &e))
+			assert.Equal(t, "ns", e.Namespace)
+			assert.Equal(t, id, e.AggregateID)
+			assert.Equal(t, mocks.EventType, e.EventType)
+			assert.Equal(t, i+1, e.Version)
+			assert.Equal(t, "event"+strconv.Itoa(i+1), e.Data.Content)
+		}
+		assert.Nil(t, client.records[0].SequenceNumberForOrdering)
+		assert.Equal(t, "1", aws.StringValue(client.records[1].SequenceNumberForOrdering))
+	}
+
+	// Without a stream nothing is published.
+	s.kinesis.streamName = ""
+	assert.Nil(t, s.publishEvents(ctx, []eh.Event{nil}))
+	assert.Len(t, client.records, 2)
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package lambdarecord decodes the records of a Lambda trigger on the event
+// table back into events, to write Lambda projectors without depending on the
+// storage format of the event store.
+//
+// The records are decoded from the JSON of the Lambda event, which is what a
+// handler receives as a json.RawMessage. With the types of aws-lambda-go, an
+// events.DynamoDBEventRecord can be marshaled back to JSON first:
+//
+//	b, _ := json.Marshal(record)
+//	var r lambdarecord.Record
+//	if err := json.Unmarshal(b, &r); err != nil {
+//		return err
+//	}
+//	event, err := lambdarecord.DecodeRecord(ctx, store, r)
+package lambdarecord
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	eh "github.com/looplab/eventhorizon"
+	ehdynamodb "github.com/sysbot/eh-dynamodb"
+)
+
+// insertEventName is the event name of records of new items.
+const insertEventName = "INSERT"
+
+// Event is the Lambda event of a DynamoDB stream trigger.
+type Event struct {
+	Records []Record `json:"Records"`
+}
+
+// Record is a record of a DynamoDB stream trigger, with the fields that are
+// needed to decode events.
+type Record struct {
+	EventID   string       `json:"eventID"`
+	EventName string       `json:"eventName"`
+	Change    StreamRecord `json:"dynamodb"`
+}
+
+// StreamRecord is the item change of a record. The attribute values have the
+// same JSON format in Lambda events as in the AWS SDK.
+type StreamRecord struct {
+	SequenceNumber string                              `json:"SequenceNumber"`
+	NewImage       map[string]*dynamodb.AttributeValue `json:"NewImage"`
+}
+
+// Option is an option setter used to configure decoding.
+type Option func(*options)
+
+// options are the options of decoding.
+type options struct {
+	filter *ehdynamodb.EventFilter
+}
+
+// WithFilter skips the records of events that don't match a filter before
+// they are decoded, so that the event data of skipped events is not fetched,
+// decrypted or upcasted.
+func WithFilter(f ehdynamodb.EventFilter) Option {
+	return func(o *options) {
+		o.filter = &f
+	}
+}
+
+// Decode decodes the events of the JSON of a Lambda event, in order. Records
+// that are not saved events, or that are skipped by the filter, are skipped.
+func Decode(ctx context.Context, store *ehdynamodb.EventStore, payload []byte, opts ...Option) ([]eh.Event, error) {
+	var e Event
+	if err := json.Unmarshal(payload, &e); err != nil {
+		return nil, eh.EventStoreError{
+			BaseErr:   err,
+			Err:       ehdynamodb.ErrCouldNotUnmarshalEvent,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	var events []eh.Event
+	for _, r := range e.Records {
+		event, err := DecodeRecord(ctx, store, r, opts...)
+		if err != nil {
+			return nil, err
+		}
+		if event != nil {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+// DecodeRecord decodes the event of a record, using the registered event data
+// factories and the codec, compression and encryption of the store. It
+// returns nil if the record is not a saved event, such as a modified head
+// item of an aggregate or a removed item, or if it is skipped by the filter.
+func DecodeRecord(ctx context.Context, store *ehdynamodb.EventStore, r Record, opts ...Option) (eh.Event, error) {
+	if r.EventName != insertEventName || r.Change.NewImage == nil {
+		return nil, nil
+	}
+
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.filter != nil {
+		ok, err := store.MatchImage(ctx, *o.filter, r.Change.NewImage)
+		if err != nil || !ok {
+			return nil, err
+		}
+	}
+
+	return store.EventFromImage(ctx, r.Change.NewImage)
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambdarecord
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+	ehdynamodb "github.com/sysbot/eh-dynamodb"
+)
+
+func TestDecode(t *testing.T) {
+	sess, err := session.NewSession(&aws.Config{Region: aws.String("us-west-2")})
+	assert.Nil(t, err)
+	store, err := ehdynamodb.NewEventStore("test", ehdynamodb.WithDynamoDB(sess))
+	assert.Nil(t, err)
+
+	id := uuid.New()
+	payload := fmt.Sprintf(`{"Records": [
+		{"eventID": "1", "eventName": "MODIFY", "dynamodb": {"NewImage": {
+			"AggregateID": {"S": "%[1]s"}, "Version": {"N": "-1"}, "CurrentVersion": {"N": "1"}
+		}}},
+		{"eventID": "2", "eventName": "INSERT", "dynamodb": {"SequenceNumber": "100", "NewImage": {
+			"AggregateID": {"S": "%[1]s"},
+			"Version": {"N": "1"},
+			"EventType": {"S": "%[2]s"},
+			"AggregateType": {"S": "%[3]s"},
+			"Timestamp": {"S": "2009-11-10T23:00:00Z"},
+			"RawData": {"M": {"Content": {"S": "event1"}}},
+			"Metadata": {"NULL": true}
+		}}}
+	]}`, id, mocks.EventType, mocks.AggregateType)
+
+	events, err := Decode(context.Background(), store, []byte(payload))
+	assert.Nil(t, err)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, mocks.EventType, events[0].EventType())
+		assert.Equal(t, id, events[0].AggregateID())
+		assert.Equal(t, 1, events[0].Version())
+		assert.Equal(t, &mocks.EventData{Content: "event1"}, events[0].Data())
+	}
+
+	events, err = Decode(context.Background(), store, []byte(payload),
+		WithFilter(ehdynamodb.EventFilter{ExcludedEventTypes: []eh.EventType{mocks.EventType}}))
+	assert.Nil(t, err)
+	assert.Empty(t, events)
+
+	_, err = Decode(context.Background(), store, []byte("{"))
+	var storeErr eh.EventStoreError
+	if assert.ErrorAs(t, err, &storeErr) {
+		assert.Equal(t, ehdynamodb.ErrCouldNotUnmarshalEvent, storeErr.Err)
+	}
+}
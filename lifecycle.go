@@ -0,0 +1,142 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// ErrClosed is when an event store or repo is used after Close.
+var ErrClosed = errors.New("closed")
+
+// lifecycle keeps the cleanups of an event store or repo, like stopping its
+// background goroutines, and whether it is closed.
+type lifecycle struct {
+	mu      sync.Mutex
+	closed  bool
+	closers []func(context.Context) error
+	wg      sync.WaitGroup
+}
+
+// onClose registers a cleanup to run on Close of the event store.
+func (s *EventStore) onClose(fn func(context.Context) error) {
+	if s.lifecycle == nil {
+		s.lifecycle = &lifecycle{}
+	}
+	s.lifecycle.onClose(fn)
+}
+
+// onClose registers a cleanup to run on Close.
+func (l *lifecycle) onClose(fn func(context.Context) error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.closers = append(l.closers, fn)
+}
+
+// goroutine runs fn in a background goroutine that Close waits for.
+func (l *lifecycle) goroutine(fn func()) {
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		fn()
+	}()
+}
+
+// isClosed returns true after Close.
+func (l *lifecycle) isClosed() bool {
+	if l == nil {
+		return false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.closed
+}
+
+// close runs the cleanups in reverse order of registration and waits for the
+// background goroutines to stop, or for the context to be done. It returns
+// the first error of the cleanups.
+func (l *lifecycle) close(ctx context.Context) error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	closers := l.closers
+	l.closers = nil
+	l.mu.Unlock()
+
+	var err error
+	for i := len(closers) - 1; i >= 0; i-- {
+		if cerr := closers[i](ctx); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		l.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		if err == nil {
+			err = ctx.Err()
+		}
+	}
+	return err
+}
+
+// Close stops the background work of the event store, like forwarding,
+// relaying the outbox and polling streams, and waits for it to finish or for
+// the context to be done. Any later use of the store, like Save and Load,
+// returns ErrClosed in an eventhorizon.EventStoreError. Closing a closed
+// store does nothing.
+func (s *EventStore) Close(ctx context.Context) error {
+	if s.lifecycle == nil {
+		s.lifecycle = &lifecycle{}
+	}
+	if err := s.lifecycle.close(ctx); err != nil {
+		return eh.EventStoreError{
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+	return nil
+}
+
+// Close releases the resources of the repo. Any later use of the repo, like
+// Save and Find, returns ErrClosed in an eventhorizon.RepoError. Closing a
+// closed repo does nothing.
+func (r *Repo) Close(ctx context.Context) error {
+	if r.lifecycle == nil {
+		r.lifecycle = &lifecycle{}
+	}
+	if err := r.lifecycle.close(ctx); err != nil {
+		return eh.RepoError{
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClose(t *testing.T) {
+	dir, err := os.MkdirTemp("", "forward")
+	if err != nil {
+		t.Fatal("could not create dir:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	sess := session.Must(session.NewSession(&aws.Config{Region: aws.String("us-west-2")}))
+	s, err := NewEventStore("events", WithDynamoDB(sess), WithStoreAndForward(dir, time.Hour, nil))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	ctx := eh.NewContextWithNamespace(context.Background(), "ns")
+	assert.Nil(t, s.Close(ctx))
+	select {
+	case <-s.forward.done:
+	default:
+		t.Error("the forwarding should be stopped")
+	}
+	assert.Nil(t, s.Close(ctx))
+
+	_, err = s.Load(ctx, uuid.New())
+	if esErr, ok := err.(eh.EventStoreError); !ok || esErr.Err != ErrClosed {
+		t.Error("there should be a closed error:", err)
+	}
+	err = s.Save(ctx, nil, 0)
+	if esErr, ok := err.(eh.EventStoreError); !ok || esErr.Err != ErrClosed {
+		t.Error("there should be a closed error:", err)
+	}
+
+	r, err := NewRepo("models", WithRepoDynamoDB(sess))
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Nil(t, r.Close(ctx))
+	_, err = r.Find(ctx, uuid.New())
+	if rErr, ok := err.(eh.RepoError); !ok || rErr.Err != ErrClosed {
+		t.Error("there should be a closed error:", err)
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// LimitError is returned when a load or find reads more items than its
+// configured limit. Callers should use the paged APIs instead, LoadPage and
+// FindAllPage, which take the cursor of the error to continue after the
+// first Count items.
+type LimitError struct {
+	// Limit is the configured limit.
+	Limit int
+	// Count is the number of items read before the limit was exceeded.
+	Count int
+	// Cursor is the cursor of the page after the first Count items.
+	Cursor string
+	// Namespace is the namespace of the operation.
+	Namespace string
+}
+
+// Error implements the Error method of the errors.Error interface.
+func (e LimitError) Error() string {
+	return fmt.Sprintf("limit of %d items exceeded, use a paged API (%s)", e.Limit, e.Namespace)
+}
+
+// WithLoadLimit limits the number of events that Load and LoadFrom read for
+// one aggregate. If an aggregate has more events a LimitError is returned
+// instead of reading the whole stream into memory.
+func WithLoadLimit(maxEvents int) Option {
+	return func(s *EventStore) error {
+		s.loadLimit = maxEvents
+		return nil
+	}
+}
+
+// WithRepoFindAllLimit limits the number of entities that FindAll reads. If
+// the table has more entities a LimitError is returned instead of reading the
+// whole table into memory.
+func WithRepoFindAllLimit(maxItems int) OptionRepo {
+	return func(r *Repo) error {
+		r.findAllLimit = maxItems
+		return nil
+	}
+}
+
+// LoadPage loads a page of at most limit events of an aggregate, starting
+// after the cursor, or at the first event for an empty cursor. It returns the
+// cursor of the next page, which is empty when there are no more events.
+func (s *EventStore) LoadPage(ctx context.Context, id uuid.UUID, cursor string, limit int) ([]eh.Event, string, error) {
+	ctx, err := s.namespace(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	version, err := s.eventsCursorVersion(ctx, id, cursor)
+	if err != nil {
+		return nil, "", eh.EventStoreError{
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	dbEvents, err := s.queryEvents(ctx, id, version, limit+1, true)
+	if err != nil {
+		return nil, "", err
+	}
+
+	next := ""
+	if len(dbEvents) > limit {
+		dbEvents = dbEvents[:limit]
+		if next, err = s.eventsCursor(ctx, dbEvents[limit-1]); err != nil {
+			return nil, "", eh.EventStoreError{
+				Err:       err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+	}
+
+	events, err := s.buildEvents(ctx, dbEvents)
+	if err != nil {
+		return nil, "", err
+	}
+	return events, next, nil
+}
+
+// FindAllPage finds a page of at most limit entities, starting after the
+// cursor, or at the start of the table for an empty cursor. It returns the
+// cursor of the next page, which is empty when there are no more entities.
+func (r *Repo) FindAllPage(ctx context.Context, cursor string, limit int) ([]eh.Entity, string, error) {
+	ctx, err := r.namespace(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if r.factoryFn == nil {
+		return nil, "", eh.RepoError{
+			Err:       ErrModelNotSet,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	key, err := decodeCursor(ctx, r.cursors, cursorKindEntities, cursor)
+	if err != nil {
+		return nil, "", eh.RepoError{
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	tableName := r.tableName(ctx)
+	scan := r.readService().Table(tableName).Scan().
+		Consistent(consistentRead(ctx, r.readConsistency)).
+		Limit(int64(limit + 1)).
+		StartFrom(key)
+
+	result := []eh.Entity{}
+	start := time.Now()
+	iter := scan.Iter()
+	entity := r.factoryFn()
+	for iter.NextWithContext(ctx, entity) {
+		result = append(result, entity)
+		entity = r.factoryFn()
+	}
+	err = iter.Err()
+	observe(ctx, r.metrics, OperationScan, tableName, start, err)
+	if err != nil {
+		return nil, "", eh.RepoError{
+			Err:       err,
+			BaseErr:   withRequestID(err),
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	next := ""
+	if len(result) > limit {
+		result = result[:limit]
+		if next, err = r.entitiesCursor(ctx, result[limit-1]); err != nil {
+			return nil, "", eh.RepoError{
+				Err:       err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+	}
+	return result, next, nil
+}
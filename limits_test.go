@@ -0,0 +1,95 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/sysbot/eh-dynamodb/cursor"
+)
+
+// TestLoadLimit will make sure that long streams must be paged
+func (suite *EventStoreTestSuite) TestLoadLimit() {
+	limited := suite.newStore(WithLoadLimit(2), WithCursorKey([]byte("test")))
+
+	id := uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	var expectedEvents []eh.Event
+	for i := 1; i <= 3; i++ {
+		expectedEvents = append(expectedEvents, eh.NewEventForAggregate(mocks.EventType,
+			&mocks.EventData{Content: fmt.Sprintf("event%d", i)}, timestamp, mocks.AggregateType, id, i))
+	}
+	assert.Nil(suite.T(), suite.store.Save(suite.ctx, expectedEvents, 0))
+
+	_, err := limited.Load(suite.ctx, id)
+	var limitErr LimitError
+	if assert.True(suite.T(), errors.As(err, &limitErr)) {
+		assert.Equal(suite.T(), 2, limitErr.Count)
+		assert.NotEmpty(suite.T(), limitErr.Cursor)
+	}
+
+	events, token, err := limited.LoadPage(suite.ctx, id, "", 2)
+	assert.Nil(suite.T(), err)
+	assert.Len(suite.T(), events, 2)
+	assert.Equal(suite.T(), limitErr.Cursor, token)
+
+	events, token, err = limited.LoadPage(suite.ctx, id, token, 2)
+	assert.Nil(suite.T(), err)
+	if assert.Len(suite.T(), events, 1) {
+		assert.Equal(suite.T(), expectedEvents[2].Data(), events[0].Data())
+	}
+	assert.Equal(suite.T(), "", token)
+
+	// Cursors of other aggregates or of other stores are refused.
+	_, _, err = limited.LoadPage(suite.ctx, uuid.New(), limitErr.Cursor, 2)
+	if esErr, ok := err.(eh.EventStoreError); !ok || !errors.Is(esErr.Err, cursor.ErrInvalidCursor) {
+		suite.T().Error("there should be an invalid cursor error:", err)
+	}
+	other := suite.newStore(WithCursorKey([]byte("other")))
+	_, _, err = other.LoadPage(suite.ctx, id, limitErr.Cursor, 2)
+	if esErr, ok := err.(eh.EventStoreError); !ok || !errors.Is(esErr.Err, cursor.ErrInvalidCursor) {
+		suite.T().Error("there should be an invalid cursor error:", err)
+	}
+
+	// All events are loaded page by page.
+	var all []eh.Event
+	next := ""
+	for {
+		var page []eh.Event
+		page, next, err = suite.store.LoadAllPage(suite.ctx, next, 2)
+		if !assert.Nil(suite.T(), err) {
+			break
+		}
+		assert.True(suite.T(), len(page) <= 2)
+		all = append(all, page...)
+		if next == "" {
+			break
+		}
+	}
+	loaded, err := suite.store.LoadAll(suite.ctx)
+	assert.Nil(suite.T(), err)
+	assert.Len(suite.T(), all, len(loaded))
+
+	// A stream within the limit is loaded.
+	events, err = limited.LoadFrom(suite.ctx, id, 2)
+	assert.Nil(suite.T(), err)
+	assert.Len(suite.T(), events, 2)
+}
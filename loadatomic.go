@@ -0,0 +1,161 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/google/uuid"
+	"github.com/guregu/dynamo"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// ErrTooManyAggregates is when more aggregates are loaded at once than fit in
+// a transaction.
+var ErrTooManyAggregates = errors.New("too many aggregates to load in one transaction")
+
+// LoadAtomic loads the events of several aggregates as of a single point in
+// time, for sagas that need a consistent view of several aggregates without
+// read skew. The current versions of all aggregates are read in one
+// TransactGetItems, and only the events up to those versions are loaded, so
+// events that are saved meanwhile are left out. At most 100 aggregates can be
+// loaded at once, and as their whole streams are loaded it is meant for
+// aggregates with small streams. Aggregates without events have an empty
+// stream in the result.
+//
+// Streams written before the version counter was added have no head item.
+// Their latest versions are read after the transaction instead, so these
+// streams are not part of the single point in time.
+func (s *EventStore) LoadAtomic(ctx context.Context, ids ...uuid.UUID) (map[uuid.UUID][]eh.Event, error) {
+	ctx, err := s.namespace(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// A transaction can not read the same item twice.
+	unique := make([]uuid.UUID, 0, len(ids))
+	seen := make(map[uuid.UUID]bool, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	if len(unique) > maxTransactItems {
+		return nil, eh.EventStoreError{
+			Err:       ErrTooManyAggregates,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	versions, err := s.currentVersions(ctx, unique)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range unique {
+		if _, ok := versions[id]; ok {
+			continue
+		}
+		if versions[id], err = s.AggregateVersion(ctx, id); err != nil {
+			return nil, err
+		}
+	}
+
+	events := make(map[uuid.UUID][]eh.Event, len(unique))
+	for _, id := range unique {
+		version := versions[id]
+		if version == 0 {
+			events[id] = []eh.Event{}
+			continue
+		}
+
+		dbEvents, err := s.queryEvents(ctx, id, 1, 0, false)
+		if err != nil {
+			return nil, err
+		}
+		n := 0
+		for n < len(dbEvents) && dbEvents[n].Version <= version {
+			n++
+		}
+
+		// The event at the version that was read is kept by the load filter.
+		stream := dbEvents[:n]
+		if n > 0 {
+			last := dbEvents[n-1]
+			stream = append(s.filterLoaded(dbEvents[:n-1]), last)
+		}
+		if events[id], err = s.buildEvents(ctx, stream); err != nil {
+			return nil, err
+		}
+	}
+	return events, nil
+}
+
+// currentVersions reads the versions of aggregates from their head items in
+// one transaction. Aggregates without a head item are left out.
+func (s *EventStore) currentVersions(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]int, error) {
+	versions := make(map[uuid.UUID]int, len(ids))
+	if len(ids) == 0 {
+		return versions, nil
+	}
+
+	tableName := s.tableName(ctx)
+	input := &dynamodb.TransactGetItemsInput{
+		TransactItems: make([]*dynamodb.TransactGetItem, len(ids)),
+	}
+	for i, id := range ids {
+		input.TransactItems[i] = &dynamodb.TransactGetItem{
+			Get: &dynamodb.Get{
+				TableName:            aws.String(tableName),
+				Key:                  s.itemKey(ctx, id, aggregateHeadVersion),
+				ProjectionExpression: aws.String("CurrentVersion"),
+			},
+		}
+	}
+
+	start := time.Now()
+	out, err := s.service.Client().TransactGetItemsWithContext(ctx, input)
+	observe(ctx, s.metrics, OperationTransactGetItems, tableName, start, err)
+	if isAWSErrorCode(err, dynamodb.ErrCodeResourceNotFoundException) {
+		return versions, nil
+	} else if err != nil {
+		return nil, eh.EventStoreError{
+			BaseErr:   withRequestID(err),
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	// The responses are in the order of the reads.
+	for i, r := range out.Responses {
+		if r == nil || len(r.Item) == 0 {
+			continue
+		}
+		var head dbAggregateHead
+		if err := dynamo.UnmarshalItem(r.Item, &head); err != nil {
+			return nil, eh.EventStoreError{
+				BaseErr:   err,
+				Err:       wrapError(ErrCouldNotUnmarshalEvent, err),
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+		versions[ids[i]] = head.CurrentVersion
+	}
+	return versions, nil
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestLoadAtomic will load several aggregates as of their current versions
+func (suite *EventStoreTestSuite) TestLoadAtomic() {
+	id1, id2 := uuid.New(), uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	assert.Nil(suite.T(), suite.store.Save(suite.ctx, []eh.Event{
+		eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event1"}, timestamp, mocks.AggregateType, id1, 1),
+		eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event2"}, timestamp, mocks.AggregateType, id1, 2),
+	}, 0))
+	assert.Nil(suite.T(), suite.store.Save(suite.ctx, []eh.Event{
+		eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event1"}, timestamp, mocks.AggregateType, id2, 1),
+	}, 0))
+
+	// An event that is written but not yet counted in the head item, as
+	// in a save in progress, is left out.
+	e, err := suite.store.newDBEvent(suite.ctx, eh.NewEventForAggregate(mocks.EventType,
+		&mocks.EventData{Content: "event3"}, timestamp, mocks.AggregateType, id1, 3))
+	assert.Nil(suite.T(), err)
+	assert.Nil(suite.T(), suite.store.service.Table(suite.store.tableName(suite.ctx)).Put(e).Run())
+
+	// A stream written before the version counter has no head item.
+	legacy := uuid.New()
+	e, err = suite.store.newDBEvent(suite.ctx, eh.NewEventForAggregate(mocks.EventType,
+		&mocks.EventData{Content: "event1"}, timestamp, mocks.AggregateType, legacy, 1))
+	assert.Nil(suite.T(), err)
+	assert.Nil(suite.T(), suite.store.service.Table(suite.store.tableName(suite.ctx)).Put(e).Run())
+
+	missing := uuid.New()
+	streams, err := suite.store.LoadAtomic(suite.ctx, id1, id2, legacy, missing, id1)
+	assert.Nil(suite.T(), err)
+	assert.Len(suite.T(), streams, 4)
+	if assert.Len(suite.T(), streams[id1], 2) {
+		assert.Equal(suite.T(), &mocks.EventData{Content: "event2"}, streams[id1][1].Data())
+	}
+	assert.Len(suite.T(), streams[id2], 1)
+	assert.Len(suite.T(), streams[legacy], 1)
+	assert.Len(suite.T(), streams[missing], 0)
+
+	ids := make([]uuid.UUID, maxTransactItems+1)
+	for i := range ids {
+		ids[i] = uuid.New()
+	}
+	_, err = suite.store.LoadAtomic(suite.ctx, ids...)
+	if esErr, ok := err.(eh.EventStoreError); !ok || esErr.Err != ErrTooManyAggregates {
+		suite.T().Error("there should be a too many aggregates error:", err)
+	}
+}
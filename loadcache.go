@@ -0,0 +1,216 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/guregu/dynamo"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// WithLoadCache keeps the event streams of the last maxAggregates loaded
+// aggregates in memory. Load then only reads the version counter of the
+// aggregate, and the events saved since it was cached if the version has
+// changed, instead of the full stream. Saves through the store update the
+// cache directly, Replace and RenameEvent invalidate it.
+//
+// Streams without a version counter, written by older versions of the store,
+// and streams with expired events are not cached. The event data of cached
+// events is shared between loads and must not be modified.
+func WithLoadCache(maxAggregates int) Option {
+	return func(s *EventStore) error {
+		s.cache = newLoadCache(maxAggregates)
+		return nil
+	}
+}
+
+// loadCache is an LRU cache of aggregate event streams.
+type loadCache struct {
+	mu      sync.Mutex
+	max     int
+	streams *list.List
+	keys    map[string]*list.Element
+}
+
+// cachedStream is the cached event stream of an aggregate.
+type cachedStream struct {
+	key     string
+	events  []eh.Event
+	version int
+}
+
+// newLoadCache creates a cache for max aggregates.
+func newLoadCache(max int) *loadCache {
+	return &loadCache{
+		max:     max,
+		streams: list.New(),
+		keys:    map[string]*list.Element{},
+	}
+}
+
+// loadCacheKey returns the cache key of an aggregate in the namespace of the
+// context.
+func loadCacheKey(ctx context.Context, id uuid.UUID) string {
+	return eh.NamespaceFromContext(ctx) + "/" + id.String()
+}
+
+// get returns a copy of the cached events of an aggregate and their version.
+func (c *loadCache) get(key string) ([]eh.Event, int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.keys[key]
+	if !ok {
+		return nil, 0, false
+	}
+	c.streams.MoveToFront(elem)
+	stream := elem.Value.(*cachedStream)
+	return append([]eh.Event(nil), stream.events...), stream.version, true
+}
+
+// put caches the events of an aggregate, evicting the least recently used
+// aggregate when the cache is full.
+func (c *loadCache) put(key string, events []eh.Event, version int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stream := &cachedStream{
+		key:     key,
+		events:  append([]eh.Event(nil), events...),
+		version: version,
+	}
+	if elem, ok := c.keys[key]; ok {
+		elem.Value = stream
+		c.streams.MoveToFront(elem)
+		return
+	}
+
+	c.keys[key] = c.streams.PushFront(stream)
+	for c.streams.Len() > c.max {
+		oldest := c.streams.Back()
+		c.streams.Remove(oldest)
+		delete(c.keys, oldest.Value.(*cachedStream).key)
+	}
+}
+
+// saved appends saved events to the cached stream of an aggregate if it was
+// cached at the original version, or else removes it. The version is the
+// version of the aggregate after the save.
+func (c *loadCache) saved(key string, originalVersion, version int, events []eh.Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.keys[key]
+	if !ok {
+		return
+	}
+	stream := elem.Value.(*cachedStream)
+	if stream.version != originalVersion {
+		c.streams.Remove(elem)
+		delete(c.keys, key)
+		return
+	}
+
+	elem.Value = &cachedStream{
+		key:     key,
+		events:  append(append([]eh.Event(nil), stream.events...), events...),
+		version: version,
+	}
+}
+
+// remove removes the cached stream of an aggregate.
+func (c *loadCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.keys[key]; ok {
+		c.streams.Remove(elem)
+		delete(c.keys, key)
+	}
+}
+
+// clear removes all cached streams.
+func (c *loadCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.streams.Init()
+	c.keys = map[string]*list.Element{}
+}
+
+// loadCached loads the events of an aggregate through the cache.
+func (s *EventStore) loadCached(ctx context.Context, id uuid.UUID) ([]eh.Event, error) {
+	key := loadCacheKey(ctx, id)
+
+	current, ok, err := s.currentVersion(ctx, id)
+	if err != nil {
+		return nil, err
+	} else if !ok {
+		s.cache.remove(key)
+		return s.LoadFrom(ctx, id, 1)
+	}
+
+	events, version, ok := s.cache.get(key)
+	if ok && version == current {
+		return events, nil
+	} else if !ok || version > current {
+		events, version = nil, 0
+	}
+
+	// Only read the events saved since the stream was cached.
+	tail, err := s.LoadFrom(ctx, id, version+1)
+	if err != nil {
+		return nil, err
+	}
+	events = append(events, tail...)
+
+	if len(events) == current {
+		s.cache.put(key, events, current)
+	} else {
+		s.cache.remove(key)
+	}
+	return events, nil
+}
+
+// currentVersion returns the version of an aggregate from its head item, if
+// it has one.
+func (s *EventStore) currentVersion(ctx context.Context, id uuid.UUID) (int, bool, error) {
+	tableName := s.tableName(ctx)
+
+	var head dbAggregateHead
+	start := time.Now()
+	err := s.service.Table(tableName).
+		Get(s.hashKey(), s.hashValue(ctx, id)).
+		Range("Version", dynamo.Equal, aggregateHeadVersion).
+		Consistent(true).
+		OneWithContext(ctx, &head)
+	observe(ctx, s.metrics, OperationGetItem, tableName, start, err)
+	if err == dynamo.ErrNotFound {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, eh.EventStoreError{
+			BaseErr:   withRequestID(err),
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	return head.CurrentVersion, true, nil
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadCache(t *testing.T) {
+	id := uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	event1 := eh.NewEventForAggregate(mocks.EventType, nil, timestamp, mocks.AggregateType, id, 1)
+	event2 := eh.NewEventForAggregate(mocks.EventType, nil, timestamp, mocks.AggregateType, id, 2)
+
+	c := newLoadCache(2)
+	c.put("a", []eh.Event{event1}, 1)
+	events, version, ok := c.get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, version)
+	assert.Equal(t, []eh.Event{event1}, events)
+
+	// Saves at the cached version are appended, others invalidate.
+	c.saved("a", 1, 2, []eh.Event{event2})
+	events, version, ok = c.get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 2, version)
+	assert.Equal(t, []eh.Event{event1, event2}, events)
+	c.saved("a", 1, 2, []eh.Event{event2})
+	_, _, ok = c.get("a")
+	assert.False(t, ok)
+
+	// The least recently used stream is evicted.
+	c.put("a", nil, 0)
+	c.put("b", nil, 0)
+	c.get("a")
+	c.put("c", nil, 0)
+	_, _, ok = c.get("b")
+	assert.False(t, ok)
+	_, _, ok = c.get("a")
+	assert.True(t, ok)
+	_, _, ok = c.get("c")
+	assert.True(t, ok)
+
+	c.remove("a")
+	_, _, ok = c.get("a")
+	assert.False(t, ok)
+	c.clear()
+	_, _, ok = c.get("c")
+	assert.False(t, ok)
+}
+
+// TestLoadCache will load cached streams and pick up saves by other writers
+func (suite *EventStoreTestSuite) TestLoadCache() {
+	cached := suite.newStore(WithLoadCache(10))
+
+	id := uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	event1 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event1"},
+		timestamp, mocks.AggregateType, id, 1)
+	event2 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event2"},
+		timestamp, mocks.AggregateType, id, 2)
+	event3 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event3"},
+		timestamp, mocks.AggregateType, id, 3)
+
+	assert.Nil(suite.T(), cached.Save(suite.ctx, []eh.Event{event1}, 0))
+	events, err := cached.Load(suite.ctx, id)
+	assert.Nil(suite.T(), err)
+	assert.Len(suite.T(), events, 1)
+
+	// Saves by the store update the cache.
+	assert.Nil(suite.T(), cached.Save(suite.ctx, []eh.Event{event2}, 1))
+	_, version, ok := cached.cache.get(loadCacheKey(suite.ctx, id))
+	assert.True(suite.T(), ok)
+	assert.Equal(suite.T(), 2, version)
+
+	// Saves by other writers are loaded by version.
+	assert.Nil(suite.T(), suite.store.Save(suite.ctx, []eh.Event{event3}, 2))
+	events, err = cached.Load(suite.ctx, id)
+	assert.Nil(suite.T(), err)
+	if assert.Len(suite.T(), events, 3) {
+		for i, event := range []eh.Event{event1, event2, event3} {
+			assert.Equal(suite.T(), event.Data(), events[i].Data())
+		}
+	}
+	_, version, _ = cached.cache.get(loadCacheKey(suite.ctx, id))
+	assert.Equal(suite.T(), 3, version)
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"github.com/guregu/dynamo"
+)
+
+// WithLoadFilter skips the events that don't match the types of a filter when
+// loading aggregates, for example soft-deleted or administrative event types
+// that don't change the state. The filter is applied by DynamoDB as a filter
+// expression, so the skipped events are not transferred, but they are still
+// read and count against the read capacity. Events from the archive, and the
+// events of LoadAtomic, are filtered after reading them. Only the aggregate
+// and event types of the filter are used.
+//
+// The filter applies to Load, LoadFrom, LoadPage, LoadMany, LoadAtomic and
+// LoadAt. The loaded streams can have gaps in their versions, but the latest
+// event of an aggregate is always loaded, even if it doesn't match, as the
+// aggregate store takes the version of an aggregate from its last event. This
+// costs one more read of the latest event per load. Filtered streams are not
+// kept in the load cache. The events relayed to the event handler by the
+// outbox are not filtered.
+func WithLoadFilter(f EventFilter) Option {
+	return func(s *EventStore) error {
+		s.loadFilter = &f
+		return nil
+	}
+}
+
+// filterLoad adds the load filter to a query of events, if any.
+func (s *EventStore) filterLoad(query *dynamo.Query) *dynamo.Query {
+	if s.loadFilter == nil {
+		return query
+	}
+	if expr, args := s.loadFilter.typesExpression(s.typeNames); expr != "" {
+		query = query.Filter(expr, args...)
+	}
+	return query
+}
+
+// filterLoaded skips the events that don't match the load filter, if any.
+func (s *EventStore) filterLoaded(dbEvents []dbEvent) []dbEvent {
+	if s.loadFilter == nil {
+		return dbEvents
+	}
+
+	filtered := dbEvents[:0]
+	for _, e := range dbEvents {
+		if s.loadFilter.matchTypes(s.typeNames, e) {
+			filtered = append(filtered, e)
+		}
+	}
+	return filtered
+}
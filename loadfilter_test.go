@@ -0,0 +1,114 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/aggregatestore/events"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+// filterAggregateType is the type of filterAggregate.
+const filterAggregateType eh.AggregateType = "FilterAggregate"
+
+func init() {
+	eh.RegisterAggregate(func(id uuid.UUID) eh.Aggregate {
+		return &filterAggregate{AggregateBase: events.NewAggregateBase(filterAggregateType, id)}
+	})
+}
+
+// filterAggregate is an aggregate that counts the events applied to it.
+type filterAggregate struct {
+	*events.AggregateBase
+	applied int
+}
+
+// HandleCommand implements the HandleCommand method of the eh.Aggregate interface.
+func (a *filterAggregate) HandleCommand(ctx context.Context, cmd eh.Command) error {
+	return nil
+}
+
+// ApplyEvent implements the ApplyEvent method of the events.VersionedAggregate interface.
+func (a *filterAggregate) ApplyEvent(ctx context.Context, event eh.Event) error {
+	a.applied++
+	return nil
+}
+
+// TestLoadFilter will skip the filtered event types when loading
+func (suite *EventStoreTestSuite) TestLoadFilter() {
+	id := uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	event1 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event1"}, timestamp, mocks.AggregateType, id, 1)
+	event2 := eh.NewEventForAggregate(mocks.EventOtherType, &mocks.EventData{Content: "event2"}, timestamp, mocks.AggregateType, id, 2)
+	event3 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event3"}, timestamp, mocks.AggregateType, id, 3)
+	assert.Nil(suite.T(), suite.store.Save(suite.ctx, []eh.Event{event1, event2, event3}, 0))
+
+	store := suite.newStore(WithLoadFilter(EventFilter{ExcludedEventTypes: []eh.EventType{mocks.EventOtherType}}))
+	events, err := store.Load(suite.ctx, id)
+	assert.Nil(suite.T(), err)
+	if assert.Len(suite.T(), events, 2) {
+		assert.Equal(suite.T(), 1, events[0].Version())
+		assert.Equal(suite.T(), 3, events[1].Version())
+	}
+
+	// The version of the aggregate is not affected.
+	version, err := store.AggregateVersion(suite.ctx, id)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 3, version)
+	event4 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event4"}, timestamp, mocks.AggregateType, id, 4)
+	assert.Nil(suite.T(), store.Save(suite.ctx, []eh.Event{event4}, 3))
+}
+
+// TestLoadFilterLatestEvent will keep the latest event when it is filtered,
+// so that aggregates are rehydrated at their current version
+func (suite *EventStoreTestSuite) TestLoadFilterLatestEvent() {
+	id := uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	event1 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event1"}, timestamp, filterAggregateType, id, 1)
+	event2 := eh.NewEventForAggregate(mocks.EventOtherType, &mocks.EventData{Content: "event2"}, timestamp, filterAggregateType, id, 2)
+	event3 := eh.NewEventForAggregate(mocks.EventOtherType, &mocks.EventData{Content: "event3"}, timestamp, filterAggregateType, id, 3)
+	assert.Nil(suite.T(), suite.store.Save(suite.ctx, []eh.Event{event1, event2, event3}, 0))
+
+	store := suite.newStore(WithLoadFilter(EventFilter{ExcludedEventTypes: []eh.EventType{mocks.EventOtherType}}))
+	loaded, err := store.Load(suite.ctx, id)
+	assert.Nil(suite.T(), err)
+	if assert.Len(suite.T(), loaded, 2) {
+		assert.Equal(suite.T(), 1, loaded[0].Version())
+		assert.Equal(suite.T(), 3, loaded[1].Version())
+	}
+
+	aggregateStore, err := events.NewAggregateStore(store)
+	assert.Nil(suite.T(), err)
+	agg, err := aggregateStore.Load(suite.ctx, filterAggregateType, id)
+	assert.Nil(suite.T(), err)
+	a, ok := agg.(*filterAggregate)
+	if !assert.True(suite.T(), ok) {
+		return
+	}
+	assert.Equal(suite.T(), 3, a.AggregateVersion())
+	assert.Equal(suite.T(), 2, a.applied)
+
+	a.AppendEvent(mocks.EventType, &mocks.EventData{Content: "event4"}, timestamp)
+	assert.Nil(suite.T(), aggregateStore.Save(suite.ctx, a))
+
+	version, err := store.AggregateVersion(suite.ctx, id)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 4, version)
+}
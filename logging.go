@@ -0,0 +1,162 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/guregu/dynamo"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// Logger is a structured logger for debug logging, with the Debug method of
+// log/slog, where args are alternating keys and values. A *slog.Logger can be
+// used as is, other loggers with LoggerFunc:
+//
+//	// zap
+//	ehdynamodb.LoggerFunc(zapLogger.Sugar().Debugw)
+//	// zerolog
+//	ehdynamodb.LoggerFunc(func(msg string, args ...interface{}) {
+//		zerologLogger.Debug().Fields(args).Msg(msg)
+//	})
+type Logger interface {
+	Debug(msg string, args ...interface{})
+}
+
+// LoggerFunc is a function that can be used as Logger.
+type LoggerFunc func(msg string, args ...interface{})
+
+// Debug implements the Debug method of the Logger interface.
+func (f LoggerFunc) Debug(msg string, args ...interface{}) {
+	f(msg, args...)
+}
+
+// WithLogger logs every DynamoDB operation of the event store at debug level,
+// with failed conditions, retries, and operations and calls of Save, Load,
+// LoadAll and Replace that take longer than slow. Slow calls are not logged
+// if slow is 0. Operations of a DAX cluster are not logged.
+func WithLogger(l Logger, slow time.Duration) Option {
+	return func(s *EventStore) error {
+		s.logger = &logger{Logger: l, slow: slow}
+		return nil
+	}
+}
+
+// WithRepoLogger logs every DynamoDB operation of the repo at debug level, as
+// WithLogger.
+func WithRepoLogger(l Logger, slow time.Duration) OptionRepo {
+	return func(r *Repo) error {
+		r.logger = &logger{Logger: l, slow: slow}
+		return nil
+	}
+}
+
+// logger is a logger with the threshold of slow calls.
+type logger struct {
+	Logger
+	slow time.Duration
+}
+
+// isSlow checks if a call that took a duration is slow.
+func (l *logger) isSlow(d time.Duration) bool {
+	return l.slow > 0 && d >= l.slow
+}
+
+// logMethod logs a call of a method of the event store if it was slow.
+func (l *logger) logMethod(ctx context.Context, method Method, d time.Duration, err error) {
+	if l == nil || !l.isSlow(d) {
+		return
+	}
+	l.Debug("slow event store call",
+		"method", string(method),
+		"namespace", eh.NamespaceFromContext(ctx),
+		"duration", d,
+		"error", err,
+	)
+}
+
+// applyLogger adds handlers to the DynamoDB client of a service that log
+// every operation, and its retries.
+func applyLogger(db *dynamo.DB, l *logger) {
+	if l == nil {
+		return
+	}
+	c, ok := db.Client().(*dynamodb.DynamoDB)
+	if !ok {
+		return
+	}
+
+	// Retries are logged before the retry handler of the SDK, which clears
+	// the error, by deciding if the request is retried as it does.
+	c.Handlers.AfterRetry.PushFront(func(r *request.Request) {
+		if r.Retryable == nil || aws.BoolValue(r.Config.EnforceShouldRetryCheck) {
+			r.Retryable = aws.Bool(r.ShouldRetry(r))
+		}
+		if !r.WillRetry() {
+			return
+		}
+		l.Debug("retrying dynamodb operation",
+			"operation", r.Operation.Name,
+			"table", requestTable(r),
+			"namespace", eh.NamespaceFromContext(r.Context()),
+			"retry", r.RetryCount+1,
+			"request_id", r.RequestID,
+			"error", r.Error,
+		)
+	})
+	c.Handlers.Complete.PushBack(func(r *request.Request) {
+		d := time.Since(r.Time)
+		args := []interface{}{
+			"operation", r.Operation.Name,
+			"table", requestTable(r),
+			"namespace", eh.NamespaceFromContext(r.Context()),
+			"duration", d,
+			"retries", r.RetryCount,
+			"request_id", r.RequestID,
+		}
+		if r.Error != nil {
+			args = append(args, "error", r.Error)
+		}
+
+		switch {
+		case r.Error != nil && isConditionalCheckFailed(r.Error):
+			l.Debug("dynamodb condition failed", args...)
+		case l.isSlow(d):
+			l.Debug("slow dynamodb operation", args...)
+		default:
+			l.Debug("dynamodb operation", args...)
+		}
+	})
+}
+
+// requestTable returns the table of the input of a request, or an empty
+// string for operations on several tables.
+func requestTable(r *request.Request) string {
+	v := reflect.ValueOf(r.Params)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return ""
+	}
+	f := v.Elem().FieldByName("TableName")
+	if !f.IsValid() || f.Kind() != reflect.Ptr || f.IsNil() {
+		return ""
+	}
+	table, _ := f.Interface().(*string)
+	return aws.StringValue(table)
+}
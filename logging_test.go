@@ -0,0 +1,110 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/guregu/dynamo"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/stretchr/testify/assert"
+)
+
+type logEntry struct {
+	msg  string
+	args []interface{}
+}
+
+func TestLogger(t *testing.T) {
+	sess := session.Must(session.NewSession(&aws.Config{Region: aws.String("us-west-2")}))
+	db := dynamo.New(sess)
+
+	var entries []logEntry
+	l := &logger{Logger: LoggerFunc(func(msg string, args ...interface{}) {
+		entries = append(entries, logEntry{msg, args})
+	}), slow: time.Second}
+	applyLogger(db, l)
+	client := db.Client().(*dynamodb.DynamoDB)
+	ctx := eh.NewContextWithNamespace(context.Background(), "ns")
+
+	req, _ := client.GetItemRequest(&dynamodb.GetItemInput{TableName: aws.String("events")})
+	req.SetContext(ctx)
+	req.RequestID = "request"
+	req.Handlers.Complete.Run(req)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "dynamodb operation", entries[0].msg)
+		assert.Equal(t, []interface{}{"operation", "GetItem", "table", "events", "namespace", "ns"}, entries[0].args[:6])
+		assert.Equal(t, []interface{}{"request_id", "request"}, entries[0].args[10:12])
+	}
+
+	// Failed conditions and slow operations are logged as such.
+	entries = nil
+	req, _ = client.PutItemRequest(&dynamodb.PutItemInput{TableName: aws.String("events")})
+	req.Error = awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "failed", nil)
+	req.Handlers.Complete.Run(req)
+	req, _ = client.PutItemRequest(&dynamodb.PutItemInput{TableName: aws.String("events")})
+	req.Time = time.Now().Add(-2 * time.Second)
+	req.Handlers.Complete.Run(req)
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, "dynamodb condition failed", entries[0].msg)
+		assert.Equal(t, "slow dynamodb operation", entries[1].msg)
+	}
+
+	// Retries are logged before the error is cleared.
+	entries = nil
+	req, _ = client.QueryRequest(&dynamodb.QueryInput{TableName: aws.String("events")})
+	req.Config.SleepDelay = func(time.Duration) {}
+	req.HTTPResponse = &http.Response{StatusCode: http.StatusBadRequest}
+	req.Error = awserr.New(dynamodb.ErrCodeProvisionedThroughputExceededException, "throttled", nil)
+	req.RequestID = "throttled"
+	req.Handlers.AfterRetry.Run(req)
+	assert.Nil(t, req.Error)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "retrying dynamodb operation", entries[0].msg)
+		assert.Contains(t, entries[0].args, 1)
+		assert.Contains(t, entries[0].args, "throttled")
+	}
+}
+
+func TestLoggerMethod(t *testing.T) {
+	var entries []logEntry
+	l := &logger{Logger: LoggerFunc(func(msg string, args ...interface{}) {
+		entries = append(entries, logEntry{msg, args})
+	}), slow: time.Second}
+	ctx := eh.NewContextWithNamespace(context.Background(), "ns")
+	failed := errors.New("failed")
+
+	l.logMethod(ctx, MethodSave, time.Millisecond, nil)
+	l.logMethod(ctx, MethodSave, 2*time.Second, failed)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "slow event store call", entries[0].msg)
+		assert.Equal(t, []interface{}{"method", "Save", "namespace", "ns", "duration", 2 * time.Second, "error", failed}, entries[0].args)
+	}
+
+	// Slow calls are not logged without a threshold or a logger.
+	l.slow = 0
+	l.logMethod(ctx, MethodSave, time.Hour, nil)
+	var none *logger
+	none.logMethod(ctx, MethodSave, time.Hour, nil)
+	assert.Len(t, entries, 1)
+}
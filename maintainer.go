@@ -0,0 +1,82 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// Maintainer is the maintenance interface of event stores in newer versions
+// of eventhorizon, which adds Clear and Remove to the methods of
+// eventhorizon.EventStoreMaintenance. It lets the store be used with
+// maintenance tooling written against either version.
+type Maintainer interface {
+	eh.EventStoreMaintenance
+
+	// Clear removes all events of the namespace of the context.
+	Clear(ctx context.Context) error
+	// Remove removes all events of an aggregate.
+	Remove(ctx context.Context, id uuid.UUID) error
+}
+
+// EventStore implements the full maintenance interface.
+var _ Maintainer = (*EventStore)(nil)
+
+// Clear removes all events of the namespace of the context, by deleting the
+// event table, and all partition tables when partitioned by month, and
+// creating the event table again.
+//
+// Only the event tables are cleared. The tables that are shared by all
+// namespaces are left as they are: the dispatch records of the outbox, the
+// deduplicated payloads and the keys of crypto shredding, as well as the
+// archive in S3 and the cursor, lease and quarantine tables of replayers,
+// stream dispatchers and quarantines. The references of
+// the cleared events to deduplicated payloads are not subtracted either, so
+// PrunePayloads does not remove their payloads.
+func (s *EventStore) Clear(ctx context.Context) error {
+	ctx, err := s.namespace(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := s.DeleteTable(ctx); err != nil {
+		return eh.EventStoreError{
+			BaseErr:   err,
+			Err:       wrapError(ErrCouldNotClearDB, err),
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+	if s.cache != nil {
+		s.cache.clear()
+	}
+
+	return s.createEventTable(ctx, s.tableName(ctx))
+}
+
+// Remove removes all events of an aggregate, as DeleteAggregate. It returns
+// eventhorizon.ErrAggregateNotFound if the aggregate has no events.
+func (s *EventStore) Remove(ctx context.Context, id uuid.UUID) error {
+	deleted, err := s.DeleteAggregate(ctx, id)
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return eh.ErrAggregateNotFound
+	}
+	return nil
+}
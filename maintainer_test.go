@@ -0,0 +1,41 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestClear will make sure that all events of the namespace are removed
+func (suite *EventStoreTestSuite) TestClear() {
+	id := uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	event1 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event1"}, timestamp, mocks.AggregateType, id, 1)
+	assert.Nil(suite.T(), suite.store.Save(suite.ctx, []eh.Event{event1}, 0))
+
+	assert.Nil(suite.T(), suite.store.Clear(suite.ctx))
+	events, err := suite.store.Load(suite.ctx, id)
+	assert.Nil(suite.T(), err)
+	assert.Len(suite.T(), events, 0)
+
+	assert.Equal(suite.T(), eh.ErrAggregateNotFound, suite.store.Remove(suite.ctx, id))
+	assert.Nil(suite.T(), suite.store.Save(suite.ctx, []eh.Event{event1}, 0))
+	assert.Nil(suite.T(), suite.store.Remove(suite.ctx, id))
+}
@@ -0,0 +1,565 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// The kinds of tables in a manifest.
+const (
+	TableKindEvents    = "events"
+	TableKindRepo      = "repo"
+	TableKindSnapshots = "snapshots"
+)
+
+// The indexes that can be declared for event tables in a manifest.
+const (
+	ManifestIndexCorrelation = "correlation"
+	ManifestIndexEventType   = "eventType"
+	ManifestIndexPosition    = "position"
+	ManifestIndexActivity    = "activity"
+)
+
+// Manifest declares the tables of the package that should exist.
+type Manifest struct {
+	// Environment scopes the tables to an environment as in
+	// WithEnvironmentSuffix, and OtherEnvironments are the other environments
+	// that share the AWS account.
+	Environment       string   `json:"environment,omitempty"`
+	OtherEnvironments []string `json:"otherEnvironments,omitempty"`
+
+	Tables []ManifestTable `json:"tables"`
+}
+
+// manifestTag is the tag of the tables that are managed by Apply, with the
+// environment of the manifest as value. Only tables with the tag are pruned.
+const manifestTag = "eh-dynamodb:manifest"
+
+// ManifestTable declares a kind of table with a prefix, for a set of namespaces.
+type ManifestTable struct {
+	// Kind is one of TableKindEvents, TableKindRepo or TableKindSnapshots.
+	Kind string `json:"kind"`
+	// Prefix is the table prefix given to the store or repo.
+	Prefix string `json:"prefix"`
+	// Namespaces are the namespaces to have tables for.
+	Namespaces []string `json:"namespaces"`
+
+	// BillingMode, ReadCapacity, WriteCapacity, TableClass and KMSKeyID are
+	// as in NamespaceConfig.
+	BillingMode   string `json:"billingMode,omitempty"`
+	ReadCapacity  int64  `json:"readCapacity,omitempty"`
+	WriteCapacity int64  `json:"writeCapacity,omitempty"`
+	TableClass    string `json:"tableClass,omitempty"`
+	KMSKeyID      string `json:"kmsKeyId,omitempty"`
+	// Tags are the tags of the tables, as in NamespaceConfig.
+	Tags map[string]string `json:"tags,omitempty"`
+	// PointInTimeRecovery enables point-in-time recovery of the tables.
+	PointInTimeRecovery bool `json:"pointInTimeRecovery,omitempty"`
+	// Retention is the event retention as a duration like "720h", which
+	// enables TTL on event tables.
+	Retention string `json:"retention,omitempty"`
+	// Indexes are the optional indexes of event tables, like "correlation".
+	Indexes []string `json:"indexes,omitempty"`
+	// StreamViewType enables DynamoDB Streams with a view type, like
+	// "NEW_IMAGE".
+	StreamViewType string `json:"streamViewType,omitempty"`
+}
+
+// ReadManifest reads a JSON manifest.
+func ReadManifest(r io.Reader) (Manifest, error) {
+	var m Manifest
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&m); err != nil {
+		return Manifest{}, err
+	}
+
+	var names []string
+	for _, t := range m.Tables {
+		switch t.Kind {
+		case TableKindEvents, TableKindRepo, TableKindSnapshots:
+		default:
+			return Manifest{}, fmt.Errorf("unknown table kind %q", t.Kind)
+		}
+		if t.Prefix == "" {
+			return Manifest{}, fmt.Errorf("missing prefix for %s tables", t.Kind)
+		}
+		if _, err := t.config(); err != nil {
+			return Manifest{}, err
+		}
+		for _, index := range t.Indexes {
+			if _, ok := manifestIndexes[index]; !ok || t.Kind != TableKindEvents {
+				return Manifest{}, fmt.Errorf("unknown index %q for %s tables", index, t.Kind)
+			}
+		}
+		for _, ns := range t.Namespaces {
+			names = append(names, m.tableName(t, ns))
+		}
+	}
+	if err := m.environment().check(names...); err != nil {
+		return Manifest{}, err
+	}
+
+	return m, nil
+}
+
+// environment returns the environment of the manifest.
+func (m Manifest) environment() *environment {
+	return &environment{name: m.Environment, others: m.OtherEnvironments}
+}
+
+// tableName returns the name of the table of a namespace.
+func (m Manifest) tableName(t ManifestTable, ns string) string {
+	return m.environment().tableName(t.Prefix + "_" + ns)
+}
+
+// manifestIndexes are the indexes that can be declared in a manifest.
+var manifestIndexes = map[string]tableIndex{
+	ManifestIndexCorrelation: correlationIndex,
+	ManifestIndexEventType:   eventTypeIndex,
+	ManifestIndexPosition:    positionIndex,
+	ManifestIndexActivity:    activityIndex,
+}
+
+// The actions of changes found when applying a manifest.
+const (
+	// ChangeCreate is a missing table that is created.
+	ChangeCreate = "create"
+	// ChangeUpdate is a missing index or stream that is added to a table.
+	ChangeUpdate = "update"
+	// ChangeDrift is a difference that is only reported, as it can not be
+	// changed safely.
+	ChangeDrift = "drift"
+	// ChangeDelete is a table that is not in the manifest. It is only
+	// deleted when pruning.
+	ChangeDelete = "delete"
+)
+
+// Change is a difference between the manifest and the actual tables.
+type Change struct {
+	Action string
+	Table  string
+	Detail string
+	// Applied is set if the change was made.
+	Applied bool
+}
+
+func (c Change) String() string {
+	s := c.Action + " " + c.Table
+	if c.Detail != "" {
+		s += ": " + c.Detail
+	}
+	if !c.Applied && c.Action != ChangeDrift {
+		s += " (not applied)"
+	}
+	return s
+}
+
+// ApplyOptions are the options of Apply.
+type ApplyOptions struct {
+	// DryRun only reports the changes without making them.
+	DryRun bool
+	// Prune deletes the tables that were created by Apply for the
+	// environment of the manifest, or were in it before, and are no longer
+	// in the manifest. Without it they are only reported. Tables with a
+	// manifest prefix that Apply never managed, like tables of namespaces
+	// created at runtime, and the outbox, payload, shredding and lease
+	// tables, are never deleted, and neither are the tables of other
+	// environments.
+	Prune bool
+	// TableWaiter waits for tables instead of DefaultTableWaiter.
+	TableWaiter *TableWaiter
+}
+
+// Apply converges the tables to a manifest: missing tables are created with
+// their config, missing indexes and streams are added, differences that can
+// not be changed safely are reported as drift, and tables that are not in the
+// manifest are only deleted when pruning. The managed tables are tagged with
+// the environment of the manifest. It is safe to run concurrently, for
+// example from the deploys of several services.
+func Apply(ctx context.Context, sess *session.Session, m Manifest, opts ApplyOptions) ([]Change, error) {
+	client := dynamodb.New(sess)
+	if opts.TableWaiter != nil {
+		ctx = withTableWaiter(ctx, newTableWaiter(*opts.TableWaiter))
+	}
+
+	var changes []Change
+	desired := map[string]bool{}
+	for _, t := range m.Tables {
+		for _, ns := range t.Namespaces {
+			tableName := m.tableName(t, ns)
+			desired[tableName] = true
+
+			tableChanges, err := applyTable(ctx, sess, client, m, t, ns, tableName, opts)
+			changes = append(changes, tableChanges...)
+			if err != nil {
+				return changes, fmt.Errorf("could not apply %s: %v", tableName, err)
+			}
+		}
+	}
+
+	unmanaged, err := unmanagedTables(ctx, client, m, desired)
+	if err != nil {
+		return changes, err
+	}
+	for _, tableName := range unmanaged {
+		change := Change{Action: ChangeDelete, Table: tableName, Detail: "not in manifest"}
+		if opts.Prune && !opts.DryRun {
+			if _, err := client.DeleteTableWithContext(ctx, &dynamodb.DeleteTableInput{
+				TableName: aws.String(tableName),
+			}); err != nil && !isAWSErrorCode(err, dynamodb.ErrCodeResourceNotFoundException) {
+				return changes, err
+			}
+			change.Applied = true
+		}
+		changes = append(changes, change)
+	}
+
+	return changes, nil
+}
+
+// applyTable converges one table.
+func applyTable(ctx context.Context, sess *session.Session, client dynamodbiface.DynamoDBAPI, m Manifest, t ManifestTable, ns, tableName string, opts ApplyOptions) ([]Change, error) {
+	cfg, err := t.config()
+	if err != nil {
+		return nil, err
+	}
+	var indexes []tableIndex
+	for _, name := range t.Indexes {
+		indexes = append(indexes, manifestIndexes[name])
+	}
+
+	out, err := client.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(tableName),
+	})
+	if isAWSErrorCode(err, dynamodb.ErrCodeResourceNotFoundException) {
+		change := Change{Action: ChangeCreate, Table: tableName}
+		if opts.DryRun {
+			return []Change{change}, nil
+		}
+		if err := createManifestTable(ctx, sess, m, t, ns, cfg, indexes); err != nil {
+			return nil, err
+		}
+		if t.StreamViewType != "" {
+			if err := enableStream(ctx, client, tableName, t.StreamViewType); err != nil {
+				return nil, err
+			}
+		}
+		out, err := client.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{
+			TableName: aws.String(tableName),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := tagManaged(ctx, client, out.Table.TableArn, m.Environment); err != nil {
+			return nil, err
+		}
+		change.Applied = true
+		return []Change{change}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var changes []Change
+	for _, detail := range tableDrift(ctx, client, out.Table, t, cfg) {
+		changes = append(changes, Change{Action: ChangeDrift, Table: tableName, Detail: detail})
+	}
+
+	if env, ok, err := managedEnvironment(ctx, client, out.Table.TableArn); err != nil {
+		return changes, err
+	} else if !ok || env != m.Environment {
+		change := Change{Action: ChangeUpdate, Table: tableName, Detail: "tag " + manifestTag}
+		if !opts.DryRun {
+			if err := tagManaged(ctx, client, out.Table.TableArn, m.Environment); err != nil {
+				return changes, err
+			}
+			change.Applied = true
+		}
+		changes = append(changes, change)
+	}
+
+	for _, index := range indexes {
+		if _, projection := findIndex(out.Table, index.name); projection != nil {
+			continue
+		}
+		change := Change{Action: ChangeUpdate, Table: tableName, Detail: "add index " + index.name}
+		if !opts.DryRun {
+			if err := ensureIndexes(ctx, client, tableName, []tableIndex{index}); err != nil {
+				return changes, err
+			}
+			change.Applied = true
+		}
+		changes = append(changes, change)
+	}
+
+	if t.StreamViewType != "" && (out.Table.StreamSpecification == nil || !aws.BoolValue(out.Table.StreamSpecification.StreamEnabled)) {
+		change := Change{Action: ChangeUpdate, Table: tableName, Detail: "enable stream " + t.StreamViewType}
+		if !opts.DryRun {
+			if err := enableStream(ctx, client, tableName, t.StreamViewType); err != nil {
+				return changes, err
+			}
+			change.Applied = true
+		}
+		changes = append(changes, change)
+	}
+
+	return changes, nil
+}
+
+// createManifestTable creates a table with the store or repo of its kind.
+func createManifestTable(ctx context.Context, sess *session.Session, m Manifest, t ManifestTable, ns string, cfg NamespaceConfig, indexes []tableIndex) error {
+	ctx = eh.NewContextWithNamespace(ctx, ns)
+	configs := NewNamespaceConfigs(cfg)
+
+	switch t.Kind {
+	case TableKindEvents:
+		s, err := NewEventStore(t.Prefix, WithDynamoDB(sess), WithNamespaceConfigs(configs),
+			WithEnvironmentSuffix(m.Environment, m.OtherEnvironments...))
+		if err != nil {
+			return err
+		}
+		s.indexes = indexes
+		return s.CreateTable(ctx)
+	case TableKindRepo:
+		r, err := NewRepo(t.Prefix, WithRepoDynamoDB(sess), WithRepoNamespaceConfigs(configs),
+			WithRepoEnvironmentSuffix(m.Environment, m.OtherEnvironments...),
+			WithRepoEntityFactoryFunc(func() eh.Entity { return &manifestEntity{} }))
+		if err != nil {
+			return err
+		}
+		return r.CreateTable(ctx)
+	case TableKindSnapshots:
+		s, err := NewSnapshotStore(t.Prefix, WithSnapshotDynamoDB(sess),
+			WithSnapshotEnvironmentSuffix(m.Environment, m.OtherEnvironments...))
+		if err != nil {
+			return err
+		}
+		return s.CreateTable(ctx)
+	}
+	return fmt.Errorf("unknown table kind %q", t.Kind)
+}
+
+// manifestEntity has the key schema of repo tables.
+type manifestEntity struct {
+	ID uuid.UUID `dynamo:",hash"`
+}
+
+// EntityID implements the EntityID method of the eventhorizon.Entity interface.
+func (e *manifestEntity) EntityID() uuid.UUID {
+	return e.ID
+}
+
+// tableDrift returns the differences of a table that can not be changed safely.
+func tableDrift(ctx context.Context, client dynamodbiface.DynamoDBAPI, table *dynamodb.TableDescription, t ManifestTable, cfg NamespaceConfig) []string {
+	var drift []string
+
+	billingMode := dynamodb.BillingModeProvisioned
+	if table.BillingModeSummary != nil {
+		billingMode = aws.StringValue(table.BillingModeSummary.BillingMode)
+	}
+	if cfg.BillingMode != "" && cfg.BillingMode != billingMode {
+		drift = append(drift, fmt.Sprintf("billing mode is %s, want %s", billingMode, cfg.BillingMode))
+	}
+	if cfg.BillingMode == dynamodb.BillingModeProvisioned && table.ProvisionedThroughput != nil {
+		read := aws.Int64Value(table.ProvisionedThroughput.ReadCapacityUnits)
+		write := aws.Int64Value(table.ProvisionedThroughput.WriteCapacityUnits)
+		if read != cfg.ReadCapacity || write != cfg.WriteCapacity {
+			drift = append(drift, fmt.Sprintf("capacity is %d/%d, want %d/%d", read, write, cfg.ReadCapacity, cfg.WriteCapacity))
+		}
+	}
+
+	if cfg.TableClass != "" && cfg.TableClass != tableClass(table) {
+		drift = append(drift, fmt.Sprintf("table class is %s, want %s", tableClass(table), cfg.TableClass))
+	}
+
+	if cfg.KMSKeyID != "" {
+		sse := table.SSEDescription
+		if sse == nil || aws.StringValue(sse.Status) != dynamodb.SSEStatusEnabled ||
+			!strings.HasSuffix(aws.StringValue(sse.KMSMasterKeyArn), cfg.KMSKeyID) {
+			drift = append(drift, "not encrypted with KMS key "+cfg.KMSKeyID)
+		}
+	}
+
+	if cfg.PointInTimeRecovery {
+		out, err := client.DescribeContinuousBackupsWithContext(ctx, &dynamodb.DescribeContinuousBackupsInput{
+			TableName: table.TableName,
+		})
+		if err != nil {
+			drift = append(drift, "could not describe continuous backups: "+err.Error())
+		} else if d := out.ContinuousBackupsDescription; d == nil || d.PointInTimeRecoveryDescription == nil ||
+			aws.StringValue(d.PointInTimeRecoveryDescription.PointInTimeRecoveryStatus) != dynamodb.PointInTimeRecoveryStatusEnabled {
+			drift = append(drift, "point-in-time recovery not enabled")
+		}
+	}
+
+	if t.Kind == TableKindEvents && cfg.Retention > 0 {
+		out, err := client.DescribeTimeToLiveWithContext(ctx, &dynamodb.DescribeTimeToLiveInput{
+			TableName: table.TableName,
+		})
+		if err != nil {
+			drift = append(drift, "could not describe TTL: "+err.Error())
+		} else if d := out.TimeToLiveDescription; d == nil ||
+			aws.StringValue(d.AttributeName) != expiresAtAttr ||
+			(aws.StringValue(d.TimeToLiveStatus) != dynamodb.TimeToLiveStatusEnabled &&
+				aws.StringValue(d.TimeToLiveStatus) != dynamodb.TimeToLiveStatusEnabling) {
+			drift = append(drift, "TTL not enabled on "+expiresAtAttr)
+		}
+	}
+
+	if t.StreamViewType != "" && table.StreamSpecification != nil &&
+		aws.BoolValue(table.StreamSpecification.StreamEnabled) &&
+		aws.StringValue(table.StreamSpecification.StreamViewType) != t.StreamViewType {
+		drift = append(drift, fmt.Sprintf("stream view type is %s, want %s",
+			aws.StringValue(table.StreamSpecification.StreamViewType), t.StreamViewType))
+	}
+
+	return drift
+}
+
+// enableStream enables DynamoDB Streams on a table and waits for it.
+func enableStream(ctx context.Context, client dynamodbiface.DynamoDBAPI, tableName, viewType string) error {
+	if _, err := client.UpdateTableWithContext(ctx, &dynamodb.UpdateTableInput{
+		TableName: aws.String(tableName),
+		StreamSpecification: &dynamodb.StreamSpecification{
+			StreamEnabled:  aws.Bool(true),
+			StreamViewType: aws.String(viewType),
+		},
+	}); err != nil {
+		return err
+	}
+	return waitForTableActive(ctx, client, tableName)
+}
+
+// unmanagedTables returns the tables that were managed by Apply for the
+// environment of the manifest but are no longer in it: tables with the name
+// of a manifest prefix in the environment and the manifest tag of the
+// environment. Monthly event partitions are managed by the event store and
+// never returned.
+func unmanagedTables(ctx context.Context, client dynamodbiface.DynamoDBAPI, m Manifest, desired map[string]bool) ([]string, error) {
+	env := m.environment()
+	var candidates []string
+	err := client.ListTablesPagesWithContext(ctx, &dynamodb.ListTablesInput{},
+		func(out *dynamodb.ListTablesOutput, last bool) bool {
+			for _, name := range out.TableNames {
+				tableName := aws.StringValue(name)
+				base, ok := env.trim(tableName)
+				if desired[tableName] || !ok {
+					continue
+				}
+				for _, t := range m.Tables {
+					if !strings.HasPrefix(base, t.Prefix+"_") {
+						continue
+					}
+					if t.Kind == TableKindEvents && isPartitionTable(base) {
+						break
+					}
+					candidates = append(candidates, tableName)
+					break
+				}
+			}
+			return true
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	var unmanaged []string
+	for _, tableName := range candidates {
+		out, err := client.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{
+			TableName: aws.String(tableName),
+		})
+		if isAWSErrorCode(err, dynamodb.ErrCodeResourceNotFoundException) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if env, ok, err := managedEnvironment(ctx, client, out.Table.TableArn); err != nil {
+			return nil, err
+		} else if ok && env == m.Environment {
+			unmanaged = append(unmanaged, tableName)
+		}
+	}
+
+	sort.Strings(unmanaged)
+	return unmanaged, nil
+}
+
+// managedEnvironment returns the environment of the manifest tag of a table,
+// and if the table has the tag.
+func managedEnvironment(ctx context.Context, client dynamodbiface.DynamoDBAPI, arn *string) (string, bool, error) {
+	input := &dynamodb.ListTagsOfResourceInput{ResourceArn: arn}
+	for {
+		out, err := client.ListTagsOfResourceWithContext(ctx, input)
+		if err != nil {
+			return "", false, err
+		}
+		for _, tag := range out.Tags {
+			if aws.StringValue(tag.Key) == manifestTag {
+				return aws.StringValue(tag.Value), true, nil
+			}
+		}
+		if out.NextToken == nil {
+			return "", false, nil
+		}
+		input.NextToken = out.NextToken
+	}
+}
+
+// tagManaged tags a table as managed by Apply for an environment.
+func tagManaged(ctx context.Context, client dynamodbiface.DynamoDBAPI, arn *string, env string) error {
+	_, err := client.TagResourceWithContext(ctx, &dynamodb.TagResourceInput{
+		ResourceArn: arn,
+		Tags: []*dynamodb.Tag{{
+			Key:   aws.String(manifestTag),
+			Value: aws.String(env),
+		}},
+	})
+	return err
+}
+
+// config returns the namespace config of a manifest table.
+func (t ManifestTable) config() (NamespaceConfig, error) {
+	cfg := NamespaceConfig{
+		BillingMode:         t.BillingMode,
+		ReadCapacity:        t.ReadCapacity,
+		WriteCapacity:       t.WriteCapacity,
+		TableClass:          t.TableClass,
+		KMSKeyID:            t.KMSKeyID,
+		Tags:                t.Tags,
+		PointInTimeRecovery: t.PointInTimeRecovery,
+	}
+	if t.Retention != "" {
+		retention, err := time.ParseDuration(t.Retention)
+		if err != nil {
+			return NamespaceConfig{}, fmt.Errorf("invalid retention for %s tables: %v", t.Prefix, err)
+		}
+		cfg.Retention = retention
+	}
+	return cfg, nil
+}
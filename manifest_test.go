@@ -0,0 +1,114 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadManifest(t *testing.T) {
+	m, err := ReadManifest(strings.NewReader(`{"tables": [
+		{"kind": "events", "prefix": "events", "namespaces": ["a", "b"], "retention": "720h", "indexes": ["correlation"]},
+		{"kind": "repo", "prefix": "users", "namespaces": ["a"], "billingMode": "PAY_PER_REQUEST"}
+	]}`))
+	assert.Nil(t, err)
+	assert.Len(t, m.Tables, 2)
+	cfg, err := m.Tables[0].config()
+	assert.Nil(t, err)
+	assert.Equal(t, 720*time.Hour, cfg.Retention)
+
+	for _, manifest := range []string{
+		`{"tables": [{"kind": "other", "prefix": "events"}]}`,
+		`{"tables": [{"kind": "events"}]}`,
+		`{"tables": [{"kind": "events", "prefix": "events", "retention": "forever"}]}`,
+		`{"tables": [{"kind": "repo", "prefix": "users", "indexes": ["correlation"]}]}`,
+		`{"tables": [{"kind": "events", "prefix": "events", "unknown": true}]}`,
+		`{"environment": "staging", "otherEnvironments": ["staging"], "tables": []}`,
+		`{"otherEnvironments": ["b"], "tables": [{"kind": "repo", "prefix": "users", "namespaces": ["a-b"]}]}`,
+	} {
+		_, err := ReadManifest(strings.NewReader(manifest))
+		assert.NotNil(t, err, manifest)
+	}
+}
+
+func TestApply(t *testing.T) {
+	sess, err := session.NewSession(&aws.Config{
+		Region:   aws.String("us-west-2"),
+		Endpoint: aws.String("http://localhost:8000"),
+	})
+	assert.Nil(t, err)
+	client := dynamodb.New(sess)
+	ctx := context.Background()
+
+	m := Manifest{Tables: []ManifestTable{
+		{Kind: TableKindEvents, Prefix: "manifest_events", Namespaces: []string{"a"}, Indexes: []string{ManifestIndexCorrelation}},
+		{Kind: TableKindRepo, Prefix: "manifest_repo", Namespaces: []string{"a"}},
+	}}
+	defer func() {
+		for _, tableName := range []string{"manifest_events_a", "manifest_repo_a", "manifest_repo_b", "manifest_repo_c"} {
+			client.DeleteTable(&dynamodb.DeleteTableInput{TableName: aws.String(tableName)})
+		}
+	}()
+
+	changes, err := Apply(ctx, sess, m, ApplyOptions{DryRun: true})
+	assert.Nil(t, err)
+	assert.Equal(t, []Change{
+		{Action: ChangeCreate, Table: "manifest_events_a"},
+		{Action: ChangeCreate, Table: "manifest_repo_a"},
+	}, changes)
+
+	changes, err = Apply(ctx, sess, m, ApplyOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, []Change{
+		{Action: ChangeCreate, Table: "manifest_events_a", Applied: true},
+		{Action: ChangeCreate, Table: "manifest_repo_a", Applied: true},
+	}, changes)
+
+	// Applying again should be a no-op.
+	changes, err = Apply(ctx, sess, m, ApplyOptions{})
+	assert.Nil(t, err)
+	assert.Empty(t, changes)
+
+	// Tables that Apply didn't create, like of namespaces created at
+	// runtime, are never deleted.
+	repo, err := NewRepo("manifest_repo", WithRepoDynamoDB(sess),
+		WithRepoEntityFactoryFunc(func() eh.Entity { return &manifestEntity{} }))
+	assert.Nil(t, err)
+	assert.Nil(t, repo.CreateTable(eh.NewContextWithNamespace(ctx, "c")))
+
+	// Tables that are not in the manifest are only deleted when pruning.
+	m.Tables[1].Namespaces = []string{"b"}
+	changes, err = Apply(ctx, sess, m, ApplyOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, []Change{
+		{Action: ChangeCreate, Table: "manifest_repo_b", Applied: true},
+		{Action: ChangeDelete, Table: "manifest_repo_a", Detail: "not in manifest"},
+	}, changes)
+
+	changes, err = Apply(ctx, sess, m, ApplyOptions{Prune: true})
+	assert.Nil(t, err)
+	assert.Equal(t, []Change{
+		{Action: ChangeDelete, Table: "manifest_repo_a", Detail: "not in manifest", Applied: true},
+	}, changes)
+}
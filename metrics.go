@@ -0,0 +1,167 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"time"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// Operation is a DynamoDB operation, used to tag metrics.
+type Operation string
+
+// The DynamoDB operations that are measured.
+const (
+	OperationPutItem            Operation = "PutItem"
+	OperationGetItem            Operation = "GetItem"
+	OperationUpdateItem         Operation = "UpdateItem"
+	OperationDeleteItem         Operation = "DeleteItem"
+	OperationQuery              Operation = "Query"
+	OperationScan               Operation = "Scan"
+	OperationBatchGetItem       Operation = "BatchGetItem"
+	OperationBatchWriteItem     Operation = "BatchWriteItem"
+	OperationTransactWriteItems Operation = "TransactWriteItems"
+	OperationTransactGetItems   Operation = "TransactGetItems"
+	OperationDescribeTable      Operation = "DescribeTable"
+)
+
+// Method is a method of the event store, used to tag metrics.
+type Method string
+
+// The methods of the event store that are measured.
+const (
+	MethodSave    Method = "Save"
+	MethodLoad    Method = "Load"
+	MethodLoadAll Method = "LoadAll"
+	MethodReplace Method = "Replace"
+)
+
+// OperationMetric is the measurement of a single DynamoDB operation.
+type OperationMetric struct {
+	// Operation is the DynamoDB operation.
+	Operation Operation
+	// Table is the name of the table.
+	Table string
+	// Namespace is the namespace of the context.
+	Namespace string
+	// Duration is the latency of the operation.
+	Duration time.Duration
+	// Err is the error of the operation, if any.
+	Err error
+	// ErrorClass is the class of Err, by the error classifier of the store
+	// or repo.
+	ErrorClass ErrorClass
+}
+
+// Metrics is a sink for operation metrics, typically recording a latency
+// histogram and an error counter tagged by operation, table and namespace.
+type Metrics interface {
+	ObserveOperation(ctx context.Context, m OperationMetric)
+}
+
+// MethodMetric is the measurement of a call of a method of the event store,
+// including all its DynamoDB operations and retries.
+type MethodMetric struct {
+	// Method is the method of the event store.
+	Method Method
+	// Namespace is the namespace of the context.
+	Namespace string
+	// Duration is the latency of the call.
+	Duration time.Duration
+	// Err is the error of the call, if any. IsVersionConflict checks if a
+	// save failed on a version conflict.
+	Err error
+	// ErrorClass is the class of Err, by the error classifier of the store.
+	ErrorClass ErrorClass
+}
+
+// MethodMetrics is implemented by a metrics sink that also measures the
+// calls of the methods of the event store, like the latency of saves as seen
+// by the command handlers.
+type MethodMetrics interface {
+	ObserveMethod(ctx context.Context, m MethodMetric)
+}
+
+// MetricsFunc is a function that can be used as Metrics.
+type MetricsFunc func(ctx context.Context, m OperationMetric)
+
+// ObserveOperation implements the ObserveOperation method of the Metrics interface.
+func (f MetricsFunc) ObserveOperation(ctx context.Context, m OperationMetric) {
+	f(ctx, m)
+}
+
+// WithMetrics adds a metrics sink that is called for every DynamoDB operation,
+// and for every call of Save, Load, LoadAll and Replace if it implements
+// MethodMetrics.
+func WithMetrics(m Metrics) Option {
+	return func(s *EventStore) error {
+		s.metrics = m
+		return nil
+	}
+}
+
+// WithRepoMetrics adds a metrics sink that is called for every DynamoDB operation.
+func WithRepoMetrics(m Metrics) OptionRepo {
+	return func(r *Repo) error {
+		r.metrics = m
+		return nil
+	}
+}
+
+// observeMethod reports a call of a method that started at start, with its
+// error, to the metrics sink if it implements MethodMetrics, and logs it if it
+// was slow. The methods defer it in a closure, so that it gets the context
+// with the resolved namespace.
+func (s *EventStore) observeMethod(ctx context.Context, method Method, start time.Time, err error) {
+	d := time.Since(start)
+	s.logger.logMethod(ctx, method, d, err)
+
+	m, ok := s.metrics.(MethodMetrics)
+	if !ok {
+		return
+	}
+
+	metric := MethodMetric{
+		Method:    method,
+		Namespace: eh.NamespaceFromContext(ctx),
+		Duration:  d,
+		Err:       err,
+	}
+	if err != nil {
+		metric.ErrorClass = classifyError(s.errorClassifier, err)
+	}
+	m.ObserveMethod(ctx, metric)
+}
+
+// observe reports an operation that started at start to the metrics sink.
+func observe(ctx context.Context, m Metrics, op Operation, table string, start time.Time, err error) {
+	if m == nil {
+		return
+	}
+
+	metric := OperationMetric{
+		Operation: op,
+		Table:     table,
+		Namespace: eh.NamespaceFromContext(ctx),
+		Duration:  time.Since(start),
+		Err:       err,
+	}
+	if err != nil {
+		metric.ErrorClass = classifyError(errorClassifierFromContext(ctx), err)
+	}
+	m.ObserveOperation(ctx, metric)
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObserve(t *testing.T) {
+	var metrics []OperationMetric
+	m := MetricsFunc(func(ctx context.Context, m OperationMetric) {
+		metrics = append(metrics, m)
+	})
+
+	ctx := eh.NewContextWithNamespace(context.Background(), "ns")
+	failed := errors.New("failed")
+	observe(ctx, m, OperationQuery, "table_ns", time.Now().Add(-time.Second), failed)
+
+	assert.Len(t, metrics, 1)
+	assert.Equal(t, OperationQuery, metrics[0].Operation)
+	assert.Equal(t, "table_ns", metrics[0].Table)
+	assert.Equal(t, "ns", metrics[0].Namespace)
+	assert.True(t, metrics[0].Duration >= time.Second)
+	assert.Equal(t, failed, metrics[0].Err)
+
+	// A missing sink is ignored.
+	observe(ctx, nil, OperationQuery, "table_ns", time.Now(), nil)
+}
+
+type methodMetrics struct {
+	MetricsFunc
+	methods []MethodMetric
+}
+
+func (m *methodMetrics) ObserveMethod(ctx context.Context, metric MethodMetric) {
+	m.methods = append(m.methods, metric)
+}
+
+func TestObserveMethod(t *testing.T) {
+	ctx := eh.NewContextWithNamespace(context.Background(), "ns")
+	failed := errors.New("failed")
+
+	// A sink without MethodMetrics is ignored.
+	s := &EventStore{metrics: MetricsFunc(func(ctx context.Context, m OperationMetric) {})}
+	s.observeMethod(ctx, MethodSave, time.Now(), failed)
+
+	m := &methodMetrics{}
+	s = &EventStore{metrics: m}
+	s.observeMethod(ctx, MethodSave, time.Now().Add(-time.Second), failed)
+	s.observeMethod(ctx, MethodLoad, time.Now(), nil)
+
+	if assert.Len(t, m.methods, 2) {
+		assert.Equal(t, MethodSave, m.methods[0].Method)
+		assert.Equal(t, "ns", m.methods[0].Namespace)
+		assert.True(t, m.methods[0].Duration >= time.Second)
+		assert.Equal(t, failed, m.methods[0].Err)
+		assert.Equal(t, ErrorPermanent, m.methods[0].ErrorClass)
+		assert.Equal(t, MethodLoad, m.methods[1].Method)
+		assert.Nil(t, m.methods[1].Err)
+	}
+
+	// Calls are observed in the resolved namespace.
+	m = &methodMetrics{}
+	s, err := NewEventStore("test", WithMetrics(m), WithNamespaceProvider(func(ctx context.Context) (string, error) {
+		return "tenant", nil
+	}))
+	if !assert.Nil(t, err) {
+		return
+	}
+	_, err = s.SaveWithResult(context.Background(), nil, 0)
+	assert.NotNil(t, err)
+	if assert.Len(t, m.methods, 1) {
+		assert.Equal(t, "tenant", m.methods[0].Namespace)
+	}
+}
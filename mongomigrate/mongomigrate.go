@@ -0,0 +1,393 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mongomigrate migrates the events of the MongoDB event store of
+// Event Horizon to the DynamoDB event store, keeping their versions,
+// timestamps and metadata.
+//
+// The events are read from an export of the event collection, as written by
+// mongoexport in relaxed or canonical Extended JSON, which avoids a
+// dependency on the MongoDB driver:
+//
+//	mongoexport --db=app --collection=events --out=events.json
+//
+// Both layouts of the MongoDB stores are read: one document per aggregate
+// with its events, and one document per event. The event data is decoded
+// into the registered event data of its type from JSON, which matches the
+// default BSON field names; use WithDataDecoder for custom BSON names.
+//
+// A migration can be run again to resume after a failure or to catch up with
+// new events, as the events that are already in the DynamoDB store are
+// skipped. A dry run reads and validates everything without writing.
+package mongomigrate
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	ehdynamodb "github.com/sysbot/eh-dynamodb"
+)
+
+// maxLineSize is the maximum size of an exported document, the BSON
+// document size limit with room for the Extended JSON encoding.
+const maxLineSize = 32 << 20
+
+// ErrInvalidDocument is when an exported document is not an event or an
+// aggregate with events.
+var ErrInvalidDocument = errors.New("invalid document")
+
+// Store is the event store to migrate to, implemented by the DynamoDB event
+// store.
+type Store interface {
+	AggregateVersion(ctx context.Context, id uuid.UUID) (int, error)
+	Import(ctx context.Context, it ehdynamodb.EventIterator, options ...ehdynamodb.ImportOption) (*ehdynamodb.ImportReport, error)
+}
+
+// DataDecoder decodes the data of an event of a type from JSON.
+type DataDecoder func(eventType eh.EventType, data json.RawMessage) (eh.EventData, error)
+
+// Report is the report of a migration.
+type Report struct {
+	// Documents is the number of documents read.
+	Documents int
+	// Events is the number of events read, and Skipped the number of them
+	// that were already in the store.
+	Events  int
+	Skipped int
+	// Aggregates is the number of aggregates of the events.
+	Aggregates int
+	// Import is the report of the import, nil for a dry run.
+	Import *ehdynamodb.ImportReport
+}
+
+// Migrator migrates events from a MongoDB export to an event store.
+type Migrator struct {
+	store         Store
+	dryRun        bool
+	decode        DataDecoder
+	importOptions []ehdynamodb.ImportOption
+}
+
+// Option is an option setter used to configure creation.
+type Option func(*Migrator)
+
+// WithDryRun reads and validates the events, and reports what would be
+// migrated, without writing to the store.
+func WithDryRun() Option {
+	return func(m *Migrator) {
+		m.dryRun = true
+	}
+}
+
+// WithDataDecoder uses a custom decoder of the event data. The default
+// decodes the data into the registered event data of the event type, or
+// leaves it empty if there is no data.
+func WithDataDecoder(d DataDecoder) Option {
+	return func(m *Migrator) {
+		m.decode = d
+	}
+}
+
+// WithImportOptions sets the options of the import, like the number of
+// workers and a progress callback.
+func WithImportOptions(options ...ehdynamodb.ImportOption) Option {
+	return func(m *Migrator) {
+		m.importOptions = append(m.importOptions, options...)
+	}
+}
+
+// NewMigrator creates a migrator to a store.
+func NewMigrator(store Store, options ...Option) *Migrator {
+	m := &Migrator{
+		store:  store,
+		decode: decodeData,
+	}
+	for _, option := range options {
+		option(m)
+	}
+	return m
+}
+
+// Migrate migrates the events of an export to the namespace of the context.
+// The events of each aggregate must be in version order in the export, which
+// is the case for exports in insertion order. The report is returned also on
+// error.
+func (m *Migrator) Migrate(ctx context.Context, r io.Reader) (*Report, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	it := &iterator{
+		m:        m,
+		scanner:  scanner,
+		report:   &Report{},
+		versions: map[uuid.UUID]int{},
+	}
+
+	if m.dryRun {
+		for {
+			if _, err := it.Next(ctx); errors.Is(err, io.EOF) {
+				return it.report, nil
+			} else if err != nil {
+				return it.report, err
+			}
+		}
+	}
+
+	var err error
+	it.report.Import, err = m.store.Import(ctx, it, m.importOptions...)
+	return it.report, err
+}
+
+// iterator reads the events of an export that are not in the store yet.
+type iterator struct {
+	m       *Migrator
+	scanner *bufio.Scanner
+	report  *Report
+	pending []eh.Event
+	// versions are the versions of the aggregates in the store, before the
+	// migration.
+	versions map[uuid.UUID]int
+}
+
+// Next implements the Next method of the ehdynamodb.EventIterator interface.
+func (it *iterator) Next(ctx context.Context) (eh.Event, error) {
+	for len(it.pending) == 0 {
+		if !it.scanner.Scan() {
+			if err := it.scanner.Err(); err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+		}
+		line := strings.TrimSpace(it.scanner.Text())
+		if line == "" {
+			continue
+		}
+		it.report.Documents++
+
+		events, err := it.m.parseDocument([]byte(line))
+		if err != nil {
+			return nil, fmt.Errorf("document %d: %w", it.report.Documents, err)
+		}
+		for _, event := range events {
+			it.report.Events++
+			version, ok := it.versions[event.AggregateID()]
+			if !ok {
+				if version, err = it.m.store.AggregateVersion(ctx, event.AggregateID()); err != nil {
+					return nil, err
+				}
+				it.versions[event.AggregateID()] = version
+				it.report.Aggregates++
+			}
+			if event.Version() <= version {
+				it.report.Skipped++
+				continue
+			}
+			it.pending = append(it.pending, event)
+		}
+	}
+
+	event := it.pending[0]
+	it.pending = it.pending[1:]
+	return event, nil
+}
+
+// mongoEvent is an event as stored by the MongoDB event stores.
+type mongoEvent struct {
+	EventType     eh.EventType           `json:"event_type"`
+	Data          json.RawMessage        `json:"data"`
+	Timestamp     time.Time              `json:"timestamp"`
+	AggregateType eh.AggregateType       `json:"aggregate_type"`
+	AggregateID   string                 `json:"aggregate_id"`
+	ID            string                 `json:"_id"`
+	Version       int                    `json:"version"`
+	Metadata      map[string]interface{} `json:"metadata"`
+}
+
+// mongoAggregate is an aggregate with its events, as stored by the first
+// MongoDB event store.
+type mongoAggregate struct {
+	ID     string       `json:"_id"`
+	Events []mongoEvent `json:"events"`
+}
+
+// parseDocument parses an exported document into its events.
+func (m *Migrator) parseDocument(line []byte) ([]eh.Event, error) {
+	var doc interface{}
+	dec := json.NewDecoder(strings.NewReader(string(line)))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	fields, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, ErrInvalidDocument
+	}
+	plain, err := json.Marshal(plainJSON(fields))
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := fields["events"]; ok {
+		var a mongoAggregate
+		if err := json.Unmarshal(plain, &a); err != nil {
+			return nil, err
+		}
+		events := make([]eh.Event, len(a.Events))
+		for i, e := range a.Events {
+			// The events of an aggregate have the aggregate ID as ID.
+			if e.AggregateID == "" {
+				e.AggregateID = a.ID
+			}
+			if events[i], err = m.newEvent(e); err != nil {
+				return nil, err
+			}
+		}
+		return events, nil
+	}
+
+	var e mongoEvent
+	if err := json.Unmarshal(plain, &e); err != nil {
+		return nil, err
+	}
+	event, err := m.newEvent(e)
+	if err != nil {
+		return nil, err
+	}
+	return []eh.Event{event}, nil
+}
+
+// newEvent creates the event of a MongoDB event.
+func (m *Migrator) newEvent(e mongoEvent) (eh.Event, error) {
+	if e.EventType == "" || e.Version < 1 {
+		return nil, ErrInvalidDocument
+	}
+	id, err := uuid.Parse(e.AggregateID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: aggregate ID: %v", ErrInvalidDocument, err)
+	}
+
+	var data eh.EventData
+	if len(e.Data) > 0 && string(e.Data) != "null" {
+		if data, err = m.decode(e.EventType, e.Data); err != nil {
+			return nil, fmt.Errorf("event data of %s: %w", e.EventType, err)
+		}
+	}
+
+	return eh.NewEventForAggregate(e.EventType, data, e.Timestamp,
+		e.AggregateType, id, e.Version, eh.WithMetadata(e.Metadata)), nil
+}
+
+// decodeData decodes event data into the registered event data of the type.
+func decodeData(eventType eh.EventType, data json.RawMessage) (eh.EventData, error) {
+	d, err := eh.CreateEventData(eventType)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, d); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// plainJSON converts the typed values of Extended JSON to plain JSON values:
+// dates to RFC 3339 strings, numbers to numbers, binary UUIDs to UUID strings
+// and other binary data to base64 strings.
+func plainJSON(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if len(v) <= 2 {
+			if plain, ok := plainValue(v); ok {
+				return plain
+			}
+		}
+		for k, field := range v {
+			v[k] = plainJSON(field)
+		}
+		return v
+	case []interface{}:
+		for i, elem := range v {
+			v[i] = plainJSON(elem)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// plainValue converts a typed value of Extended JSON, if it is one.
+func plainValue(v map[string]interface{}) (interface{}, bool) {
+	for _, key := range []string{"$numberInt", "$numberLong", "$numberDouble", "$numberDecimal"} {
+		if n, ok := v[key].(string); ok && len(v) == 1 {
+			return json.Number(n), true
+		}
+	}
+	if s, ok := v["$oid"].(string); ok && len(v) == 1 {
+		return s, true
+	}
+	if s, ok := v["$uuid"].(string); ok && len(v) == 1 {
+		return s, true
+	}
+
+	if date, ok := v["$date"]; ok && len(v) == 1 {
+		switch date := date.(type) {
+		case string:
+			// Relaxed Extended JSON has dates after 1970 as ISO-8601.
+			return date, true
+		case json.Number:
+			if ms, err := date.Int64(); err == nil {
+				return time.Unix(0, ms*int64(time.Millisecond)).UTC().Format(time.RFC3339Nano), true
+			}
+		case map[string]interface{}:
+			if n, ok := date["$numberLong"].(string); ok {
+				if ms, err := strconv.ParseInt(n, 10, 64); err == nil {
+					return time.Unix(0, ms*int64(time.Millisecond)).UTC().Format(time.RFC3339Nano), true
+				}
+			}
+		}
+	}
+
+	// Binary is {"$binary": {"base64": ..., "subType": ...}}, or in the legacy
+	// format {"$binary": ..., "$type": ...}.
+	var data, subType string
+	switch b := v["$binary"].(type) {
+	case map[string]interface{}:
+		data, _ = b["base64"].(string)
+		subType, _ = b["subType"].(string)
+	case string:
+		data = b
+		subType, _ = v["$type"].(string)
+	default:
+		return nil, false
+	}
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, false
+	}
+	if t, err := hex.DecodeString(fmt.Sprintf("%02s", subType)); err == nil && len(t) == 1 &&
+		(t[0] == 3 || t[0] == 4) && len(raw) == 16 {
+		id, _ := uuid.FromBytes(raw)
+		return id.String(), true
+	}
+	return data, true
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongomigrate
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+	ehdynamodb "github.com/sysbot/eh-dynamodb"
+)
+
+type store struct {
+	versions map[uuid.UUID]int
+	events   []eh.Event
+}
+
+func (s *store) AggregateVersion(ctx context.Context, id uuid.UUID) (int, error) {
+	return s.versions[id], nil
+}
+
+func (s *store) Import(ctx context.Context, it ehdynamodb.EventIterator, options ...ehdynamodb.ImportOption) (*ehdynamodb.ImportReport, error) {
+	report := &ehdynamodb.ImportReport{}
+	for {
+		event, err := it.Next(ctx)
+		if errors.Is(err, io.EOF) {
+			return report, nil
+		} else if err != nil {
+			return report, err
+		}
+		s.events = append(s.events, event)
+		report.Imported++
+	}
+}
+
+const export = `{"_id": "c1138e5f-f6fb-4dd0-8e79-255c6c8d3756", "version": {"$numberInt": "2"}, "events": [{"event_type": "Event", "data": {"content": "event1"}, "timestamp": {"$date": "2009-11-10T23:00:00Z"}, "aggregate_type": "Aggregate", "_id": "c1138e5f-f6fb-4dd0-8e79-255c6c8d3756", "version": 1, "metadata": {"user": "a"}}, {"event_type": "Event", "data": {"content": "event2"}, "timestamp": {"$date": {"$numberLong": "1257894060000"}}, "aggregate_type": "Aggregate", "_id": "c1138e5f-f6fb-4dd0-8e79-255c6c8d3756", "version": {"$numberLong": "2"}}]}
+
+{"_id": {"$oid": "5f1b0c7e9d3e2a0001a1b2c3"}, "event_type": "Event", "data": {"content": "event3"}, "timestamp": {"$date": "2009-11-10T23:02:00Z"}, "aggregate_type": "Aggregate", "aggregate_id": {"$binary": {"base64": "qEDfv6BVT5CBZGfXQhXHyQ==", "subType": "04"}}, "version": 1}
+`
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	id := uuid.MustParse("c1138e5f-f6fb-4dd0-8e79-255c6c8d3756")
+	other := uuid.MustParse("a840dfbf-a055-4f90-8164-67d74215c7c9")
+
+	s := &store{versions: map[uuid.UUID]int{}}
+	report, err := NewMigrator(s, WithDryRun()).Migrate(ctx, strings.NewReader(export))
+	assert.Nil(t, err)
+	assert.Equal(t, &Report{Documents: 2, Events: 3, Aggregates: 2}, report)
+	assert.Empty(t, s.events)
+
+	report, err = NewMigrator(s).Migrate(ctx, strings.NewReader(export))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, report.Import.Imported)
+	if assert.Len(t, s.events, 3) {
+		event := s.events[0]
+		assert.Equal(t, mocks.EventType, event.EventType())
+		assert.Equal(t, &mocks.EventData{Content: "event1"}, event.Data())
+		assert.Equal(t, time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC), event.Timestamp().UTC())
+		assert.Equal(t, mocks.AggregateType, event.AggregateType())
+		assert.Equal(t, id, event.AggregateID())
+		assert.Equal(t, 1, event.Version())
+		assert.Equal(t, map[string]interface{}{"user": "a"}, event.Metadata())
+
+		assert.Equal(t, time.Date(2009, time.November, 10, 23, 1, 0, 0, time.UTC), s.events[1].Timestamp().UTC())
+		assert.Equal(t, 2, s.events[1].Version())
+		assert.Equal(t, other, s.events[2].AggregateID())
+	}
+
+	// Resume with the events of the first aggregate already migrated.
+	s = &store{versions: map[uuid.UUID]int{id: 2}}
+	report, err = NewMigrator(s).Migrate(ctx, strings.NewReader(export))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, report.Import.Imported)
+	assert.Equal(t, 2, report.Skipped)
+	if assert.Len(t, s.events, 1) {
+		assert.Equal(t, other, s.events[0].AggregateID())
+	}
+
+	_, err = NewMigrator(s, WithDryRun()).Migrate(ctx, strings.NewReader(`{"_id": "x", "version": 1}`))
+	assert.True(t, errors.Is(err, ErrInvalidDocument))
+}
@@ -0,0 +1,140 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"strings"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// NamespaceProvider returns the namespace of a context, for example the
+// tenant from the claims of a JWT or from gRPC metadata. It returns an error
+// if the context has no namespace, which fails the operation.
+type NamespaceProvider func(ctx context.Context) (string, error)
+
+type namespaceResolvedKey int
+
+// namespaceResolvedCtxKey is the context key that marks that the namespace
+// of the context was already resolved.
+const namespaceResolvedCtxKey namespaceResolvedKey = iota
+
+// WithNamespaceProvider uses a custom namespace provider instead of the
+// namespace of eventhorizon.NamespaceFromContext.
+func WithNamespaceProvider(p NamespaceProvider) Option {
+	return func(s *EventStore) error {
+		s.namespaceProvider = p
+		return nil
+	}
+}
+
+// WithRepoNamespaceProvider uses a custom namespace provider instead of the
+// namespace of eventhorizon.NamespaceFromContext.
+func WithRepoNamespaceProvider(p NamespaceProvider) OptionRepo {
+	return func(r *Repo) error {
+		r.namespaceProvider = p
+		return nil
+	}
+}
+
+// WithSnapshotNamespaceProvider uses a custom namespace provider instead of
+// the namespace of eventhorizon.NamespaceFromContext.
+func WithSnapshotNamespaceProvider(p NamespaceProvider) OptionSnapshotStore {
+	return func(s *SnapshotStore) error {
+		s.namespaceProvider = p
+		return nil
+	}
+}
+
+// resolveNamespace returns a context with the namespace from the provider
+// as its eventhorizon namespace, unless it was already resolved.
+func resolveNamespace(ctx context.Context, p NamespaceProvider) (context.Context, error) {
+	if p == nil || ctx.Value(namespaceResolvedCtxKey) != nil {
+		return ctx, nil
+	}
+
+	ns, err := p(ctx)
+	if err != nil {
+		return ctx, err
+	}
+	return NewContextWithExplicitNamespace(ctx, ns), nil
+}
+
+// NewContextWithExplicitNamespace returns a context that targets a namespace
+// explicitly, for example for admin tooling that works across tenants. The
+// namespace provider of a store or repo is not called for the context; the
+// namespace is used as is.
+func NewContextWithExplicitNamespace(ctx context.Context, ns string) context.Context {
+	return context.WithValue(eh.NewContextWithNamespace(ctx, ns), namespaceResolvedCtxKey, true)
+}
+
+// namespace resolves the namespace of a context for the event store, and
+// adds the error classifier for the metrics. It fails if the store is closed.
+func (s *EventStore) namespace(ctx context.Context) (context.Context, error) {
+	if s.lifecycle.isClosed() {
+		return ctx, eh.EventStoreError{
+			Err:       ErrClosed,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	ctx, err := resolveNamespace(ctx, s.namespaceProvider)
+	if err != nil {
+		return ctx, eh.EventStoreError{
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+	if s.sharedTable && strings.Contains(eh.NamespaceFromContext(ctx), sharedKeySeparator) {
+		return ctx, eh.EventStoreError{
+			Err:       ErrInvalidSharedNamespace,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+	return withErrorClassifier(ctx, s.errorClassifier), nil
+}
+
+// namespace resolves the namespace of a context for the repo, and adds the
+// error classifier for the metrics. It fails if the repo is closed.
+func (r *Repo) namespace(ctx context.Context) (context.Context, error) {
+	if r.lifecycle.isClosed() {
+		return ctx, eh.RepoError{
+			Err:       ErrClosed,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	ctx, err := resolveNamespace(ctx, r.namespaceProvider)
+	if err != nil {
+		return ctx, eh.RepoError{
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+	return withErrorClassifier(ctx, r.errorClassifier), nil
+}
+
+// namespace resolves the namespace of a context for the snapshot store.
+func (s *SnapshotStore) namespace(ctx context.Context) (context.Context, error) {
+	ctx, err := resolveNamespace(ctx, s.namespaceProvider)
+	if err != nil {
+		return ctx, eh.EventStoreError{
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+	return ctx, nil
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/stretchr/testify/assert"
+)
+
+// tenantKey is the context key of the tenant in tests.
+type tenantKey struct{}
+
+func TestResolveNamespace(t *testing.T) {
+	calls := 0
+	provider := func(ctx context.Context) (string, error) {
+		calls++
+		if tenant, ok := ctx.Value(tenantKey{}).(string); ok {
+			return tenant, nil
+		}
+		return "", errors.New("no tenant")
+	}
+
+	// Without a provider the context is used as is.
+	ctx := eh.NewContextWithNamespace(context.Background(), "ns")
+	resolved, err := resolveNamespace(ctx, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "ns", eh.NamespaceFromContext(resolved))
+
+	// The provider sets the namespace.
+	ctx = context.WithValue(context.Background(), tenantKey{}, "tenant-a")
+	resolved, err = resolveNamespace(ctx, provider)
+	assert.Nil(t, err)
+	assert.Equal(t, "tenant-a", eh.NamespaceFromContext(resolved))
+	assert.Equal(t, 1, calls)
+
+	// A resolved context is not resolved again.
+	resolved, err = resolveNamespace(resolved, provider)
+	assert.Nil(t, err)
+	assert.Equal(t, "tenant-a", eh.NamespaceFromContext(resolved))
+	assert.Equal(t, 1, calls)
+
+	// An explicit namespace is not resolved.
+	resolved, err = resolveNamespace(NewContextWithExplicitNamespace(context.Background(), "other"), provider)
+	assert.Nil(t, err)
+	assert.Equal(t, "other", eh.NamespaceFromContext(resolved))
+	assert.Equal(t, 1, calls)
+
+	// Provider errors fail the operation.
+	s := &EventStore{namespaceProvider: provider}
+	_, err = s.namespace(context.Background())
+	var storeErr eh.EventStoreError
+	assert.True(t, errors.As(err, &storeErr))
+	assert.EqualError(t, storeErr.Err, "no tenant")
+}
@@ -0,0 +1,449 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/guregu/dynamo"
+)
+
+// expiresAtAttr is the TTL attribute of event items.
+const expiresAtAttr = "ExpiresAt"
+
+// NamespaceConfig is the table policy for a namespace.
+type NamespaceConfig struct {
+	// BillingMode is either dynamodb.BillingModePayPerRequest or
+	// dynamodb.BillingModeProvisioned. Empty uses the dynamo package default.
+	BillingMode string
+	// ReadCapacity is the provisioned read capacity.
+	ReadCapacity int64
+	// WriteCapacity is the provisioned write capacity.
+	WriteCapacity int64
+	// TableClass is either dynamodb.TableClassStandard or
+	// dynamodb.TableClassStandardInfrequentAccess. Empty uses the DynamoDB
+	// default, which is the standard class.
+	TableClass string
+	// KMSKeyID is the customer managed KMS key used to encrypt the tables,
+	// and the data keys of events with WithEncryption. Empty uses the AWS
+	// owned key for the tables and the key of WithEncryption for events.
+	KMSKeyID string
+	// Retention is how long events are kept before they are expired by
+	// DynamoDB TTL, counted from the event timestamp. Zero keeps them forever.
+	// It does not apply to repo tables.
+	Retention time.Duration
+	// Tags are the tags of the tables, for example for cost allocation.
+	Tags map[string]string
+	// PointInTimeRecovery enables point-in-time recovery of the tables.
+	PointInTimeRecovery bool
+}
+
+// NamespaceConfigs is a registry of table policies per namespace, with a
+// default for namespaces that are not configured. It can be changed at
+// runtime, for example when a new tenant is added.
+type NamespaceConfigs struct {
+	mu         sync.RWMutex
+	defaultCfg NamespaceConfig
+	configs    map[string]NamespaceConfig
+}
+
+// NewNamespaceConfigs creates a new registry with a default config.
+func NewNamespaceConfigs(defaultCfg NamespaceConfig) *NamespaceConfigs {
+	return &NamespaceConfigs{
+		defaultCfg: defaultCfg,
+		configs:    map[string]NamespaceConfig{},
+	}
+}
+
+// WithNamespaceConfigs uses a registry of per-namespace table policies.
+func WithNamespaceConfigs(c *NamespaceConfigs) Option {
+	return func(s *EventStore) error {
+		s.namespaceConfigs = c
+		return nil
+	}
+}
+
+// WithRepoNamespaceConfigs uses a registry of per-namespace table policies.
+func WithRepoNamespaceConfigs(c *NamespaceConfigs) OptionRepo {
+	return func(r *Repo) error {
+		r.namespaceConfigs = c
+		return nil
+	}
+}
+
+// WithTableConfig sets the table policy of namespaces that are not
+// configured otherwise, with billing mode, capacity, table class and tags,
+// creating a registry if there is none.
+func WithTableConfig(cfg NamespaceConfig) Option {
+	return func(s *EventStore) error {
+		if s.namespaceConfigs == nil {
+			s.namespaceConfigs = NewNamespaceConfigs(cfg)
+		} else {
+			s.namespaceConfigs.SetDefault(cfg)
+		}
+		return nil
+	}
+}
+
+// WithRepoTableConfig sets the table policy of namespaces that are not
+// configured otherwise, with billing mode, capacity, table class and tags,
+// creating a registry if there is none.
+func WithRepoTableConfig(cfg NamespaceConfig) OptionRepo {
+	return func(r *Repo) error {
+		if r.namespaceConfigs == nil {
+			r.namespaceConfigs = NewNamespaceConfigs(cfg)
+		} else {
+			r.namespaceConfigs.SetDefault(cfg)
+		}
+		return nil
+	}
+}
+
+// WithTableEncryption encrypts the tables that are created with a customer
+// managed KMS key, for namespaces that are not configured otherwise.
+func WithTableEncryption(kmsKeyID string) Option {
+	return func(s *EventStore) error {
+		s.namespaceConfigs = s.namespaceConfigs.updateDefault(func(cfg *NamespaceConfig) {
+			cfg.KMSKeyID = kmsKeyID
+		})
+		return nil
+	}
+}
+
+// WithRepoTableEncryption encrypts the tables that are created with a
+// customer managed KMS key, for namespaces that are not configured otherwise.
+func WithRepoTableEncryption(kmsKeyID string) OptionRepo {
+	return func(r *Repo) error {
+		r.namespaceConfigs = r.namespaceConfigs.updateDefault(func(cfg *NamespaceConfig) {
+			cfg.KMSKeyID = kmsKeyID
+		})
+		return nil
+	}
+}
+
+// WithPointInTimeRecovery enables point-in-time recovery on the tables that
+// are created, once they are active, for namespaces that are not configured
+// otherwise.
+func WithPointInTimeRecovery() Option {
+	return func(s *EventStore) error {
+		s.namespaceConfigs = s.namespaceConfigs.updateDefault(func(cfg *NamespaceConfig) {
+			cfg.PointInTimeRecovery = true
+		})
+		return nil
+	}
+}
+
+// WithRepoPointInTimeRecovery enables point-in-time recovery on the tables
+// that are created, once they are active, for namespaces that are not
+// configured otherwise.
+func WithRepoPointInTimeRecovery() OptionRepo {
+	return func(r *Repo) error {
+		r.namespaceConfigs = r.namespaceConfigs.updateDefault(func(cfg *NamespaceConfig) {
+			cfg.PointInTimeRecovery = true
+		})
+		return nil
+	}
+}
+
+// updateDefault changes the default config, creating a registry if there is
+// none, and returns the registry.
+func (c *NamespaceConfigs) updateDefault(update func(*NamespaceConfig)) *NamespaceConfigs {
+	if c == nil {
+		c = NewNamespaceConfigs(NamespaceConfig{})
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	update(&c.defaultCfg)
+	return c
+}
+
+// SetDefault sets the config of namespaces that are not configured.
+func (c *NamespaceConfigs) SetDefault(cfg NamespaceConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.defaultCfg = cfg
+}
+
+// Set sets the config of a namespace.
+func (c *NamespaceConfigs) Set(namespace string, cfg NamespaceConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.configs[namespace] = cfg
+}
+
+// Get returns the config of a namespace, or the default config.
+func (c *NamespaceConfigs) Get(namespace string) NamespaceConfig {
+	if c == nil {
+		return NamespaceConfig{}
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if cfg, ok := c.configs[namespace]; ok {
+		return cfg
+	}
+	return c.defaultCfg
+}
+
+// applyCreateTable applies the billing mode to a table creation.
+func (cfg NamespaceConfig) applyCreateTable(ct *dynamo.CreateTable) *dynamo.CreateTable {
+	switch cfg.BillingMode {
+	case dynamodb.BillingModePayPerRequest:
+		ct = ct.OnDemand(true)
+	case dynamodb.BillingModeProvisioned:
+		ct = ct.Provision(cfg.ReadCapacity, cfg.WriteCapacity)
+	}
+	return ct
+}
+
+type tableClassKey int
+
+// tableClassCtxKey is the context key of the table class of created tables.
+const tableClassCtxKey tableClassKey = iota
+
+// withTableClass returns a context that creates tables with a table class.
+func withTableClass(ctx context.Context, class string) context.Context {
+	if class == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, tableClassCtxKey, class)
+}
+
+// applyTableClass adds a handler to the DynamoDB client of a service that
+// sets the table class of the context on table creations, as the dynamo
+// package has no option for it.
+func applyTableClass(db *dynamo.DB) {
+	c, ok := db.Client().(*dynamodb.DynamoDB)
+	if !ok {
+		return
+	}
+
+	c.Handlers.Validate.PushFront(func(r *request.Request) {
+		input, ok := r.Params.(*dynamodb.CreateTableInput)
+		if !ok || input.TableClass != nil {
+			return
+		}
+		if class, ok := r.Context().Value(tableClassCtxKey).(string); ok {
+			input.TableClass = aws.String(class)
+		}
+	})
+}
+
+// tableClass returns the table class of a table.
+func tableClass(table *dynamodb.TableDescription) string {
+	if table.TableClassSummary == nil || table.TableClassSummary.TableClass == nil {
+		return dynamodb.TableClassStandard
+	}
+	return aws.StringValue(table.TableClassSummary.TableClass)
+}
+
+// configureTable configures the table class, encryption, point-in-time
+// recovery, TTL and tags on a table. It is run every time the table is created or found to exist,
+// so that a table whose creator failed before configuring it still gets its
+// config, and only changes what differs from the current settings of the
+// table. The TTL is only enabled when there is a TTL attribute.
+func (cfg NamespaceConfig) configureTable(ctx context.Context, client dynamodbiface.DynamoDBAPI, tableName, ttlAttr string) error {
+	out, err := client.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(tableName),
+	})
+	if err != nil {
+		return err
+	}
+
+	// The table class is set on creation, but not when another creator got
+	// there first or the client has no handlers.
+	if cfg.TableClass != "" && tableClass(out.Table) != cfg.TableClass {
+		if _, err := client.UpdateTableWithContext(ctx, &dynamodb.UpdateTableInput{
+			TableName:  aws.String(tableName),
+			TableClass: aws.String(cfg.TableClass),
+		}); err != nil {
+			return err
+		}
+		if err := waitForTableActive(ctx, client, tableName); err != nil {
+			return err
+		}
+	}
+
+	if cfg.KMSKeyID != "" && !kmsKeyEnabled(out.Table.SSEDescription, cfg.KMSKeyID) {
+		if _, err := client.UpdateTableWithContext(ctx, &dynamodb.UpdateTableInput{
+			TableName: aws.String(tableName),
+			SSESpecification: &dynamodb.SSESpecification{
+				Enabled:        aws.Bool(true),
+				SSEType:        aws.String(dynamodb.SSETypeKms),
+				KMSMasterKeyId: aws.String(cfg.KMSKeyID),
+			},
+		}); err != nil {
+			return err
+		}
+		if err := waitForTableActive(ctx, client, tableName); err != nil {
+			return err
+		}
+	}
+
+	if cfg.PointInTimeRecovery {
+		backups, err := client.DescribeContinuousBackupsWithContext(ctx, &dynamodb.DescribeContinuousBackupsInput{
+			TableName: aws.String(tableName),
+		})
+		if err != nil && !isAWSErrorCode(err, dynamodb.ErrCodeContinuousBackupsUnavailableException) {
+			return err
+		}
+		if err != nil || backups.ContinuousBackupsDescription == nil ||
+			backups.ContinuousBackupsDescription.PointInTimeRecoveryDescription == nil ||
+			aws.StringValue(backups.ContinuousBackupsDescription.PointInTimeRecoveryDescription.PointInTimeRecoveryStatus) != dynamodb.PointInTimeRecoveryStatusEnabled {
+			if err := enablePointInTimeRecovery(ctx, client, tableName); err != nil {
+				return err
+			}
+		}
+	}
+
+	if ttlAttr != "" {
+		ttl, err := client.DescribeTimeToLiveWithContext(ctx, &dynamodb.DescribeTimeToLiveInput{
+			TableName: aws.String(tableName),
+		})
+		if err != nil {
+			return err
+		}
+		if d := ttl.TimeToLiveDescription; d == nil || aws.StringValue(d.AttributeName) != ttlAttr ||
+			(aws.StringValue(d.TimeToLiveStatus) != dynamodb.TimeToLiveStatusEnabled &&
+				aws.StringValue(d.TimeToLiveStatus) != dynamodb.TimeToLiveStatusEnabling) {
+			if _, err := client.UpdateTimeToLiveWithContext(ctx, &dynamodb.UpdateTimeToLiveInput{
+				TableName: aws.String(tableName),
+				TimeToLiveSpecification: &dynamodb.TimeToLiveSpecification{
+					AttributeName: aws.String(ttlAttr),
+					Enabled:       aws.Bool(true),
+				},
+			}); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(cfg.Tags) > 0 {
+		tags, err := cfg.missingTags(ctx, client, out.Table.TableArn)
+		if err != nil {
+			return err
+		}
+		if len(tags) > 0 {
+			if _, err := client.TagResourceWithContext(ctx, &dynamodb.TagResourceInput{
+				ResourceArn: out.Table.TableArn,
+				Tags:        tags,
+			}); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// kmsKeyEnabled checks if a table is encrypted, or being encrypted, with a
+// KMS key. The table reports the ARN of the key, which is matched with a key
+// ID or ARN; a key alias can't be compared, and any KMS key is accepted for
+// it.
+func kmsKeyEnabled(sse *dynamodb.SSEDescription, kmsKeyID string) bool {
+	if sse == nil || aws.StringValue(sse.SSEType) != dynamodb.SSETypeKms {
+		return false
+	}
+	if status := aws.StringValue(sse.Status); status != dynamodb.SSEStatusEnabled &&
+		status != dynamodb.SSEStatusEnabling && status != dynamodb.SSEStatusUpdating {
+		return false
+	}
+	arn := aws.StringValue(sse.KMSMasterKeyArn)
+	return arn == kmsKeyID || strings.HasSuffix(arn, ":key/"+kmsKeyID) ||
+		strings.HasPrefix(kmsKeyID, "alias/") || strings.Contains(kmsKeyID, ":alias/")
+}
+
+// missingTags returns the tags of the config that a table doesn't have with
+// the same value.
+func (cfg NamespaceConfig) missingTags(ctx context.Context, client dynamodbiface.DynamoDBAPI, arn *string) ([]*dynamodb.Tag, error) {
+	current := map[string]string{}
+	input := &dynamodb.ListTagsOfResourceInput{ResourceArn: arn}
+	for {
+		out, err := client.ListTagsOfResourceWithContext(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		for _, tag := range out.Tags {
+			current[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+		}
+		if out.NextToken == nil {
+			break
+		}
+		input.NextToken = out.NextToken
+	}
+
+	var tags []*dynamodb.Tag
+	for _, tag := range cfg.tags() {
+		if value, ok := current[aws.StringValue(tag.Key)]; !ok || value != aws.StringValue(tag.Value) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags, nil
+}
+
+// tags returns the tags of the config in key order.
+func (cfg NamespaceConfig) tags() []*dynamodb.Tag {
+	keys := make([]string, 0, len(cfg.Tags))
+	for key := range cfg.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	tags := make([]*dynamodb.Tag, len(keys))
+	for i, key := range keys {
+		tags[i] = &dynamodb.Tag{Key: aws.String(key), Value: aws.String(cfg.Tags[key])}
+	}
+	return tags
+}
+
+// enablePointInTimeRecovery enables point-in-time recovery on a table. The
+// continuous backups of a new table can be unavailable for a short while
+// after it is active, which is polled with jitter.
+func enablePointInTimeRecovery(ctx context.Context, client dynamodbiface.DynamoDBAPI, tableName string) error {
+	w := tableWaiterFromContext(ctx)
+	ctx, cancel := context.WithTimeout(ctx, w.Timeout)
+	defer cancel()
+
+	for {
+		_, err := client.UpdateContinuousBackupsWithContext(ctx, &dynamodb.UpdateContinuousBackupsInput{
+			TableName: aws.String(tableName),
+			PointInTimeRecoverySpecification: &dynamodb.PointInTimeRecoverySpecification{
+				PointInTimeRecoveryEnabled: aws.Bool(true),
+			},
+		})
+		if err == nil || !isAWSErrorCode(err, dynamodb.ErrCodeContinuousBackupsUnavailableException) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(jitter(w.PollInterval)):
+		}
+	}
+}
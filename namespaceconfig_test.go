@@ -0,0 +1,254 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/google/uuid"
+	"github.com/guregu/dynamo"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamespaceConfigs(t *testing.T) {
+	defaultCfg := NamespaceConfig{BillingMode: dynamodb.BillingModePayPerRequest}
+	configs := NewNamespaceConfigs(defaultCfg)
+
+	tenantCfg := NamespaceConfig{
+		BillingMode:   dynamodb.BillingModeProvisioned,
+		ReadCapacity:  5,
+		WriteCapacity: 5,
+		KMSKeyID:      "alias/tenant",
+		Retention:     24 * time.Hour,
+	}
+	configs.Set("tenant", tenantCfg)
+
+	assert.Equal(t, tenantCfg, configs.Get("tenant"))
+	assert.Equal(t, defaultCfg, configs.Get("other"))
+
+	// A missing registry has the zero config.
+	var none *NamespaceConfigs
+	assert.Equal(t, NamespaceConfig{}, none.Get("tenant"))
+}
+
+func TestWithTableConfig(t *testing.T) {
+	cfg := NamespaceConfig{
+		BillingMode:   dynamodb.BillingModeProvisioned,
+		ReadCapacity:  5,
+		WriteCapacity: 10,
+		Tags:          map[string]string{"team": "orders", "env": "prod"},
+	}
+
+	s := &EventStore{}
+	assert.Nil(t, WithTableConfig(cfg)(s))
+	assert.Equal(t, cfg, s.namespaceConfigs.Get("ns"))
+
+	// An existing registry keeps its namespace configs.
+	configs := NewNamespaceConfigs(NamespaceConfig{})
+	configs.Set("tenant", NamespaceConfig{KMSKeyID: "alias/tenant"})
+	r := &Repo{namespaceConfigs: configs}
+	assert.Nil(t, WithRepoTableConfig(cfg)(r))
+	assert.Equal(t, cfg, r.namespaceConfigs.Get("ns"))
+	assert.Equal(t, "alias/tenant", r.namespaceConfigs.Get("tenant").KMSKeyID)
+
+	tags := cfg.tags()
+	if assert.Len(t, tags, 2) {
+		assert.Equal(t, "env", *tags[0].Key)
+		assert.Equal(t, "prod", *tags[0].Value)
+		assert.Equal(t, "team", *tags[1].Key)
+	}
+}
+
+func TestWithTableEncryptionAndPointInTimeRecovery(t *testing.T) {
+	s := &EventStore{}
+	assert.Nil(t, WithTableEncryption("alias/events")(s))
+	assert.Nil(t, WithPointInTimeRecovery()(s))
+	assert.Equal(t, NamespaceConfig{KMSKeyID: "alias/events", PointInTimeRecovery: true}, s.namespaceConfigs.Get("ns"))
+
+	// The rest of the default config is kept.
+	r := &Repo{}
+	assert.Nil(t, WithRepoTableConfig(NamespaceConfig{BillingMode: dynamodb.BillingModePayPerRequest})(r))
+	assert.Nil(t, WithRepoTableEncryption("alias/repo")(r))
+	assert.Nil(t, WithRepoPointInTimeRecovery()(r))
+	assert.Equal(t, NamespaceConfig{
+		BillingMode:         dynamodb.BillingModePayPerRequest,
+		KMSKeyID:            "alias/repo",
+		PointInTimeRecovery: true,
+	}, r.namespaceConfigs.Get("ns"))
+}
+
+// configClient is a DynamoDB client with the settings of a table, which
+// records the updates of the settings.
+type configClient struct {
+	dynamodbiface.DynamoDBAPI
+	class   *dynamodb.TableClassSummary
+	sse     *dynamodb.SSEDescription
+	pitr    string
+	ttl     *dynamodb.TimeToLiveDescription
+	tags    []*dynamodb.Tag
+	updates []string
+}
+
+func (c *configClient) DescribeTableWithContext(ctx aws.Context, in *dynamodb.DescribeTableInput, opts ...request.Option) (*dynamodb.DescribeTableOutput, error) {
+	return &dynamodb.DescribeTableOutput{Table: &dynamodb.TableDescription{
+		TableArn:          aws.String("arn:aws:dynamodb:us-east-1:123456789012:table/" + aws.StringValue(in.TableName)),
+		TableStatus:       aws.String(dynamodb.TableStatusActive),
+		TableClassSummary: c.class,
+		SSEDescription:    c.sse,
+	}}, nil
+}
+
+func (c *configClient) UpdateTableWithContext(ctx aws.Context, in *dynamodb.UpdateTableInput, opts ...request.Option) (*dynamodb.UpdateTableOutput, error) {
+	if in.TableClass != nil {
+		c.updates = append(c.updates, "class "+aws.StringValue(in.TableClass))
+	}
+	if in.SSESpecification != nil {
+		c.updates = append(c.updates, "sse")
+	}
+	return &dynamodb.UpdateTableOutput{}, nil
+}
+
+func (c *configClient) DescribeContinuousBackupsWithContext(ctx aws.Context, in *dynamodb.DescribeContinuousBackupsInput, opts ...request.Option) (*dynamodb.DescribeContinuousBackupsOutput, error) {
+	return &dynamodb.DescribeContinuousBackupsOutput{ContinuousBackupsDescription: &dynamodb.ContinuousBackupsDescription{
+		PointInTimeRecoveryDescription: &dynamodb.PointInTimeRecoveryDescription{
+			PointInTimeRecoveryStatus: aws.String(c.pitr),
+		},
+	}}, nil
+}
+
+func (c *configClient) UpdateContinuousBackupsWithContext(ctx aws.Context, in *dynamodb.UpdateContinuousBackupsInput, opts ...request.Option) (*dynamodb.UpdateContinuousBackupsOutput, error) {
+	c.updates = append(c.updates, "pitr")
+	return &dynamodb.UpdateContinuousBackupsOutput{}, nil
+}
+
+func (c *configClient) DescribeTimeToLiveWithContext(ctx aws.Context, in *dynamodb.DescribeTimeToLiveInput, opts ...request.Option) (*dynamodb.DescribeTimeToLiveOutput, error) {
+	return &dynamodb.DescribeTimeToLiveOutput{TimeToLiveDescription: c.ttl}, nil
+}
+
+func (c *configClient) UpdateTimeToLiveWithContext(ctx aws.Context, in *dynamodb.UpdateTimeToLiveInput, opts ...request.Option) (*dynamodb.UpdateTimeToLiveOutput, error) {
+	c.updates = append(c.updates, "ttl")
+	return &dynamodb.UpdateTimeToLiveOutput{}, nil
+}
+
+func (c *configClient) ListTagsOfResourceWithContext(ctx aws.Context, in *dynamodb.ListTagsOfResourceInput, opts ...request.Option) (*dynamodb.ListTagsOfResourceOutput, error) {
+	return &dynamodb.ListTagsOfResourceOutput{Tags: c.tags}, nil
+}
+
+func (c *configClient) TagResourceWithContext(ctx aws.Context, in *dynamodb.TagResourceInput, opts ...request.Option) (*dynamodb.TagResourceOutput, error) {
+	for _, tag := range in.Tags {
+		c.updates = append(c.updates, "tag "+aws.StringValue(tag.Key))
+	}
+	return &dynamodb.TagResourceOutput{}, nil
+}
+
+func TestConfigureTable(t *testing.T) {
+	ctx := context.Background()
+	cfg := NamespaceConfig{
+		TableClass:          dynamodb.TableClassStandardInfrequentAccess,
+		KMSKeyID:            "1234abcd-12ab-34cd-56ef-1234567890ab",
+		PointInTimeRecovery: true,
+		Tags:                map[string]string{"team": "orders", "env": "prod"},
+	}
+
+	// A table that was created without its config gets all of it.
+	client := &configClient{}
+	assert.Nil(t, cfg.configureTable(ctx, client, "test", expiresAtAttr))
+	assert.Equal(t, []string{"class STANDARD_INFREQUENT_ACCESS", "sse", "pitr", "ttl", "tag env", "tag team"}, client.updates)
+
+	// A configured table is left as is, and only differing tags are set.
+	client = &configClient{
+		class: &dynamodb.TableClassSummary{
+			TableClass: aws.String(dynamodb.TableClassStandardInfrequentAccess),
+		},
+		sse: &dynamodb.SSEDescription{
+			Status:          aws.String(dynamodb.SSEStatusEnabled),
+			SSEType:         aws.String(dynamodb.SSETypeKms),
+			KMSMasterKeyArn: aws.String("arn:aws:kms:us-east-1:123456789012:key/" + cfg.KMSKeyID),
+		},
+		pitr: dynamodb.PointInTimeRecoveryStatusEnabled,
+		ttl: &dynamodb.TimeToLiveDescription{
+			AttributeName:    aws.String(expiresAtAttr),
+			TimeToLiveStatus: aws.String(dynamodb.TimeToLiveStatusEnabled),
+		},
+		tags: []*dynamodb.Tag{
+			{Key: aws.String("env"), Value: aws.String("prod")},
+			{Key: aws.String("team"), Value: aws.String("billing")},
+		},
+	}
+	assert.Nil(t, cfg.configureTable(ctx, client, "test", expiresAtAttr))
+	assert.Equal(t, []string{"tag team"}, client.updates)
+
+	// A table encrypted with another key is encrypted with the key of the
+	// config.
+	client.sse.KMSMasterKeyArn = aws.String("arn:aws:kms:us-east-1:123456789012:key/other")
+	client.updates = nil
+	assert.Nil(t, cfg.configureTable(ctx, client, "test", ""))
+	assert.Equal(t, []string{"sse", "tag team"}, client.updates)
+}
+
+// TestNamespaceRetention will make sure that events get the expiry of their namespace
+func (suite *EventStoreTestSuite) TestNamespaceRetention() {
+	configs := NewNamespaceConfigs(NamespaceConfig{})
+	configs.Set("ns", NamespaceConfig{Retention: time.Hour})
+	store := suite.newStore(WithNamespaceConfigs(configs))
+
+	id := uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	event := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event1"},
+		timestamp, mocks.AggregateType, id, 1)
+	assert.Nil(suite.T(), store.Save(suite.ctx, []eh.Event{event}, 0))
+	assert.Nil(suite.T(), store.Save(context.Background(), []eh.Event{event}, 0))
+
+	var e dbEvent
+	err := store.service.Table(store.tableName(suite.ctx)).
+		Get("AggregateID", id.String()).Range("Version", dynamo.Equal, 1).One(&e)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), timestamp.Add(time.Hour).Unix(), e.ExpiresAt)
+
+	var e2 dbEvent
+	err = store.service.Table(store.tableName(context.Background())).
+		Get("AggregateID", id.String()).Range("Version", dynamo.Equal, 1).One(&e2)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), int64(0), e2.ExpiresAt)
+}
+
+func TestTableClass(t *testing.T) {
+	sess := session.Must(session.NewSession(&aws.Config{Region: aws.String("us-west-2")}))
+	db := dynamo.New(sess)
+	applyTableClass(db)
+	client := db.Client().(*dynamodb.DynamoDB)
+
+	ctx := withTableClass(context.Background(), dynamodb.TableClassStandardInfrequentAccess)
+	req, _ := client.CreateTableRequest(&dynamodb.CreateTableInput{TableName: aws.String("events")})
+	req.SetContext(ctx)
+	req.Handlers.Validate.Run(req)
+	assert.Equal(t, dynamodb.TableClassStandardInfrequentAccess,
+		aws.StringValue(req.Params.(*dynamodb.CreateTableInput).TableClass))
+
+	// Without a table class the default is used.
+	req, _ = client.CreateTableRequest(&dynamodb.CreateTableInput{TableName: aws.String("events")})
+	req.SetContext(context.Background())
+	req.Handlers.Validate.Run(req)
+	assert.Nil(t, req.Params.(*dynamodb.CreateTableInput).TableClass)
+}
@@ -0,0 +1,155 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// renameParallelism is the number of namespaces that are renamed at once.
+const renameParallelism = 4
+
+// NamespaceRenameResult is the result of renaming an event type in one namespace.
+type NamespaceRenameResult struct {
+	Namespace string
+	Err       error
+}
+
+// Namespaces returns the namespaces that have an event table, found by
+// listing the tables with the table prefix of the store. It only finds
+// namespaces of the default table naming, in the environment of the store.
+// Tables with another key than event tables, like the outbox, payload, key,
+// cursor and lease tables, are left out, and monthly partitions are
+// listed as the namespace they belong to. Use NewContextWithExplicitNamespace
+// to operate on one of them. With a shared table the namespaces are found by
+// scanning the table instead.
+func (s *EventStore) Namespaces(ctx context.Context) ([]string, error) {
+	if s.sharedTable {
+		return s.sharedNamespaces(ctx)
+	}
+
+	prefix := s.tablePrefix + "_"
+
+	var tables []string
+	err := s.service.Client().ListTablesPagesWithContext(ctx, &dynamodb.ListTablesInput{},
+		func(out *dynamodb.ListTablesOutput, last bool) bool {
+			for _, name := range out.TableNames {
+				if tableName := aws.StringValue(name); strings.HasPrefix(tableName, prefix) {
+					tables = append(tables, tableName)
+				}
+			}
+			return true
+		})
+	if err != nil {
+		return nil, eh.EventStoreError{
+			BaseErr:   withRequestID(err),
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	seen := map[string]bool{}
+	var namespaces []string
+	for _, tableName := range tables {
+		// Monthly partitions belong to the namespace of their base table.
+		ns := tableName
+		if isPartitionTable(ns) {
+			ns = ns[:len(ns)-len(partitionLayout)-1]
+		}
+		ns, ok := s.environment.trim(ns)
+		if !ok || seen[ns] {
+			continue
+		}
+
+		isEvents, err := s.hasEventKey(ctx, tableName)
+		if err != nil {
+			return nil, err
+		} else if !isEvents {
+			continue
+		}
+		seen[ns] = true
+		namespaces = append(namespaces, strings.TrimPrefix(ns, prefix))
+	}
+
+	sort.Strings(namespaces)
+	return namespaces, nil
+}
+
+// hasEventKey checks if a table has the key of event tables. Tables that are
+// deleted meanwhile do not.
+func (s *EventStore) hasEventKey(ctx context.Context, tableName string) (bool, error) {
+	start := time.Now()
+	out, err := s.service.Client().DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(tableName),
+	})
+	observe(ctx, s.metrics, OperationDescribeTable, tableName, start, err)
+	if isAWSErrorCode(err, dynamodb.ErrCodeResourceNotFoundException) {
+		return false, nil
+	} else if err != nil {
+		return false, eh.EventStoreError{
+			BaseErr:   withRequestID(err),
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	keys := map[string]string{}
+	for _, k := range out.Table.KeySchema {
+		keys[aws.StringValue(k.KeyType)] = aws.StringValue(k.AttributeName)
+	}
+	return len(keys) == 2 &&
+		keys[dynamodb.KeyTypeHash] == "AggregateID" &&
+		keys[dynamodb.KeyTypeRange] == "Version", nil
+}
+
+// RenameEventAllNamespaces renames an event type in every namespace that is
+// found by Namespaces, a few namespaces at a time. A failure in one namespace
+// does not stop the others; the result of each namespace is reported in order
+// of namespace. The error is only set when the namespaces could not be listed.
+func (s *EventStore) RenameEventAllNamespaces(ctx context.Context, from, to eh.EventType) ([]NamespaceRenameResult, error) {
+	namespaces, err := s.Namespaces(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]NamespaceRenameResult, len(namespaces))
+	sem := make(chan struct{}, renameParallelism)
+	var wg sync.WaitGroup
+	for i, ns := range namespaces {
+		results[i].Namespace = ns
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(result *NamespaceRenameResult) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			nsCtx := NewContextWithExplicitNamespace(ctx, result.Namespace)
+			result.Err = s.RenameEvent(nsCtx, from, to)
+		}(&results[i])
+	}
+	wg.Wait()
+
+	return results, nil
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRenameEventAllNamespaces will rename an event type in every namespace
+func (suite *EventStoreTestSuite) TestRenameEventAllNamespaces() {
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	id := uuid.New()
+	event := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event1"},
+		timestamp, mocks.AggregateType, id, 1)
+	assert.Nil(suite.T(), suite.store.Save(context.Background(), []eh.Event{event}, 0))
+	assert.Nil(suite.T(), suite.store.Save(suite.ctx, []eh.Event{event}, 0))
+
+	results, err := suite.store.RenameEventAllNamespaces(context.Background(), mocks.EventType, mocks.EventOtherType)
+	assert.Nil(suite.T(), err)
+
+	renamed := map[string]error{}
+	for _, result := range results {
+		renamed[result.Namespace] = result.Err
+	}
+	for _, ctx := range []context.Context{context.Background(), suite.ctx} {
+		ns := eh.NamespaceFromContext(ctx)
+		err, ok := renamed[ns]
+		assert.True(suite.T(), ok, "namespace should be renamed: "+ns)
+		assert.Nil(suite.T(), err)
+
+		events, err := suite.store.Load(ctx, id)
+		assert.Nil(suite.T(), err)
+		if assert.Len(suite.T(), events, 1) {
+			assert.Equal(suite.T(), mocks.EventOtherType, events[0].EventType())
+		}
+	}
+}
+
+// TestNamespaces will list the namespaces of the event tables only
+func (suite *EventStoreTestSuite) TestNamespaces() {
+	store := suite.newStore(WithMonthlyPartitions(), WithOutbox("test_outbox", 0, nil))
+	assert.Nil(suite.T(), store.CreateTable(suite.ctx))
+	defer store.deleteTable(suite.ctx, "test_outbox")
+
+	id := uuid.New()
+	for i, month := range []time.Month{time.November, time.December} {
+		timestamp := time.Date(2009, month, 10, 23, 0, 0, 0, time.UTC)
+		event := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event"},
+			timestamp, mocks.AggregateType, id, i+1)
+		assert.Nil(suite.T(), store.Save(suite.ctx, []eh.Event{event}, i))
+	}
+	defer store.DeleteTable(suite.ctx)
+
+	namespaces, err := store.Namespaces(context.Background())
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), []string{eh.NamespaceFromContext(context.Background()), "ns"}, namespaces)
+}
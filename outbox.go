@@ -0,0 +1,209 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/google/uuid"
+	"github.com/guregu/dynamo"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// outbox is the config of the outbox mode.
+type outbox struct {
+	tableName string
+	interval  time.Duration
+	onError   func(error)
+	done      chan struct{}
+}
+
+// WithOutbox enables the outbox mode for the event handler. Save writes a
+// dispatch record to the outbox table in the same transaction as the events,
+// and removes it once the event handler has handled the events. Records that
+// are left behind, because the handler failed or the process stopped after
+// the write, are relayed to the handler again every interval, so that no
+// saved events are lost for the handler. Events may be handled more than
+// once. Errors from relaying in the background are passed to onError if set.
+// The outbox table is shared by all namespaces and created by CreateTable.
+func WithOutbox(tableName string, interval time.Duration, onError func(error)) Option {
+	return func(s *EventStore) error {
+		s.outbox = &outbox{
+			tableName: tableName,
+			interval:  interval,
+			onError:   onError,
+			done:      make(chan struct{}),
+		}
+		s.onClose(func(context.Context) error {
+			close(s.outbox.done)
+			return nil
+		})
+		return nil
+	}
+}
+
+// RelayOutbox relays the dispatch records of all namespaces that are older
+// than the relay interval to the event handler, in version order per
+// aggregate, and removes the records that were handled. When records fail,
+// a PartialFailureError is returned with an outcome per record; the later
+// records of an aggregate with a failed record are skipped and fail too.
+func (s *EventStore) RelayOutbox(ctx context.Context) error {
+	if s.outbox == nil {
+		return nil
+	}
+
+	var records []dbOutboxRecord
+	start := time.Now()
+	err := s.service.Table(s.outbox.tableName).
+		Scan().
+		Filter("CreatedAt < ?", s.now().Add(-s.outbox.interval)).
+		Consistent(true).
+		AllWithContext(ctx, &records)
+	observe(ctx, s.metrics, OperationScan, s.outbox.tableName, start, err)
+	if err != nil {
+		return eh.EventStoreError{
+			BaseErr:   withRequestID(err),
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	// Skip the later records of aggregates with failed records, to keep the
+	// order of their events.
+	failed := map[uuid.UUID]string{}
+	outcomes := make([]ItemOutcome, len(records))
+	for i, r := range records {
+		key := r.Namespace + "/" + r.Key
+		outcomes[i].Key = key
+		if failedKey, ok := failed[r.AggregateID]; ok {
+			outcomes[i].Err = fmt.Errorf("skipped after the failure of %s", failedKey)
+			continue
+		}
+		outcomes[i].Attempts = 1
+		if err := s.relay(NewContextWithExplicitNamespace(ctx, r.Namespace), r); err != nil {
+			failed[r.AggregateID] = key
+			outcomes[i].Err = err
+		}
+	}
+
+	return batchResult(outcomes, eh.NamespaceFromContext(ctx))
+}
+
+// relay handles the events of a dispatch record and removes it.
+func (s *EventStore) relay(ctx context.Context, r dbOutboxRecord) error {
+	// The handlers get all events, the load filter is only for rehydration.
+	events, err := s.loadFrom(ctx, r.AggregateID, r.FromVersion, false)
+	if err != nil {
+		return err
+	}
+	// Only the versions of the record, which may have gaps after a rewrite
+	// of the aggregate.
+	var recorded []eh.Event
+	for _, e := range events {
+		if e.Version() <= r.ToVersion {
+			recorded = append(recorded, e)
+		}
+	}
+	events = recorded
+
+	if err := s.handleEvents(ctx, events); err != nil {
+		return err
+	}
+	return s.dispatched(ctx, r.Key)
+}
+
+// runOutbox relays the dispatch records every interval.
+func (s *EventStore) runOutbox() {
+	if s.outbox.interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(s.outbox.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.outbox.done:
+			return
+		case <-ticker.C:
+			if err := s.RelayOutbox(context.Background()); err != nil && s.outbox.onError != nil {
+				s.outbox.onError(err)
+			}
+		}
+	}
+}
+
+// outboxItem returns the transaction item that writes the dispatch record of
+// saved events.
+func (s *EventStore) outboxItem(ctx context.Context, aggregateID uuid.UUID, fromVersion, toVersion int) (*dynamodb.TransactWriteItem, string, error) {
+	r := dbOutboxRecord{
+		Namespace:   eh.NamespaceFromContext(ctx),
+		Key:         outboxKey(aggregateID, fromVersion),
+		AggregateID: aggregateID,
+		FromVersion: fromVersion,
+		ToVersion:   toVersion,
+		CreatedAt:   s.now(),
+	}
+	item, err := dynamo.MarshalItem(r)
+	if err != nil {
+		return nil, "", err
+	}
+	return &dynamodb.TransactWriteItem{
+		Put: &dynamodb.Put{
+			TableName: aws.String(s.outbox.tableName),
+			Item:      item,
+		},
+	}, r.Key, nil
+}
+
+// dispatched removes a handled dispatch record.
+func (s *EventStore) dispatched(ctx context.Context, key string) error {
+	start := time.Now()
+	err := s.service.Table(s.outbox.tableName).
+		Delete("Namespace", eh.NamespaceFromContext(ctx)).
+		Range("Key", key).
+		RunWithContext(ctx)
+	observe(ctx, s.metrics, OperationDeleteItem, s.outbox.tableName, start, err)
+	if err != nil {
+		return eh.EventStoreError{
+			BaseErr:   withRequestID(err),
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+	return nil
+}
+
+// outboxKey returns the key of the dispatch record of events, which sorts
+// the records of an aggregate in version order.
+func outboxKey(aggregateID uuid.UUID, fromVersion int) string {
+	return fmt.Sprintf("%s/%010d", aggregateID, fromVersion)
+}
+
+// dbOutboxRecord is the dispatch record of saved events in the outbox.
+type dbOutboxRecord struct {
+	Namespace string `dynamo:",hash"`
+	// Key is the aggregate ID and the first version of the events.
+	Key string `dynamo:",range"`
+
+	AggregateID uuid.UUID
+	FromVersion int
+	ToVersion   int
+	CreatedAt   time.Time
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestOutbox will relay events that the event handler failed to handle
+func (suite *EventStoreTestSuite) TestOutbox() {
+	handler := &poisonHandler{poison: map[int]bool{1: true}}
+	store := suite.newStore(WithEventHandler(handler), WithOutbox("test_outbox", 0, nil))
+	assert.Nil(suite.T(), store.CreateTable(suite.ctx))
+	defer store.deleteTable(suite.ctx, "test_outbox")
+
+	id := uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	event1 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event1"},
+		timestamp, mocks.AggregateType, id, 1)
+	event2 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event2"},
+		timestamp, mocks.AggregateType, id, 2)
+
+	err := store.Save(suite.ctx, []eh.Event{event1}, 0)
+	_, ok := err.(eh.CouldNotHandleEventError)
+	assert.True(suite.T(), ok)
+
+	var records []dbOutboxRecord
+	assert.Nil(suite.T(), store.service.Table("test_outbox").Scan().All(&records))
+	if assert.Len(suite.T(), records, 1) {
+		assert.Equal(suite.T(), id, records[0].AggregateID)
+		assert.Equal(suite.T(), 1, records[0].FromVersion)
+		assert.Equal(suite.T(), 1, records[0].ToVersion)
+	}
+
+	// A failing relay keeps the record.
+	err = store.RelayOutbox(context.Background())
+	if pfErr, ok := err.(PartialFailureError); assert.True(suite.T(), ok, err) {
+		assert.Equal(suite.T(), []string{
+			eh.NamespaceFromContext(suite.ctx) + "/" + outboxKey(id, 1),
+		}, pfErr.FailedKeys())
+	}
+	handler.poison = nil
+	assert.Nil(suite.T(), store.RelayOutbox(context.Background()))
+	if assert.Len(suite.T(), handler.handled, 1) {
+		assert.Equal(suite.T(), event1.Data(), handler.handled[0].Data())
+	}
+
+	// Handled saves leave no records.
+	assert.Nil(suite.T(), store.Save(suite.ctx, []eh.Event{event2}, 1))
+	assert.Len(suite.T(), handler.handled, 2)
+	records = nil
+	assert.Nil(suite.T(), store.service.Table("test_outbox").Scan().All(&records))
+	assert.Len(suite.T(), records, 0)
+}
@@ -0,0 +1,173 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// ErrCouldNotOffloadEventData is when event data could not be stored in S3.
+var ErrCouldNotOffloadEventData = errors.New("could not offload event data")
+
+// ErrNoS3Overflow is when event data is stored in S3 but S3 overflow is not enabled.
+var ErrNoS3Overflow = errors.New("no S3 overflow set")
+
+// s3Overflow is the config of the S3 overflow mode.
+type s3Overflow struct {
+	client    s3iface.S3API
+	bucket    string
+	threshold int
+}
+
+// WithS3Overflow stores event data that is larger than threshold bytes when
+// encoded in an S3 bucket instead of in the event item, which keeps the item
+// below the DynamoDB item size limit. The item keeps a pointer to the S3
+// object and the data is fetched transparently when the event is loaded.
+// Without a codec the data is stored as JSON in S3.
+//
+// The S3 objects are written before the events, so objects of saves that fail
+// are left behind and can be removed by a bucket lifecycle rule.
+func WithS3Overflow(bucket string, threshold int) Option {
+	return func(s *EventStore) error {
+		if s.overflow == nil {
+			s.overflow = &s3Overflow{}
+		}
+		s.overflow.bucket = bucket
+		s.overflow.threshold = threshold
+		return nil
+	}
+}
+
+// WithS3OverflowClient uses a custom S3 client for the S3 overflow mode.
+// Without it a client is created from the AWS session of the store.
+func WithS3OverflowClient(client s3iface.S3API) Option {
+	return func(s *EventStore) error {
+		if s.overflow == nil {
+			s.overflow = &s3Overflow{}
+		}
+		s.overflow.client = client
+		return nil
+	}
+}
+
+// offloadData moves the data of an event to S3 if it is over the threshold.
+func (s *EventStore) offloadData(ctx context.Context, event eh.Event, e *dbEvent) error {
+	if s.overflow == nil || s.overflow.bucket == "" || event.Data() == nil {
+		return nil
+	}
+
+	payload := e.EncodedData
+	encoded := payload != nil
+	if e.encrypted() {
+		payload = e.Encrypted
+	} else if !encoded {
+		var err error
+		if payload, err = (JSONCodec{}).Marshal(event.Data()); err != nil {
+			return err
+		}
+	}
+	if len(payload) <= s.overflow.threshold {
+		return nil
+	}
+
+	// The key is unique per save, so that a save that fails on a version
+	// conflict never overwrites the data of the stored event.
+	key := fmt.Sprintf("%s/%s/%d/%s", s.eventTableName(ctx, event.Timestamp()),
+		event.AggregateID(), event.Version(), uuid.New())
+	if _, err := s.overflow.client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.overflow.bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(payload),
+	}); err != nil {
+		return err
+	}
+
+	e.DataRef = key
+	e.DataRefEncoded = encoded
+	e.RawData = nil
+	e.EncodedData = nil
+	e.Encrypted = nil
+	return nil
+}
+
+// rehydrateData fetches the data of an event from S3 if it was offloaded.
+func (s *EventStore) rehydrateData(ctx context.Context, e *dbEvent) error {
+	if e.DataRef == "" {
+		return nil
+	}
+	if s.overflow == nil || s.overflow.client == nil {
+		return ErrNoS3Overflow
+	}
+
+	out, err := s.overflow.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.overflow.bucket),
+		Key:    aws.String(e.DataRef),
+	})
+	if err != nil {
+		return err
+	}
+	defer out.Body.Close()
+
+	b, err := io.ReadAll(out.Body)
+	if err != nil {
+		return err
+	}
+	if e.encrypted() {
+		e.Encrypted = b
+	} else {
+		e.EncodedData = b
+	}
+	return nil
+}
+
+// maxDeleteObjects is the max number of keys of a DeleteObjects request.
+const maxDeleteObjects = 1000
+
+// deleteOffloadedData deletes the S3 objects of offloaded event data.
+func (s *EventStore) deleteOffloadedData(ctx context.Context, keys []string) error {
+	for start := 0; start < len(keys); start += maxDeleteObjects {
+		end := start + maxDeleteObjects
+		if end > len(keys) {
+			end = len(keys)
+		}
+		objects := make([]*s3.ObjectIdentifier, 0, end-start)
+		for _, key := range keys[start:end] {
+			objects = append(objects, &s3.ObjectIdentifier{Key: aws.String(key)})
+		}
+
+		out, err := s.overflow.client.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(s.overflow.bucket),
+			Delete: &s3.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return err
+		}
+		if len(out.Errors) > 0 {
+			e := out.Errors[0]
+			return fmt.Errorf("could not delete event data %s: %s", aws.StringValue(e.Key), aws.StringValue(e.Message))
+		}
+	}
+	return nil
+}
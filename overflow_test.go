@@ -0,0 +1,133 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/google/uuid"
+	"github.com/guregu/dynamo"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryS3 is an in-memory S3 client for the S3 overflow and archive tests.
+type memoryS3 struct {
+	s3iface.S3API
+
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func newMemoryS3() *memoryS3 {
+	return &memoryS3{objects: map[string][]byte{}}
+}
+
+func (m *memoryS3) PutObjectWithContext(ctx aws.Context, in *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error) {
+	b, err := io.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[aws.StringValue(in.Bucket)+"/"+aws.StringValue(in.Key)] = b
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (m *memoryS3) GetObjectWithContext(ctx aws.Context, in *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.objects[aws.StringValue(in.Bucket)+"/"+aws.StringValue(in.Key)]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "no such key", nil)
+	}
+	return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(b))}, nil
+}
+
+func (m *memoryS3) DeleteObjectsWithContext(ctx aws.Context, in *s3.DeleteObjectsInput, opts ...request.Option) (*s3.DeleteObjectsOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, o := range in.Delete.Objects {
+		delete(m.objects, aws.StringValue(in.Bucket)+"/"+aws.StringValue(o.Key))
+	}
+	return &s3.DeleteObjectsOutput{}, nil
+}
+
+// TestS3Overflow will store large event data in S3 and load it transparently
+func (suite *EventStoreTestSuite) TestS3Overflow() {
+	objects := newMemoryS3()
+	store := suite.newStore(WithS3Overflow("events", 32), WithS3OverflowClient(objects))
+
+	id := uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	small := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "small"},
+		timestamp, mocks.AggregateType, id, 1)
+	large := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: strings.Repeat("large", 20)},
+		timestamp, mocks.AggregateType, id, 2)
+	assert.Nil(suite.T(), store.Save(suite.ctx, []eh.Event{small, large}, 0))
+	assert.Len(suite.T(), objects.objects, 1)
+
+	var e dbEvent
+	err := store.service.Table(store.tableName(suite.ctx)).
+		Get("AggregateID", id.String()).Range("Version", dynamo.Equal, 2).One(&e)
+	assert.Nil(suite.T(), err)
+	assert.NotEmpty(suite.T(), e.DataRef)
+	assert.Nil(suite.T(), e.RawData)
+
+	events, err := store.Load(suite.ctx, id)
+	assert.Nil(suite.T(), err)
+	if assert.Len(suite.T(), events, 2) {
+		assert.Equal(suite.T(), small.Data(), events[0].Data())
+		assert.Equal(suite.T(), large.Data(), events[1].Data())
+	}
+}
+
+// TestS3OverflowDeleteAggregate will delete the data in S3 with the events of
+// an aggregate, or refuse to delete them without S3 overflow
+func (suite *EventStoreTestSuite) TestS3OverflowDeleteAggregate() {
+	objects := newMemoryS3()
+	store := suite.newStore(WithS3Overflow("events", 32), WithS3OverflowClient(objects))
+
+	id := uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	large := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: strings.Repeat("large", 20)},
+		timestamp, mocks.AggregateType, id, 1)
+	assert.Nil(suite.T(), store.Save(suite.ctx, []eh.Event{large}, 0))
+	assert.Len(suite.T(), objects.objects, 1)
+
+	// Without S3 overflow nothing is deleted.
+	_, err := suite.newStore().DeleteAggregate(suite.ctx, id)
+	assert.True(suite.T(), errors.Is(err, ErrNoS3Overflow), err)
+	events, err := store.Load(suite.ctx, id)
+	assert.Nil(suite.T(), err)
+	assert.Len(suite.T(), events, 1)
+
+	deleted, err := store.DeleteAggregate(suite.ctx, id)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 1, deleted)
+	assert.Empty(suite.T(), objects.objects)
+}
@@ -0,0 +1,335 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// parquetMagic starts and ends a Parquet file.
+const parquetMagic = "PAR1"
+
+// The Parquet physical and converted types of the columns.
+const (
+	parquetInt64     = 2
+	parquetByteArray = 6
+
+	parquetNoConvertedType = -1
+	parquetUTF8            = 0
+	parquetTimestampMillis = 9
+)
+
+// The Parquet enum values of the file layout.
+const (
+	parquetRequired      = 0
+	parquetPlain         = 0
+	parquetRLE           = 3
+	parquetDataPage      = 0
+	parquetUncompressed  = 0
+	parquetFormatVersion = 1
+)
+
+const (
+	// parquetGroupSize is the number of rows in a row group.
+	parquetGroupSize = 10000
+	// parquetCreatedBy is the writer in the file metadata.
+	parquetCreatedBy = "eh-dynamodb"
+)
+
+// parquetColumn is a required column of a Parquet file, with the PLAIN
+// encoded values of the current row group.
+type parquetColumn struct {
+	name          string
+	physicalType  int32
+	convertedType int32
+	values        bytes.Buffer
+}
+
+// parquetChunk is the location of a column chunk in the file.
+type parquetChunk struct {
+	offset int64
+	size   int64
+}
+
+// parquetRowGroup is the location of a row group in the file.
+type parquetRowGroup struct {
+	chunks  []parquetChunk
+	numRows int64
+}
+
+// parquetWriter writes a Parquet file with a flat schema of required
+// columns, in row groups of at most groupSize rows. It is a minimal writer
+// without compression, dictionaries or statistics, which keeps the export
+// free of dependencies; the files are read by Athena, Spark and pyarrow.
+type parquetWriter struct {
+	w         io.Writer
+	offset    int64
+	columns   []*parquetColumn
+	groupSize int64
+	rows      int64
+	groups    []parquetRowGroup
+}
+
+// newParquetWriter starts a Parquet file with the columns.
+func newParquetWriter(w io.Writer, columns []*parquetColumn) (*parquetWriter, error) {
+	p := &parquetWriter{
+		w:         w,
+		columns:   columns,
+		groupSize: parquetGroupSize,
+	}
+	if err := p.write([]byte(parquetMagic)); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// int64Value appends an INT64 value to a column.
+func (c *parquetColumn) int64Value(v int64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], uint64(v))
+	c.values.Write(b[:])
+}
+
+// bytesValue appends a BYTE_ARRAY value to a column.
+func (c *parquetColumn) bytesValue(v []byte) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], uint32(len(v)))
+	c.values.Write(b[:])
+	c.values.Write(v)
+}
+
+// endRow ends a row, after a value was appended to every column, and writes
+// the row group when it is full.
+func (p *parquetWriter) endRow() error {
+	p.rows++
+	if p.rows < p.groupSize {
+		return nil
+	}
+	return p.flush()
+}
+
+// flush writes the current row group, with one data page per column.
+func (p *parquetWriter) flush() error {
+	if p.rows == 0 {
+		return nil
+	}
+
+	group := parquetRowGroup{numRows: p.rows}
+	for _, c := range p.columns {
+		size := int32(c.values.Len())
+		var h thriftCompact
+		h.begin()
+		h.i32(1, parquetDataPage)
+		h.i32(2, size)
+		h.i32(3, size)
+		h.beginStruct(5)
+		h.i32(1, int32(p.rows))
+		h.i32(2, parquetPlain)
+		h.i32(3, parquetRLE)
+		h.i32(4, parquetRLE)
+		h.endStruct()
+		h.endStruct()
+
+		chunk := parquetChunk{offset: p.offset}
+		if err := p.write(h.Bytes()); err != nil {
+			return err
+		}
+		if err := p.write(c.values.Bytes()); err != nil {
+			return err
+		}
+		chunk.size = p.offset - chunk.offset
+		group.chunks = append(group.chunks, chunk)
+		c.values.Reset()
+	}
+	p.groups = append(p.groups, group)
+	p.rows = 0
+	return nil
+}
+
+// close writes the last row group and the file metadata.
+func (p *parquetWriter) close() error {
+	if err := p.flush(); err != nil {
+		return err
+	}
+
+	var numRows int64
+	for _, g := range p.groups {
+		numRows += g.numRows
+	}
+
+	var m thriftCompact
+	m.begin()
+	m.i32(1, parquetFormatVersion)
+	m.list(2, thriftStruct, len(p.columns)+1)
+	m.beginElem()
+	m.binary(4, []byte("schema"))
+	m.i32(5, int32(len(p.columns)))
+	m.endStruct()
+	for _, c := range p.columns {
+		m.beginElem()
+		m.i32(1, c.physicalType)
+		m.i32(3, parquetRequired)
+		m.binary(4, []byte(c.name))
+		if c.convertedType != parquetNoConvertedType {
+			m.i32(6, c.convertedType)
+		}
+		m.endStruct()
+	}
+	m.i64(3, numRows)
+	m.list(4, thriftStruct, len(p.groups))
+	for _, g := range p.groups {
+		m.beginElem()
+		m.list(1, thriftStruct, len(g.chunks))
+		var size int64
+		for i, chunk := range g.chunks {
+			c := p.columns[i]
+			size += chunk.size
+			m.beginElem()
+			m.i64(2, chunk.offset)
+			m.beginStruct(3)
+			m.i32(1, c.physicalType)
+			m.list(2, thriftI32, 1)
+			m.varint(zigzag(parquetPlain))
+			m.list(3, thriftBinary, 1)
+			m.varint(uint64(len(c.name)))
+			m.WriteString(c.name)
+			m.i32(4, parquetUncompressed)
+			m.i64(5, g.numRows)
+			m.i64(6, chunk.size)
+			m.i64(7, chunk.size)
+			m.i64(9, chunk.offset)
+			m.endStruct()
+			m.endStruct()
+		}
+		m.i64(2, size)
+		m.i64(3, g.numRows)
+		m.endStruct()
+	}
+	m.binary(6, []byte(parquetCreatedBy))
+	m.endStruct()
+
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(m.Len()))
+	if err := p.write(m.Bytes()); err != nil {
+		return err
+	}
+	if err := p.write(length[:]); err != nil {
+		return err
+	}
+	return p.write([]byte(parquetMagic))
+}
+
+// write writes to the file and keeps track of the offset.
+func (p *parquetWriter) write(b []byte) error {
+	n, err := p.w.Write(b)
+	p.offset += int64(n)
+	return err
+}
+
+// The Thrift compact protocol types used by the Parquet metadata.
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftCompact encodes structs with the Thrift compact protocol, which is
+// the encoding of the Parquet page headers and file metadata.
+type thriftCompact struct {
+	bytes.Buffer
+	// lastIDs are the last field IDs of the nested structs.
+	lastIDs []int16
+}
+
+// begin starts the top level struct.
+func (t *thriftCompact) begin() {
+	t.lastIDs = append(t.lastIDs, 0)
+}
+
+// beginStruct starts a struct field.
+func (t *thriftCompact) beginStruct(id int16) {
+	t.field(id, thriftStruct)
+	t.lastIDs = append(t.lastIDs, 0)
+}
+
+// beginElem starts a struct element of a list.
+func (t *thriftCompact) beginElem() {
+	t.lastIDs = append(t.lastIDs, 0)
+}
+
+// endStruct ends the current struct.
+func (t *thriftCompact) endStruct() {
+	t.WriteByte(0)
+	t.lastIDs = t.lastIDs[:len(t.lastIDs)-1]
+}
+
+// field writes a field header, with the ID as a delta of the last field if
+// it fits.
+func (t *thriftCompact) field(id int16, typ byte) {
+	last := &t.lastIDs[len(t.lastIDs)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		t.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.WriteByte(typ)
+		t.varint(zigzag(int64(id)))
+	}
+	*last = id
+}
+
+// i32 writes an i32 field.
+func (t *thriftCompact) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.varint(zigzag(int64(v)))
+}
+
+// i64 writes an i64 field.
+func (t *thriftCompact) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.varint(zigzag(v))
+}
+
+// binary writes a binary or string field.
+func (t *thriftCompact) binary(id int16, v []byte) {
+	t.field(id, thriftBinary)
+	t.varint(uint64(len(v)))
+	t.Write(v)
+}
+
+// list writes the header of a list field with n elements of a type.
+func (t *thriftCompact) list(id int16, elemType byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.WriteByte(byte(n)<<4 | elemType)
+		return
+	}
+	t.WriteByte(0xf0 | elemType)
+	t.varint(uint64(n))
+}
+
+// varint writes an unsigned varint.
+func (t *thriftCompact) varint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	t.Write(b[:n])
+}
+
+// zigzag encodes a signed integer for a varint.
+func zigzag(v int64) uint64 {
+	return uint64((v << 1) ^ (v >> 63))
+}
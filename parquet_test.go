@@ -0,0 +1,259 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+func TestThriftCompact(t *testing.T) {
+	var c thriftCompact
+	c.begin()
+	c.i32(1, 3)
+	c.i64(17, -1)
+	c.binary(18, []byte("ab"))
+	c.list(19, thriftI32, 15)
+	c.beginStruct(20)
+	c.i32(1, 0)
+	c.endStruct()
+	c.endStruct()
+	assert.Equal(t, []byte{
+		0x15, 0x06, // Field 1 as delta, zigzag 3.
+		0x06, 0x22, 0x01, // Field 17 in long form, zigzag -1.
+		0x18, 0x02, 'a', 'b', // Field 18 as delta.
+		0x19, 0xf5, 0x0f, // Field 19 with a list of 15 i32s.
+		0x1c, 0x15, 0x00, 0x00, // Field 20 with a struct.
+		0x00,
+	}, c.Bytes())
+}
+
+func TestParquetWriter(t *testing.T) {
+	var buf bytes.Buffer
+	x, err := newParquetExport(&buf)
+	if !assert.Nil(t, err) {
+		return
+	}
+	x.p.groupSize = 2
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, x.write(ExportedEvent{
+			Namespace: "ns",
+			Version:   i + 1,
+			Data:      []byte(`{"content":"event"}`),
+			Metadata:  []byte(`{}`),
+		}))
+	}
+	assert.Nil(t, x.close())
+
+	b := buf.Bytes()
+	assert.Equal(t, parquetMagic, string(b[:4]))
+	assert.Equal(t, parquetMagic, string(b[len(b)-4:]))
+	footer := int(binary.LittleEndian.Uint32(b[len(b)-8:]))
+	assert.True(t, footer > 0 && footer < len(b)-12)
+	assert.Contains(t, string(b[len(b)-8-footer:]), "aggregate_id")
+
+	// The rows are written in two row groups, with a data page per column.
+	if assert.Len(t, x.p.groups, 2) {
+		assert.Equal(t, int64(2), x.p.groups[0].numRows)
+		assert.Equal(t, int64(1), x.p.groups[1].numRows)
+		chunk := x.p.groups[0].chunks[3]
+		assert.Equal(t, byte(0x15), b[chunk.offset])
+		assert.Equal(t, int64(1), int64(binary.LittleEndian.Uint64(b[chunk.offset+chunk.size-16:])))
+		assert.Equal(t, int64(2), int64(binary.LittleEndian.Uint64(b[chunk.offset+chunk.size-8:])))
+	}
+}
+
+// updateParquetGolden writes the golden Parquet file again.
+var updateParquetGolden = flag.Bool("update-parquet", false, "update the golden Parquet file")
+
+// TestParquetGolden compares an export with testdata/events.parquet, which is
+// checked with an independent reader by TestParquetReader.
+func TestParquetGolden(t *testing.T) {
+	var buf bytes.Buffer
+	x, err := newParquetExport(&buf)
+	if !assert.Nil(t, err) {
+		return
+	}
+	x.p.groupSize = 2
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, x.write(ExportedEvent{
+			Namespace:     "ns",
+			AggregateType: "Aggregate",
+			AggregateID:   "c1138e5f-f6fb-4dd0-8e79-255c6c8d3756",
+			Version:       i + 1,
+			EventType:     "Event",
+			Timestamp:     timestamp.Add(time.Duration(i) * time.Second),
+			Position:      int64(10 + i),
+			Data:          []byte(fmt.Sprintf(`{"content":"event%d"}`, i+1)),
+			Metadata:      []byte(`{}`),
+		}))
+	}
+	assert.Nil(t, x.close())
+
+	golden := filepath.Join("testdata", "events.parquet")
+	if *updateParquetGolden {
+		if err := os.WriteFile(golden, buf.Bytes(), 0644); err != nil {
+			t.Fatal("could not write golden file:", err)
+		}
+	}
+	expected, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal("could not read golden file:", err)
+	}
+	assert.Equal(t, expected, buf.Bytes())
+}
+
+// parquetRow is a row of an export as read by parquet-go.
+type parquetRow struct {
+	Namespace     string `parquet:"name=namespace, type=BYTE_ARRAY, convertedtype=UTF8"`
+	AggregateType string `parquet:"name=aggregate_type, type=BYTE_ARRAY, convertedtype=UTF8"`
+	AggregateID   string `parquet:"name=aggregate_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Version       int64  `parquet:"name=version, type=INT64"`
+	EventType     string `parquet:"name=event_type, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Timestamp     int64  `parquet:"name=timestamp, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	Position      int64  `parquet:"name=position, type=INT64"`
+	Data          string `parquet:"name=data, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Metadata      string `parquet:"name=metadata, type=BYTE_ARRAY, convertedtype=UTF8"`
+}
+
+// TestParquetReader reads the golden file and an export of more rows than
+// fit in a row group with parquet-go, to check the schema, the row groups and
+// the values with an independent reader.
+func TestParquetReader(t *testing.T) {
+	golden, err := os.ReadFile(filepath.Join("testdata", "events.parquet"))
+	if err != nil {
+		t.Fatal("could not read golden file:", err)
+	}
+
+	var buf bytes.Buffer
+	x, err := newParquetExport(&buf)
+	if !assert.Nil(t, err) {
+		return
+	}
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	numRows := parquetGroupSize + parquetGroupSize/2
+	for i := 0; i < numRows; i++ {
+		assert.Nil(t, x.write(ExportedEvent{
+			Namespace:     "ns",
+			AggregateType: "Aggregate",
+			AggregateID:   "c1138e5f-f6fb-4dd0-8e79-255c6c8d3756",
+			Version:       i + 1,
+			EventType:     "Event",
+			Timestamp:     timestamp.Add(time.Duration(i) * time.Second),
+			Position:      int64(10 + i),
+			Data:          []byte(fmt.Sprintf(`{"content":"event%d"}`, i+1)),
+			Metadata:      []byte(`{}`),
+		}))
+	}
+	assert.Nil(t, x.close())
+
+	for _, tc := range []struct {
+		name   string
+		file   []byte
+		groups []int64
+	}{
+		{"golden", golden, []int64{2, 1}},
+		{"row groups", buf.Bytes(), []int64{parquetGroupSize, parquetGroupSize / 2}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// The footer is read on its own, as the reader renames the
+			// schema to the fields of the row.
+			f, err := buffer.NewBufferFile(tc.file)
+			if !assert.Nil(t, err) {
+				return
+			}
+			footer := &reader.ParquetReader{PFile: f}
+			if !assert.Nil(t, footer.ReadFooter()) {
+				return
+			}
+
+			// The schema is a root with a required column per field.
+			schema := footer.Footer.Schema
+			if assert.Len(t, schema, 10) {
+				assert.Equal(t, int32(9), schema[0].GetNumChildren())
+				for i, c := range []struct {
+					name          string
+					physicalType  parquet.Type
+					convertedType *parquet.ConvertedType
+				}{
+					{"namespace", parquet.Type_BYTE_ARRAY, parquet.ConvertedTypePtr(parquet.ConvertedType_UTF8)},
+					{"aggregate_type", parquet.Type_BYTE_ARRAY, parquet.ConvertedTypePtr(parquet.ConvertedType_UTF8)},
+					{"aggregate_id", parquet.Type_BYTE_ARRAY, parquet.ConvertedTypePtr(parquet.ConvertedType_UTF8)},
+					{"version", parquet.Type_INT64, nil},
+					{"event_type", parquet.Type_BYTE_ARRAY, parquet.ConvertedTypePtr(parquet.ConvertedType_UTF8)},
+					{"timestamp", parquet.Type_INT64, parquet.ConvertedTypePtr(parquet.ConvertedType_TIMESTAMP_MILLIS)},
+					{"position", parquet.Type_INT64, nil},
+					{"data", parquet.Type_BYTE_ARRAY, parquet.ConvertedTypePtr(parquet.ConvertedType_UTF8)},
+					{"metadata", parquet.Type_BYTE_ARRAY, parquet.ConvertedTypePtr(parquet.ConvertedType_UTF8)},
+				} {
+					e := schema[i+1]
+					assert.Equal(t, c.name, e.GetName())
+					assert.Equal(t, c.physicalType, e.GetType(), c.name)
+					assert.Equal(t, c.convertedType, e.ConvertedType, c.name)
+					assert.Equal(t, parquet.FieldRepetitionType_REQUIRED, e.GetRepetitionType(), c.name)
+				}
+			}
+
+			var groups []int64
+			for _, g := range footer.Footer.RowGroups {
+				groups = append(groups, g.GetNumRows())
+			}
+			assert.Equal(t, tc.groups, groups)
+
+			if f, err = buffer.NewBufferFile(tc.file); !assert.Nil(t, err) {
+				return
+			}
+			pr, err := reader.NewParquetReader(f, new(parquetRow), 1)
+			if !assert.Nil(t, err) {
+				return
+			}
+			defer pr.ReadStop()
+
+			numRows := int(pr.GetNumRows())
+			rows := make([]parquetRow, numRows)
+			if !assert.Nil(t, pr.Read(&rows)) {
+				return
+			}
+			for i, row := range rows {
+				expected := parquetRow{
+					Namespace:     "ns",
+					AggregateType: "Aggregate",
+					AggregateID:   "c1138e5f-f6fb-4dd0-8e79-255c6c8d3756",
+					Version:       int64(i + 1),
+					EventType:     "Event",
+					Timestamp:     timestamp.Add(time.Duration(i)*time.Second).UnixNano() / int64(time.Millisecond),
+					Position:      int64(10 + i),
+					Data:          fmt.Sprintf(`{"content":"event%d"}`, i+1),
+					Metadata:      `{}`,
+				}
+				if !assert.Equal(t, expected, row) {
+					break
+				}
+			}
+		})
+	}
+}
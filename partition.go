@@ -0,0 +1,154 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// partitionLayout is the month suffix of partition tables.
+const partitionLayout = "200601"
+
+// WithMonthlyPartitions stores events in one table per month, named by the
+// event table name and the month of the event timestamp, like
+// "prefix_namespace_200911". Old events can then be archived by dropping
+// whole tables with DropPartition. The partition tables are created on first
+// use; the table created by CreateTable only holds the version counters of
+// the aggregates, which keeps the optimistic locking across partitions.
+//
+// Load merges the partitions by version and LoadAll returns the events of
+// the partitions in month order. LoadEach reads the partitions in month
+// order, which is version order as long as event timestamps increase with
+// the version. Replace only finds events in the partition of the timestamp
+// of the new event.
+func WithMonthlyPartitions() Option {
+	return func(s *EventStore) error {
+		s.partitions = &partitions{}
+		return nil
+	}
+}
+
+// partitions keeps track of the partition tables that are known to exist.
+type partitions struct {
+	created sync.Map
+}
+
+// DropPartition deletes the partition table of a month, with all its events.
+func (s *EventStore) DropPartition(ctx context.Context, month time.Time) error {
+	ctx, err := s.namespace(ctx)
+	if err != nil {
+		return err
+	}
+
+	if s.partitions == nil {
+		return nil
+	}
+
+	tableName := partitionTableName(s.tableName(ctx), month)
+	s.partitions.created.Delete(tableName)
+	return s.deleteTable(ctx, tableName)
+}
+
+// eventTableName returns the table to store an event with a timestamp in.
+func (s *EventStore) eventTableName(ctx context.Context, timestamp time.Time) string {
+	if s.partitions == nil {
+		return s.tableName(ctx)
+	}
+	return partitionTableName(s.tableName(ctx), timestamp)
+}
+
+// eventTables returns the tables with events, in month order for partitions.
+func (s *EventStore) eventTables(ctx context.Context) ([]string, error) {
+	baseName := s.tableName(ctx)
+	if s.partitions == nil {
+		return []string{baseName}, nil
+	}
+
+	var tables []string
+	err := s.service.Client().ListTablesPagesWithContext(ctx, &dynamodb.ListTablesInput{
+		ExclusiveStartTableName: aws.String(baseName + "_"),
+	}, func(out *dynamodb.ListTablesOutput, last bool) bool {
+		for _, name := range out.TableNames {
+			tableName := aws.StringValue(name)
+			if !strings.HasPrefix(tableName, baseName+"_") {
+				// Tables are listed in name order, so there are no more
+				// partitions.
+				return false
+			}
+			if isPartitionTable(tableName) && tableName[:len(tableName)-len(partitionLayout)-1] == baseName {
+				tables = append(tables, tableName)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, eh.EventStoreError{
+			BaseErr:   withRequestID(err),
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	sort.Strings(tables)
+	return tables, nil
+}
+
+// ensurePartition creates a partition table unless it is known to exist.
+func (s *EventStore) ensurePartition(ctx context.Context, tableName string) error {
+	if _, ok := s.partitions.created.Load(tableName); ok {
+		return nil
+	}
+
+	if err := s.createEventTable(ctx, tableName); err != nil {
+		return eh.EventStoreError{
+			BaseErr:   withRequestID(err),
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	s.partitions.created.Store(tableName, struct{}{})
+	return nil
+}
+
+// partitionTableName returns the name of the partition table of a month.
+func partitionTableName(baseName string, t time.Time) string {
+	return baseName + "_" + t.UTC().Format(partitionLayout)
+}
+
+// isPartitionTable checks if a table name ends with a month suffix.
+func isPartitionTable(tableName string) bool {
+	i := len(tableName) - len(partitionLayout) - 1
+	if i < 0 || tableName[i] != '_' {
+		return false
+	}
+	_, err := time.Parse(partitionLayout, tableName[i+1:])
+	return err == nil
+}
+
+// isEventTable checks if a table is the event table baseName or one of its
+// monthly partitions.
+func isEventTable(baseName, tableName string) bool {
+	return tableName == baseName ||
+		isPartitionTable(tableName) && tableName[:len(tableName)-len(partitionLayout)-1] == baseName
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPartitionTableName(t *testing.T) {
+	local := time.Date(2009, time.December, 1, 0, 30, 0, 0, time.FixedZone("CET", 3600))
+	assert.Equal(t, "events_ns_200911", partitionTableName("events_ns", local))
+
+	assert.True(t, isPartitionTable("events_ns_200911"))
+	assert.False(t, isPartitionTable("events_ns"))
+	assert.False(t, isPartitionTable("events_ns_200913"))
+	assert.False(t, isPartitionTable("events_ns-200911"))
+	assert.False(t, isPartitionTable("200911"))
+}
+
+// TestMonthlyPartitions will store events in monthly tables and merge them when loading
+func (suite *EventStoreTestSuite) TestMonthlyPartitions() {
+	store := suite.newStore(WithMonthlyPartitions())
+	defer store.DeleteTable(suite.ctx)
+
+	id := uuid.New()
+	november := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	december := time.Date(2009, time.December, 10, 23, 0, 0, 0, time.UTC)
+	event1 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event1"},
+		november, mocks.AggregateType, id, 1)
+	event2 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event2"},
+		december, mocks.AggregateType, id, 2)
+	assert.Nil(suite.T(), store.Save(suite.ctx, []eh.Event{event1}, 0))
+	assert.Nil(suite.T(), store.Save(suite.ctx, []eh.Event{event2}, 1))
+
+	// The version counter should span the partitions.
+	err := store.Save(suite.ctx, []eh.Event{event2}, 1)
+	if esErr, ok := err.(eh.EventStoreError); !ok || !errors.Is(esErr.Err, ErrCouldNotSaveAggregate) {
+		suite.T().Fatal("there should be a conflict error:", err)
+	}
+
+	tables, err := store.eventTables(suite.ctx)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), []string{"test_ns_200911", "test_ns_200912"}, tables)
+
+	events, err := store.Load(suite.ctx, id)
+	assert.Nil(suite.T(), err)
+	if assert.Len(suite.T(), events, 2) {
+		assert.Equal(suite.T(), event1.Data(), events[0].Data())
+		assert.Equal(suite.T(), event2.Data(), events[1].Data())
+	}
+
+	events, err = store.LoadAll(suite.ctx)
+	assert.Nil(suite.T(), err)
+	assert.Len(suite.T(), events, 2)
+
+	// Dropping the old partition archives its events.
+	assert.Nil(suite.T(), store.DropPartition(suite.ctx, november))
+	events, err = store.Load(suite.ctx, id)
+	assert.Nil(suite.T(), err)
+	if assert.Len(suite.T(), events, 1) {
+		assert.Equal(suite.T(), event2.Data(), events[0].Data())
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// ErrNoPayloadDedup is when event data is stored in the payload table but
+// payload deduplication is not enabled.
+var ErrNoPayloadDedup = errors.New("no payload deduplication set")
+
+// maxCachedPayloads is the number of payloads that are cached when loading.
+const maxCachedPayloads = 100
+
+// payloadDedup is the config of the payload deduplication mode.
+type payloadDedup struct {
+	tableName string
+	minSize   int
+
+	mu       sync.Mutex
+	payloads map[string][]byte
+}
+
+// WithPayloadDedup stores event data that is at least minSize bytes when
+// encoded once per distinct content in a payload table shared by all
+// namespaces, keyed by its SHA-256 hash. Events with identical data only
+// store the hash, and the payload item counts the events that reference it.
+// The payload table is created by CreateTable.
+//
+// References are added in the same transaction as the events and are
+// updated by Replace, so payloads with no references left can be removed
+// with PrunePayloads. Events removed by dropping tables are not subtracted.
+// Encrypted events and event data offloaded to S3 are not deduplicated.
+func WithPayloadDedup(tableName string, minSize int) Option {
+	return func(s *EventStore) error {
+		s.payloads = &payloadDedup{
+			tableName: tableName,
+			minSize:   minSize,
+			payloads:  map[string][]byte{},
+		}
+		return nil
+	}
+}
+
+// PrunePayloads removes the payloads that are no longer referenced by any
+// event and returns the number of removed payloads.
+func (s *EventStore) PrunePayloads(ctx context.Context) (int, error) {
+	if s.payloads == nil {
+		return 0, eh.EventStoreError{
+			Err:       ErrNoPayloadDedup,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	table := s.service.Table(s.payloads.tableName)
+	var unreferenced []dbPayload
+	start := time.Now()
+	err := table.Scan().Filter("Refs <= ?", 0).Project("Hash").AllWithContext(ctx, &unreferenced)
+	observe(ctx, s.metrics, OperationScan, s.payloads.tableName, start, err)
+	if err != nil {
+		return 0, eh.EventStoreError{
+			BaseErr:   withRequestID(err),
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	pruned := 0
+	for _, p := range unreferenced {
+		// Only delete the payload if it was not referenced again meanwhile.
+		start := time.Now()
+		err := table.Delete("Hash", p.Hash).If("Refs <= ?", 0).RunWithContext(ctx)
+		observe(ctx, s.metrics, OperationDeleteItem, s.payloads.tableName, start, err)
+		if isConditionalCheckFailed(err) {
+			continue
+		} else if err != nil {
+			return pruned, eh.EventStoreError{
+				BaseErr:   withRequestID(err),
+				Err:       err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+		pruned++
+	}
+
+	return pruned, nil
+}
+
+// dedupData replaces the data of an event with the hash of its payload if it
+// is at least the min size. The payload is kept in the event to be written
+// to the payload table by the save.
+func (s *EventStore) dedupData(event eh.Event, e *dbEvent) error {
+	if s.payloads == nil || event.Data() == nil || e.encrypted() || e.DataRef != "" || e.Chunks > 0 {
+		return nil
+	}
+
+	payload := e.EncodedData
+	encoded := payload != nil
+	if !encoded {
+		var err error
+		if payload, err = (JSONCodec{}).Marshal(event.Data()); err != nil {
+			return err
+		}
+	}
+	if len(payload) < s.payloads.minSize {
+		return nil
+	}
+
+	hash := sha256.Sum256(payload)
+	e.PayloadHash = hex.EncodeToString(hash[:])
+	e.PayloadEncoded = encoded
+	e.payload = payload
+	e.RawData = nil
+	e.EncodedData = nil
+	return nil
+}
+
+// resolvePayload fetches the data of an event from the payload table if it
+// was deduplicated, with the read consistency of the event queries.
+func (s *EventStore) resolvePayload(ctx context.Context, e *dbEvent) error {
+	if s.payloads == nil {
+		return ErrNoPayloadDedup
+	}
+
+	s.payloads.mu.Lock()
+	payload, ok := s.payloads.payloads[e.PayloadHash]
+	s.payloads.mu.Unlock()
+
+	if !ok {
+		var p dbPayload
+		start := time.Now()
+		err := s.service.Table(s.payloads.tableName).
+			Get("Hash", e.PayloadHash).
+			Consistent(consistentRead(ctx, s.readConsistency)).
+			OneWithContext(ctx, &p)
+		observe(ctx, s.metrics, OperationGetItem, s.payloads.tableName, start, err)
+		if err != nil {
+			return err
+		}
+		payload = p.Payload
+
+		// Payloads never change, start over when the cache is full.
+		s.payloads.mu.Lock()
+		if len(s.payloads.payloads) >= maxCachedPayloads {
+			s.payloads.payloads = map[string][]byte{}
+		}
+		s.payloads.payloads[e.PayloadHash] = payload
+		s.payloads.mu.Unlock()
+	}
+
+	e.EncodedData = payload
+	return nil
+}
+
+// payloadRefItems returns the transaction items that add references to the
+// payloads of events, one item per distinct payload.
+func (s *EventStore) payloadRefItems(events []*dbEvent) []*dynamodb.TransactWriteItem {
+	var hashes []string
+	refs := map[string]int{}
+	payloads := map[string][]byte{}
+	for _, e := range events {
+		if e.PayloadHash == "" {
+			continue
+		}
+		if _, ok := refs[e.PayloadHash]; !ok {
+			hashes = append(hashes, e.PayloadHash)
+		}
+		refs[e.PayloadHash]++
+		payloads[e.PayloadHash] = e.payload
+	}
+
+	items := make([]*dynamodb.TransactWriteItem, len(hashes))
+	for i, hash := range hashes {
+		items[i] = s.payloadRefItem(hash, payloads[hash], refs[hash])
+	}
+	return items
+}
+
+// payloadRefItem returns a transaction item that changes the references to
+// a payload by delta, writing the payload if it is not stored yet.
+func (s *EventStore) payloadRefItem(hash string, payload []byte, delta int) *dynamodb.TransactWriteItem {
+	update := &dynamodb.Update{
+		TableName: aws.String(s.payloads.tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Hash": {S: aws.String(hash)},
+		},
+		UpdateExpression: aws.String("ADD Refs :delta"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":delta": {N: aws.String(strconv.Itoa(delta))},
+		},
+	}
+	if payload != nil {
+		update.UpdateExpression = aws.String("SET Payload = if_not_exists(Payload, :payload) ADD Refs :delta")
+		update.ExpressionAttributeValues[":payload"] = &dynamodb.AttributeValue{B: payload}
+	}
+	return &dynamodb.TransactWriteItem{Update: update}
+}
+
+// removePayloadRefs subtracts references to payloads of deleted events.
+func (s *EventStore) removePayloadRefs(ctx context.Context, refs map[string]int) error {
+	if s.payloads == nil {
+		return nil
+	}
+
+	table := s.service.Table(s.payloads.tableName)
+	for hash, n := range refs {
+		start := time.Now()
+		err := table.Update("Hash", hash).Add("Refs", -n).RunWithContext(ctx)
+		observe(ctx, s.metrics, OperationUpdateItem, s.payloads.tableName, start, err)
+		if err != nil {
+			return eh.EventStoreError{
+				BaseErr:   withRequestID(err),
+				Err:       err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+	}
+	return nil
+}
+
+// dbPayload is the item of a deduplicated payload.
+type dbPayload struct {
+	Hash    string `dynamo:",hash"`
+	Payload []byte
+	// Refs is the number of events that reference the payload.
+	Refs int
+}
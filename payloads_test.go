@@ -0,0 +1,85 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestPayloadDedup will store identical event data once
+func (suite *EventStoreTestSuite) TestPayloadDedup() {
+	store := suite.newStore(WithPayloadDedup("test_payloads", 1))
+
+	ctx := eh.NewContextWithNamespace(context.Background(), "payloads")
+	assert.Nil(suite.T(), store.CreateTable(ctx))
+	defer store.DeleteTable(ctx)
+	defer store.deleteTable(ctx, "test_payloads")
+
+	id1, id2 := uuid.New(), uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	data := &mocks.EventData{Content: strings.Repeat("large", 100)}
+	assert.Nil(suite.T(), store.Save(ctx, []eh.Event{
+		eh.NewEventForAggregate(mocks.EventType, data, timestamp, mocks.AggregateType, id1, 1),
+		eh.NewEventForAggregate(mocks.EventType, data, timestamp, mocks.AggregateType, id1, 2),
+	}, 0))
+	assert.Nil(suite.T(), store.Save(ctx, []eh.Event{
+		eh.NewEventForAggregate(mocks.EventType, data, timestamp, mocks.AggregateType, id2, 1),
+	}, 0))
+
+	var payloads []dbPayload
+	assert.Nil(suite.T(), store.service.Table("test_payloads").Scan().All(&payloads))
+	if assert.Len(suite.T(), payloads, 1) {
+		assert.Equal(suite.T(), 3, payloads[0].Refs)
+	}
+
+	store.payloads.payloads = map[string][]byte{}
+	events, err := store.Load(ctx, id1)
+	assert.Nil(suite.T(), err)
+	if assert.Len(suite.T(), events, 2) {
+		assert.Equal(suite.T(), data, events[0].Data())
+		assert.Equal(suite.T(), data, events[1].Data())
+	}
+
+	// Replacing the events moves their references to the new payload.
+	other := &mocks.EventData{Content: "other"}
+	for _, event := range []eh.Event{
+		eh.NewEventForAggregate(mocks.EventType, other, timestamp, mocks.AggregateType, id1, 1),
+		eh.NewEventForAggregate(mocks.EventType, other, timestamp, mocks.AggregateType, id1, 2),
+		eh.NewEventForAggregate(mocks.EventType, other, timestamp, mocks.AggregateType, id2, 1),
+	} {
+		assert.Nil(suite.T(), store.Replace(ctx, event))
+	}
+	events, err = store.Load(ctx, id2)
+	assert.Nil(suite.T(), err)
+	if assert.Len(suite.T(), events, 1) {
+		assert.Equal(suite.T(), other, events[0].Data())
+	}
+
+	pruned, err := store.PrunePayloads(ctx)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 1, pruned)
+	payloads = nil
+	assert.Nil(suite.T(), store.service.Table("test_payloads").Scan().All(&payloads))
+	if assert.Len(suite.T(), payloads, 1) {
+		assert.Equal(suite.T(), 3, payloads[0].Refs)
+	}
+}
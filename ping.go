@@ -0,0 +1,148 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// PingFailure is the kind of failure of a ping.
+type PingFailure int
+
+const (
+	// PingFailed is any other failure, like throttling.
+	PingFailed PingFailure = iota
+	// PingTableNotFound is when the table does not exist.
+	PingTableNotFound
+	// PingCredentialsFailed is when the credentials are missing, expired or
+	// not allowed to describe the table.
+	PingCredentialsFailed
+	// PingUnreachable is when DynamoDB could not be reached.
+	PingUnreachable
+)
+
+// String returns the name of the failure.
+func (f PingFailure) String() string {
+	switch f {
+	case PingTableNotFound:
+		return "table not found"
+	case PingCredentialsFailed:
+		return "credentials failed"
+	case PingUnreachable:
+		return "unreachable"
+	default:
+		return "failed"
+	}
+}
+
+// credentialsFailureCodes are the error codes of requests that failed on the
+// credentials, in addition to the codes of expired credentials.
+var credentialsFailureCodes = []string{
+	"NoCredentialProviders",
+	"UnrecognizedClientException",
+	"InvalidSignatureException",
+	"MissingAuthenticationToken",
+	"AccessDeniedException",
+}
+
+// PingError is the error of a failed ping, with the kind of failure.
+type PingError struct {
+	// Failure is the kind of failure.
+	Failure PingFailure
+	// Table is the pinged table.
+	Table string
+	// Err is the error from DynamoDB.
+	Err error
+	// Namespace is the namespace of the ping.
+	Namespace string
+}
+
+// Error implements the Error method of the errors.Error interface.
+func (e PingError) Error() string {
+	return "ping " + e.Table + ": " + e.Failure.String() + ": " + e.Err.Error() + " (" + e.Namespace + ")"
+}
+
+// Unwrap returns the error from DynamoDB.
+func (e PingError) Unwrap() error {
+	return e.Err
+}
+
+// Ping checks that the event table of the namespace of the context can be
+// reached, with a DescribeTable call, for readiness probes. It returns a
+// PingError with the kind of failure.
+func (s *EventStore) Ping(ctx context.Context) error {
+	ctx, err := s.namespace(ctx)
+	if err != nil {
+		return err
+	}
+
+	return ping(ctx, s.service.Client(), s.metrics, s.tableName(ctx))
+}
+
+// Ping checks that the table of the namespace of the context can be reached,
+// with a DescribeTable call, for readiness probes. It returns a PingError
+// with the kind of failure.
+func (r *Repo) Ping(ctx context.Context) error {
+	ctx, err := r.namespace(ctx)
+	if err != nil {
+		return err
+	}
+
+	return ping(ctx, r.service.Client(), r.metrics, r.tableName(ctx))
+}
+
+// ping describes a table and classifies the error.
+func ping(ctx context.Context, client dynamodbiface.DynamoDBAPI, metrics Metrics, tableName string) error {
+	start := time.Now()
+	_, err := client.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(tableName),
+	})
+	observe(ctx, metrics, OperationDescribeTable, tableName, start, err)
+	if err == nil {
+		return nil
+	}
+
+	return PingError{
+		Failure:   pingFailure(err),
+		Table:     tableName,
+		Err:       withRequestID(err),
+		Namespace: eh.NamespaceFromContext(ctx),
+	}
+}
+
+// pingFailure returns the kind of failure of a ping error.
+func pingFailure(err error) PingFailure {
+	if isAWSErrorCode(err, dynamodb.ErrCodeResourceNotFoundException) {
+		return PingTableNotFound
+	}
+	if isExpiredCredentials(err) {
+		return PingCredentialsFailed
+	}
+	for _, code := range credentialsFailureCodes {
+		if isAWSErrorCode(err, code) {
+			return PingCredentialsFailed
+		}
+	}
+	if isUnreachable(err) {
+		return PingUnreachable
+	}
+	return PingFailed
+}
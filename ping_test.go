@@ -0,0 +1,59 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/stretchr/testify/assert"
+)
+
+// describeErrClient is a DynamoDB client that fails to describe tables.
+type describeErrClient struct {
+	dynamodbiface.DynamoDBAPI
+	err error
+}
+
+func (c describeErrClient) DescribeTableWithContext(ctx aws.Context, in *dynamodb.DescribeTableInput, opts ...request.Option) (*dynamodb.DescribeTableOutput, error) {
+	return &dynamodb.DescribeTableOutput{}, c.err
+}
+
+func TestPing(t *testing.T) {
+	ctx := context.Background()
+	assert.Nil(t, ping(ctx, describeErrClient{}, nil, "test"))
+
+	for err, failure := range map[error]PingFailure{
+		awserr.New(dynamodb.ErrCodeResourceNotFoundException, "not found", nil):               PingTableNotFound,
+		awserr.New("ExpiredTokenException", "expired", nil):                                   PingCredentialsFailed,
+		awserr.New("UnrecognizedClientException", "invalid token", nil):                       PingCredentialsFailed,
+		awserr.New(request.ErrCodeRequestError, "send request failed", errors.New("refused")): PingUnreachable,
+		awserr.New(dynamodb.ErrCodeInternalServerError, "internal", nil):                      PingFailed,
+	} {
+		pingErr := ping(ctx, describeErrClient{err: err}, nil, "test")
+		var e PingError
+		if assert.True(t, errors.As(pingErr, &e), err.Error()) {
+			assert.Equal(t, failure, e.Failure, err.Error())
+			assert.Equal(t, "test", e.Table)
+			assert.True(t, errors.Is(pingErr, err))
+		}
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/google/uuid"
+	"github.com/guregu/dynamo"
+	eh "github.com/looplab/eventhorizon"
+)
+
+const (
+	// positionIndexName is the name of the global position index.
+	positionIndexName = "PositionIndex"
+	// globalFeed is the partition of all events in the global position index.
+	globalFeed = "all"
+	// positionSettleTime is how long LoadAllFrom waits for a gap in the
+	// positions to be filled by a save that is still in flight.
+	positionSettleTime = 5 * time.Second
+)
+
+// positionIndex is the index used to load events in global position order.
+var positionIndex = tableIndex{
+	name:         positionIndexName,
+	hashKey:      "Feed",
+	hashKeyType:  dynamodb.ScalarAttributeTypeS,
+	rangeKey:     "Position",
+	rangeKeyType: dynamodb.ScalarAttributeTypeN,
+}
+
+// WithGlobalPosition assigns a global position to every saved event, from an
+// atomic counter item, and adds an index on the position to the table in
+// CreateTable. LoadAllFrom then loads the events of the store in a stable,
+// resumable order. The positions of a namespace are increasing but can have
+// gaps from saves that failed. Note that the counter and the index limit the
+// write throughput of a namespace to what a single partition can take.
+func WithGlobalPosition() Option {
+	return func(s *EventStore) error {
+		s.globalPosition = true
+		s.indexes = append(s.indexes, positionIndex)
+		return nil
+	}
+}
+
+// GlobalPosition returns the global position of an event loaded from the
+// store, or 0 if it has none.
+func GlobalPosition(e eh.Event) int64 {
+	if e, ok := e.(event); ok {
+		return e.Position
+	}
+	return 0
+}
+
+// LoadAllFrom loads at most limit events after a global position, in
+// position order. Pass the position of the last handled event to resume. It
+// stops before a gap in the positions until the gap is older than a few
+// seconds, so that events of saves that are still in flight are not skipped.
+// It needs the WithGlobalPosition option.
+func (s *EventStore) LoadAllFrom(ctx context.Context, position int64, limit int) ([]eh.Event, error) {
+	ctx, err := s.namespace(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if !s.globalPosition {
+		return nil, eh.EventStoreError{
+			Err:       ErrIndexNotEnabled,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	tables, err := s.eventTables(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var dbEvents []dbEvent
+	for _, tableName := range tables {
+		table := s.service.Table(tableName)
+
+		var tableEvents []dbEvent
+		start := time.Now()
+		err := table.Get("Feed", globalFeed).
+			Range("Position", dynamo.Greater, position).
+			Index(positionIndexName).
+			Limit(int64(limit)).
+			AllWithContext(ctx, &tableEvents)
+		observe(ctx, s.metrics, OperationQuery, tableName, start, err)
+		if err != nil {
+			return nil, eh.EventStoreError{
+				BaseErr:   withRequestID(err),
+				Err:       err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+		dbEvents = append(dbEvents, tableEvents...)
+	}
+
+	sort.Slice(dbEvents, func(i, j int) bool {
+		return dbEvents[i].Position < dbEvents[j].Position
+	})
+	if len(dbEvents) > limit {
+		dbEvents = dbEvents[:limit]
+	}
+
+	// Stop at the first recent gap.
+	next := position + 1
+	for i, e := range dbEvents {
+		if e.Position != next && s.now().Sub(time.Unix(0, e.PositionedAt)) < positionSettleTime {
+			dbEvents = dbEvents[:i]
+			break
+		}
+		next = e.Position + 1
+	}
+
+	return s.buildEvents(ctx, dbEvents)
+}
+
+// reservePositions reserves n global positions and returns the first.
+func (s *EventStore) reservePositions(ctx context.Context, n int) (int64, error) {
+	tableName := s.tableName(ctx)
+	var out *dynamodb.UpdateItemOutput
+	err := s.autoCreate(ctx, func() error {
+		start := time.Now()
+		var err error
+		out, err = s.service.Client().UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+			TableName: aws.String(tableName),
+			Key: map[string]*dynamodb.AttributeValue{
+				"AggregateID": {S: aws.String(uuid.Nil.String())},
+				"Version":     {N: aws.String(strconv.Itoa(aggregateHeadVersion))},
+			},
+			UpdateExpression: aws.String("ADD Position :n"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":n": {N: aws.String(strconv.Itoa(n))},
+			},
+			ReturnValues: aws.String(dynamodb.ReturnValueUpdatedNew),
+		})
+		observe(ctx, s.metrics, OperationUpdateItem, tableName, start, err)
+		return err
+	})
+	if err != nil {
+		return 0, eh.EventStoreError{
+			BaseErr:   withRequestID(err),
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	last, err := strconv.ParseInt(aws.StringValue(out.Attributes["Position"].N), 10, 64)
+	if err != nil {
+		return 0, eh.EventStoreError{
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+	return last - int64(n) + 1, nil
+}
+
+// keepPosition copies the global position of a stored event to its
+// replacement.
+func (s *EventStore) keepPosition(ctx context.Context, table dynamo.Table, e *dbEvent) error {
+	var existing dbEvent
+	err := table.Get("AggregateID", e.AggregateID.String()).
+		Range("Version", dynamo.Equal, e.Version).
+		Consistent(true).
+		OneWithContext(ctx, &existing)
+	if err == dynamo.ErrNotFound {
+		return eh.ErrInvalidEvent
+	} else if err != nil {
+		return eh.EventStoreError{
+			BaseErr:   withRequestID(err),
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	e.Feed = existing.Feed
+	e.Position = existing.Position
+	e.PositionedAt = existing.PositionedAt
+	return nil
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestGlobalPosition will load the events of all aggregates in save order
+func (suite *EventStoreTestSuite) TestGlobalPosition() {
+	store := suite.newStore(WithGlobalPosition())
+
+	ctx := eh.NewContextWithNamespace(context.Background(), "position")
+	assert.Nil(suite.T(), store.CreateTable(ctx))
+	defer store.DeleteTable(ctx)
+
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	id1, id2 := uuid.New(), uuid.New()
+	event1 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event1"},
+		timestamp, mocks.AggregateType, id1, 1)
+	event2 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event2"},
+		timestamp, mocks.AggregateType, id2, 1)
+	event3 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event3"},
+		timestamp, mocks.AggregateType, id1, 2)
+	assert.Nil(suite.T(), store.Save(ctx, []eh.Event{event1}, 0))
+	assert.Nil(suite.T(), store.Save(ctx, []eh.Event{event2}, 0))
+	assert.Nil(suite.T(), store.Save(ctx, []eh.Event{event3}, 1))
+
+	loaded, err := store.LoadAllFrom(ctx, 0, 10)
+	assert.Nil(suite.T(), err)
+	if assert.Len(suite.T(), loaded, 3) {
+		for i, event := range []eh.Event{event1, event2, event3} {
+			assert.Equal(suite.T(), event.Data(), loaded[i].Data())
+			assert.Equal(suite.T(), int64(i+1), GlobalPosition(loaded[i]))
+		}
+	}
+
+	// Resume after the first event, with a limit.
+	loaded, err = store.LoadAllFrom(ctx, 1, 1)
+	assert.Nil(suite.T(), err)
+	if assert.Len(suite.T(), loaded, 1) {
+		assert.Equal(suite.T(), event2.Data(), loaded[0].Data())
+	}
+
+	// Replacing an event keeps its position.
+	event2 = eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "updated"},
+		timestamp, mocks.AggregateType, id2, 1)
+	assert.Nil(suite.T(), store.Replace(ctx, event2))
+	loaded, err = store.LoadAllFrom(ctx, 1, 1)
+	assert.Nil(suite.T(), err)
+	if assert.Len(suite.T(), loaded, 1) {
+		assert.Equal(suite.T(), event2.Data(), loaded[0].Data())
+		assert.Equal(suite.T(), int64(2), GlobalPosition(loaded[0]))
+	}
+
+	_, err = store.LoadAllFrom(ctx, 3, 10)
+	assert.Nil(suite.T(), err)
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/google/uuid"
+	"github.com/guregu/dynamo"
+	eh "github.com/looplab/eventhorizon"
+)
+
+const (
+	// appliedVersionsAttr is the attribute with the version of the last
+	// event applied to an entity per source aggregate, as a map keyed by
+	// aggregate ID.
+	appliedVersionsAttr = "LastAppliedVersions"
+	// appliedTimestampAttr is the attribute with the timestamp of the last
+	// event applied to an entity.
+	appliedTimestampAttr = "LastAppliedTimestamp"
+)
+
+// SaveForEvent saves an entity that was projected from an event, recording
+// the version of the event per source aggregate and the timestamp of the
+// event on the entity item. The save is conditional on the event not already
+// being applied, which makes duplicate deliveries from at-least-once event
+// buses harmless, also for entities that are projected from the events of
+// several aggregates. It returns false if the event was already applied and
+// the entity was left untouched.
+func (r *Repo) SaveForEvent(ctx context.Context, entity eh.Entity, event eh.Event) (bool, error) {
+	ctx, err := r.namespace(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	if entity.EntityID() == uuid.Nil {
+		return false, eh.RepoError{
+			Err:       wrapError(eh.ErrCouldNotSaveEntity, eh.ErrMissingEntityID),
+			BaseErr:   eh.ErrMissingEntityID,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	item, err := dynamo.MarshalItem(entity)
+	if err != nil {
+		return false, eh.RepoError{
+			Err:       wrapError(eh.ErrCouldNotSaveEntity, err),
+			BaseErr:   withRequestID(err),
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+	item[appliedTimestampAttr] = &dynamodb.AttributeValue{S: aws.String(event.Timestamp().UTC().Format(time.RFC3339Nano))}
+
+	tableName := r.tableName(ctx)
+	aggregateID := event.AggregateID().String()
+	for {
+		versions, err := r.appliedVersions(ctx, tableName, entity.EntityID())
+		if err != nil {
+			return false, eh.RepoError{
+				Err:       wrapError(eh.ErrCouldNotSaveEntity, err),
+				BaseErr:   withRequestID(err),
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+		if v, ok := versions[aggregateID]; ok {
+			if applied, _ := strconv.Atoi(aws.StringValue(v.N)); applied >= event.Version() {
+				// The event is already applied, so the read model is at
+				// least as fresh as the event.
+				r.staleness.applied(tableName, event.Timestamp())
+				return false, nil
+			}
+		}
+
+		// Keep the versions of the other source aggregates, on the
+		// condition that they were not changed since they were read.
+		input := &dynamodb.PutItemInput{
+			TableName:                aws.String(tableName),
+			Item:                     item,
+			ConditionExpression:      aws.String("attribute_not_exists(#versions)"),
+			ExpressionAttributeNames: map[string]*string{"#versions": aws.String(appliedVersionsAttr)},
+		}
+		next := map[string]*dynamodb.AttributeValue{}
+		if versions != nil {
+			input.ConditionExpression = aws.String("#versions = :versions")
+			input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+				":versions": {M: versions},
+			}
+			for id, v := range versions {
+				next[id] = v
+			}
+		}
+		next[aggregateID] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(event.Version()))}
+		item[appliedVersionsAttr] = &dynamodb.AttributeValue{M: next}
+
+		start := time.Now()
+		_, err = r.service.Client().PutItemWithContext(ctx, input)
+		observe(ctx, r.metrics, OperationPutItem, tableName, start, err)
+		if isAWSErrorCode(err, dynamodb.ErrCodeConditionalCheckFailedException) {
+			// Another event was applied meanwhile, check again.
+			continue
+		} else if err != nil {
+			return false, eh.RepoError{
+				Err:       wrapError(eh.ErrCouldNotSaveEntity, err),
+				BaseErr:   withRequestID(err),
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+
+		r.staleness.applied(tableName, event.Timestamp())
+		return true, nil
+	}
+}
+
+// appliedVersions returns the versions of the last events applied to an
+// entity per source aggregate, or nil if the entity has none.
+func (r *Repo) appliedVersions(ctx context.Context, tableName string, id uuid.UUID) (map[string]*dynamodb.AttributeValue, error) {
+	start := time.Now()
+	out, err := r.service.Client().GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"ID": {S: aws.String(id.String())},
+		},
+		ProjectionExpression:     aws.String("#versions"),
+		ExpressionAttributeNames: map[string]*string{"#versions": aws.String(appliedVersionsAttr)},
+		ConsistentRead:           aws.Bool(true),
+	})
+	observe(ctx, r.metrics, OperationGetItem, tableName, start, err)
+	if err != nil {
+		return nil, err
+	}
+	if v, ok := out.Item[appliedVersionsAttr]; ok && v.M != nil {
+		return v.M, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,171 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package prommetrics exports the metrics of the event store to Prometheus:
+// calls, errors by class and latency histograms of Save, Load, LoadAll and
+// Replace, and errors and latency histograms of the DynamoDB operations.
+//
+// The metrics are registered with a prometheus.Registerer:
+//
+//	store, err := ehdynamodb.NewEventStore("app",
+//		prommetrics.WithMetrics(prometheus.DefaultRegisterer))
+//
+// Version conflicts of saves are counted with the error class "conflict", to
+// alert on conflict rates. The metrics are labeled by namespace, which is
+// meant for a bounded number of namespaces.
+package prommetrics
+
+import (
+	"context"
+	"errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+	ehdynamodb "github.com/sysbot/eh-dynamodb"
+)
+
+// metricsNamespace is the prefix of the names of the metrics.
+const metricsNamespace = "eh_dynamodb"
+
+// conflictClass is the error class of version conflicts.
+const conflictClass = "conflict"
+
+// Metrics records the metrics of event stores, and implements the Metrics
+// and MethodMetrics interfaces of the event store. The metrics can be shared
+// by several event stores.
+type Metrics struct {
+	calls           *prometheus.CounterVec
+	errors          *prometheus.CounterVec
+	duration        *prometheus.HistogramVec
+	operationErrors *prometheus.CounterVec
+	operations      *prometheus.HistogramVec
+}
+
+// New creates the metrics and registers them. Metrics that are already
+// registered, by another call of New with the same registerer, are shared.
+func New(reg prometheus.Registerer) (*Metrics, error) {
+	m := &Metrics{
+		calls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "store_calls_total",
+			Help:      "Number of calls of the methods of the event store.",
+		}, []string{"method", "namespace"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "store_errors_total",
+			Help:      "Number of failed calls of the methods of the event store, by error class.",
+		}, []string{"method", "namespace", "class"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "store_duration_seconds",
+			Help:      "Latency of the calls of the methods of the event store.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "namespace"}),
+		operationErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "operation_errors_total",
+			Help:      "Number of failed DynamoDB operations, by error class.",
+		}, []string{"operation", "table", "class"}),
+		operations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "operation_duration_seconds",
+			Help:      "Latency of the DynamoDB operations.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"operation", "table"}),
+	}
+
+	var err error
+	if m.calls, err = registerCounter(reg, m.calls); err != nil {
+		return nil, err
+	}
+	if m.errors, err = registerCounter(reg, m.errors); err != nil {
+		return nil, err
+	}
+	if m.duration, err = registerHistogram(reg, m.duration); err != nil {
+		return nil, err
+	}
+	if m.operationErrors, err = registerCounter(reg, m.operationErrors); err != nil {
+		return nil, err
+	}
+	if m.operations, err = registerHistogram(reg, m.operations); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// WithMetrics records the metrics of the event store, registered with a
+// registerer as by New.
+func WithMetrics(reg prometheus.Registerer) ehdynamodb.Option {
+	return func(s *ehdynamodb.EventStore) error {
+		m, err := New(reg)
+		if err != nil {
+			return err
+		}
+		return ehdynamodb.WithMetrics(m)(s)
+	}
+}
+
+// ObserveMethod implements the ObserveMethod method of the
+// ehdynamodb.MethodMetrics interface.
+func (m *Metrics) ObserveMethod(ctx context.Context, metric ehdynamodb.MethodMetric) {
+	method := string(metric.Method)
+	m.calls.WithLabelValues(method, metric.Namespace).Inc()
+	m.duration.WithLabelValues(method, metric.Namespace).Observe(metric.Duration.Seconds())
+	if metric.Err == nil {
+		return
+	}
+
+	class := metric.ErrorClass.String()
+	if ehdynamodb.IsVersionConflict(metric.Err) {
+		class = conflictClass
+	}
+	m.errors.WithLabelValues(method, metric.Namespace, class).Inc()
+}
+
+// ObserveOperation implements the ObserveOperation method of the
+// ehdynamodb.Metrics interface.
+func (m *Metrics) ObserveOperation(ctx context.Context, metric ehdynamodb.OperationMetric) {
+	operation := string(metric.Operation)
+	m.operations.WithLabelValues(operation, metric.Table).Observe(metric.Duration.Seconds())
+	if metric.Err != nil {
+		m.operationErrors.WithLabelValues(operation, metric.Table, metric.ErrorClass.String()).Inc()
+	}
+}
+
+// registerCounter registers a counter, or returns the registered one.
+func registerCounter(reg prometheus.Registerer, c *prometheus.CounterVec) (*prometheus.CounterVec, error) {
+	if err := reg.Register(c); err != nil {
+		var registered prometheus.AlreadyRegisteredError
+		if errors.As(err, &registered) {
+			if existing, ok := registered.ExistingCollector.(*prometheus.CounterVec); ok {
+				return existing, nil
+			}
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+// registerHistogram registers a histogram, or returns the registered one.
+func registerHistogram(reg prometheus.Registerer, h *prometheus.HistogramVec) (*prometheus.HistogramVec, error) {
+	if err := reg.Register(h); err != nil {
+		var registered prometheus.AlreadyRegisteredError
+		if errors.As(err, &registered) {
+			if existing, ok := registered.ExistingCollector.(*prometheus.HistogramVec); ok {
+				return existing, nil
+			}
+		}
+		return nil, err
+	}
+	return h, nil
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prommetrics
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	ehdynamodb "github.com/sysbot/eh-dynamodb"
+)
+
+func TestMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := New(reg)
+	assert.Nil(t, err)
+
+	// The metrics are shared when registered again.
+	shared, err := New(reg)
+	assert.Nil(t, err)
+
+	ctx := context.Background()
+	m.ObserveMethod(ctx, ehdynamodb.MethodMetric{
+		Method:    ehdynamodb.MethodSave,
+		Namespace: "ns",
+		Duration:  10 * time.Millisecond,
+	})
+	shared.ObserveMethod(ctx, ehdynamodb.MethodMetric{
+		Method:    ehdynamodb.MethodSave,
+		Namespace: "ns",
+		Duration:  20 * time.Millisecond,
+		Err:       ehdynamodb.VersionConflictError{Version: 1},
+	})
+	m.ObserveMethod(ctx, ehdynamodb.MethodMetric{
+		Method:     ehdynamodb.MethodLoad,
+		Namespace:  "ns",
+		Err:        errors.New("throttled"),
+		ErrorClass: ehdynamodb.ErrorThrottled,
+	})
+	m.ObserveOperation(ctx, ehdynamodb.OperationMetric{
+		Operation:  ehdynamodb.OperationQuery,
+		Table:      "table_ns",
+		Err:        errors.New("timeout"),
+		ErrorClass: ehdynamodb.ErrorTimeout,
+	})
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.calls.WithLabelValues("Save", "ns")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.errors.WithLabelValues("Save", "ns", "conflict")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.errors.WithLabelValues("Load", "ns", "throttled")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.operationErrors.WithLabelValues("Query", "table_ns", "timeout")))
+	assert.Equal(t, 2, testutil.CollectAndCount(m.duration))
+	assert.Equal(t, 1, testutil.CollectAndCount(m.operations))
+}
@@ -0,0 +1,231 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/guregu/dynamo"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// Quarantine is an event handler that wraps another handler and quarantines
+// poison events: an event that still fails after the max number of attempts
+// is recorded in a quarantine table and reported as handled, so that a replay
+// or consumer continues with the next event. Quarantined events can be
+// handled again with Requeue once the handler is fixed.
+type Quarantine struct {
+	store       *EventStore
+	tableName   string
+	handler     eh.EventHandler
+	maxAttempts int
+}
+
+// QuarantinedEvent is an event in quarantine.
+type QuarantinedEvent struct {
+	Event         eh.Event
+	Err           string
+	Attempts      int
+	QuarantinedAt time.Time
+}
+
+// NewQuarantine creates a quarantine for a handler, with the quarantine table
+// shared by all namespaces. The store is used to encode the events, with its
+// codec, compression and encryption.
+func NewQuarantine(store *EventStore, tableName string, handler eh.EventHandler, maxAttempts int) *Quarantine {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	return &Quarantine{
+		store:       store,
+		tableName:   store.environment.tableName(tableName),
+		handler:     handler,
+		maxAttempts: maxAttempts,
+	}
+}
+
+// HandlerType implements the HandlerType method of the eventhorizon.EventHandler interface.
+func (q *Quarantine) HandlerType() eh.EventHandlerType {
+	return q.handler.HandlerType()
+}
+
+// HandleEvent implements the HandleEvent method of the eventhorizon.EventHandler
+// interface. It only returns an error if the event could not be quarantined.
+func (q *Quarantine) HandleEvent(ctx context.Context, event eh.Event) error {
+	ctx, err := q.store.namespace(ctx)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 0; attempt < q.maxAttempts; attempt++ {
+		if err = q.handler.HandleEvent(ctx, event); err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+	}
+
+	return q.quarantine(ctx, event, err, q.maxAttempts)
+}
+
+// List returns the quarantined events of the namespace of the context.
+func (q *Quarantine) List(ctx context.Context) ([]QuarantinedEvent, error) {
+	ctx, err := q.store.namespace(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := q.items(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]QuarantinedEvent, len(items))
+	for i, item := range items {
+		event, err := q.store.buildEvent(ctx, item.Event)
+		if err != nil {
+			return nil, err
+		}
+		events[i] = QuarantinedEvent{
+			Event:         event,
+			Err:           item.Err,
+			Attempts:      item.Attempts,
+			QuarantinedAt: item.QuarantinedAt,
+		}
+	}
+	return events, nil
+}
+
+// Requeue handles the quarantined events of the namespace of the context
+// again, once each. Events that are handled are removed from the quarantine
+// and the others stay with their new error. It returns the number of events
+// that were handled.
+func (q *Quarantine) Requeue(ctx context.Context) (int, error) {
+	ctx, err := q.store.namespace(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	items, err := q.items(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	table := q.store.service.Table(q.tableName)
+	handled := 0
+	for _, item := range items {
+		event, err := q.store.buildEvent(ctx, item.Event)
+		if err != nil {
+			return handled, err
+		}
+
+		if err := q.handler.HandleEvent(ctx, event); err != nil {
+			if err := q.quarantine(ctx, event, err, item.Attempts+1); err != nil {
+				return handled, err
+			}
+			continue
+		}
+
+		start := time.Now()
+		err = table.Delete("Namespace", item.Namespace).Range("Key", item.Key).RunWithContext(ctx)
+		observe(ctx, q.store.metrics, OperationDeleteItem, q.tableName, start, err)
+		if err != nil {
+			return handled, eh.EventStoreError{
+				BaseErr:   withRequestID(err),
+				Err:       err,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+		handled++
+	}
+
+	return handled, nil
+}
+
+// CreateTable creates the quarantine table if it is not already existing.
+func (q *Quarantine) CreateTable(ctx context.Context) error {
+	ctx = withTableWaiter(ctx, q.store.tableWaiter)
+	return createTable(ctx, q.store.service.Client(), q.tableName, q.store.service.CreateTable(q.tableName, dbQuarantinedEvent{}), nil)
+}
+
+// DeleteTable deletes the quarantine table.
+func (q *Quarantine) DeleteTable(ctx context.Context) error {
+	return q.store.deleteTable(ctx, q.tableName)
+}
+
+// quarantine records an event with the error of its last attempt.
+func (q *Quarantine) quarantine(ctx context.Context, event eh.Event, handlerErr error, attempts int) error {
+	e, err := q.store.newDBEvent(ctx, event)
+	if err != nil {
+		return err
+	}
+
+	item := dbQuarantinedEvent{
+		Namespace:     eh.NamespaceFromContext(ctx),
+		Key:           fmt.Sprintf("%s:%s:%d", q.handler.HandlerType(), event.AggregateID(), event.Version()),
+		Event:         *e,
+		Err:           handlerErr.Error(),
+		Attempts:      attempts,
+		QuarantinedAt: q.store.now(),
+	}
+
+	start := time.Now()
+	err = q.store.service.Table(q.tableName).Put(item).RunWithContext(ctx)
+	observe(ctx, q.store.metrics, OperationPutItem, q.tableName, start, err)
+	if err != nil {
+		return eh.EventStoreError{
+			BaseErr:   withRequestID(err),
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+	return nil
+}
+
+// items returns the quarantine items of the namespace of the context.
+func (q *Quarantine) items(ctx context.Context) ([]dbQuarantinedEvent, error) {
+	var items []dbQuarantinedEvent
+	start := time.Now()
+	err := q.store.service.Table(q.tableName).
+		Get("Namespace", eh.NamespaceFromContext(ctx)).
+		Range("Key", dynamo.BeginsWith, string(q.handler.HandlerType())+":").
+		Consistent(true).
+		AllWithContext(ctx, &items)
+	observe(ctx, q.store.metrics, OperationQuery, q.tableName, start, err)
+	if err != nil && !isAWSErrorCode(err, dynamodb.ErrCodeResourceNotFoundException) {
+		return nil, eh.EventStoreError{
+			BaseErr:   withRequestID(err),
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+	return items, nil
+}
+
+// dbQuarantinedEvent is the item of a quarantined event.
+type dbQuarantinedEvent struct {
+	Namespace string `dynamo:",hash"`
+	// Key is the handler type, aggregate ID and version of the event.
+	Key string `dynamo:",range"`
+
+	Event         dbEvent
+	Err           string
+	Attempts      int
+	QuarantinedAt time.Time
+}
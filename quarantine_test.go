@@ -0,0 +1,76 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestQuarantine will quarantine a poison event and requeue it once fixed
+func (suite *EventStoreTestSuite) TestQuarantine() {
+	handler := &poisonHandler{poison: map[int]bool{2: true}}
+	q := NewQuarantine(suite.store, "test_quarantine", handler, 3)
+	assert.Nil(suite.T(), q.CreateTable(context.Background()))
+	defer q.DeleteTable(context.Background())
+
+	id := uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	for i := 1; i <= 3; i++ {
+		event := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event"},
+			timestamp, mocks.AggregateType, id, i)
+		assert.Nil(suite.T(), q.HandleEvent(suite.ctx, event))
+	}
+	assert.Len(suite.T(), handler.handled, 2)
+	assert.Equal(suite.T(), 5, handler.calls)
+
+	quarantined, err := q.List(suite.ctx)
+	assert.Nil(suite.T(), err)
+	if assert.Len(suite.T(), quarantined, 1) {
+		assert.Equal(suite.T(), 2, quarantined[0].Event.Version())
+		assert.Equal(suite.T(), &mocks.EventData{Content: "event"}, quarantined[0].Event.Data())
+		assert.Equal(suite.T(), "poison event", quarantined[0].Err)
+		assert.Equal(suite.T(), 3, quarantined[0].Attempts)
+	}
+
+	// Other namespaces have their own quarantine.
+	quarantined, err = q.List(context.Background())
+	assert.Nil(suite.T(), err)
+	assert.Len(suite.T(), quarantined, 0)
+
+	// Requeuing a still failing event keeps it in quarantine.
+	n, err := q.Requeue(suite.ctx)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 0, n)
+	quarantined, err = q.List(suite.ctx)
+	assert.Nil(suite.T(), err)
+	if assert.Len(suite.T(), quarantined, 1) {
+		assert.Equal(suite.T(), 4, quarantined[0].Attempts)
+	}
+
+	handler.poison = nil
+	n, err = q.Requeue(suite.ctx)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 1, n)
+	assert.Len(suite.T(), handler.handled, 3)
+	quarantined, err = q.List(suite.ctx)
+	assert.Nil(suite.T(), err)
+	assert.Len(suite.T(), quarantined, 0)
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"time"
+
+	"github.com/guregu/dynamo"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// QueryIndex queries an index and unmarshals the items into out, which must
+// be a pointer to a slice of any type, for example of a list view with only
+// some of the fields of the entity. It does not need an entity factory and
+// returns the typed results without type assertions:
+//
+//	var items []struct{ ID uuid.UUID; Content string }
+//	err := repo.QueryIndex(ctx, indexInput, &items, "")
+//
+// The sort key of the index input is optional. The filter is optional and
+// skipped if empty.
+func (r *Repo) QueryIndex(ctx context.Context, indexInput IndexInput, out interface{}, filterQuery string, filterArgs ...interface{}) error {
+	ctx, err := r.namespace(ctx)
+	if err != nil {
+		return err
+	}
+
+	tableName := r.tableName(ctx)
+	table := r.readService().Table(tableName)
+
+	query := table.Get(indexInput.PartitionKey, indexInput.PartitionKeyValue).
+		Index(indexInput.IndexName)
+	if indexInput.SortKey != "" {
+		query = query.Range(indexInput.SortKey, dynamo.Equal, indexInput.SortKeyValue)
+	}
+	if filterQuery != "" {
+		query = query.Filter(filterQuery, filterArgs...)
+	}
+
+	start := time.Now()
+	err = query.AllWithContext(ctx, out)
+	observe(ctx, r.metrics, OperationQuery, tableName, start, err)
+	if err != nil {
+		return eh.RepoError{
+			Err:       err,
+			BaseErr:   withRequestID(err),
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	return nil
+}
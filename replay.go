@@ -0,0 +1,322 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/guregu/dynamo"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// replayPageSize is the max number of items read per page of a replay.
+const replayPageSize = 100
+
+// Replayer streams the events of the store into an event handler, for
+// example to rebuild a read model. A checkpoint is saved in a checkpoint
+// table after every page, so that an interrupted replay resumes where it
+// stopped when run again. Events of the page that was interrupted are handled
+// again, which handlers must tolerate.
+type Replayer struct {
+	store     *EventStore
+	tableName string
+	handler   eh.EventHandler
+	pageSize  int
+
+	aggregateID uuid.UUID
+	eventType   eh.EventType
+}
+
+// ReplayOption is an option setter used to configure a replayer.
+type ReplayOption func(*Replayer) error
+
+// WithReplayAggregate replays only the events of one aggregate.
+func WithReplayAggregate(id uuid.UUID) ReplayOption {
+	return func(r *Replayer) error {
+		r.aggregateID = id
+		return nil
+	}
+}
+
+// WithReplayEventType replays only the events of one event type.
+func WithReplayEventType(eventType eh.EventType) ReplayOption {
+	return func(r *Replayer) error {
+		r.eventType = eventType
+		return nil
+	}
+}
+
+// NewReplayer creates a replayer for a handler, with the checkpoint table
+// shared by all namespaces.
+func NewReplayer(store *EventStore, tableName string, handler eh.EventHandler, options ...ReplayOption) (*Replayer, error) {
+	r := &Replayer{
+		store:     store,
+		tableName: store.environment.tableName(tableName),
+		handler:   handler,
+		pageSize:  replayPageSize,
+	}
+
+	for _, option := range options {
+		if err := option(r); err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// Replay handles the events of the namespace of the context, resuming from
+// the checkpoint of an earlier replay. It returns the first error from the
+// handler. A completed replay does nothing until Reset is called.
+func (r *Replayer) Replay(ctx context.Context) error {
+	ctx, err := r.store.namespace(ctx)
+	if err != nil {
+		return err
+	}
+
+	cp, err := r.checkpoint(ctx)
+	if err != nil {
+		return err
+	}
+	if cp.Done {
+		return nil
+	}
+
+	tables, err := r.store.eventTables(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Skip the tables that were already replayed.
+	for i, tableName := range tables {
+		if tableName == cp.Table {
+			tables = tables[i:]
+			break
+		}
+	}
+
+	// Throttle and report the progress when run as a scheduler job.
+	run := jobRunFromContext(ctx)
+	var pages int64
+
+	for _, tableName := range tables {
+		var key dynamo.PagingKey
+		if tableName == cp.Table && cp.StartKey != nil {
+			if err := json.Unmarshal(cp.StartKey, &key); err != nil {
+				return eh.EventStoreError{
+					Err:       err,
+					Namespace: eh.NamespaceFromContext(ctx),
+				}
+			}
+		}
+		cp.Table = tableName
+
+		for {
+			if run != nil {
+				if err := run.Wait(ctx); err != nil {
+					return err
+				}
+			}
+			if key, err = r.replayPage(ctx, tableName, key); err != nil {
+				return err
+			}
+			if key == nil {
+				cp.StartKey = nil
+				break
+			}
+
+			if cp.StartKey, err = json.Marshal(key); err != nil {
+				return eh.EventStoreError{
+					Err:       err,
+					Namespace: eh.NamespaceFromContext(ctx),
+				}
+			}
+			if err := r.saveCheckpoint(ctx, cp); err != nil {
+				return err
+			}
+			if run != nil {
+				pages++
+				run.Progress(pages, 0)
+			}
+		}
+	}
+
+	cp.Done = true
+	return r.saveCheckpoint(ctx, cp)
+}
+
+// Reset removes the checkpoint of the namespace of the context, so that the
+// next replay starts from the beginning.
+func (r *Replayer) Reset(ctx context.Context) error {
+	ctx, err := r.store.namespace(ctx)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	err = r.store.service.Table(r.tableName).
+		Delete("Namespace", eh.NamespaceFromContext(ctx)).
+		Range("Key", r.key()).
+		RunWithContext(ctx)
+	observe(ctx, r.store.metrics, OperationDeleteItem, r.tableName, start, err)
+	if err != nil {
+		return eh.EventStoreError{
+			BaseErr:   withRequestID(err),
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+	return nil
+}
+
+// CreateTable creates the checkpoint table if it is not already existing.
+func (r *Replayer) CreateTable(ctx context.Context) error {
+	ctx = withTableWaiter(ctx, r.store.tableWaiter)
+	return createTable(ctx, r.store.service.Client(), r.tableName, r.store.service.CreateTable(r.tableName, dbReplayCheckpoint{}), nil)
+}
+
+// DeleteTable deletes the checkpoint table.
+func (r *Replayer) DeleteTable(ctx context.Context) error {
+	return r.store.deleteTable(ctx, r.tableName)
+}
+
+// replayPage handles one page of events from a table and returns the key to
+// continue from, or nil after the last page.
+func (r *Replayer) replayPage(ctx context.Context, tableName string, startKey dynamo.PagingKey) (dynamo.PagingKey, error) {
+	table := r.store.service.Table(tableName)
+
+	var iter dynamo.PagingIter
+	var op Operation
+	var throttle *scanThrottle
+	if r.aggregateID != uuid.Nil {
+		query := table.Get(r.store.hashKey(), r.store.hashValue(ctx, r.aggregateID)).
+			Range("Version", dynamo.Greater, aggregateHeadVersion).
+			Consistent(true)
+		if r.eventType != "" {
+			query = query.Filter("EventType = ?", r.store.typeNames.EventTypeName(r.eventType))
+		}
+		iter = query.SearchLimit(int64(r.pageSize)).StartFrom(startKey).Iter()
+		op = OperationQuery
+	} else {
+		scan := r.store.scanNamespace(ctx, table.Scan()).Filter("Version > ?", aggregateHeadVersion).Consistent(true)
+		if r.eventType != "" {
+			scan = scan.Filter("EventType = ?", r.store.typeNames.EventTypeName(r.eventType))
+		}
+		throttle = r.store.newScanThrottle()
+		iter = throttle.scan(scan).SearchLimit(int64(r.pageSize)).StartFrom(startKey).Iter()
+		op = OperationScan
+	}
+
+	start := time.Now()
+	var e dbEvent
+	for iter.NextWithContext(ctx, &e) {
+		event, err := r.store.buildEvent(ctx, e)
+		if err != nil {
+			return nil, err
+		}
+		if err := r.handler.HandleEvent(ctx, event); err != nil {
+			return nil, err
+		}
+		e = dbEvent{}
+	}
+	err := iter.Err()
+	observe(ctx, r.store.metrics, op, tableName, start, err)
+	if err != nil {
+		return nil, eh.EventStoreError{
+			BaseErr:   withRequestID(err),
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	// Wait for the capacity of the page before the next one, if limited.
+	if err := throttle.wait(ctx); err != nil {
+		return nil, err
+	}
+
+	return iter.LastEvaluatedKey(), nil
+}
+
+// checkpoint loads the checkpoint of the namespace of the context, or a new
+// one if there is none.
+func (r *Replayer) checkpoint(ctx context.Context) (dbReplayCheckpoint, error) {
+	cp := dbReplayCheckpoint{
+		Namespace: eh.NamespaceFromContext(ctx),
+		Key:       r.key(),
+	}
+
+	start := time.Now()
+	err := r.store.service.Table(r.tableName).
+		Get("Namespace", cp.Namespace).
+		Range("Key", dynamo.Equal, cp.Key).
+		Consistent(true).
+		OneWithContext(ctx, &cp)
+	observe(ctx, r.store.metrics, OperationGetItem, r.tableName, start, err)
+	if err != nil && err != dynamo.ErrNotFound {
+		return cp, eh.EventStoreError{
+			BaseErr:   withRequestID(err),
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+	return cp, nil
+}
+
+// saveCheckpoint saves the checkpoint of the namespace of the context.
+func (r *Replayer) saveCheckpoint(ctx context.Context, cp dbReplayCheckpoint) error {
+	cp.UpdatedAt = r.store.now()
+
+	start := time.Now()
+	err := r.store.service.Table(r.tableName).Put(cp).RunWithContext(ctx)
+	observe(ctx, r.store.metrics, OperationPutItem, r.tableName, start, err)
+	if err != nil {
+		return eh.EventStoreError{
+			BaseErr:   withRequestID(err),
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+	return nil
+}
+
+// key returns the checkpoint key of the replay, from the handler type and
+// the event filters.
+func (r *Replayer) key() string {
+	key := string(r.handler.HandlerType())
+	if r.aggregateID != uuid.Nil {
+		key += ":" + r.aggregateID.String()
+	}
+	if r.eventType != "" {
+		key += ":" + string(r.eventType)
+	}
+	return key
+}
+
+// dbReplayCheckpoint is the item of a replay checkpoint.
+type dbReplayCheckpoint struct {
+	Namespace string `dynamo:",hash"`
+	// Key is the handler type and the event filters of the replay.
+	Key string `dynamo:",range"`
+
+	// Table and StartKey are where to continue the replay, with the start
+	// key as JSON.
+	Table     string
+	StartKey  []byte `dynamo:",omitempty"`
+	Done      bool
+	UpdatedAt time.Time
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestReplayer will replay events and resume from the checkpoint
+func (suite *EventStoreTestSuite) TestReplayer() {
+	ctx := eh.NewContextWithNamespace(context.Background(), "replay")
+	assert.Nil(suite.T(), suite.store.CreateTable(ctx))
+	defer suite.store.DeleteTable(ctx)
+
+	id1, id2 := uuid.New(), uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	var events []eh.Event
+	for i := 1; i <= 3; i++ {
+		events = append(events, eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event"},
+			timestamp, mocks.AggregateType, id1, i))
+	}
+	assert.Nil(suite.T(), suite.store.Save(ctx, events, 0))
+	assert.Nil(suite.T(), suite.store.Save(ctx, []eh.Event{
+		eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event"},
+			timestamp, mocks.AggregateType, id2, 1),
+		eh.NewEventForAggregate(mocks.EventOtherType, &mocks.EventData{Content: "event"},
+			timestamp, mocks.AggregateType, id2, 2),
+	}, 0))
+
+	handler := &poisonHandler{poison: map[int]bool{3: true}}
+	r, err := NewReplayer(suite.store, "test_replay", handler)
+	assert.Nil(suite.T(), err)
+	r.pageSize = 1
+	assert.Nil(suite.T(), r.CreateTable(ctx))
+	defer r.DeleteTable(ctx)
+
+	// An interrupted replay resumes after the last handled page.
+	assert.EqualError(suite.T(), r.Replay(ctx), "poison event")
+	handler.poison = nil
+	assert.Nil(suite.T(), r.Replay(ctx))
+	assert.Len(suite.T(), handler.handled, 5)
+
+	// A completed replay does nothing until reset.
+	assert.Nil(suite.T(), r.Replay(ctx))
+	assert.Len(suite.T(), handler.handled, 5)
+	assert.Nil(suite.T(), r.Reset(ctx))
+	assert.Nil(suite.T(), r.Replay(ctx))
+	assert.Len(suite.T(), handler.handled, 10)
+
+	handler = &poisonHandler{}
+	r, err = NewReplayer(suite.store, "test_replay", handler, WithReplayAggregate(id2))
+	assert.Nil(suite.T(), err)
+	assert.Nil(suite.T(), r.Replay(ctx))
+	if assert.Len(suite.T(), handler.handled, 2) {
+		assert.Equal(suite.T(), 1, handler.handled[0].Version())
+		assert.Equal(suite.T(), 2, handler.handled[1].Version())
+	}
+
+	handler = &poisonHandler{}
+	r, err = NewReplayer(suite.store, "test_replay", handler, WithReplayEventType(mocks.EventOtherType))
+	assert.Nil(suite.T(), err)
+	assert.Nil(suite.T(), r.Replay(ctx))
+	if assert.Len(suite.T(), handler.handled, 1) {
+		assert.Equal(suite.T(), id2, handler.handled[0].AggregateID())
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
@@ -26,6 +27,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/guregu/dynamo"
 	eh "github.com/looplab/eventhorizon"
+	"github.com/sysbot/eh-dynamodb/cursor"
 )
 
 // ErrCouldNotDialDB is when the database could not be dialed.
@@ -38,8 +40,29 @@ var ErrModelNotSet = errors.New("model not set")
 type Repo struct {
 	tablePrefix string
 	service     *dynamo.DB
+	awsConfig   *aws.Config
 	factoryFn   func() eh.Entity
 	tableName   func(context.Context) string
+
+	indexQueries  map[string][]string
+	verifyIndexes bool
+	metrics       Metrics
+
+	namespaceConfigs  *NamespaceConfigs
+	capacityMetrics   CapacityMetrics
+	autoCreateTable   bool
+	tableWaiter       *TableWaiter
+	staleness         *staleness
+	namespaceProvider NamespaceProvider
+	findAllLimit      int
+	retryPolicy       *RetryPolicy
+	errorClassifier   ErrorClassifier
+	logger            *logger
+	readConsistency   ReadConsistency
+	environment       *environment
+	dax               *dynamo.DB
+	cursors           *cursor.Signer
+	lifecycle         *lifecycle
 }
 
 // Option is an option setter used to configure creation.
@@ -63,7 +86,8 @@ func WithRepoTableName(tableName func(context.Context) string) OptionRepo {
 	}
 }
 
-// WithRepoDBName uses a custom DB name function.
+// WithRepoDynamoDB uses a custom AWS session. Without it a session is created
+// from the standard AWS config chain and the config options.
 func WithRepoDynamoDB(sess *session.Session) OptionRepo {
 	return func(r *Repo) error {
 		r.service = dynamo.New(sess)
@@ -80,19 +104,10 @@ func WithRepoEntityFactoryFunc(f func() eh.Entity) OptionRepo {
 
 // NewRepo creates a new Repo.
 func NewRepo(tablePrefix string, options ...OptionRepo) (*Repo, error) {
-	awsConfig := &aws.Config{
-		Region:   aws.String("us-west-2"),
-		Endpoint: aws.String("http://localhost:8000"),
-	}
-
-	sess, err := session.NewSession(awsConfig)
-	if err != nil {
-		return nil, ErrCouldNotDialDB
-	}
-
 	r := &Repo{
 		tablePrefix: tablePrefix,
-		service:     dynamo.New(sess),
+		awsConfig:   aws.NewConfig(),
+		lifecycle:   &lifecycle{},
 	}
 
 	r.tableName = func(ctx context.Context) string {
@@ -105,6 +120,32 @@ func NewRepo(tablePrefix string, options ...OptionRepo) (*Repo, error) {
 			return nil, fmt.Errorf("error while applying option: %v", err)
 		}
 	}
+	if err := r.applyEnvironment(); err != nil {
+		return nil, err
+	}
+	if r.cursors == nil {
+		var err error
+		if r.cursors, err = newCursorSigner(); err != nil {
+			return nil, err
+		}
+	}
+
+	if r.service == nil {
+		var err error
+		if r.service, err = newService(r.awsConfig); err != nil {
+			return nil, ErrCouldNotDialDB
+		}
+	}
+	applyRetryPolicy(r.service, r.retryPolicy, r.errorClassifier)
+	applyCapacityMetrics(r.service, r.capacityMetrics)
+	applyLogger(r.service, r.logger)
+	applyTableClass(r.service)
+
+	if r.verifyIndexes {
+		if err := r.VerifyIndexes(context.Background()); err != nil {
+			return nil, err
+		}
+	}
 
 	return r, nil
 }
@@ -114,7 +155,15 @@ func (r *Repo) Parent() eh.ReadRepo {
 	return nil
 }
 
+// CreateTable creates the table if it is not already existing. It is safe to
+// call concurrently, a table that is already being created by someone else is
+// waited for until it is active.
 func (r *Repo) CreateTable(ctx context.Context) error {
+	ctx, err := r.namespace(ctx)
+	if err != nil {
+		return err
+	}
+
 	if r.service == nil {
 		return ErrCouldNotDialDB
 	}
@@ -122,22 +171,21 @@ func (r *Repo) CreateTable(ctx context.Context) error {
 		return ErrModelNotSet
 	}
 
-	if err := r.service.CreateTable(r.tableName(ctx), r.factoryFn()).Run(); err != nil {
-		return err
-	}
+	ctx = withTableWaiter(ctx, r.tableWaiter)
+	tableName := r.tableName(ctx)
+	cfg := r.namespaceConfigs.Get(eh.NamespaceFromContext(ctx))
+	ct := cfg.applyCreateTable(r.service.CreateTable(tableName, r.factoryFn()))
+	return createTable(withTableClass(ctx, cfg.TableClass), r.service.Client(), tableName, ct, func(ctx context.Context) error {
+		return cfg.configureTable(ctx, r.service.Client(), tableName, "")
+	})
+}
 
-	describeParams := &dynamodb.DescribeTableInput{
-		TableName: aws.String(r.tableName(ctx)),
-	}
-	if err := r.service.Client().WaitUntilTableExists(describeParams); err != nil {
+func (r *Repo) DeleteTable(ctx context.Context) error {
+	ctx, err := r.namespace(ctx)
+	if err != nil {
 		return err
 	}
 
-	return nil
-
-}
-
-func (r *Repo) DeleteTable(ctx context.Context) error {
 	if r.service == nil {
 		return ErrCouldNotDialDB
 	}
@@ -149,18 +197,16 @@ func (r *Repo) DeleteTable(ctx context.Context) error {
 		return ErrCouldNotClearDB
 	}
 
-	describeParams := &dynamodb.DescribeTableInput{
-		TableName: aws.String(r.tableName(ctx)),
-	}
-	if err := r.service.Client().WaitUntilTableNotExists(describeParams); err != nil {
-		return err
-	}
-
-	return nil
+	return waitForTableDeleted(withTableWaiter(ctx, r.tableWaiter), r.service.Client(), r.tableName(ctx))
 }
 
 // Find implements the Find method of the eventhorizon.ReadRepo interface.
 func (r *Repo) Find(ctx context.Context, id uuid.UUID) (eh.Entity, error) {
+	ctx, err := r.namespace(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	if r.factoryFn == nil {
 		return nil, eh.RepoError{
 			Err:       ErrModelNotSet,
@@ -168,16 +214,19 @@ func (r *Repo) Find(ctx context.Context, id uuid.UUID) (eh.Entity, error) {
 		}
 	}
 
-	table := r.service.Table(r.tableName(ctx))
+	tableName := r.tableName(ctx)
+	table := r.readService().Table(tableName)
 	entity := r.factoryFn()
 
 	// TODO support range by adding Get().Range() here
-	err := table.Get("ID", id.String()).Consistent(true).One(entity)
+	start := time.Now()
+	err = table.Get("ID", id.String()).Consistent(consistentRead(ctx, r.readConsistency)).OneWithContext(ctx, entity)
+	observe(ctx, r.metrics, OperationGetItem, tableName, start, err)
 
 	if err != nil {
 		return nil, eh.RepoError{
-			Err:       eh.ErrEntityNotFound,
-			BaseErr:   err,
+			Err:       wrapError(eh.ErrEntityNotFound, err),
+			BaseErr:   withRequestID(err),
 			Namespace: eh.NamespaceFromContext(ctx),
 		}
 	}
@@ -187,6 +236,11 @@ func (r *Repo) Find(ctx context.Context, id uuid.UUID) (eh.Entity, error) {
 
 // FindAll implements the FindAll method of the eventhorizon.ReadRepo interface.
 func (r *Repo) FindAll(ctx context.Context) ([]eh.Entity, error) {
+	ctx, err := r.namespace(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	if r.factoryFn == nil {
 		return nil, eh.RepoError{
 			Err:       ErrModelNotSet,
@@ -194,14 +248,49 @@ func (r *Repo) FindAll(ctx context.Context) ([]eh.Entity, error) {
 		}
 	}
 
-	table := r.service.Table(r.tableName(ctx))
+	tableName := r.tableName(ctx)
+	table := r.readService().Table(tableName)
 
-	iter := table.Scan().Consistent(true).Iter()
+	// Resume the scan once if the credentials expire.
+	start := time.Now()
 	result := []eh.Entity{}
-	entity := r.factoryFn()
-	for iter.Next(entity) {
-		result = append(result, entity)
-		entity = r.factoryFn()
+	var key dynamo.PagingKey
+	for retried := false; ; retried = true {
+		iter := table.Scan().Consistent(consistentRead(ctx, r.readConsistency)).StartFrom(key).Iter()
+		entity := r.factoryFn()
+		for iter.NextWithContext(ctx, entity) {
+			result = append(result, entity)
+			if r.findAllLimit > 0 && len(result) > r.findAllLimit {
+				observe(ctx, r.metrics, OperationScan, tableName, start, nil)
+				cursor, err := r.entitiesCursor(ctx, result[r.findAllLimit-1])
+				if err != nil {
+					return nil, eh.RepoError{
+						Err:       err,
+						Namespace: eh.NamespaceFromContext(ctx),
+					}
+				}
+				return nil, LimitError{
+					Limit:     r.findAllLimit,
+					Count:     r.findAllLimit,
+					Cursor:    cursor,
+					Namespace: eh.NamespaceFromContext(ctx),
+				}
+			}
+			entity = r.factoryFn()
+		}
+		err = iter.Err()
+		if retried || !refreshExpiredCredentials(r.service.Client(), err) {
+			break
+		}
+		key = iter.LastEvaluatedKey()
+	}
+	observe(ctx, r.metrics, OperationScan, tableName, start, err)
+	if err != nil {
+		return nil, eh.RepoError{
+			Err:       err,
+			BaseErr:   withRequestID(err),
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
 	}
 
 	return result, nil
@@ -209,6 +298,11 @@ func (r *Repo) FindAll(ctx context.Context) ([]eh.Entity, error) {
 
 // FindWithFilter allows to find entities with a filter
 func (r *Repo) FindWithFilter(ctx context.Context, expr string, args ...interface{}) ([]eh.Entity, error) {
+	ctx, err := r.namespace(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	if r.factoryFn == nil {
 		return nil, eh.RepoError{
 			Err:       ErrModelNotSet,
@@ -216,14 +310,33 @@ func (r *Repo) FindWithFilter(ctx context.Context, expr string, args ...interfac
 		}
 	}
 
-	table := r.service.Table(r.tableName(ctx))
+	tableName := r.tableName(ctx)
+	table := r.readService().Table(tableName)
 
-	iter := table.Scan().Filter(expr, args...).Consistent(true).Iter()
+	// Resume the scan once if the credentials expire.
+	start := time.Now()
 	result := []eh.Entity{}
-	entity := r.factoryFn()
-	for iter.Next(entity) {
-		result = append(result, entity)
-		entity = r.factoryFn()
+	var key dynamo.PagingKey
+	for retried := false; ; retried = true {
+		iter := table.Scan().Filter(expr, args...).Consistent(consistentRead(ctx, r.readConsistency)).StartFrom(key).Iter()
+		entity := r.factoryFn()
+		for iter.NextWithContext(ctx, entity) {
+			result = append(result, entity)
+			entity = r.factoryFn()
+		}
+		err = iter.Err()
+		if retried || !refreshExpiredCredentials(r.service.Client(), err) {
+			break
+		}
+		key = iter.LastEvaluatedKey()
+	}
+	observe(ctx, r.metrics, OperationScan, tableName, start, err)
+	if err != nil {
+		return nil, eh.RepoError{
+			Err:       err,
+			BaseErr:   withRequestID(err),
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
 	}
 
 	return result, nil
@@ -231,6 +344,11 @@ func (r *Repo) FindWithFilter(ctx context.Context, expr string, args ...interfac
 
 // FindWithFilterUsingIndex allows to find entities with a filter using an index
 func (r *Repo) FindWithFilterUsingIndex(ctx context.Context, indexInput IndexInput, filterQuery string, filterArgs ...interface{}) ([]eh.Entity, error) {
+	ctx, err := r.namespace(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	if r.factoryFn == nil {
 		return nil, eh.RepoError{
 			Err:       ErrModelNotSet,
@@ -238,8 +356,10 @@ func (r *Repo) FindWithFilterUsingIndex(ctx context.Context, indexInput IndexInp
 		}
 	}
 
-	table := r.service.Table(r.tableName(ctx))
+	tableName := r.tableName(ctx)
+	table := r.readService().Table(tableName)
 
+	start := time.Now()
 	iter := table.Get(indexInput.PartitionKey, indexInput.PartitionKeyValue).
 		Range(indexInput.SortKey, dynamo.Equal, indexInput.SortKeyValue).
 		Index(indexInput.IndexName).
@@ -248,45 +368,207 @@ func (r *Repo) FindWithFilterUsingIndex(ctx context.Context, indexInput IndexInp
 
 	result := []eh.Entity{}
 	entity := r.factoryFn()
-	for iter.Next(entity) {
+	for iter.NextWithContext(ctx, entity) {
 		result = append(result, entity)
 		entity = r.factoryFn()
 	}
+	observe(ctx, r.metrics, OperationQuery, tableName, start, iter.Err())
+	if err := iter.Err(); err != nil {
+		return nil, eh.RepoError{
+			Err:       err,
+			BaseErr:   withRequestID(err),
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
 
 	return result, nil
 }
 
 // Save implements the Save method of the eventhorizon.WriteRepo interface.
 func (r *Repo) Save(ctx context.Context, entity eh.Entity) error {
-	table := r.service.Table(r.tableName(ctx))
+	ctx, err := r.namespace(ctx)
+	if err != nil {
+		return err
+	}
+
+	tableName := r.tableName(ctx)
+	table := r.service.Table(tableName)
 
 	if entity.EntityID() == uuid.Nil {
 		return eh.RepoError{
-			Err:       eh.ErrCouldNotSaveEntity,
+			Err:       wrapError(eh.ErrCouldNotSaveEntity, eh.ErrMissingEntityID),
 			BaseErr:   eh.ErrMissingEntityID,
 			Namespace: eh.NamespaceFromContext(ctx),
 		}
 	}
 
-	if err := table.Put(entity).Run(); err != nil {
+	if len(conditionChecksFromContext(ctx)) > 0 || len(transactItemsFromContext(ctx)) > 0 {
+		return r.saveWithChecks(ctx, tableName, entity)
+	}
+
+	err = r.autoCreate(ctx, func() error {
+		start := time.Now()
+		err := table.Put(entity).RunWithContext(ctx)
+		observe(ctx, r.metrics, OperationPutItem, tableName, start, err)
+		return err
+	})
+	if err != nil {
+		return eh.RepoError{
+			Err:       wrapError(eh.ErrCouldNotSaveEntity, err),
+			BaseErr:   withRequestID(err),
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	return nil
+}
+
+// saveWithChecks saves an entity in one transaction with the condition
+// checks and transact items of the context.
+func (r *Repo) saveWithChecks(ctx context.Context, tableName string, entity eh.Entity) error {
+	item, err := dynamo.MarshalItem(entity)
+	if err != nil {
 		return eh.RepoError{
-			Err:       eh.ErrCouldNotSaveEntity,
+			Err:       wrapError(eh.ErrCouldNotSaveEntity, err),
 			BaseErr:   err,
 			Namespace: eh.NamespaceFromContext(ctx),
 		}
 	}
+	checks, err := conditionCheckItems(ctx)
+	if err != nil {
+		return eh.RepoError{
+			Err:       wrapError(eh.ErrCouldNotSaveEntity, err),
+			BaseErr:   err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	items := append([]*dynamodb.TransactWriteItem{{
+		Put: &dynamodb.Put{
+			TableName: aws.String(tableName),
+			Item:      item,
+		},
+	}}, checks...)
+
+	start := time.Now()
+	_, err = r.service.Client().TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+	observe(ctx, r.metrics, OperationTransactWriteItems, tableName, start, err)
+	if isConditionCheckFailed(ctx, err, items) {
+		return eh.RepoError{
+			Err:       wrapError(ErrConditionCheckFailed, err),
+			BaseErr:   withRequestID(err),
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	} else if err != nil {
+		return eh.RepoError{
+			Err:       wrapError(eh.ErrCouldNotSaveEntity, err),
+			BaseErr:   withRequestID(err),
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
 
 	return nil
 }
 
 // Remove implements the Remove method of the eventhorizon.WriteRepo interface.
 func (r *Repo) Remove(ctx context.Context, id uuid.UUID) error {
-	table := r.service.Table(r.tableName(ctx))
+	ctx, err := r.namespace(ctx)
+	if err != nil {
+		return err
+	}
+
+	tableName := r.tableName(ctx)
+	table := r.service.Table(tableName)
 
-	if err := table.Delete("ID", id.String()).Run(); err != nil {
+	start := time.Now()
+	err = table.Delete("ID", id.String()).RunWithContext(ctx)
+	observe(ctx, r.metrics, OperationDeleteItem, tableName, start, err)
+	if err != nil {
 		return eh.RepoError{
-			Err:       eh.ErrEntityNotFound,
-			BaseErr:   err,
+			Err:       wrapError(eh.ErrEntityNotFound, err),
+			BaseErr:   withRequestID(err),
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	return nil
+}
+
+// SaveMany saves several entities using batch writes. If some of the entities
+// could not be saved a PartialFailureError is returned in the RepoError,
+// listing the outcome of every entity by ID.
+func (r *Repo) SaveMany(ctx context.Context, entities ...eh.Entity) error {
+	ctx, err := r.namespace(ctx)
+	if err != nil {
+		return err
+	}
+
+	reqs := make([]*dynamodb.WriteRequest, len(entities))
+	for i, entity := range entities {
+		if entity.EntityID() == uuid.Nil {
+			return eh.RepoError{
+				Err:       wrapError(eh.ErrCouldNotSaveEntity, eh.ErrMissingEntityID),
+				BaseErr:   eh.ErrMissingEntityID,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+
+		item, err := dynamo.MarshalItem(entity)
+		if err != nil {
+			return eh.RepoError{
+				Err:       wrapError(eh.ErrCouldNotSaveEntity, err),
+				BaseErr:   withRequestID(err),
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+		reqs[i] = &dynamodb.WriteRequest{PutRequest: &dynamodb.PutRequest{Item: item}}
+	}
+
+	tableName := r.tableName(ctx)
+	start := time.Now()
+	outcomes := batchWrite(ctx, r.service.Client(), tableName, []string{"ID"}, reqs)
+	err = batchResult(outcomes, eh.NamespaceFromContext(ctx))
+	observe(ctx, r.metrics, OperationBatchWriteItem, tableName, start, err)
+	if err != nil {
+		return eh.RepoError{
+			Err:       wrapError(eh.ErrCouldNotSaveEntity, err),
+			BaseErr:   withRequestID(err),
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	return nil
+}
+
+// RemoveMany removes several entities using batch writes. If some of the
+// entities could not be removed a PartialFailureError is returned in the
+// RepoError, listing the outcome of every entity by ID.
+func (r *Repo) RemoveMany(ctx context.Context, ids ...uuid.UUID) error {
+	ctx, err := r.namespace(ctx)
+	if err != nil {
+		return err
+	}
+
+	reqs := make([]*dynamodb.WriteRequest, len(ids))
+	for i, id := range ids {
+		reqs[i] = &dynamodb.WriteRequest{DeleteRequest: &dynamodb.DeleteRequest{
+			Key: map[string]*dynamodb.AttributeValue{
+				"ID": {S: aws.String(id.String())},
+			},
+		}}
+	}
+
+	tableName := r.tableName(ctx)
+	start := time.Now()
+	outcomes := batchWrite(ctx, r.service.Client(), tableName, []string{"ID"}, reqs)
+	err = batchResult(outcomes, eh.NamespaceFromContext(ctx))
+	observe(ctx, r.metrics, OperationBatchWriteItem, tableName, start, err)
+	if err != nil {
+		return eh.RepoError{
+			Err:       wrapError(eh.ErrEntityNotFound, err),
+			BaseErr:   withRequestID(err),
 			Namespace: eh.NamespaceFromContext(ctx),
 		}
 	}
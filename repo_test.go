@@ -15,10 +15,14 @@
 package dynamodb
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/looplab/eventhorizon/mocks"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -34,9 +38,10 @@ import (
 // RepoTestSuite is intended to store values shared by multiple test and manage the setup/teardown
 type RepoTestSuite struct {
 	suite.Suite
-	repo *Repo
-	ctx  context.Context
-	db   *dynamo.DB
+	repo    *Repo
+	ctx     context.Context
+	db      *dynamo.DB
+	session *session.Session
 }
 
 // SetupSuite will be run once, at the very start of the testing suite
@@ -52,6 +57,7 @@ func (suite *RepoTestSuite) SetupSuite() {
 	}
 
 	suite.db = dynamo.New(awsSession)
+	suite.session = awsSession
 
 	tablePrefix := "eventhorizonTest_" + uuid.New().String()
 	suite.repo, err = NewRepo(
@@ -108,6 +114,31 @@ func (suite *RepoTestSuite) TestSaveAndFindAll() {
 	assert.Equal(suite.T(), 2, len(results))
 }
 
+func (suite *RepoTestSuite) TestFindAllLimit() {
+	for i := 0; i < 3; i++ {
+		_ = suite.repo.Save(context.Background(), &TestModel{ID: uuid.New(), Content: "test"})
+	}
+
+	limited := *suite.repo
+	limited.findAllLimit = 2
+	_, err := limited.FindAll(context.Background())
+	var limitErr LimitError
+	if assert.True(suite.T(), errors.As(err, &limitErr)) {
+		assert.Equal(suite.T(), 2, limitErr.Count)
+	}
+
+	results, cursor, err := limited.FindAllPage(context.Background(), "", 2)
+	assert.Nil(suite.T(), err)
+	assert.Len(suite.T(), results, 2)
+	assert.Equal(suite.T(), limitErr.Cursor, cursor)
+
+	rest, cursor, err := limited.FindAllPage(context.Background(), cursor, 2)
+	assert.Nil(suite.T(), err)
+	assert.Len(suite.T(), rest, 1)
+	assert.Equal(suite.T(), "", cursor)
+	assert.NotContains(suite.T(), results, rest[0])
+}
+
 func (suite *RepoTestSuite) TestSaveAndFindWithFilter() {
 	_ = suite.repo.Save(context.Background(), &TestModel{ID: uuid.New(), Content: "test", FilterableID: 123})
 	_ = suite.repo.Save(context.Background(), &TestModel{ID: uuid.New(), Content: "test2", FilterableID: 123})
@@ -154,6 +185,76 @@ func (suite *RepoTestSuite) TestSaveAndFindUsingIndex() {
 	}
 	assert.Equal(suite.T(), 2, len(results))
 
+	// Query into a list view without the entity factory.
+	var views []struct {
+		ID      uuid.UUID
+		Content string
+	}
+	err = suite.repo.QueryIndex(context.Background(), indexInput, &views, "Content = ?", "testContent")
+	assert.Nil(suite.T(), err)
+	assert.Len(suite.T(), views, 2)
+	for _, view := range views {
+		assert.Equal(suite.T(), "testContent", view.Content)
+		assert.NotEqual(suite.T(), uuid.Nil, view.ID)
+	}
+
+	views = nil
+	indexInput.SortKey = ""
+	err = suite.repo.QueryIndex(context.Background(), indexInput, &views, "")
+	assert.Nil(suite.T(), err)
+	assert.Len(suite.T(), views, 4)
+
+	// Query the same items page by page.
+	var paged []struct {
+		ID      uuid.UUID
+		Content string
+	}
+	token := ""
+	for i := 0; i < 5; i++ {
+		token, err = suite.repo.QueryIndexPage(context.Background(), indexInput, token, 3, &paged, "")
+		if !assert.Nil(suite.T(), err) || token == "" {
+			break
+		}
+	}
+	assert.Equal(suite.T(), "", token)
+	assert.ElementsMatch(suite.T(), views, paged)
+}
+
+func (suite *RepoTestSuite) TestVerifyIndexes() {
+	index := dynamo.Index{
+		Name:           "keysOnlyIndex",
+		HashKey:        "FilterableID",
+		HashKeyType:    dynamo.NumberType,
+		ProjectionType: dynamodb.ProjectionTypeKeysOnly,
+	}
+	if _, err := suite.db.Table(suite.repo.tableName(context.Background())).UpdateTable().CreateIndex(index).OnDemand(true).Run(); err != nil {
+		suite.T().Fatal("could not create index:", err)
+	}
+	defer suite.db.Table(suite.repo.tableName(context.Background())).UpdateTable().DeleteIndex(index.Name).Run()
+
+	suite.repo.indexQueries = map[string][]string{index.Name: {"ID", "FilterableID"}}
+	defer func() { suite.repo.indexQueries = nil }()
+	assert.Nil(suite.T(), suite.repo.VerifyIndexes(context.Background()))
+
+	suite.repo.indexQueries = map[string][]string{index.Name: nil}
+	assert.EqualError(suite.T(), suite.repo.VerifyIndexes(context.Background()),
+		"index keysOnlyIndex with KEYS_ONLY projection is missing attributes: Content, FilterableSortKey")
+
+	suite.repo.indexQueries = map[string][]string{"missingIndex": nil}
+	assert.EqualError(suite.T(), suite.repo.VerifyIndexes(context.Background()), "index missingIndex not found")
+
+	// The indexes are verified when the repo is created, if enabled.
+	newRepo := func(options ...OptionRepo) error {
+		_, err := NewRepo(suite.repo.tablePrefix, append([]OptionRepo{
+			WithRepoDynamoDB(suite.session),
+			WithRepoEntityFactoryFunc(func() eh.Entity { return &TestModel{} }),
+		}, options...)...)
+		return err
+	}
+	assert.Nil(suite.T(), newRepo(WithRepoIndexQuery("missingIndex")))
+	assert.Nil(suite.T(), newRepo(WithRepoIndexQuery(index.Name, "ID"), WithRepoIndexVerification()))
+	assert.EqualError(suite.T(), newRepo(WithRepoIndexQuery("missingIndex"), WithRepoIndexVerification()),
+		"index missingIndex not found")
 }
 
 func (suite *RepoTestSuite) TearDownAllSuite() {
@@ -170,20 +271,166 @@ func (suite *RepoTestSuite) TestRemove() {
 	}
 
 	result, err := suite.repo.Find(context.Background(), testModel.ID)
-	if rrErr, ok := err.(eh.RepoError); !ok || rrErr.Err != eh.ErrEntityNotFound || result != nil {
+	if rrErr, ok := err.(eh.RepoError); !ok || !errors.Is(rrErr.Err, eh.ErrEntityNotFound) || result != nil {
 		suite.T().Fatal("entity should've been removed:", err)
 	}
 }
 
+func (suite *RepoTestSuite) TestSaveManyAndRemoveMany() {
+	var entities []eh.Entity
+	var ids []uuid.UUID
+	for i := 0; i < 30; i++ {
+		testModel := &TestModel{ID: uuid.New(), Content: "test"}
+		entities = append(entities, testModel)
+		ids = append(ids, testModel.ID)
+	}
+
+	if err := suite.repo.SaveMany(context.Background(), entities...); err != nil {
+		suite.T().Fatal("error saving entities:", err)
+	}
+
+	results, err := suite.repo.FindAll(context.Background())
+	if err != nil {
+		suite.T().Fatal("error finding entities:", err)
+	}
+	assert.Equal(suite.T(), 30, len(results))
+
+	if err := suite.repo.RemoveMany(context.Background(), ids...); err != nil {
+		suite.T().Fatal("error removing entities:", err)
+	}
+
+	results, err = suite.repo.FindAll(context.Background())
+	if err != nil {
+		suite.T().Fatal("error finding entities:", err)
+	}
+	assert.Equal(suite.T(), 0, len(results))
+}
+
+func (suite *RepoTestSuite) TestSaveForEvent() {
+	id := uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	event1 := eh.NewEventForAggregate(mocks.EventType, nil, timestamp, mocks.AggregateType, id, 1)
+	event2 := eh.NewEventForAggregate(mocks.EventType, nil, timestamp, mocks.AggregateType, id, 2)
+
+	testModel := &TestModel{ID: uuid.New(), Content: "v1"}
+	applied, err := suite.repo.SaveForEvent(context.Background(), testModel, event1)
+	assert.Nil(suite.T(), err)
+	assert.True(suite.T(), applied)
+
+	testModel.Content = "v2"
+	applied, err = suite.repo.SaveForEvent(context.Background(), testModel, event2)
+	assert.Nil(suite.T(), err)
+	assert.True(suite.T(), applied)
+
+	// A duplicate delivery of an older event should be skipped.
+	testModel.Content = "duplicate"
+	applied, err = suite.repo.SaveForEvent(context.Background(), testModel, event1)
+	assert.Nil(suite.T(), err)
+	assert.False(suite.T(), applied)
+
+	result, err := suite.repo.Find(context.Background(), testModel.ID)
+	if err != nil {
+		suite.T().Fatal("error finding entity:", err)
+	}
+	assert.Equal(suite.T(), "v2", result.(*TestModel).Content)
+
+	// The events of other source aggregates are applied by their own
+	// versions.
+	other := eh.NewEventForAggregate(mocks.EventType, nil, timestamp, mocks.AggregateType, uuid.New(), 1)
+	testModel.Content = "other"
+	applied, err = suite.repo.SaveForEvent(context.Background(), testModel, other)
+	assert.Nil(suite.T(), err)
+	assert.True(suite.T(), applied)
+	applied, err = suite.repo.SaveForEvent(context.Background(), testModel, event2)
+	assert.Nil(suite.T(), err)
+	assert.False(suite.T(), applied)
+}
+
+// TestStaleness will track the time since the newest applied event
+func (suite *RepoTestSuite) TestStaleness() {
+	suite.repo.staleness = &staleness{lastApplied: map[string]time.Time{}}
+	defer func() { suite.repo.staleness = nil }()
+
+	_, ok := suite.repo.TableStaleness(context.Background())
+	assert.False(suite.T(), ok)
+
+	timestamp := time.Now().Add(-time.Minute)
+	event := eh.NewEventForAggregate(mocks.EventType, nil, timestamp, mocks.AggregateType, uuid.New(), 1)
+	_, err := suite.repo.SaveForEvent(context.Background(), &TestModel{ID: uuid.New(), Content: "v1"}, event)
+	assert.Nil(suite.T(), err)
+
+	staleness, ok := suite.repo.TableStaleness(context.Background())
+	assert.True(suite.T(), ok)
+	assert.True(suite.T(), staleness >= time.Minute)
+
+	metrics := suite.repo.Staleness()
+	if assert.Len(suite.T(), metrics, 1) {
+		assert.Equal(suite.T(), suite.repo.tableName(context.Background()), metrics[0].Table)
+		assert.True(suite.T(), metrics[0].LastApplied.Equal(timestamp))
+	}
+}
+
+func (suite *RepoTestSuite) TestSaveWithConditionChecks() {
+	parent := &TestModel{ID: uuid.New(), Content: "parent"}
+	assert.Nil(suite.T(), suite.repo.Save(context.Background(), parent))
+
+	tableName := suite.repo.tableName(context.Background())
+	child := &TestModel{ID: uuid.New(), Content: "child"}
+	ctx := NewContextWithConditionChecks(context.Background(),
+		EntityCondition(tableName, parent.ID, "Content = :content", map[string]interface{}{":content": "parent"}))
+	assert.Nil(suite.T(), suite.repo.Save(ctx, child))
+	_, err := suite.repo.Find(context.Background(), child.ID)
+	assert.Nil(suite.T(), err)
+
+	// The save fails without writing if the check fails.
+	other := &TestModel{ID: uuid.New(), Content: "other"}
+	ctx = NewContextWithConditionChecks(context.Background(), EntityExists(tableName, uuid.New()))
+	err = suite.repo.Save(ctx, other)
+	if repoErr, ok := err.(eh.RepoError); assert.True(suite.T(), ok) {
+		assert.True(suite.T(), errors.Is(repoErr.Err, ErrConditionCheckFailed))
+	}
+	_, err = suite.repo.Find(context.Background(), other.ID)
+	assert.NotNil(suite.T(), err)
+}
+
+func (suite *RepoTestSuite) TestSaveWithTransactItems() {
+	tableName := suite.repo.tableName(context.Background())
+	reserve := func(name string) *dynamodb.TransactWriteItem {
+		id := uuid.NewSHA1(uuid.NameSpaceURL, []byte("username:"+name))
+		return &dynamodb.TransactWriteItem{Put: &dynamodb.Put{
+			TableName: aws.String(tableName),
+			Item: map[string]*dynamodb.AttributeValue{
+				"ID":      {S: aws.String(id.String())},
+				"Content": {S: aws.String(name)},
+			},
+			ConditionExpression: aws.String("attribute_not_exists(ID)"),
+		}}
+	}
+
+	user := &TestModel{ID: uuid.New(), Content: "alice"}
+	ctx := NewContextWithTransactItems(context.Background(), reserve("alice"))
+	assert.Nil(suite.T(), suite.repo.Save(ctx, user))
+
+	// The save fails without writing if the name is taken.
+	other := &TestModel{ID: uuid.New(), Content: "alice"}
+	ctx = NewContextWithTransactItems(context.Background(), reserve("alice"))
+	err := suite.repo.Save(ctx, other)
+	if repoErr, ok := err.(eh.RepoError); assert.True(suite.T(), ok) {
+		assert.True(suite.T(), errors.Is(repoErr.Err, ErrConditionCheckFailed))
+	}
+	_, err = suite.repo.Find(context.Background(), other.ID)
+	assert.NotNil(suite.T(), err)
+}
+
 func (suite *RepoTestSuite) TestNoFactoryFn() {
 	suite.repo.SetEntityFactory(nil)
 	result, err := suite.repo.Find(context.Background(), uuid.New())
-	if rrErr, ok := err.(eh.RepoError); !ok || rrErr.Err != ErrModelNotSet || result != nil {
+	if rrErr, ok := err.(eh.RepoError); !ok || !errors.Is(rrErr.Err, ErrModelNotSet) || result != nil {
 		suite.T().Fatal("an error should have occurred")
 	}
 
 	results, err := suite.repo.FindAll(context.Background())
-	if rrErr, ok := err.(eh.RepoError); !ok || rrErr.Err != ErrModelNotSet || results != nil {
+	if rrErr, ok := err.(eh.RepoError); !ok || !errors.Is(rrErr.Err, ErrModelNotSet) || results != nil {
 		suite.T().Fatal("an error should have occurred")
 	}
 }
@@ -198,6 +445,29 @@ func (suite *RepoTestSuite) TestParent() {
 	assert.Nil(suite.T(), result)
 }
 
+// TestExportImport will export entities and import them into another table
+func (suite *RepoTestSuite) TestExportImport() {
+	for i := 0; i < 3; i++ {
+		testModel := &TestModel{ID: uuid.New(), Content: "test", FilterableID: i}
+		if err := suite.repo.Save(context.Background(), testModel); err != nil {
+			suite.T().Fatal("error saving entity:", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	assert.Nil(suite.T(), suite.repo.ExportWithFilter(context.Background(), &buf, "FilterableID > ?", 0))
+	assert.Equal(suite.T(), 2, bytes.Count(buf.Bytes(), []byte("\n")))
+
+	ctx := eh.NewContextWithNamespace(context.Background(), "import")
+	assert.Nil(suite.T(), suite.repo.CreateTable(ctx))
+	defer suite.repo.DeleteTable(ctx)
+
+	assert.Nil(suite.T(), suite.repo.Import(ctx, &buf))
+	results, err := suite.repo.FindAll(ctx)
+	assert.Nil(suite.T(), err)
+	assert.Len(suite.T(), results, 2)
+}
+
 type TestModel struct {
 	ID                uuid.UUID `dynamo:",hash"`
 	Content           string
@@ -0,0 +1,172 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/guregu/dynamo"
+)
+
+// RetryPolicy is how requests to DynamoDB are retried when they are throttled
+// or fail with a retryable error. Backoff between attempts grows
+// exponentially from MinBackoff up to MaxBackoff.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts of a request, including the
+	// first one.
+	MaxAttempts int
+	// MinBackoff is the backoff before the first retry.
+	MinBackoff time.Duration
+	// MaxBackoff is the longest backoff between attempts.
+	MaxBackoff time.Duration
+	// Jitter is the fraction of the backoff that is random, from 0 to 1.
+	Jitter float64
+}
+
+// DefaultRetryPolicy is the retry policy for the fields of a policy that are
+// not set.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 8,
+	MinBackoff:  25 * time.Millisecond,
+	MaxBackoff:  5 * time.Second,
+	Jitter:      0.5,
+}
+
+// WithRetryPolicy retries throttled and failed requests, including
+// transactions that were canceled because they were throttled, with a retry
+// policy instead of the default retries of the AWS SDK.
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(s *EventStore) error {
+		s.retryPolicy = &p
+		return nil
+	}
+}
+
+// WithRepoRetryPolicy retries throttled and failed requests with a retry
+// policy instead of the default retries of the AWS SDK.
+func WithRepoRetryPolicy(p RetryPolicy) OptionRepo {
+	return func(r *Repo) error {
+		r.retryPolicy = &p
+		return nil
+	}
+}
+
+// applyRetryPolicy sets the retryer of the DynamoDB client of a service, with
+// the default policy if only an error classifier is set. The client of a
+// service is not shared, it is created for the service.
+func applyRetryPolicy(db *dynamo.DB, p *RetryPolicy, classify ErrorClassifier) {
+	if p == nil && classify == nil {
+		return
+	}
+	policy := DefaultRetryPolicy
+	if p != nil {
+		policy = *p
+	}
+	if c, ok := db.Client().(*dynamodb.DynamoDB); ok {
+		r := newRetryer(policy)
+		r.classify = classify
+		c.Retryer = r
+	}
+}
+
+// retryer implements the request.Retryer interface of the AWS SDK with a
+// retry policy, and an error classifier if set.
+type retryer struct {
+	policy   RetryPolicy
+	classify ErrorClassifier
+}
+
+// newRetryer returns a retryer for a policy, with defaults for unset fields.
+func newRetryer(p RetryPolicy) retryer {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = DefaultRetryPolicy.MaxAttempts
+	}
+	if p.MinBackoff <= 0 {
+		p.MinBackoff = DefaultRetryPolicy.MinBackoff
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = DefaultRetryPolicy.MaxBackoff
+	}
+	if p.MaxBackoff < p.MinBackoff {
+		p.MaxBackoff = p.MinBackoff
+	}
+	if p.Jitter < 0 {
+		p.Jitter = 0
+	} else if p.Jitter > 1 {
+		p.Jitter = 1
+	}
+	return retryer{policy: p}
+}
+
+// MaxRetries implements the MaxRetries method of the request.Retryer interface.
+func (r retryer) MaxRetries() int {
+	return r.policy.MaxAttempts - 1
+}
+
+// ShouldRetry implements the ShouldRetry method of the request.Retryer
+// interface.
+func (r retryer) ShouldRetry(req *request.Request) bool {
+	if req.Retryable != nil {
+		return *req.Retryable
+	}
+	if r.classify != nil {
+		return r.classify(req.Error).Retryable()
+	}
+	return req.IsErrorRetryable() || req.IsErrorThrottle() || isTransactionThrottled(req.Error)
+}
+
+// RetryRules implements the RetryRules method of the request.Retryer
+// interface.
+func (r retryer) RetryRules(req *request.Request) time.Duration {
+	return r.backoff(req.RetryCount)
+}
+
+// backoff returns the backoff before a retry.
+func (r retryer) backoff(retryCount int) time.Duration {
+	backoff := r.policy.MaxBackoff
+	if retryCount < 32 {
+		if d := r.policy.MinBackoff << uint(retryCount); d > 0 && d < backoff {
+			backoff = d
+		}
+	}
+
+	random := time.Duration(float64(backoff) * r.policy.Jitter)
+	if random <= 0 {
+		return backoff
+	}
+	return backoff - random + time.Duration(rand.Int63n(int64(random)+1))
+}
+
+// isTransactionThrottled checks if a transaction was canceled because one of
+// its items was throttled. The SDK does not retry those, but the whole
+// transaction was canceled so it is safe to retry.
+func isTransactionThrottled(err error) bool {
+	var txErr *dynamodb.TransactionCanceledException
+	if !errors.As(err, &txErr) {
+		return false
+	}
+	for _, reason := range txErr.CancellationReasons {
+		switch aws.StringValue(reason.Code) {
+		case "ThrottlingError", "ProvisionedThroughputExceeded":
+			return true
+		}
+	}
+	return false
+}
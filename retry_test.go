@@ -0,0 +1,67 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryer(t *testing.T) {
+	r := newRetryer(RetryPolicy{MaxAttempts: 4, MinBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond})
+	assert.Equal(t, 3, r.MaxRetries())
+
+	// The backoff grows exponentially up to the max, with jitter.
+	assert.Equal(t, 10*time.Millisecond, r.backoff(0))
+	assert.Equal(t, 40*time.Millisecond, r.backoff(2))
+	assert.Equal(t, 50*time.Millisecond, r.backoff(3))
+	assert.Equal(t, 50*time.Millisecond, r.backoff(100))
+
+	r.policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := r.backoff(2)
+		assert.True(t, d >= 20*time.Millisecond && d <= 40*time.Millisecond, d)
+	}
+
+	throttled := &request.Request{Error: awserr.New(dynamodb.ErrCodeProvisionedThroughputExceededException, "throttled", nil)}
+	assert.True(t, r.ShouldRetry(throttled))
+	canceled := &request.Request{Error: &dynamodb.TransactionCanceledException{
+		CancellationReasons: []*dynamodb.CancellationReason{{Code: aws.String("None")}, {Code: aws.String("ThrottlingError")}},
+	}}
+	assert.True(t, r.ShouldRetry(canceled))
+	failed := &request.Request{Error: &dynamodb.TransactionCanceledException{
+		CancellationReasons: []*dynamodb.CancellationReason{{Code: aws.String("ConditionalCheckFailed")}},
+	}}
+	assert.False(t, r.ShouldRetry(failed))
+}
+
+func TestWithRetryPolicy(t *testing.T) {
+	sess := session.Must(session.NewSession(&aws.Config{Region: aws.String("us-west-2")}))
+	store, err := NewEventStore("test", WithDynamoDB(sess), WithRetryPolicy(RetryPolicy{MaxAttempts: 3}))
+	assert.Nil(t, err)
+
+	r, ok := store.service.Client().(*dynamodb.DynamoDB).Retryer.(retryer)
+	if assert.True(t, ok) {
+		assert.Equal(t, 2, r.MaxRetries())
+		assert.Equal(t, DefaultRetryPolicy.MinBackoff, r.policy.MinBackoff)
+	}
+}
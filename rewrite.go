@@ -0,0 +1,270 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/google/uuid"
+	"github.com/guregu/dynamo"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// ErrRewriteConflict is when an aggregate got new events while one of its
+// events was rewritten.
+var ErrRewriteConflict = errors.New("aggregate changed while rewriting")
+
+// RewriteEntry is an event in a rewrite report.
+type RewriteEntry struct {
+	AggregateID uuid.UUID
+	Version     int
+	EventType   eh.EventType
+	Table       string
+	// Err is the reason the event was not rewritten, if any.
+	Err error
+}
+
+// RewriteReport is the audit report of RewriteEvents.
+type RewriteReport struct {
+	Namespace string
+	Started   time.Time
+	Finished  time.Time
+	// Matched is the number of events that matched the filter, and Skipped
+	// the number of them that the transform left unchanged.
+	Matched int
+	Skipped int
+	// Rewritten are the events that were rewritten, and Conflicts the ones
+	// that were not because their aggregate changed.
+	Rewritten []RewriteEntry
+	Conflicts []RewriteEntry
+}
+
+// RewriteEvents corrects the events of the namespace of the context in bulk,
+// for example to fix data that was written wrong by a bug. It streams the
+// events that match the filter through transform, which returns the
+// corrected event and true, or false to leave the event as it is. The
+// corrected event must keep the aggregate ID, version and timestamp.
+//
+// Each event is written back on the condition that the version of its
+// aggregate did not change since it was read; events of aggregates that got
+// new events meanwhile are reported as conflicts and can be rewritten by
+// running it again. The report is returned also on error, with the events
+// that were rewritten until then.
+func (s *EventStore) RewriteEvents(ctx context.Context, filter EventFilter, transform func(eh.Event) (eh.Event, bool)) (*RewriteReport, error) {
+	ctx, err := s.namespace(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &RewriteReport{
+		Namespace: eh.NamespaceFromContext(ctx),
+		Started:   time.Now(),
+	}
+	defer func() {
+		report.Finished = time.Now()
+	}()
+
+	tables, err := s.eventTables(ctx)
+	if err != nil {
+		return report, err
+	}
+
+	for _, tableName := range tables {
+		if err := s.rewriteTable(ctx, tableName, filter, transform, report); err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+// rewriteTable rewrites the matching events of one table.
+func (s *EventStore) rewriteTable(ctx context.Context, tableName string, filter EventFilter, transform func(eh.Event) (eh.Event, bool), report *RewriteReport) error {
+	iter := s.scanNamespace(ctx, s.service.Table(tableName).Scan()).
+		Filter("Version > ?", aggregateHeadVersion).
+		Consistent(true).
+		Iter()
+
+	start := time.Now()
+	var e dbEvent
+	for iter.NextWithContext(ctx, &e) {
+		existing := e
+		e = dbEvent{}
+
+		if !filter.match(s.typeNames, report.Namespace, eventFilterItem(existing)) {
+			continue
+		}
+		report.Matched++
+
+		event, err := s.buildEvent(ctx, existing)
+		if err != nil {
+			return err
+		}
+		// The version is read before the transform, so that new events that
+		// are saved meanwhile are detected.
+		version, err := s.headVersion(ctx, tableName, existing.AggregateID)
+		if err != nil {
+			return err
+		}
+		rewritten, ok := transform(event)
+		if !ok {
+			report.Skipped++
+			continue
+		}
+		if rewritten.AggregateID() != event.AggregateID() || rewritten.Version() != event.Version() ||
+			!rewritten.Timestamp().Equal(event.Timestamp()) {
+			return eh.EventStoreError{
+				Err:       eh.ErrInvalidEvent,
+				Namespace: report.Namespace,
+			}
+		}
+
+		entry := RewriteEntry{
+			AggregateID: existing.AggregateID,
+			Version:     existing.Version,
+			EventType:   rewritten.EventType(),
+			Table:       tableName,
+		}
+		if err := s.rewriteEvent(ctx, tableName, rewritten, existing, version); errors.Is(err, ErrRewriteConflict) {
+			entry.Err = err
+			report.Conflicts = append(report.Conflicts, entry)
+			continue
+		} else if err != nil {
+			return err
+		}
+		report.Rewritten = append(report.Rewritten, entry)
+	}
+	err := iter.Err()
+	observe(ctx, s.metrics, OperationScan, tableName, start, err)
+	if err != nil {
+		return eh.EventStoreError{
+			BaseErr:   withRequestID(err),
+			Err:       err,
+			Namespace: report.Namespace,
+		}
+	}
+
+	return nil
+}
+
+// rewriteEvent writes back a rewritten event, on the condition that the
+// aggregate is still at version, or has no head item if version is 0.
+func (s *EventStore) rewriteEvent(ctx context.Context, tableName string, event eh.Event, existing dbEvent, version int) error {
+	e, err := s.newDBEvent(ctx, event)
+	if err != nil {
+		return err
+	}
+	e.Feed = existing.Feed
+	e.Position = existing.Position
+	e.PositionedAt = existing.PositionedAt
+	e.ReceivedAt = existing.ReceivedAt
+
+	item, err := dynamo.MarshalItem(e)
+	if err != nil {
+		return eh.EventStoreError{
+			BaseErr:   err,
+			Err:       wrapError(ErrCouldNotMarshalEvent, err),
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	check := &dynamodb.ConditionCheck{
+		TableName:           aws.String(tableName),
+		Key:                 s.itemKey(ctx, e.AggregateID, aggregateHeadVersion),
+		ConditionExpression: aws.String(s.keyNotExists()),
+	}
+	if version > 0 {
+		check.ConditionExpression = aws.String("CurrentVersion = :version")
+		check.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+			":version": {N: aws.String(strconv.Itoa(version))},
+		}
+	}
+	items := []*dynamodb.TransactWriteItem{{
+		Put: &dynamodb.Put{
+			TableName:           aws.String(tableName),
+			Item:                item,
+			ConditionExpression: aws.String("attribute_exists(" + s.hashKey() + ") AND attribute_exists(Version)"),
+		},
+	}, {
+		ConditionCheck: check,
+	}}
+	items = append(items, s.chunkItems(ctx, tableName, e, &existing)...)
+	if s.payloads != nil && e.PayloadHash != existing.PayloadHash {
+		if e.PayloadHash != "" {
+			items = append(items, s.payloadRefItem(e.PayloadHash, e.payload, 1))
+		}
+		if existing.PayloadHash != "" {
+			items = append(items, s.payloadRefItem(existing.PayloadHash, nil, -1))
+		}
+	}
+
+	if s.cache != nil {
+		defer s.cache.remove(loadCacheKey(ctx, e.AggregateID))
+	}
+
+	start := time.Now()
+	_, err = s.service.Client().TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+	observe(ctx, s.metrics, OperationTransactWriteItems, tableName, start, err)
+	if isConditionalCheckFailed(err) {
+		return ErrRewriteConflict
+	} else if err != nil {
+		return eh.EventStoreError{
+			BaseErr:   withRequestID(err),
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+	return nil
+}
+
+// headVersion returns the current version of an aggregate from its head
+// item, or 0 if it has none.
+func (s *EventStore) headVersion(ctx context.Context, tableName string, id uuid.UUID) (int, error) {
+	var head dbAggregateHead
+	start := time.Now()
+	err := s.service.Table(tableName).
+		Get(s.hashKey(), s.hashValue(ctx, id)).
+		Range("Version", dynamo.Equal, aggregateHeadVersion).
+		Consistent(true).
+		OneWithContext(ctx, &head)
+	observe(ctx, s.metrics, OperationGetItem, tableName, start, err)
+	if err == dynamo.ErrNotFound {
+		return 0, nil
+	} else if err != nil {
+		return 0, eh.EventStoreError{
+			BaseErr:   withRequestID(err),
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+	return head.CurrentVersion, nil
+}
+
+// eventFilterItem returns the attributes of an event item that are matched
+// by event filters.
+func eventFilterItem(e dbEvent) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"AggregateType": {S: aws.String(string(e.AggregateType))},
+		"EventType":     {S: aws.String(string(e.EventType))},
+		"AggregateID":   {S: aws.String(e.AggregateID.String())},
+	}
+}
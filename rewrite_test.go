@@ -0,0 +1,82 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRewriteEvents will make sure that events are corrected in bulk
+func (suite *EventStoreTestSuite) TestRewriteEvents() {
+	id, other := uuid.New(), uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	var events []eh.Event
+	for i := 1; i <= 3; i++ {
+		events = append(events, eh.NewEventForAggregate(mocks.EventType,
+			&mocks.EventData{Content: fmt.Sprintf("event%d", i)}, timestamp, mocks.AggregateType, id, i))
+	}
+	assert.Nil(suite.T(), suite.store.Save(suite.ctx, events, 0))
+	otherEvent := eh.NewEventForAggregate(mocks.EventType,
+		&mocks.EventData{Content: "other1"}, timestamp, mocks.AggregateType, other, 1)
+	assert.Nil(suite.T(), suite.store.Save(suite.ctx, []eh.Event{otherEvent}, 0))
+
+	fixed := func(event eh.Event) eh.Event {
+		data := event.Data().(*mocks.EventData)
+		return eh.NewEventForAggregate(event.EventType(), &mocks.EventData{Content: data.Content + "-fixed"},
+			event.Timestamp(), event.AggregateType(), event.AggregateID(), event.Version())
+	}
+	report, err := suite.store.RewriteEvents(suite.ctx, EventFilter{AggregateIDs: []uuid.UUID{id, other}},
+		func(event eh.Event) (eh.Event, bool) {
+			if event.AggregateID() == other {
+				// A concurrent save makes the rewrite conflict.
+				next := eh.NewEventForAggregate(mocks.EventType,
+					&mocks.EventData{Content: "other2"}, timestamp, mocks.AggregateType, other, 2)
+				assert.Nil(suite.T(), suite.store.Save(suite.ctx, []eh.Event{next}, 1))
+				return fixed(event), true
+			}
+			if event.Version() == 2 {
+				return nil, false
+			}
+			return fixed(event), true
+		})
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), "ns", report.Namespace)
+	assert.Equal(suite.T(), 4, report.Matched)
+	assert.Equal(suite.T(), 1, report.Skipped)
+	assert.Len(suite.T(), report.Rewritten, 2)
+	if assert.Len(suite.T(), report.Conflicts, 1) {
+		assert.Equal(suite.T(), other, report.Conflicts[0].AggregateID)
+		assert.Equal(suite.T(), ErrRewriteConflict, report.Conflicts[0].Err)
+	}
+
+	loaded, err := suite.store.Load(suite.ctx, id)
+	assert.Nil(suite.T(), err)
+	if assert.Len(suite.T(), loaded, 3) {
+		assert.Equal(suite.T(), &mocks.EventData{Content: "event1-fixed"}, loaded[0].Data())
+		assert.Equal(suite.T(), &mocks.EventData{Content: "event2"}, loaded[1].Data())
+		assert.Equal(suite.T(), &mocks.EventData{Content: "event3-fixed"}, loaded[2].Data())
+	}
+	loaded, err = suite.store.Load(suite.ctx, other)
+	assert.Nil(suite.T(), err)
+	if assert.Len(suite.T(), loaded, 2) {
+		assert.Equal(suite.T(), otherEvent.Data(), loaded[0].Data())
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"time"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// SavedEvent is an event as it was written by SaveWithResult, with the
+// metadata that the store assigned to it.
+type SavedEvent struct {
+	eh.Event
+	// Position is the global position of the event, 0 without
+	// WithGlobalPosition.
+	Position int64
+	// WrittenAt is when the events were written, by the clock of the store,
+	// zero if they were buffered by WithStoreAndForward and are written when
+	// forwarded.
+	WrittenAt time.Time
+	// ItemSize is the size of the event item as DynamoDB counts it against
+	// the item size limit.
+	ItemSize int
+}
+
+// SaveWithResult saves events as Save and returns them as they were written,
+// so that callers can publish their global positions downstream. The events
+// also work with GlobalPosition.
+func (s *EventStore) SaveWithResult(ctx context.Context, events []eh.Event, originalVersion int) (_ []SavedEvent, err error) {
+	called := time.Now()
+	defer func() { s.observeMethod(ctx, MethodSave, called, err) }()
+
+	ctx, err = s.namespace(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(s.saveInterceptors) > 0 {
+		return s.interceptSave(ctx, events, originalVersion)
+	}
+	return s.save(ctx, events, originalVersion)
+}
+
+// newSavedEvent returns a saved event with the data and metadata of the
+// event and the storage fields of its record.
+func newSavedEvent(ctx context.Context, ev eh.Event, e *dbEvent) event {
+	return event{dbEvent{
+		AggregateID:   ev.AggregateID(),
+		Version:       ev.Version(),
+		EventType:     ev.EventType(),
+		data:          ev.Data(),
+		Timestamp:     ev.Timestamp(),
+		AggregateType: ev.AggregateType(),
+		Metadata:      correlationMetadata(ctx, ev.Metadata()),
+		ReceivedAt:    e.ReceivedAt,
+		Feed:          e.Feed,
+		Position:      e.Position,
+		PositionedAt:  e.PositionedAt,
+	}}
+}
+
+// savedEvents returns the events of saved events.
+func savedEvents(saved []SavedEvent) []eh.Event {
+	events := make([]eh.Event, len(saved))
+	for i, e := range saved {
+		events[i] = e.Event
+	}
+	return events
+}
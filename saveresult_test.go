@@ -0,0 +1,52 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSaveWithResult will return the saved events with their storage metadata
+func (suite *EventStoreTestSuite) TestSaveWithResult() {
+	store := suite.newStore(WithGlobalPosition())
+
+	ctx := eh.NewContextWithNamespace(context.Background(), "save_result")
+	assert.Nil(suite.T(), store.CreateTable(ctx))
+	defer store.DeleteTable(ctx)
+
+	id := uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	event1 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event1"}, timestamp, mocks.AggregateType, id, 1)
+	event2 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event2"}, timestamp, mocks.AggregateType, id, 2)
+	before := time.Now()
+	saved, err := store.SaveWithResult(ctx, []eh.Event{event1, event2}, 0)
+	assert.Nil(suite.T(), err)
+	if assert.Len(suite.T(), saved, 2) {
+		for i, event := range []eh.Event{event1, event2} {
+			assert.Equal(suite.T(), event.Data(), saved[i].Event.Data())
+			assert.Equal(suite.T(), event.Version(), saved[i].Event.Version())
+			assert.Equal(suite.T(), int64(i+1), saved[i].Position)
+			assert.Equal(suite.T(), saved[i].Position, GlobalPosition(saved[i].Event))
+			assert.False(suite.T(), saved[i].WrittenAt.Before(before))
+			assert.True(suite.T(), saved[i].ItemSize > 0)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/guregu/dynamo"
+)
+
+// WithScanRateLimit limits the read capacity that the scans of LoadAll,
+// RenameEvent and replays consume to rcuPerSecond read capacity units per
+// second on average, so that they don't starve the production traffic of the
+// table. The consumed capacity is reported by DynamoDB for every page, and the
+// scan waits before reading the next page until the capacity of the pages it
+// read is within the rate. The limit is shared by all scans of the store.
+func WithScanRateLimit(rcuPerSecond float64) Option {
+	return func(s *EventStore) error {
+		if rcuPerSecond <= 0 {
+			return fmt.Errorf("invalid scan rate limit %v", rcuPerSecond)
+		}
+		s.scanLimit = &scanLimit{rate: rcuPerSecond}
+		return nil
+	}
+}
+
+// scanLimit is the rate limit of the capacity that scans consume.
+type scanLimit struct {
+	rate float64
+
+	mu sync.Mutex
+	// next is when the capacity consumed so far is within the rate.
+	next time.Time
+}
+
+// wait waits until capacity consumed now is within the rate.
+func (l *scanLimit) wait(ctx context.Context, units float64) error {
+	if units <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(units / l.rate * float64(time.Second)))
+	delay := l.next.Sub(now)
+	l.mu.Unlock()
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// scanThrottle throttles a single scan by its consumed capacity, which is
+// nil when scans are not rate limited.
+type scanThrottle struct {
+	limit    *scanLimit
+	consumed dynamo.ConsumedCapacity
+	waited   float64
+}
+
+// newScanThrottle returns a throttle for a scan, or nil if scans are not
+// rate limited.
+func (s *EventStore) newScanThrottle() *scanThrottle {
+	if s.scanLimit == nil {
+		return nil
+	}
+	return &scanThrottle{limit: s.scanLimit}
+}
+
+// scan returns the scan with its consumed capacity reported to the throttle.
+func (t *scanThrottle) scan(scan *dynamo.Scan) *dynamo.Scan {
+	if t == nil {
+		return scan
+	}
+	return scan.ConsumedCapacity(&t.consumed)
+}
+
+// wait waits for the capacity that the scan consumed since the last wait, if
+// any, which is after every page that the scan read.
+func (t *scanThrottle) wait(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	units := t.consumed.Total - t.waited
+	t.waited = t.consumed.Total
+	return t.limit.wait(ctx, units)
+}
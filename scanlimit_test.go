@@ -0,0 +1,50 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScanLimit(t *testing.T) {
+	l := &scanLimit{rate: 100}
+	ctx := context.Background()
+
+	// Every page waits for its capacity at the rate, 50ms for 5 units.
+	start := time.Now()
+	assert.Nil(t, l.wait(ctx, 0))
+	assert.Nil(t, l.wait(ctx, 5))
+	assert.Nil(t, l.wait(ctx, 5))
+	elapsed := time.Since(start)
+	assert.True(t, elapsed >= 100*time.Millisecond, elapsed)
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.Equal(t, context.Canceled, l.wait(ctx, 100))
+
+	var throttle *scanThrottle
+	assert.Nil(t, throttle.wait(ctx))
+}
+
+func TestWithScanRateLimit(t *testing.T) {
+	s := &EventStore{}
+	assert.NotNil(t, WithScanRateLimit(0)(s))
+	assert.Nil(t, WithScanRateLimit(50)(s))
+	assert.Equal(t, 50.0, s.Capabilities().ScanRateLimit)
+}
@@ -0,0 +1,483 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrJobExists is when a job is registered with the name of another job.
+var ErrJobExists = errors.New("job already registered")
+
+// ErrJobNotFound is when a job is not registered.
+var ErrJobNotFound = errors.New("job not found")
+
+// ErrSchedulerClosed is when a job is registered after the scheduler was
+// closed.
+var ErrSchedulerClosed = errors.New("scheduler closed")
+
+// JobFunc is the work of a background job. It should call Wait on the run
+// before each unit of work, such as a page of items, so that it is rate
+// limited and paused, and Progress to report how far it is.
+type JobFunc func(ctx context.Context, run *JobRun) error
+
+// JobOption is an option setter used to configure a job.
+type JobOption func(*job)
+
+// WithJobInterval runs a job every interval, counted from the end of the
+// previous run. Without it a job runs once.
+func WithJobInterval(interval time.Duration) JobOption {
+	return func(j *job) {
+		j.interval = interval
+	}
+}
+
+// WithJobRateLimit limits how often Wait of a run of the job returns, in
+// units of work per second.
+func WithJobRateLimit(perSecond float64) JobOption {
+	return func(j *job) {
+		if perSecond > 0 {
+			j.rateInterval = time.Duration(float64(time.Second) / perSecond)
+		}
+	}
+}
+
+// WithJobPriority sets the priority of a job. When more jobs are due than can
+// run at once, jobs with a higher priority are started first. The default
+// priority is 0.
+func WithJobPriority(priority int) JobOption {
+	return func(j *job) {
+		j.priority = priority
+	}
+}
+
+// WithJobErrorHandler passes the errors of the runs of a job to onError.
+func WithJobErrorHandler(onError func(error)) JobOption {
+	return func(j *job) {
+		j.onError = onError
+	}
+}
+
+// JobStatus is the status of a job.
+type JobStatus struct {
+	Name     string
+	Priority int
+	// Running and Paused are set when the job is running or paused. A paused
+	// job can still be running until it calls Wait.
+	Running bool
+	Paused  bool
+	// Runs is the number of finished runs.
+	Runs int
+	// Done and Total are the progress of the current or last run, as reported
+	// by the job. Total is 0 if it is unknown.
+	Done  int64
+	Total int64
+	// LastRun is when the last run finished, and LastErr its error.
+	LastRun time.Time
+	LastErr error
+}
+
+// Scheduler runs background jobs with a bounded number of jobs running at
+// once: the outbox relays and store-and-forward flushes of stores with
+// WithScheduler, archivers registered with Archiver.Schedule and replays that
+// are registered as jobs. Jobs can be rate limited, paused and resumed, and
+// report their progress.
+type Scheduler struct {
+	mu            sync.Mutex
+	maxConcurrent int
+	running       int
+	jobs          map[string]*job
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wake   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// job is a registered job.
+type job struct {
+	name         string
+	fn           JobFunc
+	interval     time.Duration
+	rateInterval time.Duration
+	priority     int
+	onError      func(error)
+
+	nextRun  time.Time
+	finished bool
+	status   JobStatus
+	// resumed is closed when a paused job is resumed, nil if not paused.
+	resumed chan struct{}
+	// nextWait is the earliest time Wait returns for the rate limit.
+	nextWait time.Time
+}
+
+// WithScheduler runs the background loops of the store, relaying the outbox
+// and forwarding buffered writes, as jobs of a scheduler that is shared with
+// other stores and subsystems, instead of in their own goroutines. The jobs
+// are named by the table prefix, like "prefix/outbox", and are stopped by
+// closing the scheduler. Archivers are registered with Archiver.Schedule.
+func WithScheduler(scheduler *Scheduler) Option {
+	return func(s *EventStore) error {
+		s.scheduler = scheduler
+		return nil
+	}
+}
+
+// scheduleJob registers a background loop of the store with its scheduler.
+func (s *EventStore) scheduleJob(name string, interval time.Duration, onError func(error), fn func(context.Context) error) error {
+	if interval <= 0 {
+		return nil
+	}
+
+	jobName := s.tablePrefix + "/" + name
+	if err := s.scheduler.Register(jobName, func(ctx context.Context, run *JobRun) error {
+		if err := run.Wait(ctx); err != nil {
+			return err
+		}
+		return fn(ctx)
+	},
+		WithJobInterval(interval),
+		WithJobErrorHandler(onError),
+	); err != nil {
+		return err
+	}
+
+	s.onClose(func(context.Context) error {
+		if err := s.scheduler.Unregister(jobName); err != ErrJobNotFound {
+			return err
+		}
+		return nil
+	})
+	return nil
+}
+
+// NewScheduler creates a scheduler that runs at most maxConcurrent jobs at
+// once, and starts it.
+func NewScheduler(maxConcurrent int) *Scheduler {
+	if maxConcurrent < 1 {
+		maxConcurrent = 1
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Scheduler{
+		maxConcurrent: maxConcurrent,
+		jobs:          map[string]*job{},
+		ctx:           ctx,
+		cancel:        cancel,
+		wake:          make(chan struct{}, 1),
+	}
+
+	s.wg.Add(1)
+	go s.run()
+	return s
+}
+
+// Register registers a job, which is due at once.
+func (s *Scheduler) Register(name string, fn JobFunc, options ...JobOption) error {
+	j := &job{
+		name:    name,
+		fn:      fn,
+		nextRun: time.Now(),
+	}
+	for _, option := range options {
+		option(j)
+	}
+	j.status = JobStatus{Name: name, Priority: j.priority}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ctx.Err() != nil {
+		return ErrSchedulerClosed
+	}
+	if _, ok := s.jobs[name]; ok {
+		return ErrJobExists
+	}
+	s.jobs[name] = j
+	s.notify()
+	return nil
+}
+
+// Unregister removes a job. A run that is in progress is not stopped.
+func (s *Scheduler) Unregister(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[name]
+	if !ok {
+		return ErrJobNotFound
+	}
+	if j.resumed != nil {
+		close(j.resumed)
+		j.resumed = nil
+	}
+	delete(s.jobs, name)
+	return nil
+}
+
+// Pause pauses a job. It is not started while paused, and a running job
+// blocks in its next call to Wait until it is resumed.
+func (s *Scheduler) Pause(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[name]
+	if !ok {
+		return ErrJobNotFound
+	}
+	if j.resumed == nil {
+		j.resumed = make(chan struct{})
+	}
+	return nil
+}
+
+// Resume resumes a paused job.
+func (s *Scheduler) Resume(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[name]
+	if !ok {
+		return ErrJobNotFound
+	}
+	if j.resumed != nil {
+		close(j.resumed)
+		j.resumed = nil
+	}
+	s.notify()
+	return nil
+}
+
+// Status returns the status of a job.
+func (s *Scheduler) Status(name string) (JobStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[name]
+	if !ok {
+		return JobStatus{}, ErrJobNotFound
+	}
+	return j.currentStatus(), nil
+}
+
+// Jobs returns the status of all jobs, in name order.
+func (s *Scheduler) Jobs() []JobStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	statuses := make([]JobStatus, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		statuses = append(statuses, j.currentStatus())
+	}
+	sort.Slice(statuses, func(i, k int) bool {
+		return statuses[i].Name < statuses[k].Name
+	})
+	return statuses
+}
+
+// Close stops the scheduler, cancels the context of the running jobs and
+// waits for them to return.
+func (s *Scheduler) Close() error {
+	s.mu.Lock()
+	s.cancel()
+	for _, j := range s.jobs {
+		if j.resumed != nil {
+			close(j.resumed)
+			j.resumed = nil
+		}
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return nil
+}
+
+// run starts the due jobs until the scheduler is closed.
+func (s *Scheduler) run() {
+	defer s.wg.Done()
+
+	for {
+		s.mu.Lock()
+		next := s.dispatch()
+		s.mu.Unlock()
+
+		timer := time.NewTimer(next)
+		select {
+		case <-s.ctx.Done():
+			timer.Stop()
+			return
+		case <-s.wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// dispatch starts the due jobs by priority while there are free slots, and
+// returns the time until the next job is due. The caller must hold the lock.
+func (s *Scheduler) dispatch() time.Duration {
+	now := time.Now()
+	next := time.Hour
+
+	var due []*job
+	for _, j := range s.jobs {
+		if j.status.Running || j.finished || j.resumed != nil {
+			continue
+		}
+		if wait := j.nextRun.Sub(now); wait > 0 {
+			if wait < next {
+				next = wait
+			}
+			continue
+		}
+		due = append(due, j)
+	}
+
+	sort.Slice(due, func(i, k int) bool {
+		if due[i].priority != due[k].priority {
+			return due[i].priority > due[k].priority
+		}
+		return due[i].nextRun.Before(due[k].nextRun)
+	})
+	for _, j := range due {
+		if s.running >= s.maxConcurrent {
+			break
+		}
+		s.running++
+		j.status.Running = true
+		j.status.Done, j.status.Total = 0, 0
+
+		s.wg.Add(1)
+		go s.runJob(j)
+	}
+	return next
+}
+
+// runJob runs a job once and schedules its next run.
+func (s *Scheduler) runJob(j *job) {
+	defer s.wg.Done()
+
+	run := &JobRun{scheduler: s, job: j}
+	err := j.fn(newContextWithJobRun(s.ctx, run), run)
+	if err != nil && j.onError != nil && s.ctx.Err() == nil {
+		j.onError(err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.running--
+	j.status.Running = false
+	j.status.Runs++
+	j.status.LastRun = time.Now()
+	j.status.LastErr = err
+	if j.interval > 0 {
+		j.nextRun = j.status.LastRun.Add(j.interval)
+	} else {
+		j.finished = true
+	}
+	s.notify()
+}
+
+// notify wakes up the scheduler. The caller must hold the lock.
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// currentStatus returns the status of a job. The caller must hold the lock.
+func (j *job) currentStatus() JobStatus {
+	status := j.status
+	status.Paused = j.resumed != nil
+	return status
+}
+
+// JobRun is a run of a job.
+type JobRun struct {
+	scheduler *Scheduler
+	job       *job
+}
+
+// Wait blocks while the job is paused and until the rate limit of the job
+// allows the next unit of work. It returns the error of the context if it is
+// canceled, for example when the scheduler is closed.
+func (r *JobRun) Wait(ctx context.Context) error {
+	for {
+		r.scheduler.mu.Lock()
+		resumed := r.job.resumed
+		r.scheduler.mu.Unlock()
+		if resumed == nil {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-resumed:
+		}
+	}
+
+	r.scheduler.mu.Lock()
+	now := time.Now()
+	wait := r.job.nextWait.Sub(now)
+	if wait < 0 {
+		wait = 0
+	}
+	r.job.nextWait = now.Add(wait + r.job.rateInterval)
+	r.scheduler.mu.Unlock()
+
+	if wait == 0 {
+		return ctx.Err()
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(wait):
+		return nil
+	}
+}
+
+// Progress reports the progress of the run, with total 0 if it is unknown.
+func (r *JobRun) Progress(done, total int64) {
+	r.scheduler.mu.Lock()
+	defer r.scheduler.mu.Unlock()
+
+	r.job.status.Done = done
+	r.job.status.Total = total
+}
+
+type jobRunKey int
+
+// jobRunCtxKey is the context key of the job run.
+const jobRunCtxKey jobRunKey = iota
+
+// newContextWithJobRun returns a context with a job run, for subsystems that
+// are run both as jobs and directly.
+func newContextWithJobRun(ctx context.Context, run *JobRun) context.Context {
+	return context.WithValue(ctx, jobRunCtxKey, run)
+}
+
+// jobRunFromContext returns the job run of the context, if any.
+func jobRunFromContext(ctx context.Context) *JobRun {
+	run, _ := ctx.Value(jobRunCtxKey).(*JobRun)
+	return run
+}
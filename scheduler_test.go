@@ -0,0 +1,163 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchedulerConcurrencyAndPriority(t *testing.T) {
+	s := NewScheduler(1)
+	defer s.Close()
+
+	// Block the only slot while the other jobs are registered.
+	release := make(chan struct{})
+	assert.Nil(t, s.Register("blocker", func(ctx context.Context, run *JobRun) error {
+		<-release
+		return nil
+	}, WithJobPriority(100)))
+	assert.Eventually(t, func() bool {
+		status, _ := s.Status("blocker")
+		return status.Running
+	}, time.Second, time.Millisecond)
+
+	var mu sync.Mutex
+	var order []string
+	for _, j := range []struct {
+		name     string
+		priority int
+	}{{"low", 0}, {"high", 10}, {"mid", 5}} {
+		name := j.name
+		assert.Nil(t, s.Register(name, func(ctx context.Context, run *JobRun) error {
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			return nil
+		}, WithJobPriority(j.priority)))
+	}
+	assert.Equal(t, ErrJobExists, s.Register("low", nil))
+
+	close(release)
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(order) == 3
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, []string{"high", "mid", "low"}, order)
+
+	statuses := s.Jobs()
+	assert.Len(t, statuses, 4)
+	assert.Equal(t, "blocker", statuses[0].Name)
+	assert.Equal(t, 1, statuses[0].Runs)
+}
+
+func TestSchedulerPauseResume(t *testing.T) {
+	s := NewScheduler(2)
+	defer s.Close()
+
+	steps := make(chan int64)
+	next := make(chan struct{})
+	assert.Nil(t, s.Register("job", func(ctx context.Context, run *JobRun) error {
+		for i := int64(1); i <= 3; i++ {
+			if err := run.Wait(ctx); err != nil {
+				return err
+			}
+			run.Progress(i, 3)
+			steps <- i
+			<-next
+		}
+		return errors.New("done")
+	}))
+
+	assert.Equal(t, int64(1), <-steps)
+	assert.Nil(t, s.Pause("job"))
+	next <- struct{}{}
+	select {
+	case <-steps:
+		t.Error("job not paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	status, err := s.Status("job")
+	assert.Nil(t, err)
+	assert.True(t, status.Paused)
+	assert.True(t, status.Running)
+	assert.Equal(t, int64(1), status.Done)
+	assert.Equal(t, int64(3), status.Total)
+
+	assert.Nil(t, s.Resume("job"))
+	assert.Equal(t, int64(2), <-steps)
+	next <- struct{}{}
+	assert.Equal(t, int64(3), <-steps)
+	next <- struct{}{}
+	assert.Eventually(t, func() bool {
+		status, _ := s.Status("job")
+		return status.Runs == 1
+	}, time.Second, time.Millisecond)
+	status, _ = s.Status("job")
+	assert.EqualError(t, status.LastErr, "done")
+
+	assert.Equal(t, ErrJobNotFound, s.Pause("other"))
+	_, err = s.Status("other")
+	assert.Equal(t, ErrJobNotFound, err)
+}
+
+func TestSchedulerRateLimitAndInterval(t *testing.T) {
+	s := NewScheduler(1)
+
+	var mu sync.Mutex
+	runs := 0
+	var errs []error
+	assert.Nil(t, s.Register("job", func(ctx context.Context, run *JobRun) error {
+		// Three waits at 20 per second take at least 100ms.
+		start := time.Now()
+		for i := 0; i < 3; i++ {
+			if err := run.Wait(ctx); err != nil {
+				return err
+			}
+		}
+		if time.Since(start) < 90*time.Millisecond {
+			return errors.New("not rate limited")
+		}
+		mu.Lock()
+		runs++
+		mu.Unlock()
+		return nil
+	},
+		WithJobRateLimit(20),
+		WithJobInterval(10*time.Millisecond),
+		WithJobErrorHandler(func(err error) {
+			mu.Lock()
+			errs = append(errs, err)
+			mu.Unlock()
+		}),
+	))
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return runs >= 2
+	}, 2*time.Second, time.Millisecond)
+
+	assert.Nil(t, s.Close())
+	assert.Equal(t, ErrSchedulerClosed, s.Register("other", nil))
+	assert.Empty(t, errs)
+}
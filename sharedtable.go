@@ -0,0 +1,227 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/google/uuid"
+	"github.com/guregu/dynamo"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// ErrSharedTable is when a feature is used that is not supported with a
+// shared table.
+var ErrSharedTable = errors.New("not supported with a shared table")
+
+// ErrInvalidSharedNamespace is when a namespace contains the separator of the
+// hash key of a shared table.
+var ErrInvalidSharedNamespace = errors.New("namespace contains " + sharedKeySeparator)
+
+const (
+	// sharedHashKey is the hash key attribute of a shared table.
+	sharedHashKey = "PK"
+
+	// sharedKeySeparator separates the namespace and the aggregate ID in the
+	// hash key of a shared table.
+	sharedKeySeparator = "#"
+)
+
+// WithSharedTable stores the events of all namespaces in one table, named by
+// the table prefix, instead of in a table per namespace. This avoids running
+// into the table limits of an account with thousands of tenants, and the
+// capacity of all tenants is planned together. The hash key of the table is
+// the PK attribute with "<namespace>#<aggregate ID>" instead of the aggregate
+// ID, so the table layout is not compatible with tables per namespace, and
+// namespaces must not contain "#".
+//
+// Scans, as of LoadAll and RenameEvent, read the events of all namespaces
+// and filter out the ones of other namespaces, and DeleteTable deletes the
+// events of the namespace instead of the table. Monthly partitions, global
+// positions, payload deduplication, archiving, secondary indexes and
+// activity buckets are not supported with a shared table, and neither is the
+// stream event bus.
+func WithSharedTable() Option {
+	return func(s *EventStore) error {
+		s.sharedTable = true
+		s.tableName = func(context.Context) string {
+			return s.tablePrefix
+		}
+		return nil
+	}
+}
+
+// checkSharedTable checks that the options of a store with a shared table
+// are supported.
+func (s *EventStore) checkSharedTable() error {
+	if !s.sharedTable {
+		return nil
+	}
+
+	var feature string
+	switch {
+	case s.partitions != nil:
+		feature = "monthly partitions"
+	case s.globalPosition:
+		feature = "global positions"
+	case s.payloads != nil:
+		feature = "payload deduplication"
+	case s.archive != nil:
+		feature = "archiving"
+	case len(s.indexes) > 0:
+		feature = "secondary indexes"
+	case s.activityBucket > 0:
+		feature = "activity buckets"
+	default:
+		return nil
+	}
+	return fmt.Errorf("%s: %w", feature, ErrSharedTable)
+}
+
+// hashKey returns the name of the hash key attribute of the event tables.
+func (s *EventStore) hashKey() string {
+	if s.sharedTable {
+		return sharedHashKey
+	}
+	return "AggregateID"
+}
+
+// hashValue returns the hash key of the items of an aggregate in the
+// namespace of the context.
+func (s *EventStore) hashValue(ctx context.Context, id uuid.UUID) string {
+	if s.sharedTable {
+		return eh.NamespaceFromContext(ctx) + sharedKeySeparator + id.String()
+	}
+	return id.String()
+}
+
+// itemKey returns the key of an item of an aggregate in the namespace of the
+// context.
+func (s *EventStore) itemKey(ctx context.Context, id uuid.UUID, version int) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		s.hashKey(): {S: aws.String(s.hashValue(ctx, id))},
+		"Version":   {N: aws.String(strconv.Itoa(version))},
+	}
+}
+
+// keyNotExists returns the condition that an item with the key of a write
+// does not exist.
+func (s *EventStore) keyNotExists() string {
+	return "attribute_not_exists(" + s.hashKey() + ")"
+}
+
+// scanNamespace returns a scan that only reads the items of the namespace of
+// the context, which is the scan as is unless the table is shared.
+func (s *EventStore) scanNamespace(ctx context.Context, scan *dynamo.Scan) *dynamo.Scan {
+	if !s.sharedTable {
+		return scan
+	}
+	return scan.Filter("begins_with($, ?)", sharedHashKey, eh.NamespaceFromContext(ctx)+sharedKeySeparator)
+}
+
+// sharedTableKey is the key of an item of a shared table.
+type sharedTableKey struct {
+	PK      string `dynamo:",hash"`
+	Version int    `dynamo:",range"`
+}
+
+// deleteNamespaceItems deletes all items of the namespace of the context from
+// a shared table.
+func (s *EventStore) deleteNamespaceItems(ctx context.Context) error {
+	tableName := s.tableName(ctx)
+	table := s.service.Table(tableName)
+
+	var keys []sharedTableKey
+	start := time.Now()
+	err := s.scanNamespace(ctx, table.Scan()).
+		Project(sharedHashKey, "Version").
+		Consistent(true).
+		AllWithContext(ctx, &keys)
+	observe(ctx, s.metrics, OperationScan, tableName, start, err)
+	if isAWSErrorCode(err, dynamodb.ErrCodeResourceNotFoundException) {
+		return nil
+	} else if err != nil {
+		return eh.EventStoreError{
+			BaseErr:   withRequestID(err),
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	reqs := make([]*dynamodb.WriteRequest, len(keys))
+	for i, k := range keys {
+		reqs[i] = &dynamodb.WriteRequest{DeleteRequest: &dynamodb.DeleteRequest{
+			Key: map[string]*dynamodb.AttributeValue{
+				sharedHashKey: {S: aws.String(k.PK)},
+				"Version":     {N: aws.String(strconv.Itoa(k.Version))},
+			},
+		}}
+	}
+
+	start = time.Now()
+	outcomes := batchWrite(ctx, s.service.Client(), tableName, []string{sharedHashKey, "Version"}, reqs)
+	err = batchResult(outcomes, eh.NamespaceFromContext(ctx))
+	observe(ctx, s.metrics, OperationBatchWriteItem, tableName, start, err)
+	if err != nil {
+		return eh.EventStoreError{
+			BaseErr:   withRequestID(err),
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+	return nil
+}
+
+// sharedNamespaces returns the namespaces that have events in a shared table,
+// found by scanning the version counters of all aggregates.
+func (s *EventStore) sharedNamespaces(ctx context.Context) ([]string, error) {
+	tableName := s.tableName(ctx)
+
+	var keys []sharedTableKey
+	start := time.Now()
+	err := s.service.Table(tableName).Scan().
+		Filter("Version = ?", aggregateHeadVersion).
+		Project(sharedHashKey, "Version").
+		AllWithContext(ctx, &keys)
+	observe(ctx, s.metrics, OperationScan, tableName, start, err)
+	if err != nil {
+		return nil, eh.EventStoreError{
+			BaseErr:   withRequestID(err),
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	seen := map[string]bool{}
+	var namespaces []string
+	for _, k := range keys {
+		i := strings.Index(k.PK, sharedKeySeparator)
+		if i < 0 || seen[k.PK[:i]] {
+			continue
+		}
+		seen[k.PK[:i]] = true
+		namespaces = append(namespaces, k.PK[:i])
+	}
+	sort.Strings(namespaces)
+	return namespaces, nil
+}
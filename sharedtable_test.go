@@ -0,0 +1,78 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSharedTable will store the events of several namespaces in one table
+func (suite *EventStoreTestSuite) TestSharedTable() {
+	_, err := NewEventStore("test_shared", WithDynamoDB(suite.session), WithSharedTable(), WithEventTypeIndex())
+	assert.True(suite.T(), errors.Is(err, ErrSharedTable))
+
+	store, err := NewEventStore("test_shared", WithDynamoDB(suite.session), WithSharedTable())
+	if !assert.Nil(suite.T(), err) {
+		return
+	}
+	defer store.deleteTable(context.Background(), "test_shared")
+
+	ctxA := eh.NewContextWithNamespace(context.Background(), "tenant_a")
+	ctxB := eh.NewContextWithNamespace(context.Background(), "tenant_b")
+	assert.Nil(suite.T(), store.CreateTable(ctxA))
+	assert.Nil(suite.T(), store.CreateTable(ctxB))
+
+	// The same aggregate ID is a different aggregate in every namespace.
+	id := uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	event1 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event1"}, timestamp, mocks.AggregateType, id, 1)
+	event2 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event2"}, timestamp, mocks.AggregateType, id, 2)
+	assert.Nil(suite.T(), store.Save(ctxA, []eh.Event{event1, event2}, 0))
+	assert.Nil(suite.T(), store.Save(ctxB, []eh.Event{event1}, 0))
+	assert.True(suite.T(), IsVersionConflict(store.Save(ctxB, []eh.Event{event1}, 0)))
+
+	events, err := store.Load(ctxA, id)
+	assert.Nil(suite.T(), err)
+	assert.Len(suite.T(), events, 2)
+	events, err = store.Load(ctxB, id)
+	assert.Nil(suite.T(), err)
+	assert.Len(suite.T(), events, 1)
+	events, err = store.LoadAll(ctxB)
+	assert.Nil(suite.T(), err)
+	assert.Len(suite.T(), events, 1)
+
+	namespaces, err := store.Namespaces(context.Background())
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), []string{"tenant_a", "tenant_b"}, namespaces)
+
+	// Deleting the table of a namespace only deletes its events.
+	assert.Nil(suite.T(), store.DeleteTable(ctxA))
+	events, err = store.LoadAll(ctxA)
+	assert.Nil(suite.T(), err)
+	assert.Len(suite.T(), events, 0)
+	events, err = store.Load(ctxB, id)
+	assert.Nil(suite.T(), err)
+	assert.Len(suite.T(), events, 1)
+
+	_, err = store.Load(eh.NewContextWithNamespace(context.Background(), "a#b"), id)
+	assert.True(suite.T(), errors.Is(err.(eh.EventStoreError).Err, ErrInvalidSharedNamespace))
+}
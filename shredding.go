@@ -0,0 +1,212 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/guregu/dynamo"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// ErrAggregateShredded is when the key of an aggregate was deleted by
+// ShredAggregate, so its events can not be decrypted and no events can be
+// saved for it.
+var ErrAggregateShredded = errors.New("aggregate is shredded")
+
+// aggregateKeySize is the size of the AES-256 keys of aggregates.
+const aggregateKeySize = 32
+
+// shredding is the config of the crypto-shredding mode.
+type shredding struct {
+	tableName string
+
+	mu   sync.Mutex
+	keys map[string]cachedAggregateKey
+}
+
+// cachedAggregateKey is a key of an aggregate and when it was read.
+type cachedAggregateKey struct {
+	key  []byte
+	read time.Time
+}
+
+// WithCryptoShredding encrypts the data and metadata of events with a key per
+// aggregate, which is generated on the first save of the aggregate and stored
+// in a key table shared by all namespaces. ShredAggregate deletes the key of
+// an aggregate, which makes its events unreadable without rewriting them, to
+// erase personal data from an immutable stream. The key table is created by
+// CreateTable and should be encrypted at rest with WithTableEncryption.
+//
+// Events of a shredded aggregate are loaded without data and metadata. The
+// key item is kept without the key as a tombstone, and saves to a shredded
+// aggregate fail with ErrAggregateShredded, so that the stream never mixes
+// events of a deleted and a new key. Keys are cached for
+// a few minutes, so other store instances can still decrypt events for that
+// long after a shred. It takes precedence over WithEncryption.
+func WithCryptoShredding(keyTableName string) Option {
+	return func(s *EventStore) error {
+		s.shredding = &shredding{
+			tableName: keyTableName,
+			keys:      map[string]cachedAggregateKey{},
+		}
+		return nil
+	}
+}
+
+// ShredAggregate deletes the key of an aggregate, after which its events can
+// no longer be decrypted and no events can be saved for it.
+func (s *EventStore) ShredAggregate(ctx context.Context, id uuid.UUID) error {
+	ctx, err := s.namespace(ctx)
+	if err != nil {
+		return err
+	}
+
+	if s.shredding == nil {
+		return eh.EventStoreError{
+			Err:       ErrNoEncryption,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	// Keep the key item as a tombstone, which is also created for an
+	// aggregate without a key so that it can't get one later.
+	start := time.Now()
+	err = s.service.Table(s.shredding.tableName).
+		Update("Namespace", eh.NamespaceFromContext(ctx)).
+		Range("AggregateID", id.String()).
+		Remove("Key").
+		Set("Shredded", time.Now()).
+		RunWithContext(ctx)
+	observe(ctx, s.metrics, OperationUpdateItem, s.shredding.tableName, start, err)
+	if err != nil {
+		return eh.EventStoreError{
+			BaseErr:   withRequestID(err),
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	s.shredding.mu.Lock()
+	delete(s.shredding.keys, aggregateKeyID(ctx, id))
+	s.shredding.mu.Unlock()
+	if s.cache != nil {
+		s.cache.remove(loadCacheKey(ctx, id))
+	}
+	return nil
+}
+
+// aggregateKey returns the key of an aggregate, generating it if create is
+// set and the aggregate has no key item. It returns ErrAggregateShredded if
+// the aggregate is shredded, or has no key item and create is not set.
+func (s *EventStore) aggregateKey(ctx context.Context, id uuid.UUID, create bool) ([]byte, error) {
+	cacheKey := aggregateKeyID(ctx, id)
+	s.shredding.mu.Lock()
+	cached, ok := s.shredding.keys[cacheKey]
+	s.shredding.mu.Unlock()
+	if ok && time.Since(cached.read) < dataKeyMaxAge {
+		return cached.key, nil
+	}
+
+	table := s.service.Table(s.shredding.tableName)
+	var item dbAggregateKey
+	start := time.Now()
+	err := table.Get("Namespace", eh.NamespaceFromContext(ctx)).
+		Range("AggregateID", dynamo.Equal, id.String()).
+		Consistent(true).
+		OneWithContext(ctx, &item)
+	observe(ctx, s.metrics, OperationGetItem, s.shredding.tableName, start, err)
+	if err == dynamo.ErrNotFound && create {
+		if item, err = s.createAggregateKey(ctx, id); err != nil {
+			return nil, err
+		}
+	} else if err == dynamo.ErrNotFound {
+		return nil, ErrAggregateShredded
+	} else if err != nil {
+		return nil, err
+	}
+	if item.Key == nil {
+		return nil, ErrAggregateShredded
+	}
+
+	s.shredding.mu.Lock()
+	if len(s.shredding.keys) >= maxCachedDataKeys {
+		s.shredding.keys = map[string]cachedAggregateKey{}
+	}
+	s.shredding.keys[cacheKey] = cachedAggregateKey{key: item.Key, read: time.Now()}
+	s.shredding.mu.Unlock()
+	return item.Key, nil
+}
+
+// createAggregateKey generates and stores the key of an aggregate, or returns
+// the key item that a concurrent save or shred stored first.
+func (s *EventStore) createAggregateKey(ctx context.Context, id uuid.UUID) (dbAggregateKey, error) {
+	item := dbAggregateKey{
+		Namespace:   eh.NamespaceFromContext(ctx),
+		AggregateID: id.String(),
+		Key:         make([]byte, aggregateKeySize),
+		Created:     time.Now(),
+	}
+	if _, err := io.ReadFull(rand.Reader, item.Key); err != nil {
+		return item, err
+	}
+
+	table := s.service.Table(s.shredding.tableName)
+	start := time.Now()
+	err := table.Put(item).If("attribute_not_exists(AggregateID)").RunWithContext(ctx)
+	observe(ctx, s.metrics, OperationPutItem, s.shredding.tableName, start, err)
+	if !isConditionalCheckFailed(err) {
+		return item, err
+	}
+
+	start = time.Now()
+	err = table.Get("Namespace", item.Namespace).
+		Range("AggregateID", dynamo.Equal, item.AggregateID).
+		Consistent(true).
+		OneWithContext(ctx, &item)
+	observe(ctx, s.metrics, OperationGetItem, s.shredding.tableName, start, err)
+	return item, err
+}
+
+// createKeyTable creates the key table if it is not already existing.
+func (s *EventStore) createKeyTable(ctx context.Context) error {
+	if s.shredding == nil {
+		return nil
+	}
+	return createTable(ctx, s.service.Client(), s.shredding.tableName,
+		s.service.CreateTable(s.shredding.tableName, dbAggregateKey{}), nil)
+}
+
+// aggregateKeyID is the cache key of the key of an aggregate.
+func aggregateKeyID(ctx context.Context, id uuid.UUID) string {
+	return eh.NamespaceFromContext(ctx) + "/" + id.String()
+}
+
+// dbAggregateKey is the item of the key of an aggregate.
+type dbAggregateKey struct {
+	Namespace   string `dynamo:",hash"`
+	AggregateID string `dynamo:",range"`
+
+	Key     []byte `dynamo:",omitempty"`
+	Created time.Time
+	// Shredded is when the key was deleted, see ShredAggregate.
+	Shredded time.Time `dynamo:",omitempty"`
+}
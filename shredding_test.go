@@ -0,0 +1,81 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/guregu/dynamo"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCryptoShredding will make sure that the events of a shredded aggregate can not be read
+func (suite *EventStoreTestSuite) TestCryptoShredding() {
+	store := suite.newStore(WithCryptoShredding("test_keys"))
+
+	ctx := eh.NewContextWithNamespace(context.Background(), "shredding")
+	assert.Nil(suite.T(), store.CreateTable(ctx))
+	defer store.DeleteTable(ctx)
+	defer store.deleteTable(ctx, "test_keys")
+
+	id := uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	data := &mocks.EventData{Content: "personal"}
+	assert.Nil(suite.T(), store.Save(ctx, []eh.Event{
+		eh.NewEventForAggregate(mocks.EventType, data, timestamp, mocks.AggregateType, id, 1),
+	}, 0))
+
+	var e dbEvent
+	assert.Nil(suite.T(), store.service.Table(store.tableName(ctx)).
+		Get("AggregateID", id.String()).Range("Version", dynamo.Equal, 1).One(&e))
+	assert.True(suite.T(), e.AggregateKey)
+	assert.NotNil(suite.T(), e.Encrypted)
+
+	events, err := store.Load(ctx, id)
+	assert.Nil(suite.T(), err)
+	if assert.Len(suite.T(), events, 1) {
+		assert.Equal(suite.T(), data, events[0].Data())
+	}
+
+	assert.Nil(suite.T(), store.ShredAggregate(ctx, id))
+	events, err = store.Load(ctx, id)
+	assert.Nil(suite.T(), err)
+	if assert.Len(suite.T(), events, 1) {
+		assert.Nil(suite.T(), events[0].Data())
+		assert.Equal(suite.T(), 1, events[0].Version())
+	}
+
+	// A shredded aggregate doesn't get a new key, its events stay loadable.
+	err = store.Save(ctx, []eh.Event{
+		eh.NewEventForAggregate(mocks.EventType, data, timestamp, mocks.AggregateType, id, 2),
+	}, 1)
+	assert.True(suite.T(), errors.Is(err, ErrAggregateShredded))
+	events, err = store.Load(ctx, id)
+	assert.Nil(suite.T(), err)
+	assert.Len(suite.T(), events, 1)
+
+	// An aggregate that is shredded before its first save gets no key.
+	id2 := uuid.New()
+	assert.Nil(suite.T(), store.ShredAggregate(ctx, id2))
+	err = store.Save(ctx, []eh.Event{
+		eh.NewEventForAggregate(mocks.EventType, data, timestamp, mocks.AggregateType, id2, 1),
+	}, 0)
+	assert.True(suite.T(), errors.Is(err, ErrAggregateShredded))
+}
@@ -0,0 +1,245 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/google/uuid"
+	"github.com/guregu/dynamo"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// ErrCouldNotSaveSnapshot is when a snapshot could not be saved.
+var ErrCouldNotSaveSnapshot = errors.New("could not save snapshot")
+
+// ErrCouldNotLoadSnapshot is when a snapshot could not be loaded.
+var ErrCouldNotLoadSnapshot = errors.New("could not load snapshot")
+
+// Snapshot is a snapshot of the state of an aggregate at a version. It has
+// the same fields as the eventhorizon.Snapshot of newer eventhorizon versions.
+type Snapshot struct {
+	Version       int
+	AggregateType eh.AggregateType
+	Timestamp     time.Time
+	State         interface{}
+}
+
+// SnapshotStore implements a snapshot store for DynamoDB, with the same
+// methods as the eventhorizon.SnapshotStore interface of newer eventhorizon
+// versions.
+type SnapshotStore struct {
+	service      *dynamo.DB
+	awsConfig    *aws.Config
+	tableName    func(context.Context) string
+	stateFactory func(eh.AggregateType) interface{}
+
+	namespaceProvider NamespaceProvider
+	tableWaiter       *TableWaiter
+	environment       *environment
+}
+
+// OptionSnapshotStore is an option setter used to configure creation.
+type OptionSnapshotStore func(*SnapshotStore) error
+
+// WithSnapshotDynamoDB uses a custom AWS session. Without it a session is
+// created from the standard AWS config chain and the config options.
+func WithSnapshotDynamoDB(sess *session.Session) OptionSnapshotStore {
+	return func(s *SnapshotStore) error {
+		s.service = dynamo.New(sess)
+		return nil
+	}
+}
+
+// WithSnapshotTableName uses a custom table name function.
+func WithSnapshotTableName(tableName func(context.Context) string) OptionSnapshotStore {
+	return func(s *SnapshotStore) error {
+		s.tableName = tableName
+		return nil
+	}
+}
+
+// WithSnapshotStateFactory sets a factory function that creates concrete
+// state types to decode snapshots into. Without it the state is decoded into
+// a map[string]interface{}.
+func WithSnapshotStateFactory(f func(eh.AggregateType) interface{}) OptionSnapshotStore {
+	return func(s *SnapshotStore) error {
+		s.stateFactory = f
+		return nil
+	}
+}
+
+// NewSnapshotStore creates a new SnapshotStore.
+func NewSnapshotStore(tablePrefix string, options ...OptionSnapshotStore) (*SnapshotStore, error) {
+	s := &SnapshotStore{
+		awsConfig: aws.NewConfig(),
+	}
+
+	s.tableName = func(ctx context.Context) string {
+		ns := eh.NamespaceFromContext(ctx)
+		return tablePrefix + "_" + ns
+	}
+
+	for _, option := range options {
+		if err := option(s); err != nil {
+			return nil, fmt.Errorf("error while applying option: %v", err)
+		}
+	}
+	if err := s.applyEnvironment(); err != nil {
+		return nil, err
+	}
+
+	if s.service == nil {
+		var err error
+		if s.service, err = newService(s.awsConfig); err != nil {
+			return nil, ErrCouldNotDialDB
+		}
+	}
+
+	return s, nil
+}
+
+// LoadSnapshot loads the latest snapshot of an aggregate. It returns nil if
+// there is no snapshot.
+func (s *SnapshotStore) LoadSnapshot(ctx context.Context, id uuid.UUID) (*Snapshot, error) {
+	ctx, err := s.namespace(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	table := s.service.Table(s.tableName(ctx))
+
+	var dbSnapshot dbSnapshot
+	err = table.Get("AggregateID", id.String()).
+		Order(dynamo.Descending).
+		Limit(1).
+		Consistent(true).
+		OneWithContext(ctx, &dbSnapshot)
+	if err == dynamo.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, eh.EventStoreError{
+			BaseErr:   withRequestID(err),
+			Err:       wrapError(ErrCouldNotLoadSnapshot, err),
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	var state interface{} = &map[string]interface{}{}
+	if s.stateFactory != nil {
+		state = s.stateFactory(dbSnapshot.AggregateType)
+	}
+	if err := dynamodbattribute.UnmarshalMap(dbSnapshot.RawState, state); err != nil {
+		return nil, eh.EventStoreError{
+			BaseErr:   err,
+			Err:       wrapError(ErrCouldNotLoadSnapshot, err),
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+	if m, ok := state.(*map[string]interface{}); ok {
+		state = *m
+	}
+
+	return &Snapshot{
+		Version:       dbSnapshot.Version,
+		AggregateType: dbSnapshot.AggregateType,
+		Timestamp:     dbSnapshot.Timestamp,
+		State:         state,
+	}, nil
+}
+
+// SaveSnapshot saves a snapshot of an aggregate.
+func (s *SnapshotStore) SaveSnapshot(ctx context.Context, id uuid.UUID, snapshot Snapshot) error {
+	ctx, err := s.namespace(ctx)
+	if err != nil {
+		return err
+	}
+
+	table := s.service.Table(s.tableName(ctx))
+
+	rawState, err := dynamodbattribute.MarshalMap(snapshot.State)
+	if err != nil {
+		return eh.EventStoreError{
+			BaseErr:   err,
+			Err:       wrapError(ErrCouldNotSaveSnapshot, err),
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	dbSnapshot := dbSnapshot{
+		AggregateID:   id,
+		Version:       snapshot.Version,
+		AggregateType: snapshot.AggregateType,
+		Timestamp:     snapshot.Timestamp,
+		RawState:      rawState,
+	}
+	if err := table.Put(dbSnapshot).RunWithContext(ctx); err != nil {
+		return eh.EventStoreError{
+			BaseErr:   withRequestID(err),
+			Err:       wrapError(ErrCouldNotSaveSnapshot, err),
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	return nil
+}
+
+// CreateTable creates the table if it is not already existing. It is safe to
+// call concurrently, a table that is already being created by someone else is
+// waited for until it is active.
+func (s *SnapshotStore) CreateTable(ctx context.Context) error {
+	ctx, err := s.namespace(ctx)
+	if err != nil {
+		return err
+	}
+
+	ctx = withTableWaiter(ctx, s.tableWaiter)
+	tableName := s.tableName(ctx)
+	return createTable(ctx, s.service.Client(), tableName, s.service.CreateTable(tableName, dbSnapshot{}), nil)
+}
+
+// DeleteTable deletes the snapshot table.
+func (s *SnapshotStore) DeleteTable(ctx context.Context) error {
+	ctx, err := s.namespace(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := s.service.Table(s.tableName(ctx)).DeleteTable().RunWithContext(ctx); err != nil {
+		if isAWSErrorCode(err, dynamodb.ErrCodeResourceNotFoundException) {
+			return nil
+		}
+		return ErrCouldNotClearDB
+	}
+
+	return waitForTableDeleted(withTableWaiter(ctx, s.tableWaiter), s.service.Client(), s.tableName(ctx))
+}
+
+// dbSnapshot is the internal snapshot record for the DynamoDB snapshot store.
+type dbSnapshot struct {
+	AggregateID uuid.UUID `dynamo:",hash"`
+	Version     int       `dynamo:",range"`
+
+	AggregateType eh.AggregateType
+	Timestamp     time.Time
+	RawState      map[string]*dynamodb.AttributeValue
+}
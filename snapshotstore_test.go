@@ -0,0 +1,130 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type SnapshotStoreTestSuite struct {
+	suite.Suite
+	store *SnapshotStore
+}
+
+// SetupTest will create the store and dynamo table
+func (suite *SnapshotStoreTestSuite) SetupTest() {
+	awsConfig := &aws.Config{
+		Region:   aws.String("us-west-2"),
+		Endpoint: aws.String("http://localhost:8000"),
+	}
+
+	awsSession, err := session.NewSession(awsConfig)
+	assert.Nil(suite.T(), err, "there should be no error")
+
+	suite.store, err = NewSnapshotStore(
+		"testSnapshots",
+		WithSnapshotDynamoDB(awsSession),
+		WithSnapshotStateFactory(func(eh.AggregateType) interface{} {
+			return &mocks.Model{}
+		}),
+	)
+	assert.Nil(suite.T(), err, "there should be no error")
+
+	assert.Nil(suite.T(), suite.store.CreateTable(context.Background()), "could not create table")
+}
+
+// TearDownTest will delete the dynamo table
+func (suite *SnapshotStoreTestSuite) TearDownTest() {
+	assert.Nil(suite.T(), suite.store.DeleteTable(context.Background()), "could not delete table")
+}
+
+// TestSaveAndLoadSnapshot will save snapshots and load the latest one
+func (suite *SnapshotStoreTestSuite) TestSaveAndLoadSnapshot() {
+	id := uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+
+	snapshot, err := suite.store.LoadSnapshot(context.Background(), id)
+	assert.Nil(suite.T(), err)
+	assert.Nil(suite.T(), snapshot)
+
+	for _, version := range []int{1, 3, 2} {
+		err := suite.store.SaveSnapshot(context.Background(), id, Snapshot{
+			Version:       version,
+			AggregateType: mocks.AggregateType,
+			Timestamp:     timestamp,
+			State:         &mocks.Model{ID: id, Content: "state"},
+		})
+		assert.Nil(suite.T(), err)
+	}
+
+	snapshot, err = suite.store.LoadSnapshot(context.Background(), id)
+	assert.Nil(suite.T(), err)
+	if assert.NotNil(suite.T(), snapshot) {
+		assert.Equal(suite.T(), 3, snapshot.Version)
+		assert.Equal(suite.T(), mocks.AggregateType, snapshot.AggregateType)
+		assert.True(suite.T(), timestamp.Equal(snapshot.Timestamp))
+		assert.Equal(suite.T(), "state", snapshot.State.(*mocks.Model).Content)
+	}
+}
+
+// TestLoadWithFallback will load the latest snapshot when the event table is unreachable
+func (suite *SnapshotStoreTestSuite) TestLoadWithFallback() {
+	awsSession, err := session.NewSession(&aws.Config{
+		Region:     aws.String("us-west-2"),
+		Endpoint:   aws.String("http://localhost:1"),
+		MaxRetries: aws.Int(0),
+	})
+	assert.Nil(suite.T(), err)
+	store, err := NewEventStore("test", WithDynamoDB(awsSession), WithSnapshotFallback(suite.store))
+	assert.Nil(suite.T(), err)
+
+	// Without a snapshot the error is returned.
+	id := uuid.New()
+	_, err = store.LoadWithFallback(context.Background(), id)
+	assert.NotNil(suite.T(), err)
+
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	assert.Nil(suite.T(), suite.store.SaveSnapshot(context.Background(), id, Snapshot{
+		Version:       2,
+		AggregateType: mocks.AggregateType,
+		Timestamp:     timestamp,
+		State:         &mocks.Model{ID: id, Content: "state"},
+	}))
+
+	result, err := store.LoadWithFallback(context.Background(), id)
+	assert.Nil(suite.T(), err)
+	if assert.NotNil(suite.T(), result) {
+		assert.True(suite.T(), result.Stale)
+		assert.Nil(suite.T(), result.Events)
+		if assert.NotNil(suite.T(), result.Snapshot) {
+			assert.Equal(suite.T(), 2, result.Snapshot.Version)
+		}
+	}
+}
+
+// TestSnapshotStoreTestSuite starts the test suite
+func TestSnapshotStoreTestSuite(t *testing.T) {
+	suite.Run(t, new(SnapshotStoreTestSuite))
+}
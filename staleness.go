@@ -0,0 +1,106 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// StalenessMetric is the staleness of a read model table.
+type StalenessMetric struct {
+	// Table is the name of the read model table.
+	Table string
+	// LastApplied is the timestamp of the newest event applied to the table.
+	LastApplied time.Time
+	// Staleness is the time since the newest applied event.
+	Staleness time.Duration
+}
+
+// staleness tracks the timestamp of the newest event applied per table.
+type staleness struct {
+	mu          sync.RWMutex
+	lastApplied map[string]time.Time
+}
+
+// WithRepoStalenessTracking tracks the timestamp of the newest event applied
+// by SaveForEvent per table, which is exported by Staleness as a measure of
+// projection lag. The tracking is in memory and starts over on restart.
+func WithRepoStalenessTracking() OptionRepo {
+	return func(r *Repo) error {
+		r.staleness = &staleness{lastApplied: map[string]time.Time{}}
+		return nil
+	}
+}
+
+// Staleness returns the staleness of every read model table that has had an
+// event applied by SaveForEvent, computed at the time of the call. It is
+// meant to be polled by a gauge, for example when metrics are scraped. It
+// needs the WithRepoStalenessTracking option.
+func (r *Repo) Staleness() []StalenessMetric {
+	if r.staleness == nil {
+		return nil
+	}
+
+	r.staleness.mu.RLock()
+	defer r.staleness.mu.RUnlock()
+
+	now := time.Now()
+	metrics := make([]StalenessMetric, 0, len(r.staleness.lastApplied))
+	for table, lastApplied := range r.staleness.lastApplied {
+		metrics = append(metrics, StalenessMetric{
+			Table:       table,
+			LastApplied: lastApplied,
+			Staleness:   now.Sub(lastApplied),
+		})
+	}
+	return metrics
+}
+
+// TableStaleness returns the time since the newest event applied to the
+// table of the context, and false if no event has been applied yet.
+func (r *Repo) TableStaleness(ctx context.Context) (time.Duration, bool) {
+	if r.staleness == nil {
+		return 0, false
+	}
+	ctx, err := r.namespace(ctx)
+	if err != nil {
+		return 0, false
+	}
+
+	r.staleness.mu.RLock()
+	defer r.staleness.mu.RUnlock()
+
+	lastApplied, ok := r.staleness.lastApplied[r.tableName(ctx)]
+	if !ok {
+		return 0, false
+	}
+	return time.Since(lastApplied), true
+}
+
+// applied records an applied event timestamp, keeping the newest.
+func (s *staleness) applied(table string, timestamp time.Time) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if timestamp.After(s.lastApplied[table]) {
+		s.lastApplied[table] = timestamp
+	}
+}
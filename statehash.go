@@ -0,0 +1,64 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+type stateHashKey int
+
+// stateHashCtxKey is the context key of the state hash.
+const stateHashCtxKey stateHashKey = iota
+
+// stateHash is the expected and next state hash of an aggregate.
+type stateHash struct {
+	expected string
+	next     string
+}
+
+// NewContextWithStateHash returns a context for Save that makes the save
+// conditional on the state hash stored for the aggregate, in addition to the
+// version check. The stored hash must equal expected, where an empty expected
+// hash means that no hash may be stored yet, and is replaced by next. This
+// guards against split-brain writers that somehow share a version number.
+func NewContextWithStateHash(ctx context.Context, expected, next string) context.Context {
+	return context.WithValue(ctx, stateHashCtxKey, stateHash{
+		expected: expected,
+		next:     next,
+	})
+}
+
+// stateHashFromContext returns the state hash of the context, if any.
+func stateHashFromContext(ctx context.Context) (stateHash, bool) {
+	hash, ok := ctx.Value(stateHashCtxKey).(stateHash)
+	return hash, ok
+}
+
+// apply adds the state hash condition and update to the update of the head
+// item of the aggregate.
+func (h stateHash) apply(head *dynamodb.Update) {
+	condition := "attribute_not_exists(StateHash)"
+	if h.expected != "" {
+		condition = "StateHash = :expectedHash"
+		head.ExpressionAttributeValues[":expectedHash"] = &dynamodb.AttributeValue{S: aws.String(h.expected)}
+	}
+	head.ConditionExpression = aws.String("(" + aws.StringValue(head.ConditionExpression) + ") AND " + condition)
+	head.UpdateExpression = aws.String(aws.StringValue(head.UpdateExpression) + ", StateHash = :nextHash")
+	head.ExpressionAttributeValues[":nextHash"] = &dynamodb.AttributeValue{S: aws.String(h.next)}
+}
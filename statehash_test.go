@@ -0,0 +1,52 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSaveWithStateHash will make sure that saves with a stale state hash are rejected
+func (suite *EventStoreTestSuite) TestSaveWithStateHash() {
+	id := uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	newEvent := func(version int) []eh.Event {
+		return []eh.Event{eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event"},
+			timestamp, mocks.AggregateType, id, version)}
+	}
+
+	ctx := NewContextWithStateHash(context.Background(), "", "hash1")
+	assert.Nil(suite.T(), suite.store.Save(ctx, newEvent(1), 0))
+
+	ctx = NewContextWithStateHash(context.Background(), "hash1", "hash2")
+	assert.Nil(suite.T(), suite.store.Save(ctx, newEvent(2), 1))
+
+	// A writer with the right version but a stale state must be rejected.
+	ctx = NewContextWithStateHash(context.Background(), "hash1", "hash3")
+	err := suite.store.Save(ctx, newEvent(3), 2)
+	if esErr, ok := err.(eh.EventStoreError); !ok || !errors.Is(esErr.Err, ErrCouldNotSaveAggregate) {
+		suite.T().Fatal("there should be a conflict error:", err)
+	}
+
+	ctx = NewContextWithStateHash(context.Background(), "hash2", "hash3")
+	assert.Nil(suite.T(), suite.store.Save(ctx, newEvent(3), 2))
+}
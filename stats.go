@@ -0,0 +1,147 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// Stats are statistics of the events of a namespace, for dashboards and
+// capacity planning.
+type Stats struct {
+	// Events is the number of events, and EventsByType the number of events
+	// per event type.
+	Events       int64
+	EventsByType map[eh.EventType]int64
+	// Aggregates is the number of aggregates with a version counter item,
+	// which excludes streams that were saved before the counter existed.
+	Aggregates int64
+	// Oldest and Newest are the timestamps of the oldest and newest events,
+	// zero if there are none.
+	Oldest time.Time
+	Newest time.Time
+
+	// ItemCount and TableSizeBytes are of the event tables as reported by
+	// DescribeTable, which DynamoDB updates about every six hours. With a
+	// shared table they include the items of all namespaces.
+	ItemCount      int64
+	TableSizeBytes int64
+}
+
+// statsItem is the projection of an item that is counted by Stats.
+type statsItem struct {
+	Version   int
+	EventType string
+	Timestamp time.Time
+	Position  int64
+}
+
+// Stats returns statistics of the events of the namespace of the context. It
+// scans all event tables, which reads every item, so it should be called
+// sparingly; WithScanRateLimit limits the capacity that it uses.
+func (s *EventStore) Stats(ctx context.Context) (*Stats, error) {
+	ctx, err := s.namespace(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tables, err := s.eventTables(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &Stats{EventsByType: map[eh.EventType]int64{}}
+	for _, tableName := range tables {
+		if err := s.describeStats(ctx, tableName, stats); err != nil {
+			return nil, err
+		}
+		if err := s.scanStats(ctx, tableName, stats); err != nil {
+			return nil, err
+		}
+	}
+
+	return stats, nil
+}
+
+// describeStats adds the item count and size of a table to the stats.
+func (s *EventStore) describeStats(ctx context.Context, tableName string, stats *Stats) error {
+	start := time.Now()
+	out, err := s.service.Client().DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(tableName),
+	})
+	observe(ctx, s.metrics, OperationDescribeTable, tableName, start, err)
+	if err != nil {
+		return eh.EventStoreError{
+			BaseErr:   withRequestID(err),
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	stats.ItemCount += aws.Int64Value(out.Table.ItemCount)
+	stats.TableSizeBytes += aws.Int64Value(out.Table.TableSizeBytes)
+	return nil
+}
+
+// scanStats adds the events and aggregates of a table to the stats.
+func (s *EventStore) scanStats(ctx context.Context, tableName string, stats *Stats) error {
+	table := s.readService().Table(tableName)
+
+	start := time.Now()
+	throttle := s.newScanThrottle()
+	scan := table.Scan().Project("Version", "EventType", "Timestamp", "Position").Consistent(consistentRead(ctx, s.readConsistency))
+	iter := throttle.scan(s.scanNamespace(ctx, scan)).Iter()
+	var item statsItem
+	var waitErr error
+	for waitErr == nil && iter.NextWithContext(ctx, &item) {
+		if item.Version == aggregateHeadVersion {
+			// The global position counter is not an aggregate.
+			if item.Position == 0 {
+				stats.Aggregates++
+			}
+		} else if item.Version > 0 {
+			// Continuation items of chunked event data are not events.
+			stats.Events++
+			stats.EventsByType[s.typeNames.EventType(item.EventType)]++
+			if stats.Oldest.IsZero() || item.Timestamp.Before(stats.Oldest) {
+				stats.Oldest = item.Timestamp
+			}
+			if item.Timestamp.After(stats.Newest) {
+				stats.Newest = item.Timestamp
+			}
+		}
+		item = statsItem{}
+		waitErr = throttle.wait(ctx)
+	}
+	err := iter.Err()
+	if waitErr != nil {
+		err = waitErr
+	}
+	observe(ctx, s.metrics, OperationScan, tableName, start, err)
+	if err != nil {
+		return eh.EventStoreError{
+			BaseErr:   withRequestID(err),
+			Err:       err,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	return nil
+}
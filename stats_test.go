@@ -0,0 +1,49 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestStats will count the events and aggregates of a namespace
+func (suite *EventStoreTestSuite) TestStats() {
+	ctx := eh.NewContextWithNamespace(context.Background(), "stats")
+	assert.Nil(suite.T(), suite.store.CreateTable(ctx))
+	defer suite.store.DeleteTable(ctx)
+
+	id1, id2 := uuid.New(), uuid.New()
+	timestamp1 := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	timestamp2 := timestamp1.Add(time.Hour)
+	event1 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event1"}, timestamp1, mocks.AggregateType, id1, 1)
+	event2 := eh.NewEventForAggregate(mocks.EventOtherType, &mocks.EventData{Content: "event2"}, timestamp2, mocks.AggregateType, id1, 2)
+	event3 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event3"}, timestamp1, mocks.AggregateType, id2, 1)
+	assert.Nil(suite.T(), suite.store.Save(ctx, []eh.Event{event1, event2}, 0))
+	assert.Nil(suite.T(), suite.store.Save(ctx, []eh.Event{event3}, 0))
+
+	stats, err := suite.store.Stats(ctx)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), int64(3), stats.Events)
+	assert.Equal(suite.T(), map[eh.EventType]int64{mocks.EventType: 2, mocks.EventOtherType: 1}, stats.EventsByType)
+	assert.Equal(suite.T(), int64(2), stats.Aggregates)
+	assert.True(suite.T(), timestamp1.Equal(stats.Oldest))
+	assert.True(suite.T(), timestamp2.Equal(stats.Newest))
+}
@@ -0,0 +1,591 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams/dynamodbstreamsiface"
+	"github.com/guregu/dynamo"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// ErrStreamNotSupported is when the event table can not be streamed, either
+// because of its stream view type or because it is partitioned.
+var ErrStreamNotSupported = errors.New("event table stream not supported")
+
+// ErrStreamTrimmed is when records of a shard were trimmed from the stream
+// before they were read, and their events were not published.
+var ErrStreamTrimmed = errors.New("stream records trimmed before they were read")
+
+// ErrStreamStarted is when the stream of an event table is already polled
+// by the bus.
+var ErrStreamStarted = errors.New("event table stream already started")
+
+// defaultStreamPollInterval is the default interval between polls of the
+// stream shards.
+const defaultStreamPollInterval = time.Second
+
+// StreamEventBus is an event bus that publishes the events saved in the
+// event store from the DynamoDB stream of the event table, instead of from
+// Save. Events are published at least once, also when the process that
+// saved them crashes before publishing, and an event is published again to
+// all handlers if any of them fails. Events of an aggregate are published
+// in order.
+//
+// The bus can poll the stream itself with Start, or be fed the records of a
+// Lambda trigger with HandleRecords. Monthly partitions are not supported.
+// When the records after a checkpoint have been trimmed from the stream,
+// which keeps them for 24 hours, the shard is read from its oldest record
+// and the gap is sent as ErrStreamTrimmed on the error channel.
+type StreamEventBus struct {
+	store        *EventStore
+	client       dynamodbstreamsiface.DynamoDBStreamsAPI
+	pollInterval time.Duration
+	filter       *EventFilter
+
+	handlers   []streamHandler
+	handlersMu sync.RWMutex
+	errCh      chan eh.EventBusError
+
+	// shards are the read positions per stream, each map only used by the
+	// poller of its stream.
+	shards   map[string]map[string]*streamShard
+	shardsMu sync.Mutex
+	done     chan struct{}
+	wg       sync.WaitGroup
+
+	// leases are the shard checkpoints of a stream dispatcher.
+	leases *streamLeases
+}
+
+// streamHandler is a handler registered with a matcher.
+type streamHandler struct {
+	matcher eh.EventMatcher
+	handler eh.EventHandler
+}
+
+// streamShard is the read position in a shard of the stream.
+type streamShard struct {
+	parentID string
+	iterator *string
+	// sequence is the record to read from again after a failure, or the
+	// checkpoint to read after when after is set.
+	sequence string
+	after    bool
+	finished bool
+	// owned is true while the lease of the shard is held.
+	owned bool
+}
+
+// StreamEventBusOption is an option setter used to configure a stream event
+// bus.
+type StreamEventBusOption func(*StreamEventBus) error
+
+// WithStreamPollInterval sets the interval between polls of the stream.
+func WithStreamPollInterval(interval time.Duration) StreamEventBusOption {
+	return func(b *StreamEventBus) error {
+		if interval <= 0 {
+			return fmt.Errorf("invalid stream poll interval %v", interval)
+		}
+		b.pollInterval = interval
+		return nil
+	}
+}
+
+// WithStreamClient uses a custom DynamoDB Streams client. Without it a client
+// is created from the session of the event store.
+func WithStreamClient(client dynamodbstreamsiface.DynamoDBStreamsAPI) StreamEventBusOption {
+	return func(b *StreamEventBus) error {
+		b.client = client
+		return nil
+	}
+}
+
+// WithStreamFilter skips the records of events that don't match a filter
+// before they are decoded, so that the event data of skipped events is not
+// fetched, decrypted or upcasted. The skipped events are not published to any
+// handler.
+func WithStreamFilter(f EventFilter) StreamEventBusOption {
+	return func(b *StreamEventBus) error {
+		b.filter = &f
+		return nil
+	}
+}
+
+// NewStreamEventBus creates an event bus for the events of a store.
+func NewStreamEventBus(store *EventStore, options ...StreamEventBusOption) (*StreamEventBus, error) {
+	if store.sharedTable {
+		return nil, ErrSharedTable
+	}
+
+	b := &StreamEventBus{
+		store:        store,
+		pollInterval: defaultStreamPollInterval,
+		errCh:        make(chan eh.EventBusError, 100),
+		shards:       map[string]map[string]*streamShard{},
+		done:         make(chan struct{}),
+	}
+
+	for _, option := range options {
+		if err := option(b); err != nil {
+			return nil, err
+		}
+	}
+
+	if b.client == nil {
+		b.client = dynamodbstreams.New(store.session)
+	}
+	store.onClose(func(context.Context) error {
+		return b.Close()
+	})
+
+	return b, nil
+}
+
+// HandlerType implements the HandlerType method of the eventhorizon.EventHandler interface.
+func (b *StreamEventBus) HandlerType() eh.EventHandlerType {
+	return "dynamodb-stream-eventbus"
+}
+
+// HandleEvent implements the HandleEvent method of the eventhorizon.EventHandler
+// interface. It does nothing, the saved events are published from the stream.
+func (b *StreamEventBus) HandleEvent(ctx context.Context, event eh.Event) error {
+	return nil
+}
+
+// AddHandler implements the AddHandler method of the eventhorizon.EventBus interface.
+func (b *StreamEventBus) AddHandler(ctx context.Context, m eh.EventMatcher, h eh.EventHandler) error {
+	if m == nil {
+		return eh.ErrMissingMatcher
+	}
+	if h == nil {
+		return eh.ErrMissingHandler
+	}
+
+	b.handlersMu.Lock()
+	defer b.handlersMu.Unlock()
+
+	for _, sh := range b.handlers {
+		if sh.handler.HandlerType() == h.HandlerType() {
+			return eh.ErrHandlerAlreadyAdded
+		}
+	}
+	b.handlers = append(b.handlers, streamHandler{matcher: m, handler: h})
+	return nil
+}
+
+// Errors implements the Errors method of the eventhorizon.EventBus interface.
+func (b *StreamEventBus) Errors() <-chan eh.EventBusError {
+	return b.errCh
+}
+
+// Start enables the stream on the event table of the namespace of the
+// context if needed and polls it in the background until Close, starting
+// with the events saved after the start. Start can be called for several
+// namespaces with their own tables, but only once per table.
+func (b *StreamEventBus) Start(ctx context.Context) error {
+	ctx, err := b.store.namespace(ctx)
+	if err != nil {
+		return err
+	}
+
+	if b.store.partitions != nil {
+		return eh.EventStoreError{
+			Err:       ErrStreamNotSupported,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	streamArn, err := b.streamArn(ctx)
+	if err != nil {
+		return err
+	}
+
+	b.shardsMu.Lock()
+	if _, ok := b.shards[streamArn]; ok {
+		b.shardsMu.Unlock()
+		return eh.EventStoreError{
+			Err:       ErrStreamStarted,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+	shards := map[string]*streamShard{}
+	b.shards[streamArn] = shards
+	b.shardsMu.Unlock()
+
+	// Leased shards are read from their checkpoints when polled.
+	if b.leases == nil {
+		if err := b.startLatest(ctx, streamArn, shards); err != nil {
+			b.shardsMu.Lock()
+			delete(b.shards, streamArn)
+			b.shardsMu.Unlock()
+			return err
+		}
+	}
+
+	ctx = NewContextWithExplicitNamespace(context.Background(), eh.NamespaceFromContext(ctx))
+	b.wg.Add(1)
+	go b.run(ctx, streamArn, shards)
+	return nil
+}
+
+// startLatest starts after the latest record of the open shards.
+func (b *StreamEventBus) startLatest(ctx context.Context, streamArn string, shards map[string]*streamShard) error {
+	described, err := b.describeShards(ctx, streamArn)
+	if err != nil {
+		return err
+	}
+	for _, shard := range described {
+		if shard.SequenceNumberRange != nil && shard.SequenceNumberRange.EndingSequenceNumber != nil {
+			shards[aws.StringValue(shard.ShardId)] = &streamShard{finished: true}
+			continue
+		}
+		out, err := b.client.GetShardIteratorWithContext(ctx, &dynamodbstreams.GetShardIteratorInput{
+			StreamArn:         aws.String(streamArn),
+			ShardId:           shard.ShardId,
+			ShardIteratorType: aws.String(dynamodbstreams.ShardIteratorTypeLatest),
+		})
+		if err != nil {
+			return b.storeError(ctx, err)
+		}
+		shards[aws.StringValue(shard.ShardId)] = &streamShard{
+			parentID: aws.StringValue(shard.ParentShardId),
+			iterator: out.ShardIterator,
+		}
+	}
+	return nil
+}
+
+// HandleRecords publishes the events of stream records, for example from a
+// Lambda trigger on the event table. Records that are not saved events are
+// skipped. It returns the first error from a handler, after which the
+// remaining records are not published.
+func (b *StreamEventBus) HandleRecords(ctx context.Context, records []*dynamodbstreams.Record) error {
+	for _, record := range records {
+		if err := b.handleRecord(ctx, record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Wait waits for the background polling to stop after Close.
+func (b *StreamEventBus) Wait() {
+	b.wg.Wait()
+}
+
+// Close stops polling the stream and waits for the current poll to finish.
+// Held shard leases are released.
+func (b *StreamEventBus) Close() error {
+	select {
+	case <-b.done:
+	default:
+		close(b.done)
+	}
+	b.wg.Wait()
+
+	if b.leases == nil {
+		return nil
+	}
+	b.shardsMu.Lock()
+	defer b.shardsMu.Unlock()
+	var firstErr error
+	for streamArn, shards := range b.shards {
+		if err := b.leases.releaseAll(context.Background(), streamArn, shards); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// run polls the stream until the bus is closed.
+func (b *StreamEventBus) run(ctx context.Context, streamArn string, shards map[string]*streamShard) {
+	defer b.wg.Done()
+
+	ticker := time.NewTicker(b.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+			if err := b.poll(ctx, streamArn, shards); err != nil {
+				b.sendError(ctx, err)
+			}
+		}
+	}
+}
+
+// poll reads and publishes the new records of all shards, with parent
+// shards before their children.
+func (b *StreamEventBus) poll(ctx context.Context, streamArn string, shards map[string]*streamShard) error {
+	described, err := b.describeShards(ctx, streamArn)
+	if err != nil {
+		return err
+	}
+
+	for _, shard := range described {
+		id := aws.StringValue(shard.ShardId)
+		s, ok := shards[id]
+		if !ok {
+			// New shards are read from their start.
+			s = &streamShard{parentID: aws.StringValue(shard.ParentShardId)}
+			shards[id] = s
+		}
+		if s.finished {
+			continue
+		}
+		if b.leases != nil {
+			owned, err := b.leases.acquire(ctx, streamArn, id, s)
+			if err != nil {
+				return err
+			} else if !owned {
+				continue
+			}
+		}
+		if parent, ok := shards[s.parentID]; ok && !parent.finished {
+			continue
+		}
+
+		if err := b.pollShard(ctx, streamArn, id, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pollShard reads and publishes the new records of a shard.
+func (b *StreamEventBus) pollShard(ctx context.Context, streamArn, id string, s *streamShard) error {
+	for {
+		if s.iterator == nil {
+			input := &dynamodbstreams.GetShardIteratorInput{
+				StreamArn:         aws.String(streamArn),
+				ShardId:           aws.String(id),
+				ShardIteratorType: aws.String(dynamodbstreams.ShardIteratorTypeTrimHorizon),
+			}
+			if s.sequence != "" {
+				input.ShardIteratorType = aws.String(dynamodbstreams.ShardIteratorTypeAtSequenceNumber)
+				if s.after {
+					input.ShardIteratorType = aws.String(dynamodbstreams.ShardIteratorTypeAfterSequenceNumber)
+				}
+				input.SequenceNumber = aws.String(s.sequence)
+			}
+			out, err := b.client.GetShardIteratorWithContext(ctx, input)
+			if s.sequence != "" && isAWSErrorCode(err, dynamodbstreams.ErrCodeTrimmedDataAccessException) {
+				b.sendError(ctx, eh.EventStoreError{
+					BaseErr:   withRequestID(err),
+					Err:       fmt.Errorf("%w: shard %s from %s", ErrStreamTrimmed, id, s.sequence),
+					Namespace: eh.NamespaceFromContext(ctx),
+				})
+				input.ShardIteratorType = aws.String(dynamodbstreams.ShardIteratorTypeTrimHorizon)
+				input.SequenceNumber = nil
+				out, err = b.client.GetShardIteratorWithContext(ctx, input)
+			}
+			if err != nil {
+				return b.storeError(ctx, err)
+			}
+			s.iterator = out.ShardIterator
+			s.sequence = ""
+			s.after = false
+		}
+
+		out, err := b.client.GetRecordsWithContext(ctx, &dynamodbstreams.GetRecordsInput{
+			ShardIterator: s.iterator,
+		})
+		if isAWSErrorCode(err, dynamodbstreams.ErrCodeExpiredIteratorException) {
+			s.iterator = nil
+			continue
+		} else if err != nil {
+			return b.storeError(ctx, err)
+		}
+
+		for _, record := range out.Records {
+			if err := b.handleRecord(ctx, record); err != nil {
+				// Read from the failed record again on the next poll.
+				s.iterator = nil
+				s.sequence = aws.StringValue(record.Dynamodb.SequenceNumber)
+				b.sendError(ctx, err)
+				return nil
+			}
+		}
+
+		s.iterator = out.NextShardIterator
+		if b.leases != nil && (len(out.Records) > 0 || s.iterator == nil) {
+			var sequence string
+			if n := len(out.Records); n > 0 && out.Records[n-1].Dynamodb != nil {
+				sequence = aws.StringValue(out.Records[n-1].Dynamodb.SequenceNumber)
+			}
+			if err := b.leases.checkpoint(ctx, streamArn, id, s, sequence, s.iterator == nil); err != nil {
+				return err
+			} else if !s.owned {
+				return nil
+			}
+		}
+		if s.iterator == nil {
+			s.finished = true
+			return nil
+		}
+		if len(out.Records) == 0 {
+			return nil
+		}
+	}
+}
+
+// EventFromImage decodes the event of an item image from the event table, as
+// in stream records. It returns nil if the item is not an event, such as the
+// head item of an aggregate.
+func (s *EventStore) EventFromImage(ctx context.Context, image map[string]*dynamodb.AttributeValue) (eh.Event, error) {
+	ctx, err := s.namespace(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var e dbEvent
+	if err := dynamo.UnmarshalItem(image, &e); err != nil {
+		return nil, eh.EventStoreError{
+			BaseErr:   err,
+			Err:       wrapError(ErrCouldNotUnmarshalEvent, err),
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+	// Skip the head and counter items.
+	if e.Version <= 0 || e.EventType == "" {
+		return nil, nil
+	}
+
+	return s.buildEvent(ctx, e)
+}
+
+// handleRecord publishes the event of a stream record to the matching
+// handlers, if the record is an inserted event.
+func (b *StreamEventBus) handleRecord(ctx context.Context, record *dynamodbstreams.Record) error {
+	if aws.StringValue(record.EventName) != dynamodbstreams.OperationTypeInsert ||
+		record.Dynamodb == nil || record.Dynamodb.NewImage == nil {
+		return nil
+	}
+
+	if b.filter != nil {
+		ok, err := b.store.MatchImage(ctx, *b.filter, record.Dynamodb.NewImage)
+		if err != nil || !ok {
+			return err
+		}
+	}
+
+	event, err := b.store.EventFromImage(ctx, record.Dynamodb.NewImage)
+	if err != nil || event == nil {
+		return err
+	}
+
+	b.handlersMu.RLock()
+	defer b.handlersMu.RUnlock()
+	for _, sh := range b.handlers {
+		if !sh.matcher.Match(event) {
+			continue
+		}
+		if err := sh.handler.HandleEvent(ctx, event); err != nil {
+			return eh.CouldNotHandleEventError{
+				Err:       err,
+				Event:     event,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+	}
+	return nil
+}
+
+// streamArn returns the stream of the event table, enabling it if needed.
+func (b *StreamEventBus) streamArn(ctx context.Context) (string, error) {
+	client := b.store.service.Client()
+	tableName := b.store.tableName(ctx)
+
+	out, err := client.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(tableName),
+	})
+	if err != nil {
+		return "", b.storeError(ctx, err)
+	}
+
+	spec := out.Table.StreamSpecification
+	if spec == nil || !aws.BoolValue(spec.StreamEnabled) {
+		ctx := withTableWaiter(ctx, b.store.tableWaiter)
+		if err := enableStream(ctx, client, tableName, dynamodb.StreamViewTypeNewImage); err != nil {
+			return "", b.storeError(ctx, err)
+		}
+		if out, err = client.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{
+			TableName: aws.String(tableName),
+		}); err != nil {
+			return "", b.storeError(ctx, err)
+		}
+	} else if viewType := aws.StringValue(spec.StreamViewType); viewType != dynamodb.StreamViewTypeNewImage &&
+		viewType != dynamodb.StreamViewTypeNewAndOldImages {
+		return "", eh.EventStoreError{
+			Err:       ErrStreamNotSupported,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	return aws.StringValue(out.Table.LatestStreamArn), nil
+}
+
+// describeShards returns all shards of the stream.
+func (b *StreamEventBus) describeShards(ctx context.Context, streamArn string) ([]*dynamodbstreams.Shard, error) {
+	var shards []*dynamodbstreams.Shard
+	input := &dynamodbstreams.DescribeStreamInput{
+		StreamArn: aws.String(streamArn),
+	}
+	for {
+		out, err := b.client.DescribeStreamWithContext(ctx, input)
+		if err != nil {
+			return nil, b.storeError(ctx, err)
+		}
+		shards = append(shards, out.StreamDescription.Shards...)
+		if out.StreamDescription.LastEvaluatedShardId == nil {
+			return shards, nil
+		}
+		input.ExclusiveStartShardId = out.StreamDescription.LastEvaluatedShardId
+	}
+}
+
+// storeError wraps an error from AWS.
+func (b *StreamEventBus) storeError(ctx context.Context, err error) error {
+	return eh.EventStoreError{
+		BaseErr:   withRequestID(err),
+		Err:       err,
+		Namespace: eh.NamespaceFromContext(ctx),
+	}
+}
+
+// sendError sends an error to the error channel, unless it is full.
+func (b *StreamEventBus) sendError(ctx context.Context, err error) {
+	var event eh.Event
+	var handleErr eh.CouldNotHandleEventError
+	if errors.As(err, &handleErr) {
+		event = handleErr.Event
+	}
+
+	select {
+	case b.errCh <- eh.EventBusError{Err: err, Ctx: ctx, Event: event}:
+	default:
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams/dynamodbstreamsiface"
+	"github.com/google/uuid"
+	"github.com/guregu/dynamo"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestStreamEventBus will publish saved events from the table stream
+func (suite *EventStoreTestSuite) TestStreamEventBus() {
+	ctx := eh.NewContextWithNamespace(context.Background(), "stream")
+	assert.Nil(suite.T(), suite.store.CreateTable(ctx))
+	defer suite.store.DeleteTable(ctx)
+
+	bus, err := NewStreamEventBus(suite.store, WithStreamPollInterval(50*time.Millisecond))
+	assert.Nil(suite.T(), err)
+	handler := &streamTestHandler{events: make(chan eh.Event, 10)}
+	assert.Nil(suite.T(), bus.AddHandler(ctx, matchAll{}, handler))
+	assert.Equal(suite.T(), eh.ErrHandlerAlreadyAdded, bus.AddHandler(ctx, matchAll{}, handler))
+	assert.Nil(suite.T(), bus.Start(ctx))
+	defer bus.Close()
+	err = bus.Start(ctx)
+	assert.True(suite.T(), errors.Is(err, ErrStreamStarted), err)
+
+	id := uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	event1 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event1"},
+		timestamp, mocks.AggregateType, id, 1)
+	event2 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event2"},
+		timestamp, mocks.AggregateType, id, 2)
+	assert.Nil(suite.T(), suite.store.Save(ctx, []eh.Event{event1, event2}, 0))
+
+	for _, expected := range []eh.Event{event1, event2} {
+		select {
+		case event := <-handler.events:
+			assert.Equal(suite.T(), expected.Version(), event.Version())
+			assert.Equal(suite.T(), expected.Data(), event.Data())
+		case <-time.After(10 * time.Second):
+			suite.T().Fatal("event not published")
+		}
+	}
+
+	// Records from a Lambda trigger are published directly.
+	event3 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event3"},
+		timestamp, mocks.AggregateType, id, 3)
+	e, err := suite.store.newDBEvent(ctx, event3)
+	assert.Nil(suite.T(), err)
+	item, err := dynamo.MarshalItem(e)
+	assert.Nil(suite.T(), err)
+	assert.Nil(suite.T(), bus.HandleRecords(ctx, []*dynamodbstreams.Record{
+		{EventName: aws.String(dynamodbstreams.OperationTypeModify), Dynamodb: &dynamodbstreams.StreamRecord{NewImage: item}},
+		{EventName: aws.String(dynamodbstreams.OperationTypeInsert), Dynamodb: &dynamodbstreams.StreamRecord{NewImage: item}},
+	}))
+	select {
+	case event := <-handler.events:
+		assert.Equal(suite.T(), event3.Data(), event.Data())
+	default:
+		suite.T().Fatal("record not published")
+	}
+}
+
+func TestStreamFilter(t *testing.T) {
+	sess := session.Must(session.NewSession(&aws.Config{Region: aws.String("us-west-2")}))
+	writer, err := NewEventStore("events", WithDynamoDB(sess))
+	if !assert.Nil(t, err) {
+		return
+	}
+	// Decoding the other event type fails, so it must be skipped before.
+	store, err := NewEventStore("events", WithDynamoDB(sess),
+		WithUpcaster(mocks.EventOtherType, func(map[string]interface{}) (map[string]interface{}, error) {
+			return nil, errors.New("decoded")
+		}))
+	if !assert.Nil(t, err) {
+		return
+	}
+	bus, err := NewStreamEventBus(store,
+		WithStreamFilter(EventFilter{ExcludedEventTypes: []eh.EventType{mocks.EventOtherType}}))
+	if !assert.Nil(t, err) {
+		return
+	}
+	handler := &streamTestHandler{events: make(chan eh.Event, 10)}
+	assert.Nil(t, bus.AddHandler(context.Background(), matchAll{}, handler))
+
+	id := uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	var records []*dynamodbstreams.Record
+	for _, event := range []eh.Event{
+		eh.NewEventForAggregate(mocks.EventOtherType, &mocks.EventData{Content: "event1"},
+			timestamp, mocks.AggregateType, id, 1),
+		eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event2"},
+			timestamp, mocks.AggregateType, id, 2),
+	} {
+		e, err := writer.newDBEvent(context.Background(), event)
+		assert.Nil(t, err)
+		item, err := dynamo.MarshalItem(e)
+		assert.Nil(t, err)
+		records = append(records, &dynamodbstreams.Record{
+			EventName: aws.String(dynamodbstreams.OperationTypeInsert),
+			Dynamodb:  &dynamodbstreams.StreamRecord{NewImage: item},
+		})
+	}
+	assert.Nil(t, bus.HandleRecords(context.Background(), records))
+
+	select {
+	case event := <-handler.events:
+		assert.Equal(t, 2, event.Version())
+	default:
+		t.Fatal("record not published")
+	}
+	assert.Empty(t, handler.events)
+}
+
+func TestStreamPollInterval(t *testing.T) {
+	sess := session.Must(session.NewSession(&aws.Config{Region: aws.String("us-west-2")}))
+	store, err := NewEventStore("events", WithDynamoDB(sess))
+	if !assert.Nil(t, err) {
+		return
+	}
+	for _, interval := range []time.Duration{0, -time.Second} {
+		_, err := NewStreamEventBus(store, WithStreamPollInterval(interval))
+		assert.NotNil(t, err, interval)
+	}
+}
+
+// trimmedStreamClient is a stream client whose records after checkpoints
+// have been trimmed.
+type trimmedStreamClient struct {
+	dynamodbstreamsiface.DynamoDBStreamsAPI
+	iteratorTypes []string
+}
+
+func (c *trimmedStreamClient) GetShardIteratorWithContext(ctx aws.Context, input *dynamodbstreams.GetShardIteratorInput, opts ...request.Option) (*dynamodbstreams.GetShardIteratorOutput, error) {
+	c.iteratorTypes = append(c.iteratorTypes, aws.StringValue(input.ShardIteratorType))
+	if input.SequenceNumber != nil {
+		return nil, awserr.New(dynamodbstreams.ErrCodeTrimmedDataAccessException, "trimmed", nil)
+	}
+	return &dynamodbstreams.GetShardIteratorOutput{ShardIterator: aws.String("iterator")}, nil
+}
+
+func (c *trimmedStreamClient) GetRecordsWithContext(ctx aws.Context, input *dynamodbstreams.GetRecordsInput, opts ...request.Option) (*dynamodbstreams.GetRecordsOutput, error) {
+	return &dynamodbstreams.GetRecordsOutput{NextShardIterator: aws.String("next")}, nil
+}
+
+func TestStreamTrimmed(t *testing.T) {
+	sess := session.Must(session.NewSession(&aws.Config{Region: aws.String("us-west-2")}))
+	store, err := NewEventStore("events", WithDynamoDB(sess))
+	if !assert.Nil(t, err) {
+		return
+	}
+	client := &trimmedStreamClient{}
+	bus, err := NewStreamEventBus(store, WithStreamClient(client))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// The shard is read from its oldest record after the trimmed checkpoint.
+	s := &streamShard{sequence: "100", after: true}
+	assert.Nil(t, bus.pollShard(context.Background(), "stream", "shard", s))
+	assert.Equal(t, []string{
+		dynamodbstreams.ShardIteratorTypeAfterSequenceNumber,
+		dynamodbstreams.ShardIteratorTypeTrimHorizon,
+	}, client.iteratorTypes)
+	assert.Equal(t, "next", aws.StringValue(s.iterator))
+
+	select {
+	case err := <-bus.Errors():
+		assert.True(t, errors.Is(err.Err, ErrStreamTrimmed), err.Err)
+	default:
+		t.Fatal("gap not reported")
+	}
+}
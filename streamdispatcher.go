@@ -0,0 +1,229 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/guregu/dynamo"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// defaultStreamLeaseDuration is the default time that a shard lease is held
+// without being renewed.
+const defaultStreamLeaseDuration = 30 * time.Second
+
+// StreamDispatcher hands the events saved in the event store to an event
+// handler from the DynamoDB stream of the event table, as a complement or
+// alternative to WithEventHandler. The position in each shard of the stream
+// is checkpointed in a lease table, so that a restarted dispatcher continues
+// where it stopped. Events are handled at least once, in order per aggregate:
+// an event that fails is handled again on the next poll, and events that were
+// handled after the last checkpoint are handled again after a restart.
+//
+// Several processes can run a dispatcher with the same lease table; each
+// shard is read by one of them at a time, and taken over by another when its
+// lease is not renewed within the lease duration. A new dispatcher starts
+// with the oldest records in the stream, which are kept for 24 hours.
+//
+// With WithStreamFilter the records of events that don't match are skipped
+// before they are decoded, and checkpointed like handled records.
+type StreamDispatcher struct {
+	bus *StreamEventBus
+}
+
+// streamLeases is the lease table of a stream dispatcher.
+type streamLeases struct {
+	store     *EventStore
+	tableName string
+	owner     string
+	duration  time.Duration
+}
+
+// dbStreamLease is the lease and checkpoint of a shard of a stream.
+type dbStreamLease struct {
+	Stream  string `dynamo:",hash"`
+	ShardID string `dynamo:",range"`
+
+	Owner string
+	// Expires is when the lease can be taken over, in Unix nanoseconds.
+	Expires int64
+	// Sequence is the last handled record in the shard.
+	Sequence string
+	Finished bool
+}
+
+// WithStreamLeaseDuration sets the time that a stream dispatcher holds the
+// lease of a shard without renewing it, after which the shard is taken over
+// by another dispatcher. Leases are renewed every poll, so the duration must
+// be longer than the poll interval. The default is 30 seconds.
+func WithStreamLeaseDuration(d time.Duration) StreamEventBusOption {
+	return func(b *StreamEventBus) error {
+		if b.leases != nil {
+			b.leases.duration = d
+		}
+		return nil
+	}
+}
+
+// NewStreamDispatcher creates a dispatcher of the events of a store to an
+// event handler, with the shard checkpoints in the lease table.
+func NewStreamDispatcher(store *EventStore, leaseTable string, h eh.EventHandler, options ...StreamEventBusOption) (*StreamDispatcher, error) {
+	if h == nil {
+		return nil, eh.ErrMissingHandler
+	}
+
+	leases := &streamLeases{
+		store:     store,
+		tableName: store.environment.tableName(leaseTable),
+		owner:     uuid.New().String(),
+		duration:  defaultStreamLeaseDuration,
+	}
+	options = append([]StreamEventBusOption{func(b *StreamEventBus) error {
+		b.leases = leases
+		return nil
+	}}, options...)
+	b, err := NewStreamEventBus(store, options...)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.AddHandler(context.Background(), eh.MatchAll{}, h); err != nil {
+		return nil, err
+	}
+
+	return &StreamDispatcher{bus: b}, nil
+}
+
+// CreateTable creates the lease table if it is not already existing.
+func (d *StreamDispatcher) CreateTable(ctx context.Context) error {
+	s := d.bus.store
+	ctx = withTableWaiter(ctx, s.tableWaiter)
+	name := d.bus.leases.tableName
+	return createTable(ctx, s.service.Client(), name,
+		s.service.CreateTable(name, dbStreamLease{}), nil)
+}
+
+// Start enables the stream on the event table of the namespace of the
+// context if needed and dispatches its events in the background until Close.
+func (d *StreamDispatcher) Start(ctx context.Context) error {
+	return d.bus.Start(ctx)
+}
+
+// Errors returns the errors of handling events and of reading the stream.
+func (d *StreamDispatcher) Errors() <-chan eh.EventBusError {
+	return d.bus.Errors()
+}
+
+// Close stops dispatching and releases the held shard leases, so that other
+// dispatchers can take over the shards right away.
+func (d *StreamDispatcher) Close() error {
+	return d.bus.Close()
+}
+
+// acquire takes or renews the lease of a shard, and returns true if it is
+// held. A shard that is taken over is read from its checkpoint. A finished
+// shard is never leased again.
+func (l *streamLeases) acquire(ctx context.Context, streamArn, id string, s *streamShard) (bool, error) {
+	table := l.store.service.Table(l.tableName)
+	now := l.store.now()
+
+	var lease dbStreamLease
+	err := table.Update("Stream", streamArn).
+		Range("ShardID", id).
+		Set("Owner", l.owner).
+		Set("Expires", now.Add(l.duration).UnixNano()).
+		If("(attribute_not_exists(Finished) OR Finished = ?) AND (attribute_not_exists('Owner') OR 'Owner' = ? OR 'Expires' < ?)",
+			false, l.owner, now.UnixNano()).
+		ValueWithContext(ctx, &lease)
+	if isConditionalCheckFailed(err) {
+		s.owned = false
+		s.iterator = nil
+		// Check if the shard was finished by another dispatcher, which
+		// lets the children of the shard be read.
+		if err := table.Get("Stream", streamArn).
+			Range("ShardID", dynamo.Equal, id).
+			Consistent(true).
+			OneWithContext(ctx, &lease); err != nil {
+			return false, l.storeError(ctx, err)
+		}
+		s.finished = lease.Finished
+		return false, nil
+	} else if err != nil {
+		return false, l.storeError(ctx, err)
+	}
+
+	if !s.owned {
+		s.owned = true
+		s.iterator = nil
+		s.sequence = lease.Sequence
+		s.after = lease.Sequence != ""
+	}
+	return true, nil
+}
+
+// checkpoint stores the last handled record of a shard, if any, and if the
+// shard is finished. The shard is no longer owned if the lease was taken over.
+func (l *streamLeases) checkpoint(ctx context.Context, streamArn, id string, s *streamShard, sequence string, finished bool) error {
+	update := l.store.service.Table(l.tableName).
+		Update("Stream", streamArn).
+		Range("ShardID", id).
+		Set("Finished", finished).
+		If("'Owner' = ?", l.owner)
+	if sequence != "" {
+		update = update.Set("Sequence", sequence)
+	}
+
+	if err := update.RunWithContext(ctx); isConditionalCheckFailed(err) {
+		s.owned = false
+		s.iterator = nil
+		return nil
+	} else if err != nil {
+		return l.storeError(ctx, err)
+	}
+	return nil
+}
+
+// releaseAll releases the held leases of the shards of a stream, by letting
+// them expire. It returns the first error.
+func (l *streamLeases) releaseAll(ctx context.Context, streamArn string, shards map[string]*streamShard) error {
+	var firstErr error
+	for id, s := range shards {
+		if !s.owned {
+			continue
+		}
+		s.owned = false
+		err := l.store.service.Table(l.tableName).
+			Update("Stream", streamArn).
+			Range("ShardID", id).
+			Set("Expires", int64(0)).
+			If("'Owner' = ?", l.owner).
+			RunWithContext(ctx)
+		if err != nil && !isConditionalCheckFailed(err) && firstErr == nil {
+			firstErr = l.storeError(ctx, err)
+		}
+	}
+	return firstErr
+}
+
+// storeError wraps an error from the lease table.
+func (l *streamLeases) storeError(ctx context.Context, err error) error {
+	return eh.EventStoreError{
+		BaseErr:   withRequestID(err),
+		Err:       err,
+		Namespace: eh.NamespaceFromContext(ctx),
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestStreamDispatcher will continue handling events from the checkpoints
+// after a restart
+func (suite *EventStoreTestSuite) TestStreamDispatcher() {
+	ctx := eh.NewContextWithNamespace(context.Background(), "dispatch")
+	assert.Nil(suite.T(), suite.store.CreateTable(ctx))
+	defer suite.store.DeleteTable(ctx)
+
+	handler := &streamTestHandler{events: make(chan eh.Event, 10)}
+	d, err := NewStreamDispatcher(suite.store, "stream_leases", handler, WithStreamPollInterval(50*time.Millisecond))
+	assert.Nil(suite.T(), err)
+	assert.Nil(suite.T(), d.CreateTable(ctx))
+	defer suite.store.deleteTable(ctx, "stream_leases")
+	assert.Nil(suite.T(), d.Start(ctx))
+
+	id := uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	event1 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event1"},
+		timestamp, mocks.AggregateType, id, 1)
+	assert.Nil(suite.T(), suite.store.Save(ctx, []eh.Event{event1}, 0))
+	select {
+	case event := <-handler.events:
+		assert.Equal(suite.T(), event1.Data(), event.Data())
+	case <-time.After(10 * time.Second):
+		suite.T().Fatal("event not dispatched")
+	}
+	assert.Nil(suite.T(), d.Close())
+
+	// A restarted dispatcher only handles the events after the checkpoint.
+	event2 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event2"},
+		timestamp, mocks.AggregateType, id, 2)
+	assert.Nil(suite.T(), suite.store.Save(ctx, []eh.Event{event2}, 1))
+	d, err = NewStreamDispatcher(suite.store, "stream_leases", handler, WithStreamPollInterval(50*time.Millisecond))
+	assert.Nil(suite.T(), err)
+	assert.Nil(suite.T(), d.Start(ctx))
+	defer d.Close()
+	select {
+	case event := <-handler.events:
+		assert.Equal(suite.T(), event2.Data(), event.Data())
+	case <-time.After(10 * time.Second):
+		suite.T().Fatal("event not dispatched")
+	}
+	select {
+	case event := <-handler.events:
+		suite.T().Error("event dispatched again:", event)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+// TestStreamDispatcherFilter will only dispatch the events that match the
+// stream filter
+func (suite *EventStoreTestSuite) TestStreamDispatcherFilter() {
+	ctx := eh.NewContextWithNamespace(context.Background(), "dispatch")
+	assert.Nil(suite.T(), suite.store.CreateTable(ctx))
+	defer suite.store.DeleteTable(ctx)
+
+	handler := &streamTestHandler{events: make(chan eh.Event, 10)}
+	d, err := NewStreamDispatcher(suite.store, "stream_leases", handler,
+		WithStreamPollInterval(50*time.Millisecond),
+		WithStreamFilter(EventFilter{ExcludedEventTypes: []eh.EventType{mocks.EventOtherType}}))
+	assert.Nil(suite.T(), err)
+	assert.Nil(suite.T(), d.CreateTable(ctx))
+	defer suite.store.deleteTable(ctx, "stream_leases")
+	assert.Nil(suite.T(), d.Start(ctx))
+	defer d.Close()
+
+	id := uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	event1 := eh.NewEventForAggregate(mocks.EventOtherType, &mocks.EventData{Content: "event1"},
+		timestamp, mocks.AggregateType, id, 1)
+	event2 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event2"},
+		timestamp, mocks.AggregateType, id, 2)
+	assert.Nil(suite.T(), suite.store.Save(ctx, []eh.Event{event1, event2}, 0))
+	select {
+	case event := <-handler.events:
+		assert.Equal(suite.T(), event2.Data(), event.Data())
+	case <-time.After(10 * time.Second):
+		suite.T().Fatal("event not dispatched")
+	}
+	select {
+	case event := <-handler.events:
+		suite.T().Error("filtered event dispatched:", event)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
@@ -0,0 +1,297 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/guregu/dynamo"
+)
+
+// ErrTableNotActive is when a table did not become active in time.
+var ErrTableNotActive = errors.New("table did not become active")
+
+// ErrTableNotDeleted is when a table was not deleted in time.
+var ErrTableNotDeleted = errors.New("table was not deleted")
+
+// TableWaiter is how table lifecycle operations, like CreateTable and
+// DeleteTable, wait for a table to become active or to be deleted.
+type TableWaiter struct {
+	// Timeout is the maximum time to wait.
+	Timeout time.Duration
+	// PollInterval is the base interval between polls of the table status.
+	PollInterval time.Duration
+}
+
+// DefaultTableWaiter is the table waiter for the fields of a waiter that are
+// not set.
+var DefaultTableWaiter = TableWaiter{
+	Timeout:      5 * time.Minute,
+	PollInterval: time.Second,
+}
+
+// WithTableWaiter waits for tables with a custom timeout and poll interval,
+// instead of DefaultTableWaiter, so that deploy scripts fail fast when a
+// table doesn't become active.
+func WithTableWaiter(w TableWaiter) Option {
+	return func(s *EventStore) error {
+		s.tableWaiter = newTableWaiter(w)
+		return nil
+	}
+}
+
+// WithRepoTableWaiter waits for tables with a custom timeout and poll
+// interval, as WithTableWaiter.
+func WithRepoTableWaiter(w TableWaiter) OptionRepo {
+	return func(r *Repo) error {
+		r.tableWaiter = newTableWaiter(w)
+		return nil
+	}
+}
+
+// WithSnapshotTableWaiter waits for tables with a custom timeout and poll
+// interval, as WithTableWaiter.
+func WithSnapshotTableWaiter(w TableWaiter) OptionSnapshotStore {
+	return func(s *SnapshotStore) error {
+		s.tableWaiter = newTableWaiter(w)
+		return nil
+	}
+}
+
+// newTableWaiter returns a waiter with defaults for unset fields.
+func newTableWaiter(w TableWaiter) *TableWaiter {
+	if w.Timeout <= 0 {
+		w.Timeout = DefaultTableWaiter.Timeout
+	}
+	if w.PollInterval <= 0 {
+		w.PollInterval = DefaultTableWaiter.PollInterval
+	}
+	return &w
+}
+
+type tableWaiterKey int
+
+// tableWaiterCtxKey is the context key of the table waiter of an operation.
+const tableWaiterCtxKey tableWaiterKey = iota
+
+// withTableWaiter returns a context with a table waiter, if set, for the
+// table helpers that are called with it.
+func withTableWaiter(ctx context.Context, w *TableWaiter) context.Context {
+	if w == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, tableWaiterCtxKey, *w)
+}
+
+// tableWaiterFromContext returns the table waiter of a context, or the
+// default waiter.
+func tableWaiterFromContext(ctx context.Context) TableWaiter {
+	if w, ok := ctx.Value(tableWaiterCtxKey).(TableWaiter); ok {
+		return w
+	}
+	return DefaultTableWaiter
+}
+
+// createTable runs the table creation and waits for the table to become
+// active. It is safe to call from several processes at once: when another
+// creator got there first the table is waited for instead of failing. The
+// optional configure func is run on the active table by every caller, so it
+// must only change what is not configured yet.
+func createTable(ctx context.Context, client dynamodbiface.DynamoDBAPI, name string, ct *dynamo.CreateTable, configure func(context.Context) error) error {
+	err := ct.RunWithContext(ctx)
+	if err != nil && !isAWSErrorCode(err, dynamodb.ErrCodeResourceInUseException) {
+		return err
+	}
+
+	if err := waitForTableActive(ctx, client, name); err != nil {
+		return err
+	}
+
+	if configure != nil {
+		return configure(ctx)
+	}
+	return nil
+}
+
+// waitForTableActive polls the table status with jitter until it is active.
+// A table that is not found is polled again, as a concurrent creation may not
+// be visible yet.
+func waitForTableActive(ctx context.Context, client dynamodbiface.DynamoDBAPI, name string) error {
+	w := tableWaiterFromContext(ctx)
+	ctx, cancel := context.WithTimeout(ctx, w.Timeout)
+	defer cancel()
+
+	for {
+		out, err := client.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{
+			TableName: aws.String(name),
+		})
+		if err == nil && aws.StringValue(out.Table.TableStatus) == dynamodb.TableStatusActive {
+			return nil
+		} else if err != nil && !isAWSErrorCode(err, dynamodb.ErrCodeResourceNotFoundException) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ErrTableNotActive
+		case <-time.After(jitter(w.PollInterval)):
+		}
+	}
+}
+
+// waitForTableDeleted polls the table status with jitter until the table is
+// not found.
+func waitForTableDeleted(ctx context.Context, client dynamodbiface.DynamoDBAPI, name string) error {
+	w := tableWaiterFromContext(ctx)
+	ctx, cancel := context.WithTimeout(ctx, w.Timeout)
+	defer cancel()
+
+	for {
+		_, err := client.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{
+			TableName: aws.String(name),
+		})
+		if isAWSErrorCode(err, dynamodb.ErrCodeResourceNotFoundException) {
+			return nil
+		} else if err != nil && ctx.Err() == nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ErrTableNotDeleted
+		case <-time.After(jitter(w.PollInterval)):
+		}
+	}
+}
+
+// jitter returns a random duration between half and one and a half times d.
+func jitter(d time.Duration) time.Duration {
+	return d/2 + time.Duration(rand.Int63n(int64(d)))
+}
+
+// tableIndex is a global secondary index that is added to a table on demand.
+type tableIndex struct {
+	name         string
+	hashKey      string
+	hashKeyType  string
+	rangeKey     string
+	rangeKeyType string
+	// projection is the projection type, all attributes if empty.
+	projection string
+}
+
+// ensureIndexes adds the global secondary indexes that are missing on a
+// table, one at a time as required by DynamoDB, and waits for them to become
+// active. Provisioned tables get indexes with the same capacity as the table.
+func ensureIndexes(ctx context.Context, client dynamodbiface.DynamoDBAPI, tableName string, indexes []tableIndex) error {
+	for _, index := range indexes {
+		out, err := client.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{
+			TableName: aws.String(tableName),
+		})
+		if err != nil {
+			return err
+		}
+		if _, projection := findIndex(out.Table, index.name); projection != nil {
+			continue
+		}
+
+		keySchema := []*dynamodb.KeySchemaElement{{
+			AttributeName: aws.String(index.hashKey),
+			KeyType:       aws.String(dynamodb.KeyTypeHash),
+		}}
+		attributes := []*dynamodb.AttributeDefinition{{
+			AttributeName: aws.String(index.hashKey),
+			AttributeType: aws.String(index.hashKeyType),
+		}}
+		if index.rangeKey != "" {
+			keySchema = append(keySchema, &dynamodb.KeySchemaElement{
+				AttributeName: aws.String(index.rangeKey),
+				KeyType:       aws.String(dynamodb.KeyTypeRange),
+			})
+			attributes = append(attributes, &dynamodb.AttributeDefinition{
+				AttributeName: aws.String(index.rangeKey),
+				AttributeType: aws.String(index.rangeKeyType),
+			})
+		}
+
+		projection := index.projection
+		if projection == "" {
+			projection = dynamodb.ProjectionTypeAll
+		}
+		create := &dynamodb.CreateGlobalSecondaryIndexAction{
+			IndexName:  aws.String(index.name),
+			KeySchema:  keySchema,
+			Projection: &dynamodb.Projection{ProjectionType: aws.String(projection)},
+		}
+		if out.Table.BillingModeSummary == nil ||
+			aws.StringValue(out.Table.BillingModeSummary.BillingMode) != dynamodb.BillingModePayPerRequest {
+			create.ProvisionedThroughput = &dynamodb.ProvisionedThroughput{
+				ReadCapacityUnits:  out.Table.ProvisionedThroughput.ReadCapacityUnits,
+				WriteCapacityUnits: out.Table.ProvisionedThroughput.WriteCapacityUnits,
+			}
+		}
+
+		if _, err := client.UpdateTableWithContext(ctx, &dynamodb.UpdateTableInput{
+			TableName:            aws.String(tableName),
+			AttributeDefinitions: attributes,
+			GlobalSecondaryIndexUpdates: []*dynamodb.GlobalSecondaryIndexUpdate{{
+				Create: create,
+			}},
+		}); err != nil && !isAWSErrorCode(err, dynamodb.ErrCodeResourceInUseException) {
+			return err
+		}
+
+		if err := waitForIndexActive(ctx, client, tableName, index.name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// waitForIndexActive polls the table with jitter until the index is active.
+func waitForIndexActive(ctx context.Context, client dynamodbiface.DynamoDBAPI, tableName, indexName string) error {
+	w := tableWaiterFromContext(ctx)
+	ctx, cancel := context.WithTimeout(ctx, w.Timeout)
+	defer cancel()
+
+	for {
+		out, err := client.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{
+			TableName: aws.String(tableName),
+		})
+		if err != nil {
+			return err
+		}
+		for _, index := range out.Table.GlobalSecondaryIndexes {
+			if aws.StringValue(index.IndexName) == indexName &&
+				aws.StringValue(index.IndexStatus) == dynamodb.IndexStatusActive &&
+				aws.StringValue(out.Table.TableStatus) == dynamodb.TableStatusActive {
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ErrTableNotActive
+		case <-time.After(jitter(w.PollInterval)):
+		}
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTableWaiter(t *testing.T) {
+	ctx := context.Background()
+	assert.Equal(t, DefaultTableWaiter, tableWaiterFromContext(ctx))
+	assert.Equal(t, DefaultTableWaiter, tableWaiterFromContext(withTableWaiter(ctx, nil)))
+
+	w := newTableWaiter(TableWaiter{Timeout: time.Second})
+	assert.Equal(t, TableWaiter{Timeout: time.Second, PollInterval: DefaultTableWaiter.PollInterval}, *w)
+	assert.Equal(t, *w, tableWaiterFromContext(withTableWaiter(ctx, w)))
+}
+
+func TestWaitForTableDeleted(t *testing.T) {
+	ctx := withTableWaiter(context.Background(), &TableWaiter{
+		Timeout:      50 * time.Millisecond,
+		PollInterval: 10 * time.Millisecond,
+	})
+
+	notFound := awserr.New(dynamodb.ErrCodeResourceNotFoundException, "not found", nil)
+	assert.Nil(t, waitForTableDeleted(ctx, describeErrClient{err: notFound}, "test"))
+	assert.Equal(t, ErrTableNotDeleted, waitForTableDeleted(ctx, describeErrClient{}, "test"))
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.Equal(t, ErrTableNotDeleted, waitForTableDeleted(canceled, describeErrClient{}, "test"))
+}
@@ -0,0 +1,216 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package testsuite runs the acceptance tests of eventhorizon against the
+// DynamoDB event store and repo on dynamodb-local, which it can start in a
+// Docker container. It is exported so that users of the event store can run
+// their own integration tests against the same setup:
+//
+//	func TestIntegration(t *testing.T) {
+//		local, err := testsuite.StartLocal(context.Background())
+//		if err != nil {
+//			t.Skip("no dynamodb-local:", err)
+//		}
+//		defer local.Stop()
+//
+//		store := testsuite.NewEventStore(t, local)
+//		// ...
+//	}
+package testsuite
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/eventstore"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/looplab/eventhorizon/repo"
+	ehdynamodb "github.com/sysbot/eh-dynamodb"
+)
+
+// Image is the Docker image of dynamodb-local.
+const Image = "amazon/dynamodb-local:latest"
+
+// startTimeout is how long StartLocal waits for dynamodb-local to accept
+// requests.
+const startTimeout = time.Minute
+
+// ErrNotReady is when dynamodb-local did not accept requests in time.
+var ErrNotReady = errors.New("dynamodb-local is not ready")
+
+// Local is a running dynamodb-local.
+type Local struct {
+	// Endpoint is the endpoint of dynamodb-local.
+	Endpoint string
+
+	container string
+}
+
+// StartLocal starts dynamodb-local in a Docker container on a free port and
+// waits until it accepts requests. If the DYNAMODB_HOST environment variable
+// is set, as in the Docker Compose setup of this repo, that endpoint is used
+// instead and nothing is started.
+func StartLocal(ctx context.Context) (*Local, error) {
+	if host := os.Getenv("DYNAMODB_HOST"); host != "" {
+		l := &Local{Endpoint: host}
+		return l, l.wait(ctx)
+	}
+
+	out, err := exec.CommandContext(ctx, "docker", "run", "-d", "--rm", "-p", "127.0.0.1::8000", Image).Output()
+	if err != nil {
+		return nil, err
+	}
+	l := &Local{container: strings.TrimSpace(string(out))}
+
+	out, err = exec.CommandContext(ctx, "docker", "port", l.container, "8000").Output()
+	if err != nil {
+		l.Stop()
+		return nil, err
+	}
+	// The first line is the IPv4 address, as 127.0.0.1:port.
+	addr := strings.SplitN(strings.TrimSpace(string(out)), "\n", 2)[0]
+	l.Endpoint = "http://" + addr
+
+	if err := l.wait(ctx); err != nil {
+		l.Stop()
+		return nil, err
+	}
+	return l, nil
+}
+
+// Stop stops the container of dynamodb-local, if it was started.
+func (l *Local) Stop() error {
+	if l.container == "" {
+		return nil
+	}
+	return exec.Command("docker", "rm", "-f", l.container).Run()
+}
+
+// Session returns an AWS session for dynamodb-local, with fake credentials.
+func (l *Local) Session() *session.Session {
+	return session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String("us-west-2"),
+		Endpoint:    aws.String(l.Endpoint),
+		Credentials: credentials.NewStaticCredentials("fakeKeyId", "fakeSecret", ""),
+	}))
+}
+
+// wait polls dynamodb-local until it accepts requests.
+func (l *Local) wait(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, startTimeout)
+	defer cancel()
+
+	client := dynamodb.New(l.Session())
+	for {
+		if _, err := client.ListTablesWithContext(ctx, &dynamodb.ListTablesInput{}); err == nil {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ErrNotReady
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
+
+// NewEventStore creates an event store on dynamodb-local with a unique table
+// prefix, and creates its table in the default namespace. The tables of the
+// default namespace are deleted when the test ends; tables that the test
+// creates in other namespaces should be deleted by the test.
+func NewEventStore(t *testing.T, l *Local, options ...ehdynamodb.Option) *ehdynamodb.EventStore {
+	t.Helper()
+
+	options = append([]ehdynamodb.Option{ehdynamodb.WithDynamoDB(l.Session())}, options...)
+	store, err := ehdynamodb.NewEventStore("test_"+uuid.New().String(), options...)
+	if err != nil {
+		t.Fatal("could not create event store:", err)
+	}
+
+	ctx := context.Background()
+	if err := store.CreateTable(ctx); err != nil {
+		t.Fatal("could not create table:", err)
+	}
+	t.Cleanup(func() {
+		if err := store.DeleteTable(ctx); err != nil {
+			t.Error("could not delete table:", err)
+		}
+	})
+	return store
+}
+
+// NewRepo creates a repo on dynamodb-local with a unique table prefix, and
+// creates its table in the default namespace, which is deleted when the test
+// ends.
+func NewRepo(t *testing.T, l *Local, options ...ehdynamodb.OptionRepo) *ehdynamodb.Repo {
+	t.Helper()
+
+	options = append([]ehdynamodb.OptionRepo{ehdynamodb.WithRepoDynamoDB(l.Session())}, options...)
+	r, err := ehdynamodb.NewRepo("test_"+uuid.New().String(), options...)
+	if err != nil {
+		t.Fatal("could not create repo:", err)
+	}
+
+	ctx := context.Background()
+	if err := r.CreateTable(ctx); err != nil {
+		t.Fatal("could not create table:", err)
+	}
+	t.Cleanup(func() {
+		if err := r.DeleteTable(ctx); err != nil {
+			t.Error("could not delete table:", err)
+		}
+	})
+	return r
+}
+
+// EventStoreAcceptanceTest runs the eventhorizon acceptance tests of event
+// stores against an event store on dynamodb-local, in the default namespace
+// and in another namespace.
+func EventStoreAcceptanceTest(t *testing.T, l *Local, options ...ehdynamodb.Option) {
+	store := NewEventStore(t, l, options...)
+
+	t.Log("event store with default namespace")
+	eventstore.AcceptanceTest(t, context.Background(), store)
+
+	ctx := eh.NewContextWithNamespace(context.Background(), "ns")
+	if err := store.CreateTable(ctx); err != nil {
+		t.Fatal("could not create table:", err)
+	}
+	defer store.DeleteTable(ctx)
+
+	t.Log("event store with other namespace")
+	eventstore.AcceptanceTest(t, ctx, store)
+}
+
+// RepoAcceptanceTest runs the eventhorizon acceptance tests of repos against
+// a repo on dynamodb-local, with the model of the acceptance tests.
+func RepoAcceptanceTest(t *testing.T, l *Local, options ...ehdynamodb.OptionRepo) {
+	options = append([]ehdynamodb.OptionRepo{
+		ehdynamodb.WithRepoEntityFactoryFunc(func() eh.Entity { return &mocks.Model{} }),
+	}, options...)
+	r := NewRepo(t, l, options...)
+
+	repo.AcceptanceTest(t, context.Background(), r)
+}
@@ -0,0 +1,44 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testsuite
+
+import (
+	"context"
+	"os"
+	"testing"
+)
+
+func TestAcceptance(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping acceptance tests in short mode")
+	}
+
+	// Without a configured endpoint dynamodb-local needs Docker, which is
+	// not available everywhere.
+	local, err := StartLocal(context.Background())
+	if err != nil && os.Getenv("DYNAMODB_HOST") == "" {
+		t.Skip("could not start dynamodb-local with docker:", err)
+	} else if err != nil {
+		t.Fatal("could not start dynamodb-local:", err)
+	}
+	defer local.Stop()
+
+	t.Run("EventStore", func(t *testing.T) {
+		EventStoreAcceptanceTest(t, local)
+	})
+	t.Run("Repo", func(t *testing.T) {
+		RepoAcceptanceTest(t, local)
+	})
+}
@@ -0,0 +1,255 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package testutil provides an in-memory event store with the behavior of the
+// DynamoDB event store, to unit test code that uses the event store without
+// running DynamoDB local.
+//
+// The store validates and saves events like the DynamoDB event store, with
+// the same errors for invalid events and version conflicts, and keeps the
+// events of each namespace apart. The options of the DynamoDB event store,
+// like encryption and indexes, are not supported; use DynamoDB local for
+// integration tests of those.
+package testutil
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	ehdynamodb "github.com/sysbot/eh-dynamodb"
+)
+
+// maxEvents is the maximum number of events of a save, as limited by the
+// transaction of the DynamoDB event store with the version counter item.
+const maxEvents = 99
+
+// EventStore is an in-memory event store.
+type EventStore struct {
+	mu           sync.RWMutex
+	namespaces   map[string]map[uuid.UUID][]eh.Event
+	eventHandler eh.EventHandler
+}
+
+// Option is an option setter used to configure creation.
+type Option func(*EventStore)
+
+// WithEventHandler adds an event handler that will be called when saving
+// events, as the WithEventHandler option of the DynamoDB event store.
+func WithEventHandler(h eh.EventHandler) Option {
+	return func(s *EventStore) {
+		s.eventHandler = h
+	}
+}
+
+// NewInMemoryEventStore creates a new in-memory event store.
+func NewInMemoryEventStore(options ...Option) *EventStore {
+	s := &EventStore{
+		namespaces: map[string]map[uuid.UUID][]eh.Event{},
+	}
+	for _, option := range options {
+		option(s)
+	}
+	return s
+}
+
+// streams returns the streams of the namespace of the context, which must be
+// called with the lock held.
+func (s *EventStore) streams(ctx context.Context) map[uuid.UUID][]eh.Event {
+	ns := eh.NamespaceFromContext(ctx)
+	streams, ok := s.namespaces[ns]
+	if !ok {
+		streams = map[uuid.UUID][]eh.Event{}
+		s.namespaces[ns] = streams
+	}
+	return streams
+}
+
+// Save implements the Save method of the eventhorizon.EventStore interface.
+func (s *EventStore) Save(ctx context.Context, events []eh.Event, originalVersion int) error {
+	if len(events) == 0 {
+		return eh.EventStoreError{
+			Err:       eh.ErrNoEventsToAppend,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+	if len(events) > maxEvents {
+		return eh.EventStoreError{
+			Err:       ehdynamodb.ErrTooManyEvents,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+
+	aggregateID := events[0].AggregateID()
+	for i, event := range events {
+		// Only accept events belonging to the same aggregate.
+		if event.AggregateID() != aggregateID {
+			return eh.EventStoreError{
+				Err:       eh.ErrInvalidEvent,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+
+		// Only accept events that apply to the correct aggregate version.
+		if event.Version() != originalVersion+i+1 {
+			return eh.EventStoreError{
+				Err:       eh.ErrIncorrectEventVersion,
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+	}
+
+	s.mu.Lock()
+	streams := s.streams(ctx)
+	if len(streams[aggregateID]) != originalVersion {
+		s.mu.Unlock()
+		return eh.EventStoreError{
+			Err: ehdynamodb.VersionConflictError{
+				AggregateID: aggregateID,
+				Version:     originalVersion,
+			},
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+	streams[aggregateID] = append(streams[aggregateID], events...)
+	s.mu.Unlock()
+
+	// Let the optional event handler handle the events.
+	if s.eventHandler != nil {
+		for _, e := range events {
+			if err := s.eventHandler.HandleEvent(ctx, e); err != nil {
+				return eh.CouldNotHandleEventError{
+					Err:       err,
+					Event:     e,
+					Namespace: eh.NamespaceFromContext(ctx),
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Load implements the Load method of the eventhorizon.EventStore interface.
+func (s *EventStore) Load(ctx context.Context, id uuid.UUID) ([]eh.Event, error) {
+	return s.LoadFrom(ctx, id, 1)
+}
+
+// LoadFrom loads the events of an aggregate starting at a version.
+func (s *EventStore) LoadFrom(ctx context.Context, id uuid.UUID, version int) ([]eh.Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stream := s.streams(ctx)[id]
+	if version < 1 {
+		version = 1
+	}
+	if version > len(stream) {
+		return []eh.Event{}, nil
+	}
+	return append([]eh.Event(nil), stream[version-1:]...), nil
+}
+
+// AggregateVersion returns the current version of an aggregate, or 0 if it
+// has no events.
+func (s *EventStore) AggregateVersion(ctx context.Context, id uuid.UUID) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.streams(ctx)[id]), nil
+}
+
+// LoadAll loads all events of the namespace of the context, ordered by
+// aggregate ID and version.
+func (s *EventStore) LoadAll(ctx context.Context) ([]eh.Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	streams := s.streams(ctx)
+	ids := make([]uuid.UUID, 0, len(streams))
+	for id := range streams {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i].String() < ids[j].String()
+	})
+
+	events := []eh.Event{}
+	for _, id := range ids {
+		events = append(events, streams[id]...)
+	}
+	return events, nil
+}
+
+// Replace implements the Replace method of the eventhorizon.EventStore interface.
+func (s *EventStore) Replace(ctx context.Context, event eh.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stream := s.streams(ctx)[event.AggregateID()]
+	if len(stream) == 0 {
+		return eh.ErrAggregateNotFound
+	}
+	if event.Version() < 1 || event.Version() > len(stream) {
+		return eh.ErrInvalidEvent
+	}
+	stream[event.Version()-1] = event
+	return nil
+}
+
+// DeleteAggregate deletes all events of an aggregate and returns the number of
+// events that were deleted.
+func (s *EventStore) DeleteAggregate(ctx context.Context, id uuid.UUID) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	streams := s.streams(ctx)
+	deleted := len(streams[id])
+	delete(streams, id)
+	return deleted, nil
+}
+
+// RenameEvent implements the RenameEvent method of the eventhorizon.EventStore interface.
+func (s *EventStore) RenameEvent(ctx context.Context, from, to eh.EventType) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, stream := range s.streams(ctx) {
+		for i, e := range stream {
+			if e.EventType() != from {
+				continue
+			}
+			stream[i] = eh.NewEventForAggregate(to, e.Data(), e.Timestamp(),
+				e.AggregateType(), e.AggregateID(), e.Version(),
+				eh.WithMetadata(e.Metadata()))
+		}
+	}
+	return nil
+}
+
+// Clear removes all events of the namespace of the context.
+func (s *EventStore) Clear(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.namespaces, eh.NamespaceFromContext(ctx))
+	return nil
+}
+
+// Close does nothing, it is for symmetry with the DynamoDB event store.
+func (s *EventStore) Close(ctx context.Context) error {
+	return nil
+}
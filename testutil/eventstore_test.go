@@ -0,0 +1,78 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testutil
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+	ehdynamodb "github.com/sysbot/eh-dynamodb"
+)
+
+func TestEventStore(t *testing.T) {
+	ctx := context.Background()
+	handler := &mocks.EventBus{}
+	store := NewInMemoryEventStore(WithEventHandler(handler))
+
+	id := uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	event1 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event1"}, timestamp, mocks.AggregateType, id, 1)
+	event2 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event2"}, timestamp, mocks.AggregateType, id, 2)
+	assert.Nil(t, store.Save(ctx, []eh.Event{event1}, 0))
+	assert.Nil(t, store.Save(ctx, []eh.Event{event2}, 1))
+	assert.Equal(t, []eh.Event{event1, event2}, handler.Events)
+
+	events, err := store.Load(ctx, id)
+	assert.Nil(t, err)
+	assert.Equal(t, []eh.Event{event1, event2}, events)
+	events, err = store.LoadFrom(ctx, id, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []eh.Event{event2}, events)
+	version, err := store.AggregateVersion(ctx, id)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, version)
+
+	// Saves are validated like in the DynamoDB event store.
+	err = store.Save(ctx, []eh.Event{}, 0)
+	assert.Equal(t, eh.ErrNoEventsToAppend, err.(eh.EventStoreError).Err)
+	err = store.Save(ctx, []eh.Event{event2}, 0)
+	assert.Equal(t, eh.ErrIncorrectEventVersion, err.(eh.EventStoreError).Err)
+	err = store.Save(ctx, []eh.Event{event2}, 1)
+	assert.True(t, ehdynamodb.IsVersionConflict(err))
+
+	// Namespaces are kept apart.
+	nsCtx := eh.NewContextWithNamespace(ctx, "other")
+	events, err = store.Load(nsCtx, id)
+	assert.Nil(t, err)
+	assert.Len(t, events, 0)
+
+	assert.Nil(t, store.RenameEvent(ctx, mocks.EventType, mocks.EventOtherType))
+	events, err = store.LoadAll(ctx)
+	assert.Nil(t, err)
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, mocks.EventOtherType, events[0].EventType())
+		assert.Equal(t, event1.Data(), events[0].Data())
+	}
+
+	deleted, err := store.DeleteAggregate(ctx, id)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, deleted)
+	assert.Equal(t, eh.ErrAggregateNotFound, store.Replace(ctx, event1))
+}
@@ -0,0 +1,152 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/google/uuid"
+	"github.com/guregu/dynamo"
+	eh "github.com/looplab/eventhorizon"
+)
+
+const (
+	// timestampIndexName is the name of the timestamp index.
+	timestampIndexName = "TimestampIndex"
+
+	// dayLayout is the layout of the day of an event in the timestamp index.
+	dayLayout = "2006-01-02"
+)
+
+// timestampIndex is the index used to load the events of a time range, which
+// is partitioned by day to spread the events over the index.
+var timestampIndex = tableIndex{
+	name:         timestampIndexName,
+	hashKey:      "Day",
+	hashKeyType:  dynamodb.ScalarAttributeTypeS,
+	rangeKey:     "Timestamp",
+	rangeKeyType: dynamodb.ScalarAttributeTypeS,
+}
+
+// WithTimestampIndex stores the day of every saved event and adds an index on
+// the day and timestamp to the table in CreateTable, which is needed by
+// LoadByTimeRange. Events saved before the index was enabled are not in it.
+func WithTimestampIndex() Option {
+	return func(s *EventStore) error {
+		s.indexes = append(s.indexes, timestampIndex)
+		return nil
+	}
+}
+
+// LoadByTimeRange loads all events with a timestamp from from until to, not
+// including to, across all aggregates, ordered by timestamp. It queries the
+// index once for every day of the range, so it is meant for audit queries of
+// recent events rather than of years of events. It needs the
+// WithTimestampIndex option.
+func (s *EventStore) LoadByTimeRange(ctx context.Context, from, to time.Time) ([]eh.Event, error) {
+	ctx, err := s.namespace(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if !s.hasIndex(timestampIndexName) {
+		return nil, eh.EventStoreError{
+			Err:       ErrIndexNotEnabled,
+			Namespace: eh.NamespaceFromContext(ctx),
+		}
+	}
+	if !from.Before(to) {
+		return []eh.Event{}, nil
+	}
+
+	tables, err := s.eventTables(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// The timestamps are stored as text that drops trailing zeros of the
+	// fraction, which doesn't sort exactly, so the query is widened by a
+	// second and the events are filtered by their parsed timestamps.
+	var dbEvents []dbEvent
+	for day := eventDay(from); day <= eventDay(to); day = nextDay(day) {
+		for _, tableName := range tables {
+			var tableEvents []dbEvent
+			start := time.Now()
+			err := s.service.Table(tableName).
+				Get("Day", day).
+				Range("Timestamp", dynamo.Between, from.Add(-time.Second), to.Add(time.Second)).
+				Index(timestampIndexName).
+				AllWithContext(ctx, &tableEvents)
+			observe(ctx, s.metrics, OperationQuery, tableName, start, err)
+			if err != nil {
+				return nil, eh.EventStoreError{
+					BaseErr:   withRequestID(err),
+					Err:       err,
+					Namespace: eh.NamespaceFromContext(ctx),
+				}
+			}
+			for _, e := range tableEvents {
+				if !e.Timestamp.Before(from) && e.Timestamp.Before(to) {
+					dbEvents = append(dbEvents, e)
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(dbEvents, func(i, j int) bool {
+		return dbEvents[i].Timestamp.Before(dbEvents[j].Timestamp)
+	})
+
+	return s.buildEvents(ctx, dbEvents)
+}
+
+// LoadAt loads the events of an aggregate with a timestamp up to and
+// including a time, to see what the aggregate looked like at that time. The
+// whole stream is read and filtered by the timestamps of the events, which
+// are not required to be in version order. It doesn't need an index.
+func (s *EventStore) LoadAt(ctx context.Context, id uuid.UUID, at time.Time) ([]eh.Event, error) {
+	ctx, err := s.namespace(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	dbEvents, err := s.queryEvents(ctx, id, 1, 0, true)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := dbEvents[:0]
+	for _, e := range dbEvents {
+		if !e.Timestamp.After(at) {
+			filtered = append(filtered, e)
+		}
+	}
+
+	return s.buildEvents(ctx, filtered)
+}
+
+// eventDay returns the day of a time in UTC.
+func eventDay(t time.Time) string {
+	return t.UTC().Format(dayLayout)
+}
+
+// nextDay returns the day after a day.
+func nextDay(day string) string {
+	t, _ := time.Parse(dayLayout, day)
+	return t.AddDate(0, 0, 1).Format(dayLayout)
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestLoadByTimeRange will load the events of a time range across aggregates
+func (suite *EventStoreTestSuite) TestLoadByTimeRange() {
+	_, err := suite.store.LoadByTimeRange(context.Background(), time.Now().Add(-time.Hour), time.Now())
+	if esErr, ok := err.(eh.EventStoreError); !ok || !errors.Is(esErr.Err, ErrIndexNotEnabled) {
+		suite.T().Fatal("there should be an index not enabled error:", err)
+	}
+
+	store := suite.newStore(WithTimestampIndex())
+
+	ctx := eh.NewContextWithNamespace(context.Background(), "time_range")
+	assert.Nil(suite.T(), store.CreateTable(ctx))
+	defer store.DeleteTable(ctx)
+
+	midnight := time.Date(2009, time.November, 11, 0, 0, 0, 0, time.UTC)
+	before := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "before"},
+		midnight.Add(-2*time.Hour), mocks.AggregateType, uuid.New(), 1)
+	event1 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event1"},
+		midnight.Add(-30*time.Minute), mocks.AggregateType, uuid.New(), 1)
+	event2 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event2"},
+		midnight.Add(500*time.Millisecond), mocks.AggregateType, uuid.New(), 1)
+	after := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "after"},
+		midnight.Add(time.Hour), mocks.AggregateType, uuid.New(), 1)
+	for _, event := range []eh.Event{after, event2, event1, before} {
+		assert.Nil(suite.T(), store.Save(ctx, []eh.Event{event}, 0))
+	}
+
+	events, err := store.LoadByTimeRange(ctx, midnight.Add(-time.Hour), midnight.Add(time.Hour))
+	assert.Nil(suite.T(), err)
+	if assert.Len(suite.T(), events, 2) {
+		assert.Equal(suite.T(), event1.Data(), events[0].Data())
+		assert.Equal(suite.T(), event2.Data(), events[1].Data())
+	}
+}
+
+// TestLoadAt will load the events of an aggregate up to a time
+func (suite *EventStoreTestSuite) TestLoadAt() {
+	id := uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	event1 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event1"},
+		timestamp, mocks.AggregateType, id, 1)
+	event2 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event2"},
+		timestamp.Add(time.Hour), mocks.AggregateType, id, 2)
+	event3 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event3"},
+		timestamp.Add(2*time.Hour), mocks.AggregateType, id, 3)
+	assert.Nil(suite.T(), suite.store.Save(suite.ctx, []eh.Event{event1, event2, event3}, 0))
+
+	events, err := suite.store.LoadAt(suite.ctx, id, timestamp.Add(time.Hour))
+	assert.Nil(suite.T(), err)
+	if assert.Len(suite.T(), events, 2) {
+		assert.Equal(suite.T(), event1.Data(), events[0].Data())
+		assert.Equal(suite.T(), event2.Data(), events[1].Data())
+	}
+
+	events, err = suite.store.LoadAt(suite.ctx, id, timestamp.Add(-time.Second))
+	assert.Nil(suite.T(), err)
+	assert.Len(suite.T(), events, 0)
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"time"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// ExpiresAtKey is the metadata key of the expiry time of an event, as a
+// time.Time. It overrides the TTL of the store and the retention of the
+// namespace for the event, but DynamoDB only expires it when TTL is enabled
+// on the table.
+const ExpiresAtKey = "expires_at"
+
+// WithEventTTL expires events after a TTL, counted from the event timestamp,
+// with DynamoDB TTL, which CreateTable enables on the table. The retention of
+// a namespace config takes precedence. A TTL of zero only enables TTL on the
+// table, for events that have an expiry time in their metadata.
+//
+// Note that DynamoDB deletes expired items in the background, typically
+// within a few days, and that expired events are still loaded until then.
+func WithEventTTL(ttl time.Duration) Option {
+	return func(s *EventStore) error {
+		s.eventTTL = ttl
+		s.ttlEnabled = true
+		return nil
+	}
+}
+
+// eventExpiresAt returns the expiry of an event in Unix seconds, or 0 if it
+// does not expire.
+func (s *EventStore) eventExpiresAt(ctx context.Context, event eh.Event) int64 {
+	if expiresAt, ok := event.Metadata()[ExpiresAtKey].(time.Time); ok {
+		return expiresAt.Unix()
+	}
+	if retention := s.namespaceConfigs.Get(eh.NamespaceFromContext(ctx)).Retention; retention > 0 {
+		return event.Timestamp().Add(retention).Unix()
+	}
+	if s.eventTTL > 0 {
+		return event.Timestamp().Add(s.eventTTL).Unix()
+	}
+	return 0
+}
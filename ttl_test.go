@@ -0,0 +1,52 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventExpiresAt(t *testing.T) {
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	e := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event1"},
+		timestamp, mocks.AggregateType, uuid.New(), 1)
+	ctx := eh.NewContextWithNamespace(context.Background(), "ns")
+
+	s := &EventStore{}
+	assert.Equal(t, int64(0), s.eventExpiresAt(ctx, e))
+
+	s.eventTTL = 24 * time.Hour
+	assert.Equal(t, timestamp.Add(24*time.Hour).Unix(), s.eventExpiresAt(ctx, e))
+
+	// The retention of the namespace takes precedence over the TTL.
+	s.namespaceConfigs = NewNamespaceConfigs(NamespaceConfig{})
+	s.namespaceConfigs.Set("ns", NamespaceConfig{Retention: time.Hour})
+	assert.Equal(t, timestamp.Add(time.Hour).Unix(), s.eventExpiresAt(ctx, e))
+
+	// The expiry of the event takes precedence over both.
+	expiresAt := timestamp.Add(time.Minute)
+	e = event{dbEvent{
+		Timestamp: timestamp,
+		Metadata:  map[string]interface{}{ExpiresAtKey: expiresAt},
+	}}
+	assert.Equal(t, expiresAt.Unix(), s.eventExpiresAt(ctx, e))
+}
@@ -0,0 +1,148 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"errors"
+	"sync"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// ErrTypeNameConflict is when a type or storage name is already registered
+// with a different mapping.
+var ErrTypeNameConflict = errors.New("type name already registered")
+
+// TypeNames is a registry of the names used in storage for event and
+// aggregate types. It decouples the stored names from the Go constants so
+// that types can be renamed in code without rewriting the history. Types
+// that are not registered are stored with their own names.
+type TypeNames struct {
+	mu                 sync.RWMutex
+	eventToStorage     map[eh.EventType]string
+	storageToEvent     map[string]eh.EventType
+	aggregateToStorage map[eh.AggregateType]string
+	storageToAggregate map[string]eh.AggregateType
+}
+
+// NewTypeNames creates a new, empty TypeNames registry.
+func NewTypeNames() *TypeNames {
+	return &TypeNames{
+		eventToStorage:     map[eh.EventType]string{},
+		storageToEvent:     map[string]eh.EventType{},
+		aggregateToStorage: map[eh.AggregateType]string{},
+		storageToAggregate: map[string]eh.AggregateType{},
+	}
+}
+
+// WithTypeNames uses a registry of storage names for event and aggregate types.
+func WithTypeNames(n *TypeNames) Option {
+	return func(s *EventStore) error {
+		s.typeNames = n
+		return nil
+	}
+}
+
+// RegisterEventType registers the storage name of an event type.
+func (n *TypeNames) RegisterEventType(eventType eh.EventType, name string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if t, ok := n.storageToEvent[name]; ok && t != eventType {
+		return ErrTypeNameConflict
+	} else if s, ok := n.eventToStorage[eventType]; ok && s != name {
+		return ErrTypeNameConflict
+	}
+
+	n.eventToStorage[eventType] = name
+	n.storageToEvent[name] = eventType
+	return nil
+}
+
+// RegisterAggregateType registers the storage name of an aggregate type.
+func (n *TypeNames) RegisterAggregateType(aggregateType eh.AggregateType, name string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if t, ok := n.storageToAggregate[name]; ok && t != aggregateType {
+		return ErrTypeNameConflict
+	} else if s, ok := n.aggregateToStorage[aggregateType]; ok && s != name {
+		return ErrTypeNameConflict
+	}
+
+	n.aggregateToStorage[aggregateType] = name
+	n.storageToAggregate[name] = aggregateType
+	return nil
+}
+
+// EventTypeName returns the storage name of an event type.
+func (n *TypeNames) EventTypeName(eventType eh.EventType) string {
+	if n == nil {
+		return string(eventType)
+	}
+
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	if name, ok := n.eventToStorage[eventType]; ok {
+		return name
+	}
+	return string(eventType)
+}
+
+// EventType returns the event type for a storage name.
+func (n *TypeNames) EventType(name string) eh.EventType {
+	if n == nil {
+		return eh.EventType(name)
+	}
+
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	if t, ok := n.storageToEvent[name]; ok {
+		return t
+	}
+	return eh.EventType(name)
+}
+
+// AggregateTypeName returns the storage name of an aggregate type.
+func (n *TypeNames) AggregateTypeName(aggregateType eh.AggregateType) string {
+	if n == nil {
+		return string(aggregateType)
+	}
+
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	if name, ok := n.aggregateToStorage[aggregateType]; ok {
+		return name
+	}
+	return string(aggregateType)
+}
+
+// AggregateType returns the aggregate type for a storage name.
+func (n *TypeNames) AggregateType(name string) eh.AggregateType {
+	if n == nil {
+		return eh.AggregateType(name)
+	}
+
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	if t, ok := n.storageToAggregate[name]; ok {
+		return t
+	}
+	return eh.AggregateType(name)
+}
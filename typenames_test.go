@@ -0,0 +1,46 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"testing"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTypeNames(t *testing.T) {
+	names := NewTypeNames()
+	assert.Nil(t, names.RegisterEventType(mocks.EventType, "event.v1"))
+	assert.Nil(t, names.RegisterAggregateType(mocks.AggregateType, "aggregate.v1"))
+
+	assert.Equal(t, "event.v1", names.EventTypeName(mocks.EventType))
+	assert.Equal(t, mocks.EventType, names.EventType("event.v1"))
+	assert.Equal(t, "aggregate.v1", names.AggregateTypeName(mocks.AggregateType))
+	assert.Equal(t, mocks.AggregateType, names.AggregateType("aggregate.v1"))
+
+	// Unregistered types use their own names.
+	assert.Equal(t, "other", names.EventTypeName(eh.EventType("other")))
+	assert.Equal(t, eh.EventType("other"), names.EventType("other"))
+
+	// Conflicting registrations are rejected.
+	assert.Equal(t, ErrTypeNameConflict, names.RegisterEventType(mocks.EventOtherType, "event.v1"))
+	assert.Equal(t, ErrTypeNameConflict, names.RegisterEventType(mocks.EventType, "event.v2"))
+
+	// A nil registry maps all types to their own names.
+	var none *TypeNames
+	assert.Equal(t, string(mocks.EventType), none.EventTypeName(mocks.EventType))
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// ErrCouldNotUpcastEvent is when the data of an event could not be upcast,
+// for example when it was encoded by a codec that is not JSON.
+var ErrCouldNotUpcastEvent = errors.New("could not upcast event")
+
+// UpcastFunc migrates the data of an event from one data version to the next,
+// in its generic form as decoded from the item.
+type UpcastFunc func(data map[string]interface{}) (map[string]interface{}, error)
+
+// WithUpcaster migrates the data of events of an event type from old shapes
+// to the current event data struct when they are loaded, without rewriting
+// the table. The upcasters are a chain: the first migrates data version 0,
+// which events stored without a data version have, to version 1, the second
+// version 1 to 2 and so on. Saved events are stamped with the data version
+// after the last upcaster.
+//
+// Only data that is stored as an attribute map or as JSON can be upcast.
+func WithUpcaster(eventType eh.EventType, upcasters ...UpcastFunc) Option {
+	return func(s *EventStore) error {
+		if s.upcasters == nil {
+			s.upcasters = map[eh.EventType][]UpcastFunc{}
+		}
+		s.upcasters[eventType] = upcasters
+		return nil
+	}
+}
+
+// dataVersion returns the current data version of an event type.
+func (s *EventStore) dataVersion(eventType eh.EventType) int {
+	return len(s.upcasters[eventType])
+}
+
+// upcastData migrates the data of an event item to the current data version
+// of its event type, re-encoding it in the same form.
+func (s *EventStore) upcastData(e *dbEvent, codec Codec) error {
+	upcasters := s.upcasters[e.EventType]
+	if e.DataVersion >= len(upcasters) || (e.RawData == nil && e.EncodedData == nil) {
+		return nil
+	}
+
+	var data map[string]interface{}
+	if e.EncodedData != nil {
+		if _, ok := codec.(JSONCodec); !ok {
+			return ErrCouldNotUpcastEvent
+		}
+		if err := json.Unmarshal(e.EncodedData, &data); err != nil {
+			return err
+		}
+	} else if err := dynamodbattribute.UnmarshalMap(e.RawData, &data); err != nil {
+		return err
+	}
+
+	for _, upcast := range upcasters[e.DataVersion:] {
+		var err error
+		if data, err = upcast(data); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	if e.EncodedData != nil {
+		e.EncodedData, err = json.Marshal(data)
+	} else {
+		e.RawData, err = dynamodbattribute.MarshalMap(data)
+	}
+	if err != nil {
+		return err
+	}
+	e.DataVersion = len(upcasters)
+	return nil
+}
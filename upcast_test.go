@@ -0,0 +1,71 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpcaster(t *testing.T) {
+	s := &EventStore{tableName: func(context.Context) string { return "test" }}
+	rename := func(data map[string]interface{}) (map[string]interface{}, error) {
+		data["Content"] = data["Text"]
+		delete(data, "Text")
+		return data, nil
+	}
+	suffix := func(data map[string]interface{}) (map[string]interface{}, error) {
+		data["Content"] = data["Content"].(string) + "!"
+		return data, nil
+	}
+	assert.Nil(t, WithUpcaster(mocks.EventType, rename, suffix)(s))
+
+	ctx := context.Background()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	event := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event1"},
+		timestamp, mocks.AggregateType, uuid.New(), 1)
+	e, err := s.newDBEvent(ctx, event)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, e.DataVersion)
+
+	// Current events are not migrated.
+	loaded, err := s.buildEvent(ctx, *e)
+	assert.Nil(t, err)
+	assert.Equal(t, event.Data(), loaded.Data())
+
+	// Events stored without a data version go through the whole chain.
+	e.RawData, err = dynamodbattribute.MarshalMap(map[string]interface{}{"Text": "old"})
+	assert.Nil(t, err)
+	e.DataVersion = 0
+	loaded, err = s.buildEvent(ctx, *e)
+	assert.Nil(t, err)
+	assert.Equal(t, &mocks.EventData{Content: "old!"}, loaded.Data())
+
+	// JSON encoded data is migrated too.
+	e.RawData = nil
+	e.EncodedData = []byte(`{"Content":"json"}`)
+	e.DataJSON = true
+	e.DataVersion = 1
+	loaded, err = s.buildEvent(ctx, *e)
+	assert.Nil(t, err)
+	assert.Equal(t, &mocks.EventData{Content: "json!"}, loaded.Data())
+}
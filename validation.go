@@ -0,0 +1,55 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"errors"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// ErrInvalidEventRejected is when an event validator rejects an event.
+var ErrInvalidEventRejected = errors.New("event rejected by validator")
+
+// EventValidator validates an event before it is saved, for invariants like
+// required metadata, payload sizes or schema compliance. An error rejects the
+// save.
+type EventValidator func(ctx context.Context, event eh.Event) error
+
+// WithEventValidator adds an event validator, which is called for every
+// event that is saved or imported, before it is marshaled. Validators are
+// called in the order they are added, after the save interceptors, and the
+// first error rejects the whole save.
+func WithEventValidator(v EventValidator) Option {
+	return func(s *EventStore) error {
+		s.validators = append(s.validators, v)
+		return nil
+	}
+}
+
+// validateEvent validates an event with the event validators.
+func (s *EventStore) validateEvent(ctx context.Context, event eh.Event) error {
+	for _, v := range s.validators {
+		if err := v(ctx, event); err != nil {
+			return eh.EventStoreError{
+				BaseErr:   err,
+				Err:       wrapError(ErrInvalidEventRejected, err),
+				Namespace: eh.NamespaceFromContext(ctx),
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestEventValidator will reject a save with an invalid event
+func (suite *EventStoreTestSuite) TestEventValidator() {
+	invalid := errors.New("missing user")
+	store := suite.newStore(WithEventValidator(func(ctx context.Context, event eh.Event) error {
+		if _, ok := event.Metadata()["user"]; !ok {
+			return invalid
+		}
+		return nil
+	}))
+
+	id := uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	event1 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event1"}, timestamp, mocks.AggregateType, id, 1,
+		eh.WithMetadata(map[string]interface{}{"user": "a"}))
+	event2 := eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event2"}, timestamp, mocks.AggregateType, id, 2)
+	err := store.Save(suite.ctx, []eh.Event{event1, event2}, 0)
+	if esErr, ok := err.(eh.EventStoreError); !ok || !errors.Is(esErr.Err, ErrInvalidEventRejected) || !errors.Is(esErr.Err, invalid) {
+		suite.T().Fatal("there should be an invalid event error:", err)
+	}
+
+	events, err := store.Load(suite.ctx, id)
+	assert.Nil(suite.T(), err)
+	assert.Len(suite.T(), events, 0)
+
+	assert.Nil(suite.T(), store.Save(suite.ctx, []eh.Event{event1}, 0))
+}
@@ -0,0 +1,59 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+// VersionValidation is how Save validates the versions of the events.
+type VersionValidation int
+
+const (
+	// VersionSequential only accepts events with versions that follow each
+	// other from the original version without gaps. It is the default.
+	VersionSequential VersionValidation = iota
+	// VersionMonotonic accepts events with increasing versions after the
+	// original version, with gaps allowed.
+	VersionMonotonic
+	// VersionUnchecked accepts events with any versions from 1, for
+	// migration and import tooling that replays historical streams verbatim.
+	// Existing versions are still never overwritten.
+	VersionUnchecked
+)
+
+// WithVersionValidation sets how Save validates the versions of the events.
+// The version of the aggregate is the highest saved version, which is the
+// original version to pass to the next save.
+func WithVersionValidation(v VersionValidation) Option {
+	return func(s *EventStore) error {
+		s.versionValidation = v
+		return nil
+	}
+}
+
+// validVersion returns if an event version can be saved after the highest
+// version of the save so far. Versions below 1 are never valid, as they are
+// never loaded and -1 is the head item of the aggregate.
+func (s *EventStore) validVersion(eventVersion, version int) bool {
+	if eventVersion < 1 {
+		return false
+	}
+
+	switch s.versionValidation {
+	case VersionMonotonic:
+		return eventVersion > version
+	case VersionUnchecked:
+		return true
+	default:
+		return eventVersion == version+1
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2018 - The Event Horizon DynamoDB authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestVersionValidation will save events with gaps and out of order versions
+func (suite *EventStoreTestSuite) TestVersionValidation() {
+	id := uuid.New()
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	newEvent := func(version int) eh.Event {
+		return eh.NewEventForAggregate(mocks.EventType, &mocks.EventData{Content: "event"}, timestamp, mocks.AggregateType, id, version)
+	}
+
+	err := suite.store.Save(suite.ctx, []eh.Event{newEvent(1), newEvent(3)}, 0)
+	assert.Equal(suite.T(), eh.ErrIncorrectEventVersion, err.(eh.EventStoreError).Err)
+
+	monotonic := suite.newStore(WithVersionValidation(VersionMonotonic))
+	assert.Nil(suite.T(), monotonic.Save(suite.ctx, []eh.Event{newEvent(1), newEvent(3)}, 0))
+	assert.Nil(suite.T(), monotonic.Save(suite.ctx, []eh.Event{newEvent(5)}, 3))
+	err = monotonic.Save(suite.ctx, []eh.Event{newEvent(4)}, 5)
+	assert.Equal(suite.T(), eh.ErrIncorrectEventVersion, err.(eh.EventStoreError).Err)
+
+	// Unchecked saves fill the gaps without changing the aggregate version.
+	unchecked := suite.newStore(WithVersionValidation(VersionUnchecked))
+	assert.Nil(suite.T(), unchecked.Save(suite.ctx, []eh.Event{newEvent(4), newEvent(2)}, 5))
+	assert.NotNil(suite.T(), unchecked.Save(suite.ctx, []eh.Event{newEvent(6)}, 4))
+
+	// Versions below 1 are never accepted.
+	for _, version := range []int{0, -1} {
+		err = unchecked.Save(suite.ctx, []eh.Event{newEvent(version)}, 5)
+		assert.Equal(suite.T(), eh.ErrIncorrectEventVersion, err.(eh.EventStoreError).Err)
+	}
+
+	events, err := suite.store.Load(suite.ctx, id)
+	assert.Nil(suite.T(), err)
+	if assert.Len(suite.T(), events, 5) {
+		for i, event := range events {
+			assert.Equal(suite.T(), i+1, event.Version())
+		}
+	}
+}
+
+func TestValidVersion(t *testing.T) {
+	for _, v := range []VersionValidation{VersionSequential, VersionMonotonic, VersionUnchecked} {
+		s := &EventStore{versionValidation: v}
+		assert.True(t, s.validVersion(1, 0))
+		assert.False(t, s.validVersion(0, 0))
+		assert.False(t, s.validVersion(-1, 0))
+		assert.False(t, s.validVersion(0, -1))
+	}
+}